@@ -101,24 +101,24 @@ export async function getSetupStatus() {
   return response.json()
 }
 
-export async function verifySetupPassword(setupPassword) {
-  const response = await fetch(`${API_BASE}/setup/verify`, {
+// Create the first admin user and complete setup, the admin is logged in right away
+export async function bootstrapSetup(data) {
+  const response = await fetch(`${API_BASE}/setup/bootstrap`, {
     method: 'POST',
     headers: {
       'Content-Type': 'application/json'
     },
-    body: JSON.stringify({ setup_password: setupPassword })
+    body: JSON.stringify(data)
   })
   if (!response.ok) {
     const error = await response.json()
-    throw new Error(error.description || 'Invalid setup password')
+    throw new Error(error.description || 'Failed to complete setup')
   }
-  const data = await response.json()
-  // Store the auth key for setup completion
-  if (data.auth_key) {
-    setAuthorizationKey(data.auth_key)
+  const result = await response.json()
+  if (result.token) {
+    setToken(result.token)
   }
-  return data
+  return result
 }
 
 // Auth API
@@ -309,6 +309,32 @@ export async function cancelJob(id) {
   return response.json()
 }
 
+export async function approveJob(id, comment = '') {
+  const response = await fetchWithAuth(`${API_BASE}/jobs/${id}/approve`, {
+    method: 'POST',
+    headers: getBearerAuthHeaders(),
+    body: JSON.stringify({ comment })
+  })
+  if (!response.ok) {
+    const error = await response.json()
+    throw new Error(error.description || 'Failed to approve job')
+  }
+  return response.json()
+}
+
+export async function rejectJob(id, comment = '') {
+  const response = await fetchWithAuth(`${API_BASE}/jobs/${id}/reject`, {
+    method: 'POST',
+    headers: getBearerAuthHeaders(),
+    body: JSON.stringify({ comment })
+  })
+  if (!response.ok) {
+    const error = await response.json()
+    throw new Error(error.description || 'Failed to reject job')
+  }
+  return response.json()
+}
+
 // Pipelines API
 export async function getPipelines() {
   const response = await fetchWithAuth(`${API_BASE}/pipelines`, {
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"goli/database"
+	"goli/handler"
+	"goli/models"
+	response_util "goli/utils"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const usage = `Usage:
+  goli                                   Start the server
+  goli admin create -username NAME [-email EMAIL] [-password-stdin]
+                                         Create an admin user, completing setup on a new installation`
+
+// runCommand runs the command given on the command line instead of the server and returns the exit code
+func runCommand(args []string) int {
+	if len(args) >= 2 && args[0] == "admin" && args[1] == "create" {
+		return adminCreate(args[2:])
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// adminCreate creates an admin user. On an installation that is not set up yet this is the bootstrap,
+// the setup endpoint is disabled afterwards. Later it adds another admin, for example when the only
+// admin is locked out
+func adminCreate(args []string) int {
+	flags := flag.NewFlagSet("goli admin create", flag.ContinueOnError)
+	username := flags.String("username", "", "username of the admin (required)")
+	email := flags.String("email", "", "email address of the admin")
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of standard input instead of prompting")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	*username = strings.TrimSpace(*username)
+	if *username == "" || flags.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	password, err := readAdminPassword(*passwordStdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := response_util.ValidatePassword(password, *username); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := database.InitDatabase(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer database.CloseDatabase()
+
+	complete, err := database.IsSetupComplete()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read setup state: %v\n", err)
+		return 1
+	}
+
+	user := &models.User{Username: *username, Email: strings.TrimSpace(*email), Password: password, Role: models.RoleAdmin}
+	action := "user.create"
+	if complete {
+		user, err = database.CreateUser(user)
+	} else {
+		action = "setup.bootstrap"
+		user, err = database.BootstrapAdmin(user)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create admin user: %v\n", err)
+		return 1
+	}
+	if !complete {
+		handler.ClearSetupPassword()
+	}
+
+	after, _ := json.Marshal(map[string]string{"username": user.Username, "role": user.Role})
+	if err := database.InsertAuditEvent(&models.AuditEvent{
+		Actor:      "cli",
+		Action:     action,
+		TargetType: "user",
+		TargetID:   strconv.FormatInt(user.ID, 10),
+		After:      after,
+	}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write audit event: %v\n", err)
+	}
+
+	fmt.Printf("Created admin user %s (ID %d)\n", user.Username, user.ID)
+	if !complete {
+		fmt.Println("Setup is complete, the setup endpoint is disabled")
+	}
+	return 0
+}
+
+// readAdminPassword reads the password from the first line of standard input, or prompts for it twice
+// with echo turned off when the terminal supports it
+func readAdminPassword(fromStdin bool) (string, error) {
+	reader := bufio.NewReader(os.Stdin)
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	if fromStdin {
+		return readLine()
+	}
+
+	if stty("-echo") == nil {
+		defer stty("echo")
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := readLine()
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := readLine()
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if password != confirm {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return password, nil
+}
+
+// stty changes a setting of the terminal on standard input
+func stty(setting string) error {
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
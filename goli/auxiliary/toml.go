@@ -1,15 +1,29 @@
 package auxiliary
 
 import (
+	"log"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/laurent22/toml-go"
 )
 
+// configPathOverride replaces the default config path when set, see SetConfigPath
+var configPathOverride string
+
+// SetConfigPath makes the config be read from and written to path instead of the default location,
+// tests use it with a temporary config. An empty path restores the default
+func SetConfigPath(path string) {
+	configPathOverride = path
+}
+
 // GetConfigPath returns the path to the config file
 func GetConfigPath() string {
+	if configPathOverride != "" {
+		return configPathOverride
+	}
 	osType := runtime.GOOS
 	if osType == "windows" || os.Getenv("OS") == "Windows_NT" {
 		return "C:\\goli\\config\\config.toml"
@@ -28,6 +42,22 @@ func GetFromConfig(configField string) string {
 	return config.GetString(configField)
 }
 
+/*
+Get a non-negative integer field from config file, fallback if it is missing or invalid.
+*/
+func GetIntFromConfig(configField string, fallback int) int {
+	value := strings.TrimSpace(GetFromConfig(configField))
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s %q in config, using %d", configField, value, fallback)
+		return fallback
+	}
+	return n
+}
+
 // getSetupCompleteString properly reads setup_complete as either boolean or string
 // This function reads the config file directly to handle boolean values correctly
 func getSetupCompleteString() string {
@@ -87,6 +117,7 @@ func GetAllConfig() map[string]string {
 	result["host"] = config.GetString("constants.host")
 	result["port"] = config.GetString("constants.port")
 	result["auth_key"] = config.GetString("constants.auth_key")
+	result["auth_key_mode"] = config.GetString("constants.auth_key_mode")
 	result["setup_complete"] = getSetupCompleteString()
 	result["setup_password"] = config.GetString("constants.setup_password")
 	result["gh_username"] = config.GetString("constants.gh_username")
@@ -157,6 +188,7 @@ func UpdateConfig(updates map[string]string) error {
 	hostUpdated := false
 	portUpdated := false
 	authKeyUpdated := false
+	authKeyModeUpdated := false
 	setupCompleteUpdated := false
 	setupPasswordUpdated := false
 	ghUsernameUpdated := false
@@ -193,6 +225,10 @@ func UpdateConfig(updates map[string]string) error {
 				updatedLines = append(updatedLines, `auth_key = "`+filteredUpdates["auth_key"]+`"`)
 				authKeyUpdated = true
 			}
+			if !authKeyModeUpdated && filteredUpdates["auth_key_mode"] != "" {
+				updatedLines = append(updatedLines, `auth_key_mode = "`+filteredUpdates["auth_key_mode"]+`"`)
+				authKeyModeUpdated = true
+			}
 			if !setupCompleteUpdated && filteredUpdates["setup_complete"] != "" {
 				updatedLines = append(updatedLines, `setup_complete = `+filteredUpdates["setup_complete"])
 				setupCompleteUpdated = true
@@ -248,6 +284,15 @@ func UpdateConfig(updates map[string]string) error {
 				portUpdated = true
 				continue
 			}
+			if strings.HasPrefix(trimmed, "auth_key_mode") {
+				if filteredUpdates["auth_key_mode"] != "" {
+					updatedLines = append(updatedLines, `auth_key_mode = "`+filteredUpdates["auth_key_mode"]+`"`)
+					authKeyModeUpdated = true
+					continue
+				}
+				updatedLines = append(updatedLines, line)
+				continue
+			}
 			if strings.HasPrefix(trimmed, "auth_key") && filteredUpdates["auth_key"] != "" {
 				updatedLines = append(updatedLines, `auth_key = "`+filteredUpdates["auth_key"]+`"`)
 				authKeyUpdated = true
@@ -322,6 +367,9 @@ func UpdateConfig(updates map[string]string) error {
 		if !authKeyUpdated && filteredUpdates["auth_key"] != "" {
 			updatedLines = append(updatedLines, `auth_key = "`+filteredUpdates["auth_key"]+`"`)
 		}
+		if !authKeyModeUpdated && filteredUpdates["auth_key_mode"] != "" {
+			updatedLines = append(updatedLines, `auth_key_mode = "`+filteredUpdates["auth_key_mode"]+`"`)
+		}
 		if !setupCompleteUpdated && filteredUpdates["setup_complete"] != "" {
 			updatedLines = append(updatedLines, `setup_complete = `+filteredUpdates["setup_complete"])
 		}
@@ -365,6 +413,9 @@ func UpdateConfig(updates map[string]string) error {
 		if filteredUpdates["auth_key"] != "" {
 			updatedLines = append(updatedLines, `auth_key = "`+filteredUpdates["auth_key"]+`"`)
 		}
+		if filteredUpdates["auth_key_mode"] != "" {
+			updatedLines = append(updatedLines, `auth_key_mode = "`+filteredUpdates["auth_key_mode"]+`"`)
+		}
 		if filteredUpdates["setup_complete"] != "" {
 			updatedLines = append(updatedLines, `setup_complete = `+filteredUpdates["setup_complete"])
 		}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"goli/models"
+	"time"
+)
+
+// ErrApprovalDecided is returned when an approval was decided, expired or cancelled in the meantime
+var ErrApprovalDecided = errors.New("approval has already been decided")
+
+const approvalColumns = `id, job_id, step_id, step_name, step_order, COALESCE(message, ''), COALESCE(approvers, ''),
+			  COALESCE(roles, ''), COALESCE(allow_self_approval, 0), state_encrypted, status, COALESCE(decided_by, ''), COALESCE(comment, ''),
+			  decided_at, expires_at, created_at`
+
+// CreateJobApproval stores the approval an approval step waits for. The state holds resolved
+// variables and secrets, it is encrypted like registry tokens
+func CreateJobApproval(approval *models.JobApproval) error {
+	encrypted, err := encryptSecret(approval.State)
+	if err != nil {
+		return err
+	}
+	approvers, err := json.Marshal(approval.Approvers)
+	if err != nil {
+		return err
+	}
+	roles, err := json.Marshal(approval.Roles)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO job_approvals (job_id, step_id, step_name, step_order, message, approvers, roles, allow_self_approval,
+			  state_encrypted, status, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, created_at`
+
+	approval.Status = models.ApprovalPending
+	approval.ExpiresAt = approval.ExpiresAt.UTC()
+	return DB.QueryRow(query, approval.JobID, approval.StepID, approval.StepName, approval.StepOrder, approval.Message,
+		string(approvers), string(roles), approval.AllowSelf, encrypted, approval.Status, approval.ExpiresAt).Scan(&approval.ID, &approval.CreatedAt)
+}
+
+// GetPendingJobApproval retrieves the undecided approval of a job waiting for approval
+func GetPendingJobApproval(jobID int64) (*models.JobApproval, error) {
+	query := `SELECT ` + approvalColumns + ` FROM job_approvals WHERE job_id = ? AND status = ? ORDER BY id DESC LIMIT 1`
+	return scanJobApproval(DB.QueryRow(query, jobID, models.ApprovalPending))
+}
+
+// GetExpiredJobApprovals retrieves the undecided approvals whose timeout has passed
+func GetExpiredJobApprovals() ([]*models.JobApproval, error) {
+	query := `SELECT ` + approvalColumns + ` FROM job_approvals WHERE status = ? AND expires_at < ? ORDER BY id`
+
+	return queryJobApprovals(query, models.ApprovalPending, time.Now().UTC())
+}
+
+// GetApprovedPendingJobApprovals retrieves the latest approval of each job that was approved but is still
+// pending, because the queue had no room to resume it
+func GetApprovedPendingJobApprovals() ([]*models.JobApproval, error) {
+	query := `SELECT ` + approvalColumns + ` FROM job_approvals
+			  WHERE status = ? AND id IN (SELECT MAX(id) FROM job_approvals GROUP BY job_id)
+			  AND job_id IN (SELECT id FROM jobs WHERE status = ?) ORDER BY id`
+
+	return queryJobApprovals(query, models.ApprovalApproved, models.JobStatusPending)
+}
+
+// DecideJobApproval records the decision on a pending approval. Only the first decision counts, later
+// ones get ErrApprovalDecided. Approvals can be approved or rejected only before they expire
+func DecideJobApproval(approval *models.JobApproval, status, decidedBy, comment string) error {
+	now := time.Now().UTC()
+	query := `UPDATE job_approvals SET status = ?, decided_by = ?, comment = ?, decided_at = ? WHERE id = ? AND status = ?`
+	args := []interface{}{status, decidedBy, comment, now, approval.ID, models.ApprovalPending}
+	if status == models.ApprovalApproved || status == models.ApprovalRejected {
+		query += ` AND expires_at >= ?`
+		args = append(args, now)
+	}
+
+	result, err := DB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrApprovalDecided
+	}
+
+	approval.Status = status
+	approval.DecidedBy = decidedBy
+	approval.Comment = comment
+	approval.DecidedAt = &now
+	return nil
+}
+
+// queryJobApprovals runs a query selecting approvalColumns and scans the approvals
+func queryJobApprovals(query string, args ...interface{}) ([]*models.JobApproval, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var approvals []*models.JobApproval
+	for rows.Next() {
+		approval, err := scanJobApproval(rows)
+		if err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, approval)
+	}
+	return approvals, rows.Err()
+}
+
+// scanJobApproval scans a row selected with approvalColumns and decrypts the state
+func scanJobApproval(row interface{ Scan(...interface{}) error }) (*models.JobApproval, error) {
+	approval := &models.JobApproval{}
+	var approvers, roles, encrypted string
+	var decidedAt sql.NullTime
+	err := row.Scan(&approval.ID, &approval.JobID, &approval.StepID, &approval.StepName, &approval.StepOrder, &approval.Message,
+		&approvers, &roles, &approval.AllowSelf, &encrypted, &approval.Status, &approval.DecidedBy, &approval.Comment,
+		&decidedAt, &approval.ExpiresAt, &approval.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if decidedAt.Valid {
+		approval.DecidedAt = &decidedAt.Time
+	}
+	if approvers != "" {
+		if err := json.Unmarshal([]byte(approvers), &approval.Approvers); err != nil {
+			return nil, err
+		}
+	}
+	if roles != "" {
+		if err := json.Unmarshal([]byte(roles), &approval.Roles); err != nil {
+			return nil, err
+		}
+	}
+	if approval.State, err = decryptSecret(encrypted); err != nil {
+		return nil, err
+	}
+	return approval, nil
+}
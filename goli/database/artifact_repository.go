@@ -0,0 +1,90 @@
+package database
+
+import (
+	"database/sql"
+	"goli/models"
+)
+
+// CreateArtifact records an archived file of a job step
+func CreateArtifact(a *models.Artifact) (*models.Artifact, error) {
+	query := `INSERT INTO artifacts (job_id, step_id, step_name, path, sha256, size, expires_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id, created_at`
+
+	err := DB.QueryRow(query, a.JobID, a.StepID, a.StepName, a.Path, a.SHA256, a.Size, a.ExpiresAt).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ListJobArtifacts returns the artifacts of a job that have not expired
+func ListJobArtifacts(jobID int64) ([]*models.Artifact, error) {
+	query := `SELECT id, job_id, step_id, step_name, path, sha256, size, created_at, expires_at
+			  FROM artifacts WHERE job_id = ? AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+			  ORDER BY step_id, path`
+
+	rows, err := DB.Query(query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artifacts := []*models.Artifact{}
+	for rows.Next() {
+		a, err := scanArtifact(rows)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts, nil
+}
+
+// GetJobArtifact returns an artifact of a job that has not expired
+func GetJobArtifact(jobID, id int64) (*models.Artifact, error) {
+	query := `SELECT id, job_id, step_id, step_name, path, sha256, size, created_at, expires_at
+			  FROM artifacts WHERE job_id = ? AND id = ? AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`
+
+	return scanArtifact(DB.QueryRow(query, jobID, id))
+}
+
+// DeleteExpiredArtifacts deletes the records of expired artifacts and returns how many were deleted
+func DeleteExpiredArtifacts() (int64, error) {
+	result, err := DB.Exec(`DELETE FROM artifacts WHERE expires_at IS NOT NULL AND expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListArtifactHashes returns the content hashes still referenced by an artifact
+func ListArtifactHashes() (map[string]bool, error) {
+	rows, err := DB.Query(`SELECT DISTINCT sha256 FROM artifacts`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes[hash] = true
+	}
+	return hashes, nil
+}
+
+// scanArtifact scans an artifact row
+func scanArtifact(row interface{ Scan(...interface{}) error }) (*models.Artifact, error) {
+	a := &models.Artifact{}
+	var expiresAt sql.NullTime
+	if err := row.Scan(&a.ID, &a.JobID, &a.StepID, &a.StepName, &a.Path, &a.SHA256, &a.Size, &a.CreatedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		a.ExpiresAt = &expiresAt.Time
+	}
+	return a, nil
+}
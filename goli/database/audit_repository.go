@@ -0,0 +1,106 @@
+package database
+
+import (
+	"goli/models"
+	"strings"
+	"time"
+)
+
+// InsertAuditEvent appends an event to the audit log
+func InsertAuditEvent(event *models.AuditEvent) error {
+	query := `INSERT INTO audit_events (actor_id, actor, actor_role, action, target_type, target_id, before, after, status, ip, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`
+
+	// The time is set here rather than by the database, so that it compares with the filter times
+	event.CreatedAt = time.Now().UTC()
+	return DB.QueryRow(query, event.ActorID, event.Actor, event.ActorRole, event.Action, event.TargetType, event.TargetID,
+		nullableJSON(event.Before), nullableJSON(event.After), event.Status, event.IP, event.CreatedAt).Scan(&event.ID)
+}
+
+// ListAuditEvents retrieves the events matching a filter, newest first
+func ListAuditEvents(filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	events := []*models.AuditEvent{}
+	err := ForEachAuditEvent(filter, func(event *models.AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	return events, err
+}
+
+// ForEachAuditEvent calls fn for each event matching a filter, newest first, without loading them
+// all into memory. It stops at the first error fn returns
+func ForEachAuditEvent(filter models.AuditFilter, fn func(*models.AuditEvent) error) error {
+	query := `SELECT id, actor_id, actor, COALESCE(actor_role, ''), action, COALESCE(target_type, ''), COALESCE(target_id, ''),
+			  COALESCE(before, ''), COALESCE(after, ''), COALESCE(status, 0), COALESCE(ip, ''), created_at
+			  FROM audit_events WHERE 1 = 1`
+	var args []interface{}
+
+	if filter.Actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, filter.Actor)
+	}
+	if prefix, ok := strings.CutSuffix(filter.Action, "*"); ok {
+		query += ` AND substr(action, 1, ?) = ?`
+		args = append(args, len(prefix), prefix)
+	} else if filter.Action != "" {
+		query += ` AND action = ?`
+		args = append(args, filter.Action)
+	}
+	if filter.TargetType != "" {
+		query += ` AND target_type = ?`
+		args = append(args, filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		query += ` AND target_id = ?`
+		args = append(args, filter.TargetID)
+	}
+	if filter.Since != nil {
+		query += ` AND created_at >= ?`
+		args = append(args, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		query += ` AND created_at < ?`
+		args = append(args, filter.Until.UTC())
+	}
+
+	query += ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &models.AuditEvent{}
+		var before, after string
+		err := rows.Scan(&event.ID, &event.ActorID, &event.Actor, &event.ActorRole, &event.Action, &event.TargetType, &event.TargetID,
+			&before, &after, &event.Status, &event.IP, &event.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if before != "" {
+			event.Before = []byte(before)
+		}
+		if after != "" {
+			event.After = []byte(after)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// nullableJSON stores empty JSON as NULL
+func nullableJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
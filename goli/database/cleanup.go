@@ -0,0 +1,32 @@
+package database
+
+import (
+	"log"
+	"time"
+)
+
+// CleanupExpired removes expired sessions, two-factor codes, OIDC logins and password resets
+func CleanupExpired() {
+	cleanups := []struct {
+		name string
+		run  func() error
+	}{
+		{"sessions", CleanupExpiredSessions},
+		{"two-factor codes", CleanupExpiredTwoFactorCodes},
+		{"OIDC logins", CleanupExpiredOIDCLogins},
+		{"password resets", CleanupExpiredPasswordResets},
+	}
+	for _, cleanup := range cleanups {
+		if err := cleanup.run(); err != nil {
+			log.Printf("Failed to remove expired %s: %v", cleanup.name, err)
+		}
+	}
+}
+
+// CleanupExpiredPeriodically runs CleanupExpired at the given interval, it never returns
+func CleanupExpiredPeriodically(interval time.Duration) {
+	for {
+		CleanupExpired()
+		time.Sleep(interval)
+	}
+}
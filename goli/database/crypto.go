@@ -0,0 +1,107 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// secretKeyPath is the location of the key used to encrypt secrets at rest
+// It lives next to the database file and is generated on first use
+var secretKeyPath = "/goli/data/secret.key"
+
+var (
+	secretKey     []byte
+	secretKeyErr  error
+	secretKeyOnce sync.Once
+)
+
+// loadSecretKey reads the 32 byte AES key, creating it if it does not exist yet
+func loadSecretKey() ([]byte, error) {
+	secretKeyOnce.Do(func() {
+		key, err := os.ReadFile(secretKeyPath)
+		if err == nil {
+			if len(key) != 32 {
+				secretKeyErr = errors.New("invalid secret key length in " + secretKeyPath)
+				return
+			}
+			secretKey = key
+			return
+		}
+		if !os.IsNotExist(err) {
+			secretKeyErr = err
+			return
+		}
+
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			secretKeyErr = err
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(secretKeyPath), 0700); err != nil {
+			secretKeyErr = err
+			return
+		}
+		if err := os.WriteFile(secretKeyPath, key, 0600); err != nil {
+			secretKeyErr = err
+			return
+		}
+		secretKey = key
+	})
+	return secretKey, secretKeyErr
+}
+
+// encryptSecret encrypts a value with AES-GCM and returns it base64 encoded
+func encryptSecret(plaintext string) (string, error) {
+	key, err := loadSecretKey()
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret reverses encryptSecret
+func decryptSecret(encoded string) (string, error) {
+	key, err := loadSecretKey()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
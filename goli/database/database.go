@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"path/filepath"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -9,13 +10,23 @@ import (
 // DB is the global database connection
 var DB *sql.DB
 
+// sqliteOptions let parallel steps and API requests write at the same time: writers wait up to 10s for
+// the lock instead of failing with "database is locked", transactions take the write lock when they
+// begin so that they never fail upgrading a read lock, and WAL lets readers continue while one writes
+const sqliteOptions = "?_busy_timeout=10000&_txlock=immediate&_journal_mode=WAL"
+
 // InitDatabase initializes the database connection
 func InitDatabase() error {
+	return OpenDatabase("/goli/data/goli.db")
+}
+
+// OpenDatabase opens the SQLite database at dbPath and creates missing tables. The key that encrypts
+// secrets at rest is kept in the same directory
+func OpenDatabase(dbPath string) error {
 	var err error
-	// SQLite database file path
-	dbPath := "/goli/data/goli.db"
+	secretKeyPath = filepath.Join(filepath.Dir(dbPath), "secret.key")
 
-	DB, err = sql.Open("sqlite3", dbPath)
+	DB, err = sql.Open("sqlite3", dbPath+sqliteOptions)
 	if err != nil {
 		return err
 	}
@@ -85,7 +96,7 @@ func createTables() error {
 			email TEXT,
 			phone TEXT,
 			password TEXT NOT NULL,
-			role TEXT NOT NULL DEFAULT 'user',
+			role TEXT NOT NULL DEFAULT 'viewer',
 			two_fa_email_enabled INTEGER DEFAULT 0,
 			two_fa_sms_enabled INTEGER DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -120,6 +131,132 @@ func createTables() error {
 			FOREIGN KEY (pipeline_id) REFERENCES pipelines(id) ON DELETE CASCADE,
 			UNIQUE(pipeline_id, name)
 		)`,
+		`CREATE TABLE IF NOT EXISTS deployments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL,
+			step_id INTEGER NOT NULL,
+			pipeline_id INTEGER,
+			container_id TEXT NOT NULL,
+			container_name TEXT,
+			image TEXT NOT NULL,
+			image_id TEXT,
+			digest TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (job_id) REFERENCES jobs(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_deployments_container ON deployments(container_id)`,
+		`CREATE TABLE IF NOT EXISTS artifacts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL,
+			step_id INTEGER NOT NULL,
+			step_name TEXT NOT NULL,
+			path TEXT NOT NULL,
+			sha256 TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME,
+			FOREIGN KEY (job_id) REFERENCES jobs(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_artifacts_job ON artifacts(job_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_artifacts_sha256 ON artifacts(sha256)`,
+		`CREATE TABLE IF NOT EXISTS pipeline_grants (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			pipeline_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			access TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (pipeline_id) REFERENCES pipelines(id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+			UNIQUE(pipeline_id, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS recovery_codes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			code_hash TEXT NOT NULL,
+			used_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS oidc_logins (
+			state TEXT PRIMARY KEY,
+			nonce TEXT NOT NULL,
+			code_verifier TEXT NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		// One row once the first admin was created, setup is never offered again
+		`CREATE TABLE IF NOT EXISTS setup_state (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			admin_user_id INTEGER,
+			completed_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS password_resets (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			actor_id INTEGER NOT NULL DEFAULT 0,
+			actor TEXT NOT NULL,
+			actor_role TEXT,
+			action TEXT NOT NULL,
+			target_type TEXT,
+			target_id TEXT,
+			before TEXT,
+			after TEXT,
+			status INTEGER,
+			ip TEXT,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_events_target ON audit_events (target_type, target_id)`,
+		// The audit log is append-only
+		`CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+		 BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+		 BEGIN SELECT RAISE(ABORT, 'audit_events is append-only'); END`,
+		`CREATE TABLE IF NOT EXISTS job_approvals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER NOT NULL,
+			step_id INTEGER NOT NULL UNIQUE,
+			step_name TEXT NOT NULL,
+			step_order INTEGER NOT NULL,
+			message TEXT,
+			approvers TEXT,
+			roles TEXT,
+			allow_self_approval INTEGER DEFAULT 0,
+			state_encrypted TEXT NOT NULL,
+			status TEXT NOT NULL,
+			decided_by TEXT,
+			comment TEXT,
+			decided_at DATETIME,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (job_id) REFERENCES jobs(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_job_approvals_job ON job_approvals(job_id)`,
+		`CREATE TABLE IF NOT EXISTS registry_credentials (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			host TEXT NOT NULL UNIQUE,
+			username TEXT NOT NULL,
+			token_encrypted TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, query := range queries {
@@ -128,5 +265,76 @@ func createTables() error {
 		}
 	}
 
+	// Columns added after the initial schema (existing databases are migrated in place)
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"job_steps", "outputs", "TEXT"},
+		{"jobs", "parameters", "TEXT"},
+		{"jobs", "created_by", "TEXT"}, // User who started the job, triggered_by is set by the client
+		{"pipelines", "repository", "TEXT"},
+		{"pipelines", "repository_ref", "TEXT"},
+		{"pipelines", "definition_path", "TEXT"},
+		{"users", "totp_secret", "TEXT"}, // Encrypted, set on enrollment and active once totp_enabled is set
+		{"users", "totp_enabled", "INTEGER DEFAULT 0"},
+		{"users", "totp_last_step", "INTEGER DEFAULT 0"}, // Time step of the last accepted code, against replays
+		{"users", "failed_logins", "INTEGER DEFAULT 0"},  // Consecutive failed logins, reset by a successful one
+		{"users", "locked_at", "DATETIME"},               // Set when failed_logins reached the limit
+		{"two_factor_codes", "attempts", "INTEGER DEFAULT 0"},
+		{"users", "oidc_subject", "TEXT"}, // Subject of the identity provider account, for users logging in with OIDC
+		{"sessions", "ip", "TEXT"},        // Client address of the last request
+		{"sessions", "user_agent", "TEXT"},
+		{"sessions", "last_seen_at", "DATETIME"},
+	}
+
+	for _, col := range columns {
+		if err := addColumnIfMissing(col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+
+	// ALTER TABLE can not add a UNIQUE column, so the index makes each provider account map to one user
+	if _, err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users (oidc_subject)`); err != nil {
+		return err
+	}
+
+	// Installations with users were set up before setup_state existed
+	if _, err := DB.Exec(`INSERT OR IGNORE INTO setup_state (id, completed_at) SELECT 1, CURRENT_TIMESTAMP WHERE EXISTS (SELECT 1 FROM users)`); err != nil {
+		return err
+	}
+
+	// Users created before roles were introduced had the role "user", which could do everything but
+	// the admin-only routes; maintainer is the closest role
+	if _, err := DB.Exec(`UPDATE users SET role = 'maintainer' WHERE role = 'user'`); err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// addColumnIfMissing adds a column to an existing table unless it is already present
+func addColumnIfMissing(table, column, definition string) error {
+	rows, err := DB.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+
+	_, err = DB.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
+	return err
+}
@@ -0,0 +1,159 @@
+package database
+
+import (
+	"fmt"
+	"goli/models"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentStepWrites(t *testing.T) {
+	if err := OpenDatabase(filepath.Join(t.TempDir(), "goli.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseDatabase() })
+
+	job, err := CreateJob(&models.Job{Status: models.JobStatusRunning})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parallel matrix steps log and update their status at the same time
+	const steps, writes = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, steps*writes*2)
+	for i := 0; i < steps; i++ {
+		step := &models.JobStep{JobID: job.ID, StepName: fmt.Sprintf("test (%d)", i), StepOrder: i, Status: models.JobStatusPending}
+		if err := CreateJobStep(step); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if err := UpdateJobStepLogs(step.ID, fmt.Sprintf("line %d\n", j)); err != nil {
+					errs <- err
+				}
+				if err := UpdateJobStepStatus(step.ID, models.JobStatusRunning, ""); err != nil {
+					errs <- err
+				}
+				if _, err := GetJobSteps(job.ID); err != nil {
+					errs <- err
+				}
+				if err := incrementStepOrder(step.ID); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent write failed: %v", err)
+	}
+
+	saved, err := GetJobSteps(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range saved {
+		if lines := strings.Count(step.Logs, "line "); lines != writes {
+			t.Errorf("step %s has %d log lines, want %d", step.StepName, lines, writes)
+		}
+		if want := step.ID - saved[0].ID + writes; step.StepOrder != int(want) {
+			t.Errorf("step %s order = %d, want %d", step.StepName, step.StepOrder, want)
+		}
+	}
+}
+
+// incrementStepOrder reads and then writes in one transaction, like the repositories that use transactions
+func incrementStepOrder(stepID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var order int
+	if err := tx.QueryRow(`SELECT step_order FROM job_steps WHERE id = ?`, stepID).Scan(&order); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE job_steps SET step_order = ? WHERE id = ?`, order+1, stepID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func TestListJobsVisibleTo(t *testing.T) {
+	if err := OpenDatabase(filepath.Join(t.TempDir(), "goli.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseDatabase() })
+
+	newPipeline := func(name string) int64 {
+		p, err := CreatePipeline(&models.Pipeline{Name: name, Definition: "name: " + name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.ID
+	}
+	open, restricted, granted := newPipeline("open"), newPipeline("restricted"), newPipeline("granted")
+	alice, err := CreateUser(&models.User{Username: "alice", Password: "Secret-pass-1", Role: models.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := CreateUser(&models.User{Username: "bob", Password: "Secret-pass-1", Role: models.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []*models.PipelineGrant{
+		{PipelineID: restricted, UserID: bob.ID, Access: models.PipelineAccessEdit},
+		{PipelineID: granted, UserID: alice.ID, Access: models.PipelineAccessView},
+	} {
+		if _, err := SetPipelineGrant(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The newest jobs belong to the restricted pipeline, a page cut before filtering would be empty
+	for _, pipelineID := range []*int64{&open, &granted, nil, &open, &restricted, &restricted, &restricted} {
+		if _, err := CreateJob(&models.Job{PipelineID: pipelineID, Status: models.JobStatusCompleted}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := DB.Exec(`UPDATE jobs SET created_at = datetime('now', '-' || (10 - id) || ' minutes')`); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := ListJobs(3, 0, "", alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 3 {
+		t.Fatalf("first page has %d jobs, want 3", len(jobs))
+	}
+	page2, err := ListJobs(3, 3, "", alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page2) != 1 {
+		t.Fatalf("second page has %d jobs, want 1", len(page2))
+	}
+	for _, job := range append(jobs, page2...) {
+		if job.PipelineID != nil && *job.PipelineID == restricted {
+			t.Errorf("job %d of the restricted pipeline listed to alice", job.ID)
+		}
+	}
+
+	if jobs, _ := ListJobs(50, 0, "", bob.ID); len(jobs) != 6 {
+		t.Errorf("bob sees %d jobs, want 6", len(jobs))
+	}
+	if jobs, _ := ListJobs(50, 0, "", 0); len(jobs) != 7 {
+		t.Errorf("unfiltered listing has %d jobs, want 7", len(jobs))
+	}
+	if jobs, _ := ListJobs(50, 0, string(models.JobStatusRunning), alice.ID); len(jobs) != 0 {
+		t.Errorf("status filter ignored: %d jobs", len(jobs))
+	}
+}
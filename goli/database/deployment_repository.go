@@ -0,0 +1,45 @@
+package database
+
+import (
+	"goli/models"
+)
+
+// CreateDeployment records that a job step started a container
+func CreateDeployment(d *models.Deployment) (*models.Deployment, error) {
+	query := `INSERT INTO deployments (job_id, step_id, pipeline_id, container_id, container_name, image, image_id, digest)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id, created_at`
+
+	err := DB.QueryRow(query, d.JobID, d.StepID, d.PipelineID, d.ContainerID, d.ContainerName,
+		d.Image, d.ImageID, d.Digest).Scan(&d.ID, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// GetLatestDeploymentsByContainerID returns the most recent deployment for every recorded container ID
+func GetLatestDeploymentsByContainerID() (map[string]*models.Deployment, error) {
+	query := `SELECT id, job_id, step_id, pipeline_id, container_id, COALESCE(container_name, ''),
+			  image, COALESCE(image_id, ''), COALESCE(digest, ''), created_at
+			  FROM deployments
+			  WHERE id IN (SELECT MAX(id) FROM deployments GROUP BY container_id)`
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deployments := make(map[string]*models.Deployment)
+	for rows.Next() {
+		d := &models.Deployment{}
+		err := rows.Scan(&d.ID, &d.JobID, &d.StepID, &d.PipelineID, &d.ContainerID, &d.ContainerName,
+			&d.Image, &d.ImageID, &d.Digest, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		deployments[d.ContainerID] = d
+	}
+
+	return deployments, nil
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"goli/models"
+)
+
+// SetPipelineGrant gives a user access to a pipeline, replacing the access level of an existing grant
+func SetPipelineGrant(grant *models.PipelineGrant) (*models.PipelineGrant, error) {
+	query := `INSERT INTO pipeline_grants (pipeline_id, user_id, access) VALUES (?, ?, ?)
+			  ON CONFLICT(pipeline_id, user_id) DO UPDATE SET access = excluded.access
+			  RETURNING id, created_at`
+
+	err := DB.QueryRow(query, grant.PipelineID, grant.UserID, grant.Access).Scan(&grant.ID, &grant.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+// ListPipelineGrants retrieves the grants of a pipeline with the usernames they belong to
+func ListPipelineGrants(pipelineID int64) ([]*models.PipelineGrant, error) {
+	query := `SELECT g.id, g.pipeline_id, g.user_id, COALESCE(u.username, ''), g.access, g.created_at
+			  FROM pipeline_grants g LEFT JOIN users u ON u.id = g.user_id
+			  WHERE g.pipeline_id = ? ORDER BY u.username`
+
+	rows, err := DB.Query(query, pipelineID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []*models.PipelineGrant{}
+	for rows.Next() {
+		grant := &models.PipelineGrant{}
+		if err := rows.Scan(&grant.ID, &grant.PipelineID, &grant.UserID, &grant.Username, &grant.Access, &grant.CreatedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+// GetPipelineAccess reports whether a pipeline is restricted by grants and, if so, the access level
+// granted to the user (empty without a grant)
+func GetPipelineAccess(pipelineID, userID int64) (restricted bool, access string, err error) {
+	query := `SELECT COUNT(*), COALESCE(MAX(CASE WHEN user_id = ? THEN access END), '')
+			  FROM pipeline_grants WHERE pipeline_id = ?`
+
+	var count int
+	if err := DB.QueryRow(query, userID, pipelineID).Scan(&count, &access); err != nil {
+		return false, "", err
+	}
+	return count > 0, access, nil
+}
+
+// IsPipelineRestricted reports whether a pipeline has grants, so that only admins and the granted users can access it
+func IsPipelineRestricted(pipelineID int64) (bool, error) {
+	restricted, _, err := GetPipelineAccess(pipelineID, 0)
+	return restricted, err
+}
+
+// DeletePipelineGrant removes a grant from a pipeline
+func DeletePipelineGrant(pipelineID, id int64) error {
+	result, err := DB.Exec(`DELETE FROM pipeline_grants WHERE pipeline_id = ? AND id = ?`, pipelineID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -2,17 +2,28 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"goli/models"
+	"strings"
 	"time"
 )
 
 // CreateJob creates a new job in the database
 func CreateJob(job *models.Job) (*models.Job, error) {
-	query := `INSERT INTO jobs (pipeline_id, name, status, triggered_by, logs) 
-			  VALUES (?, ?, ?, ?, ?) RETURNING id, created_at`
+	query := `INSERT INTO jobs (pipeline_id, name, status, triggered_by, created_by, logs, parameters) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?) RETURNING id, created_at`
+
+	var parameters interface{}
+	if len(job.Parameters) > 0 {
+		data, err := json.Marshal(job.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		parameters = string(data)
+	}
 
 	var createdAt time.Time
-	err := DB.QueryRow(query, job.PipelineID, job.Name, job.Status, job.TriggeredBy, job.Logs).Scan(&job.ID, &createdAt)
+	err := DB.QueryRow(query, job.PipelineID, job.Name, job.Status, job.TriggeredBy, job.CreatedBy, job.Logs, parameters).Scan(&job.ID, &createdAt)
 	if err != nil {
 		return nil, err
 	}
@@ -28,7 +39,8 @@ func UpdateJobStatus(id int64, status models.JobStatus, errorMsg string) error {
 	var args []interface{}
 
 	if status == models.JobStatusRunning {
-		query = `UPDATE jobs SET status = ?, started_at = ?, error_message = ? WHERE id = ?`
+		// Jobs resuming after an approval keep their start time
+		query = `UPDATE jobs SET status = ?, started_at = COALESCE(started_at, ?), error_message = ? WHERE id = ?`
 		args = []interface{}{status, now, errorMsg, id}
 	} else if status == models.JobStatusCompleted || status == models.JobStatusFailed || status == models.JobStatusCancelled {
 		query = `UPDATE jobs SET status = ?, completed_at = ?, error_message = ? WHERE id = ?`
@@ -44,8 +56,8 @@ func UpdateJobStatus(id int64, status models.JobStatus, errorMsg string) error {
 
 // GetRunningJobs retrieves all jobs with status "running"
 func GetRunningJobs() ([]*models.Job, error) {
-	query := `SELECT id, pipeline_id, name, status, triggered_by, started_at, 
-			  completed_at, error_message, logs, created_at 
+	query := `SELECT id, pipeline_id, name, status, triggered_by, COALESCE(created_by, ''), started_at, 
+			  completed_at, COALESCE(error_message, ''), logs, created_at 
 			  FROM jobs WHERE status = 'running' ORDER BY started_at DESC`
 
 	rows, err := DB.Query(query)
@@ -59,7 +71,7 @@ func GetRunningJobs() ([]*models.Job, error) {
 		job := &models.Job{}
 		var startedAt, completedAt sql.NullTime
 		err := rows.Scan(
-			&job.ID, &job.PipelineID, &job.Name, &job.Status, &job.TriggeredBy,
+			&job.ID, &job.PipelineID, &job.Name, &job.Status, &job.TriggeredBy, &job.CreatedBy,
 			&startedAt, &completedAt, &job.ErrorMessage, &job.Logs, &job.CreatedAt,
 		)
 		if err != nil {
@@ -82,18 +94,20 @@ func GetRunningJobs() ([]*models.Job, error) {
 // GetJob retrieves a job by ID
 func GetJob(id int64) (*models.Job, error) {
 	job := &models.Job{}
-	query := `SELECT id, pipeline_id, name, status, triggered_by, started_at, 
-			  completed_at, error_message, logs, created_at 
+	query := `SELECT id, pipeline_id, name, status, triggered_by, COALESCE(created_by, ''), started_at, 
+			  completed_at, COALESCE(error_message, ''), logs, COALESCE(parameters, ''), created_at 
 			  FROM jobs WHERE id = ?`
 
 	var startedAt, completedAt sql.NullTime
+	var parameters string
 	err := DB.QueryRow(query, id).Scan(
-		&job.ID, &job.PipelineID, &job.Name, &job.Status, &job.TriggeredBy,
-		&startedAt, &completedAt, &job.ErrorMessage, &job.Logs, &job.CreatedAt,
+		&job.ID, &job.PipelineID, &job.Name, &job.Status, &job.TriggeredBy, &job.CreatedBy,
+		&startedAt, &completedAt, &job.ErrorMessage, &job.Logs, &parameters, &job.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	job.Parameters = decodeStringMap(parameters)
 
 	if startedAt.Valid {
 		job.StartedAt = &startedAt.Time
@@ -111,10 +125,20 @@ func GetJob(id int64) (*models.Job, error) {
 	return job, nil
 }
 
+// GetLatestPipelineJob returns the most recent job of a pipeline with the given status
+func GetLatestPipelineJob(pipelineID int64, status models.JobStatus) (*models.Job, error) {
+	var id int64
+	query := `SELECT id FROM jobs WHERE pipeline_id = ? AND status = ? ORDER BY id DESC LIMIT 1`
+	if err := DB.QueryRow(query, pipelineID, status).Scan(&id); err != nil {
+		return nil, err
+	}
+	return GetJob(id)
+}
+
 // GetJobSteps retrieves all steps for a job
 func GetJobSteps(jobID int64) ([]models.JobStep, error) {
 	query := `SELECT id, job_id, step_name, step_order, status, started_at, 
-			  completed_at, error_message, logs, created_at 
+			  completed_at, COALESCE(error_message, ''), logs, COALESCE(outputs, ''), created_at 
 			  FROM job_steps WHERE job_id = ? ORDER BY step_order`
 
 	rows, err := DB.Query(query, jobID)
@@ -127,13 +151,15 @@ func GetJobSteps(jobID int64) ([]models.JobStep, error) {
 	for rows.Next() {
 		step := models.JobStep{}
 		var startedAt, completedAt sql.NullTime
+		var outputs string
 		err := rows.Scan(
 			&step.ID, &step.JobID, &step.StepName, &step.StepOrder, &step.Status,
-			&startedAt, &completedAt, &step.ErrorMessage, &step.Logs, &step.CreatedAt,
+			&startedAt, &completedAt, &step.ErrorMessage, &step.Logs, &outputs, &step.CreatedAt,
 		)
 		if err != nil {
 			return nil, err
 		}
+		step.Outputs = decodeStringMap(outputs)
 
 		if startedAt.Valid {
 			step.StartedAt = &startedAt.Time
@@ -151,15 +177,16 @@ func GetJobSteps(jobID int64) ([]models.JobStep, error) {
 // GetRunningStep retrieves the currently running step for a job (if any)
 func GetRunningStep(jobID int64) (*models.JobStep, error) {
 	query := `SELECT id, job_id, step_name, step_order, status, started_at, 
-			  completed_at, error_message, logs, created_at 
+			  completed_at, COALESCE(error_message, ''), logs, COALESCE(outputs, ''), created_at 
 			  FROM job_steps WHERE job_id = ? AND status = 'running' 
 			  ORDER BY step_order DESC LIMIT 1`
 
 	step := &models.JobStep{}
 	var startedAt, completedAt sql.NullTime
+	var outputs string
 	err := DB.QueryRow(query, jobID).Scan(
 		&step.ID, &step.JobID, &step.StepName, &step.StepOrder, &step.Status,
-		&startedAt, &completedAt, &step.ErrorMessage, &step.Logs, &step.CreatedAt,
+		&startedAt, &completedAt, &step.ErrorMessage, &step.Logs, &outputs, &step.CreatedAt,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -167,6 +194,7 @@ func GetRunningStep(jobID int64) (*models.JobStep, error) {
 		}
 		return nil, err
 	}
+	step.Outputs = decodeStringMap(outputs)
 
 	if startedAt.Valid {
 		step.StartedAt = &startedAt.Time
@@ -179,21 +207,32 @@ func GetRunningStep(jobID int64) (*models.JobStep, error) {
 }
 
 // GetJobs retrieves all jobs with optional filters (alias for ListJobs for compatibility)
-func GetJobs(limit int, offset int, statusFilter string) ([]*models.Job, error) {
-	return ListJobs(limit, offset, statusFilter)
+func GetJobs(limit int, offset int, statusFilter string, visibleTo int64) ([]*models.Job, error) {
+	return ListJobs(limit, offset, statusFilter, visibleTo)
 }
 
-// ListJobs retrieves all jobs with optional filters
-func ListJobs(limit int, offset int, statusFilter string) ([]*models.Job, error) {
-	query := `SELECT id, pipeline_id, name, status, triggered_by, started_at, 
-			  completed_at, error_message, created_at 
+// ListJobs retrieves all jobs with optional filters. With visibleTo set, jobs of pipelines with grants
+// are left out unless that user has one, before the page is cut so restricted users get full pages
+func ListJobs(limit int, offset int, statusFilter string, visibleTo int64) ([]*models.Job, error) {
+	query := `SELECT id, pipeline_id, name, status, triggered_by, COALESCE(created_by, ''), started_at, 
+			  completed_at, COALESCE(error_message, ''), created_at 
 			  FROM jobs`
 
+	var conditions []string
 	var args []interface{}
 	if statusFilter != "" {
-		query += " WHERE status = ?"
+		conditions = append(conditions, "status = ?")
 		args = append(args, statusFilter)
 	}
+	if visibleTo != 0 {
+		conditions = append(conditions, `(pipeline_id IS NULL
+			OR NOT EXISTS (SELECT 1 FROM pipeline_grants g WHERE g.pipeline_id = jobs.pipeline_id)
+			OR EXISTS (SELECT 1 FROM pipeline_grants g WHERE g.pipeline_id = jobs.pipeline_id AND g.user_id = ?))`)
+		args = append(args, visibleTo)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
 
 	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
 	args = append(args, limit, offset)
@@ -209,7 +248,7 @@ func ListJobs(limit int, offset int, statusFilter string) ([]*models.Job, error)
 		job := &models.Job{}
 		var startedAt, completedAt sql.NullTime
 		err := rows.Scan(
-			&job.ID, &job.PipelineID, &job.Name, &job.Status, &job.TriggeredBy,
+			&job.ID, &job.PipelineID, &job.Name, &job.Status, &job.TriggeredBy, &job.CreatedBy,
 			&startedAt, &completedAt, &job.ErrorMessage, &job.CreatedAt,
 		)
 		if err != nil {
@@ -260,7 +299,7 @@ func UpdateJobStepStatus(stepID int64, status models.JobStatus, errorMsg string)
 	if status == models.JobStatusRunning {
 		query = `UPDATE job_steps SET status = ?, started_at = ?, error_message = ? WHERE id = ?`
 		args = []interface{}{status, now, errorMsg, stepID}
-	} else if status == models.JobStatusCompleted || status == models.JobStatusFailed || status == models.JobStatusCancelled {
+	} else if status == models.JobStatusCompleted || status == models.JobStatusFailed || status == models.JobStatusCancelled || status == models.JobStatusSkipped {
 		query = `UPDATE job_steps SET status = ?, completed_at = ?, error_message = ? WHERE id = ?`
 		args = []interface{}{status, now, errorMsg, stepID}
 	} else {
@@ -278,3 +317,25 @@ func UpdateJobStepLogs(stepID int64, logs string) error {
 	_, err := DB.Exec(query, "\n"+logs, stepID)
 	return err
 }
+
+// SetJobStepOutputs stores the structured outputs of a job step (replaces previous outputs)
+func SetJobStepOutputs(stepID int64, outputs map[string]string) error {
+	data, err := json.Marshal(outputs)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`UPDATE job_steps SET outputs = ? WHERE id = ?`, string(data), stepID)
+	return err
+}
+
+// decodeStringMap parses a JSON string map column (step outputs, job parameters), empty or invalid values yield nil
+func decodeStringMap(data string) map[string]string {
+	if data == "" {
+		return nil
+	}
+	var outputs map[string]string
+	if err := json.Unmarshal([]byte(data), &outputs); err != nil {
+		return nil
+	}
+	return outputs
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"goli/models"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OIDCLogin is a login started at the identity provider and not yet returned to the callback
+type OIDCLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// CreateOIDCLogin stores a started login
+func CreateOIDCLogin(login *OIDCLogin) error {
+	_, err := DB.Exec(`INSERT INTO oidc_logins (state, nonce, code_verifier, expires_at) VALUES (?, ?, ?, ?)`,
+		login.State, login.Nonce, login.CodeVerifier, login.ExpiresAt)
+	return err
+}
+
+// ConsumeOIDCLogin removes and returns the unexpired login with the given state, each state is accepted once
+func ConsumeOIDCLogin(state string) (*OIDCLogin, error) {
+	login := &OIDCLogin{}
+	err := DB.QueryRow(`DELETE FROM oidc_logins WHERE state = ? RETURNING state, nonce, code_verifier, expires_at`, state).Scan(
+		&login.State, &login.Nonce, &login.CodeVerifier, &login.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if login.ExpiresAt.Before(time.Now()) {
+		return nil, sql.ErrNoRows
+	}
+	return login, nil
+}
+
+// CleanupExpiredOIDCLogins removes logins that never returned from the identity provider
+func CleanupExpiredOIDCLogins() error {
+	_, err := DB.Exec(`DELETE FROM oidc_logins WHERE expires_at < ?`, time.Now().UTC())
+	return err
+}
+
+// GetUserByOIDCSubject retrieves the user linked to an identity provider account
+func GetUserByOIDCSubject(subject string) (*models.User, error) {
+	var id int64
+	if err := DB.QueryRow(`SELECT id FROM users WHERE oidc_subject = ?`, subject).Scan(&id); err != nil {
+		return nil, err
+	}
+	return GetUser(id)
+}
+
+// CreateOIDCUser provisions a user for an identity provider account. The user gets a random password
+// nobody knows, so they can only log in through the provider
+func CreateOIDCUser(user *models.User, subject string) (*models.User, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(b)), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO users (username, email, phone, password, role, oidc_subject) VALUES (?, ?, '', ?, ?, ?)
+			  RETURNING id, created_at, updated_at`
+	err = DB.QueryRow(query, user.Username, user.Email, string(hashedPassword), user.Role, subject).Scan(
+		&user.ID, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SyncOIDCUser updates the role and, if the provider sent one, the email of a provisioned user on login
+func SyncOIDCUser(userID int64, email, role string) error {
+	_, err := DB.Exec(`UPDATE users SET role = ?, email = CASE WHEN ? = '' THEN email ELSE ? END, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = ?`, role, email, email, userID)
+	return err
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"goli/models"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetUserForPasswordReset retrieves the user a password reset for login (a username or an email
+// address) is sent to. Users without an email address, users logging in with OIDC and email addresses
+// shared by several users never match
+func GetUserForPasswordReset(login string) (*models.User, error) {
+	rows, err := DB.Query(`SELECT id FROM users WHERE (username = ? OR email = ?) AND COALESCE(email, '') != ''
+			  AND oidc_subject IS NULL LIMIT 2`, login, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) != 1 {
+		return nil, sql.ErrNoRows
+	}
+	return GetUser(ids[0])
+}
+
+// CreatePasswordReset stores a reset token of a user, replacing the ones sent before. Only the hash of
+// the token is saved
+func CreatePasswordReset(userID int64, token string, expiresAt time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?)`,
+		HashAPIToken(token), userID, expiresAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPasswordResetUser returns the ID of the user an unexpired reset token belongs to
+func GetPasswordResetUser(token string) (int64, error) {
+	var userID int64
+	err := DB.QueryRow(`SELECT user_id FROM password_resets WHERE token_hash = ? AND expires_at >= ?`,
+		HashAPIToken(token), time.Now().UTC()).Scan(&userID)
+	return userID, err
+}
+
+// ResetPassword consumes an unexpired reset token and sets the password of its user, who is unlocked
+// and logged out everywhere. It returns sql.ErrNoRows if the token is unknown, expired or already used
+func ResetPassword(token, password string) (int64, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRow(`DELETE FROM password_resets WHERE token_hash = ? AND expires_at >= ? RETURNING user_id`,
+		HashAPIToken(token), time.Now().UTC()).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := setPassword(tx, userID, hashedPassword, 0); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET failed_logins = 0, locked_at = NULL WHERE id = ?`, userID); err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// ChangePassword sets the password of a user and revokes their sessions except the one with ID
+// keepSessionID, and their pending password resets. It returns how many sessions were revoked
+func ChangePassword(userID int64, password string, keepSessionID int64) (int64, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	revoked, err := setPassword(tx, userID, hashedPassword, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+// CleanupExpiredPasswordResets removes reset tokens that were never used
+func CleanupExpiredPasswordResets() error {
+	_, err := DB.Exec(`DELETE FROM password_resets WHERE expires_at < ?`, time.Now().UTC())
+	return err
+}
+
+// setPassword stores a password hash and revokes the user's password resets and sessions except
+// keepSessionID (0 revokes all), returning how many sessions were revoked
+func setPassword(tx *sql.Tx, userID int64, hashedPassword []byte, keepSessionID int64) (int64, error) {
+	result, err := tx.Exec(`UPDATE users SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, string(hashedPassword), userID)
+	if err != nil {
+		return 0, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rowsAffected == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID); err != nil {
+		return 0, err
+	}
+	result, err = tx.Exec(`DELETE FROM sessions WHERE user_id = ? AND id != ?`, userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
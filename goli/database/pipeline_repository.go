@@ -7,10 +7,11 @@ import (
 
 // CreatePipeline creates a new pipeline in the database
 func CreatePipeline(pipeline *models.Pipeline) (*models.Pipeline, error) {
-	query := `INSERT INTO pipelines (name, description, definition) 
-			  VALUES (?, ?, ?) RETURNING id, created_at, updated_at`
+	query := `INSERT INTO pipelines (name, description, definition, repository, repository_ref, definition_path) 
+			  VALUES (?, ?, ?, ?, ?, ?) RETURNING id, created_at, updated_at`
 
-	err := DB.QueryRow(query, pipeline.Name, pipeline.Description, pipeline.Definition).Scan(
+	err := DB.QueryRow(query, pipeline.Name, pipeline.Description, pipeline.Definition,
+		pipeline.Repository, pipeline.RepositoryRef, pipeline.DefinitionPath).Scan(
 		&pipeline.ID, &pipeline.CreatedAt, &pipeline.UpdatedAt,
 	)
 	if err != nil {
@@ -22,11 +23,13 @@ func CreatePipeline(pipeline *models.Pipeline) (*models.Pipeline, error) {
 // GetPipeline retrieves a pipeline by ID
 func GetPipeline(id int64) (*models.Pipeline, error) {
 	pipeline := &models.Pipeline{}
-	query := `SELECT id, name, description, definition, created_at, updated_at 
+	query := `SELECT id, name, description, definition, COALESCE(repository, ''), COALESCE(repository_ref, ''),
+			  COALESCE(definition_path, ''), created_at, updated_at 
 			  FROM pipelines WHERE id = ?`
 
 	err := DB.QueryRow(query, id).Scan(
 		&pipeline.ID, &pipeline.Name, &pipeline.Description, &pipeline.Definition,
+		&pipeline.Repository, &pipeline.RepositoryRef, &pipeline.DefinitionPath,
 		&pipeline.CreatedAt, &pipeline.UpdatedAt,
 	)
 	if err != nil {
@@ -52,11 +55,13 @@ func GetPipeline(id int64) (*models.Pipeline, error) {
 // GetPipelineWithSecrets retrieves a pipeline by ID including secret values (for execution)
 func GetPipelineWithSecrets(id int64) (*models.Pipeline, error) {
 	pipeline := &models.Pipeline{}
-	query := `SELECT id, name, description, definition, created_at, updated_at 
+	query := `SELECT id, name, description, definition, COALESCE(repository, ''), COALESCE(repository_ref, ''),
+			  COALESCE(definition_path, ''), created_at, updated_at 
 			  FROM pipelines WHERE id = ?`
 
 	err := DB.QueryRow(query, id).Scan(
 		&pipeline.ID, &pipeline.Name, &pipeline.Description, &pipeline.Definition,
+		&pipeline.Repository, &pipeline.RepositoryRef, &pipeline.DefinitionPath,
 		&pipeline.CreatedAt, &pipeline.UpdatedAt,
 	)
 	if err != nil {
@@ -78,11 +83,13 @@ func GetPipelineWithSecrets(id int64) (*models.Pipeline, error) {
 // GetPipelineByName retrieves a pipeline by name
 func GetPipelineByName(name string) (*models.Pipeline, error) {
 	pipeline := &models.Pipeline{}
-	query := `SELECT id, name, description, definition, created_at, updated_at 
+	query := `SELECT id, name, description, definition, COALESCE(repository, ''), COALESCE(repository_ref, ''),
+			  COALESCE(definition_path, ''), created_at, updated_at 
 			  FROM pipelines WHERE name = ?`
 
 	err := DB.QueryRow(query, name).Scan(
 		&pipeline.ID, &pipeline.Name, &pipeline.Description, &pipeline.Definition,
+		&pipeline.Repository, &pipeline.RepositoryRef, &pipeline.DefinitionPath,
 		&pipeline.CreatedAt, &pipeline.UpdatedAt,
 	)
 	if err != nil {
@@ -93,7 +100,8 @@ func GetPipelineByName(name string) (*models.Pipeline, error) {
 
 // ListPipelines retrieves all pipelines
 func ListPipelines() ([]*models.Pipeline, error) {
-	query := `SELECT id, name, description, definition, created_at, updated_at 
+	query := `SELECT id, name, description, definition, COALESCE(repository, ''), COALESCE(repository_ref, ''),
+			  COALESCE(definition_path, ''), created_at, updated_at 
 			  FROM pipelines ORDER BY created_at DESC`
 
 	rows, err := DB.Query(query)
@@ -107,6 +115,7 @@ func ListPipelines() ([]*models.Pipeline, error) {
 		pipeline := &models.Pipeline{}
 		err := rows.Scan(
 			&pipeline.ID, &pipeline.Name, &pipeline.Description, &pipeline.Definition,
+			&pipeline.Repository, &pipeline.RepositoryRef, &pipeline.DefinitionPath,
 			&pipeline.CreatedAt, &pipeline.UpdatedAt,
 		)
 		if err != nil {
@@ -134,10 +143,12 @@ func ListPipelines() ([]*models.Pipeline, error) {
 
 // UpdatePipeline updates an existing pipeline
 func UpdatePipeline(pipeline *models.Pipeline) error {
-	query := `UPDATE pipelines SET name = ?, description = ?, definition = ?, updated_at = CURRENT_TIMESTAMP 
+	query := `UPDATE pipelines SET name = ?, description = ?, definition = ?, repository = ?, repository_ref = ?,
+			  definition_path = ?, updated_at = CURRENT_TIMESTAMP 
 			  WHERE id = ?`
 
-	result, err := DB.Exec(query, pipeline.Name, pipeline.Description, pipeline.Definition, pipeline.ID)
+	result, err := DB.Exec(query, pipeline.Name, pipeline.Description, pipeline.Definition,
+		pipeline.Repository, pipeline.RepositoryRef, pipeline.DefinitionPath, pipeline.ID)
 	if err != nil {
 		return err
 	}
@@ -153,6 +164,13 @@ func UpdatePipeline(pipeline *models.Pipeline) error {
 	return nil
 }
 
+// UpdatePipelineDefinition stores the definition last loaded from a pipeline's repository
+func UpdatePipelineDefinition(id int64, definition string) error {
+	query := `UPDATE pipelines SET definition = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`
+	_, err := DB.Exec(query, definition, id)
+	return err
+}
+
 // DeletePipeline deletes a pipeline by ID and all related jobs and job steps (cascade delete)
 func DeletePipeline(id int64) error {
 	// Start a transaction to ensure atomicity
@@ -196,6 +214,17 @@ func DeletePipeline(id int64) error {
 		if err != nil {
 			return err
 		}
+
+		_, err = tx.Exec(`DELETE FROM job_approvals WHERE job_id IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return err
+		}
+
+		// Artifact contents are removed by the artifact cleanup once nothing references them
+		_, err = tx.Exec(`DELETE FROM artifacts WHERE job_id IN (`+placeholders+`)`, args...)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Delete all jobs for this pipeline
@@ -204,6 +233,11 @@ func DeletePipeline(id int64) error {
 		return err
 	}
 
+	_, err = tx.Exec(`DELETE FROM pipeline_grants WHERE pipeline_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
 	// Finally, delete the pipeline itself
 	_, err = tx.Exec(`DELETE FROM pipelines WHERE id = ?`, id)
 	if err != nil {
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"goli/models"
+)
+
+// CreateRegistryCredential stores a new registry login, the token is encrypted at rest
+func CreateRegistryCredential(cred *models.RegistryCredential) (*models.RegistryCredential, error) {
+	encrypted, err := encryptSecret(cred.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO registry_credentials (host, username, token_encrypted)
+			  VALUES (?, ?, ?) RETURNING id, created_at, updated_at`
+
+	err = DB.QueryRow(query, cred.Host, cred.Username, encrypted).Scan(
+		&cred.ID, &cred.CreatedAt, &cred.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+// GetRegistryCredential retrieves a registry login by ID (with decrypted token)
+func GetRegistryCredential(id int64) (*models.RegistryCredential, error) {
+	query := `SELECT id, host, username, token_encrypted, created_at, updated_at
+			  FROM registry_credentials WHERE id = ?`
+	return scanRegistryCredential(DB.QueryRow(query, id))
+}
+
+// GetRegistryCredentialByHost retrieves the registry login for a host (with decrypted token)
+func GetRegistryCredentialByHost(host string) (*models.RegistryCredential, error) {
+	query := `SELECT id, host, username, token_encrypted, created_at, updated_at
+			  FROM registry_credentials WHERE host = ?`
+	return scanRegistryCredential(DB.QueryRow(query, host))
+}
+
+// ListRegistryCredentials retrieves all registry logins (tokens are not loaded)
+func ListRegistryCredentials() ([]*models.RegistryCredential, error) {
+	query := `SELECT id, host, username, created_at, updated_at
+			  FROM registry_credentials ORDER BY host`
+
+	rows, err := DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []*models.RegistryCredential
+	for rows.Next() {
+		cred := &models.RegistryCredential{}
+		if err := rows.Scan(&cred.ID, &cred.Host, &cred.Username, &cred.CreatedAt, &cred.UpdatedAt); err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+// UpdateRegistryCredential updates a registry login; the token is only replaced if updateToken is set
+func UpdateRegistryCredential(cred *models.RegistryCredential, updateToken bool) error {
+	var result sql.Result
+	var err error
+
+	if updateToken {
+		encrypted, encErr := encryptSecret(cred.Token)
+		if encErr != nil {
+			return encErr
+		}
+		query := `UPDATE registry_credentials SET host = ?, username = ?, token_encrypted = ?, updated_at = CURRENT_TIMESTAMP
+				  WHERE id = ?`
+		result, err = DB.Exec(query, cred.Host, cred.Username, encrypted, cred.ID)
+	} else {
+		query := `UPDATE registry_credentials SET host = ?, username = ?, updated_at = CURRENT_TIMESTAMP
+				  WHERE id = ?`
+		result, err = DB.Exec(query, cred.Host, cred.Username, cred.ID)
+	}
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteRegistryCredential deletes a registry login by ID
+func DeleteRegistryCredential(id int64) error {
+	_, err := DB.Exec(`DELETE FROM registry_credentials WHERE id = ?`, id)
+	return err
+}
+
+// scanRegistryCredential scans a single row and decrypts the token
+func scanRegistryCredential(row *sql.Row) (*models.RegistryCredential, error) {
+	cred := &models.RegistryCredential{}
+	var encrypted string
+	err := row.Scan(&cred.ID, &cred.Host, &cred.Username, &encrypted, &cred.CreatedAt, &cred.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	token, err := decryptSecret(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	cred.Token = token
+	return cred, nil
+}
@@ -1,42 +1,84 @@
 package database
 
 import (
+	"database/sql"
 	"time"
 )
 
 type Session struct {
-	ID        int64
-	UserID    int64
-	Token     string
-	ExpiresAt time.Time
-	CreatedAt time.Time
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Username   string     `json:"username,omitempty"`
+	Token      string     `json:"-"` // Never serialize the token
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Current    bool       `json:"current"` // Set by handlers for the session of the request
 }
 
+// sessionColumns are the columns scanSession reads
+const sessionColumns = `s.id, s.user_id, COALESCE(u.username, ''), s.token, COALESCE(s.ip, ''), COALESCE(s.user_agent, ''),
+			  s.last_seen_at, s.expires_at, s.created_at`
+
 // CreateSession inserts a new session for a user
-func CreateSession(userID int64, token string, expiresAt time.Time) (*Session, error) {
-	query := `INSERT INTO sessions (user_id, token, expires_at) VALUES (?, ?, ?)
+func CreateSession(session *Session) (*Session, error) {
+	query := `INSERT INTO sessions (user_id, token, ip, user_agent, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)
 			  RETURNING id, created_at`
-	s := &Session{
-		UserID:    userID,
-		Token:     token,
-		ExpiresAt: expiresAt,
-	}
-	err := DB.QueryRow(query, userID, token, expiresAt).Scan(&s.ID, &s.CreatedAt)
+	now := time.Now().UTC()
+	session.LastSeenAt = &now
+	err := DB.QueryRow(query, session.UserID, session.Token, session.IP, session.UserAgent, now, session.ExpiresAt).Scan(
+		&session.ID, &session.CreatedAt,
+	)
 	if err != nil {
 		return nil, err
 	}
-	return s, nil
+	return session, nil
 }
 
 // GetSessionByToken retrieves a session by its token
 func GetSessionByToken(token string) (*Session, error) {
-	query := `SELECT id, user_id, token, expires_at, created_at FROM sessions WHERE token = ?`
-	s := &Session{}
-	err := DB.QueryRow(query, token).Scan(&s.ID, &s.UserID, &s.Token, &s.ExpiresAt, &s.CreatedAt)
+	query := `SELECT ` + sessionColumns + ` FROM sessions s LEFT JOIN users u ON u.id = s.user_id WHERE s.token = ?`
+	return scanSession(DB.QueryRow(query, token))
+}
+
+// GetSession retrieves a session by ID
+func GetSession(id int64) (*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions s LEFT JOIN users u ON u.id = s.user_id WHERE s.id = ?`
+	return scanSession(DB.QueryRow(query, id))
+}
+
+// ListSessions retrieves the unexpired sessions of a user, or of all users if userID is 0
+func ListSessions(userID int64) ([]*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions s LEFT JOIN users u ON u.id = s.user_id
+			  WHERE (? = 0 OR s.user_id = ?) AND s.expires_at >= ? ORDER BY s.last_seen_at DESC`
+
+	rows, err := DB.Query(query, userID, userID, time.Now().UTC())
 	if err != nil {
 		return nil, err
 	}
-	return s, nil
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// TouchSession records that a session was used from ip and moves its expiry, at most once per
+// lastUsedResolution so that every request does not write to the database
+func TouchSession(id int64, ip string, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	_, err := DB.Exec(`UPDATE sessions SET last_seen_at = ?, ip = ?, expires_at = ? WHERE id = ? AND (last_seen_at IS NULL OR last_seen_at < ?)`,
+		now, ip, expiresAt, id, now.Add(-lastUsedResolution))
+	return err
 }
 
 // DeleteSession deletes a session by token
@@ -45,8 +87,45 @@ func DeleteSession(token string) error {
 	return err
 }
 
+// DeleteSessionByID revokes a session
+func DeleteSessionByID(id int64) error {
+	result, err := DB.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteUserSessions revokes all sessions of a user except the one with ID exceptID (0 to revoke all),
+// and returns how many were revoked
+func DeleteUserSessions(userID int64, exceptID int64) (int64, error) {
+	result, err := DB.Exec(`DELETE FROM sessions WHERE user_id = ? AND id != ?`, userID, exceptID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // CleanupExpiredSessions removes expired sessions
 func CleanupExpiredSessions() error {
 	_, err := DB.Exec(`DELETE FROM sessions WHERE expires_at < ?`, time.Now().UTC())
 	return err
 }
+
+// scanSession scans a row selected with sessionColumns
+func scanSession(row interface{ Scan(...interface{}) error }) (*Session, error) {
+	s := &Session{}
+	err := row.Scan(&s.ID, &s.UserID, &s.Username, &s.Token, &s.IP, &s.UserAgent, &s.LastSeenAt, &s.ExpiresAt, &s.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
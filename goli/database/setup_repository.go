@@ -0,0 +1,74 @@
+package database
+
+import (
+	"errors"
+	"goli/models"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrSetupComplete is returned by BootstrapAdmin once setup is complete
+var ErrSetupComplete = errors.New("setup has already been completed")
+
+// IsSetupComplete reports whether the first admin was created
+func IsSetupComplete() (bool, error) {
+	var complete bool
+	err := DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM setup_state)`).Scan(&complete)
+	return complete, err
+}
+
+// MarkSetupComplete records that setup is complete without creating a user, for installations set up
+// before setup_state existed
+func MarkSetupComplete() error {
+	_, err := DB.Exec(`INSERT OR IGNORE INTO setup_state (id, completed_at) VALUES (1, ?)`, time.Now().UTC())
+	return err
+}
+
+// BootstrapAdmin creates the first admin and marks setup complete in one transaction, so that exactly
+// one bootstrap succeeds. It returns ErrSetupComplete if setup is already complete
+func BootstrapAdmin(user *models.User) (*models.User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user.Role = models.RoleAdmin
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var complete bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM setup_state)`).Scan(&complete); err != nil {
+		return nil, err
+	}
+	if complete {
+		return nil, ErrSetupComplete
+	}
+
+	query := `INSERT INTO users (username, email, phone, password, role, two_fa_email_enabled, two_fa_sms_enabled)
+			  VALUES (?, ?, ?, ?, ?, 0, 0) RETURNING id, created_at, updated_at`
+	err = tx.QueryRow(query, user.Username, user.Email, user.Phone, string(hashedPassword), user.Role).Scan(
+		&user.ID, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// The primary key allows a single row, a concurrent bootstrap fails here or when committing
+	if _, err := tx.Exec(`INSERT INTO setup_state (id, admin_user_id, completed_at) VALUES (1, ?, ?)`, user.ID, time.Now().UTC()); err != nil {
+		if complete, _ := IsSetupComplete(); complete {
+			return nil, ErrSetupComplete
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		if complete, _ := IsSetupComplete(); complete {
+			return nil, ErrSetupComplete
+		}
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"goli/models"
+	"time"
+)
+
+// lastUsedResolution limits how often using a token writes its last-used time
+const lastUsedResolution = time.Minute
+
+// HashAPIToken returns the hash an API token is stored and looked up by. Tokens are long random
+// strings, so a fast hash is enough (unlike passwords)
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken stores a new API token, token.Token must hold the plain token, only its hash is saved
+func CreateAPIToken(token *models.APIToken) (*models.APIToken, error) {
+	scopes, err := json.Marshal(token.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	token.TokenHash = HashAPIToken(token.Token)
+
+	query := `INSERT INTO api_tokens (user_id, name, prefix, token_hash, scopes, expires_at)
+			  VALUES (?, ?, ?, ?, ?, ?) RETURNING id, created_at`
+
+	err = DB.QueryRow(query, token.UserID, token.Name, token.Prefix, token.TokenHash, string(scopes), token.ExpiresAt).Scan(
+		&token.ID, &token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// GetAPITokenByToken retrieves the API token matching a plain token
+func GetAPITokenByToken(token string) (*models.APIToken, error) {
+	query := `SELECT t.id, t.user_id, COALESCE(u.username, ''), t.name, t.prefix, t.scopes, t.expires_at, t.last_used_at, t.created_at
+			  FROM api_tokens t LEFT JOIN users u ON u.id = t.user_id WHERE t.token_hash = ?`
+	return scanAPIToken(DB.QueryRow(query, HashAPIToken(token)))
+}
+
+// GetAPIToken retrieves an API token by ID
+func GetAPIToken(id int64) (*models.APIToken, error) {
+	query := `SELECT t.id, t.user_id, COALESCE(u.username, ''), t.name, t.prefix, t.scopes, t.expires_at, t.last_used_at, t.created_at
+			  FROM api_tokens t LEFT JOIN users u ON u.id = t.user_id WHERE t.id = ?`
+	return scanAPIToken(DB.QueryRow(query, id))
+}
+
+// ListAPITokens retrieves the API tokens of a user, or of all users if userID is 0
+func ListAPITokens(userID int64) ([]*models.APIToken, error) {
+	query := `SELECT t.id, t.user_id, COALESCE(u.username, ''), t.name, t.prefix, t.scopes, t.expires_at, t.last_used_at, t.created_at
+			  FROM api_tokens t LEFT JOIN users u ON u.id = t.user_id
+			  WHERE ? = 0 OR t.user_id = ? ORDER BY t.created_at DESC`
+
+	rows, err := DB.Query(query, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// TouchAPIToken records that a token was used, at most once per lastUsedResolution
+func TouchAPIToken(id int64) error {
+	now := time.Now().UTC()
+	_, err := DB.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`,
+		now, id, now.Add(-lastUsedResolution))
+	return err
+}
+
+// DeleteAPIToken revokes an API token
+func DeleteAPIToken(id int64) error {
+	result, err := DB.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// scanAPIToken reads an API token row selected by the queries above
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*models.APIToken, error) {
+	token := &models.APIToken{}
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	if err := row.Scan(&token.ID, &token.UserID, &token.Username, &token.Name, &token.Prefix, &scopes,
+		&expiresAt, &lastUsedAt, &token.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(scopes), &token.Scopes); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return token, nil
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTPState is the authenticator app enrollment of a user
+type TOTPState struct {
+	Secret   string // Decrypted secret, empty if the user never enrolled
+	Enabled  bool   // False while an enrollment is not confirmed yet
+	LastStep int64  // Time step of the last accepted code
+}
+
+// GetTOTPState retrieves the TOTP enrollment of a user
+func GetTOTPState(userID int64) (*TOTPState, error) {
+	var encrypted sql.NullString
+	state := &TOTPState{}
+	err := DB.QueryRow(`SELECT totp_secret, COALESCE(totp_enabled, 0), COALESCE(totp_last_step, 0) FROM users WHERE id = ?`, userID).Scan(
+		&encrypted, &state.Enabled, &state.LastStep,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypted.String != "" {
+		if state.Secret, err = decryptSecret(encrypted.String); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// SetPendingTOTPSecret stores a new secret for a user, it is only used once EnableTOTP confirms it
+func SetPendingTOTPSecret(userID int64, secret string) error {
+	encrypted, err := encryptSecret(secret)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		encrypted, userID)
+	return err
+}
+
+// EnableTOTP activates the stored secret of a user
+func EnableTOTP(userID int64, step int64) error {
+	_, err := DB.Exec(`UPDATE users SET totp_enabled = 1, totp_last_step = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, step, userID)
+	return err
+}
+
+// UseTOTPStep records an accepted code. It fails with sql.ErrNoRows if the step (or a later one) was
+// already used, so the same code can not be used twice
+func UseTOTPStep(userID int64, step int64) error {
+	result, err := DB.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND COALESCE(totp_last_step, 0) < ?`, step, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DisableTOTP removes the authenticator app enrollment and the recovery codes of a user
+func DisableTOTP(userID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResetTwoFactor turns off every second factor of a user (authenticator app, email, SMS) and removes
+// their recovery codes and pending codes, so they can log in with their password and enroll again
+func ResetTwoFactor(userID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := []string{
+		`UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0, two_fa_email_enabled = 0, two_fa_sms_enabled = 0,
+		 updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM two_factor_codes WHERE user_id = ?`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes hashes and stores a new set of recovery codes, the previous codes stop working
+func ReplaceRecoveryCodes(userID int64, codes []string) error {
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, string(hash))
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks the unused recovery code matching code as used and reports whether there was one
+func UseRecoveryCode(userID int64, code string) (bool, error) {
+	rows, err := DB.Query(`SELECT id, code_hash FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var matched int64
+	for rows.Next() {
+		var id int64
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return false, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			matched = id
+			break
+		}
+	}
+	rows.Close()
+	if matched == 0 {
+		return false, nil
+	}
+
+	// The used_at condition makes concurrent uses of the same code fail
+	result, err := DB.Exec(`UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL`, time.Now().UTC(), matched)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func CountRecoveryCodes(userID int64) (int, error) {
+	var count int
+	err := DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
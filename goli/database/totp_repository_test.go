@@ -0,0 +1,95 @@
+package database
+
+import (
+	"goli/models"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// openTOTPTestDatabase opens a temporary database with its own secret key and returns a new user
+func openTOTPTestDatabase(t *testing.T) int64 {
+	t.Helper()
+	dir := t.TempDir()
+	defaultKeyPath := secretKeyPath
+	secretKeyPath = filepath.Join(dir, "secret.key")
+	secretKey, secretKeyErr, secretKeyOnce = nil, nil, sync.Once{}
+	t.Cleanup(func() {
+		secretKeyPath = defaultKeyPath
+		secretKey, secretKeyErr, secretKeyOnce = nil, nil, sync.Once{}
+	})
+	if err := OpenDatabase(filepath.Join(dir, "goli.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseDatabase() })
+
+	user, err := CreateUser(&models.User{Username: "alice", Password: "Secret-pass-1", Role: models.RoleViewer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID
+}
+
+func TestUseTOTPStepRejectsReplays(t *testing.T) {
+	userID := openTOTPTestDatabase(t)
+
+	if err := SetPendingTOTPSecret(userID, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableTOTP(userID, 100); err != nil {
+		t.Fatal(err)
+	}
+	state, err := GetTOTPState(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Secret != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || !state.Enabled || state.LastStep != 100 {
+		t.Errorf("state = %+v", state)
+	}
+
+	// The code confirming the enrollment can not be used to log in
+	if err := UseTOTPStep(userID, 100); err == nil {
+		t.Error("step of the enrollment accepted again")
+	}
+	if err := UseTOTPStep(userID, 101); err != nil {
+		t.Fatalf("next step rejected: %v", err)
+	}
+	if err := UseTOTPStep(userID, 101); err == nil {
+		t.Error("replayed step accepted")
+	}
+	// An older code that is still within the skew must not be accepted after a newer one
+	if err := UseTOTPStep(userID, 100); err == nil {
+		t.Error("earlier step accepted after a later one")
+	}
+}
+
+func TestUseRecoveryCodeOnce(t *testing.T) {
+	userID := openTOTPTestDatabase(t)
+
+	if err := ReplaceRecoveryCodes(userID, []string{"aaaa-1111", "bbbb-2222"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := UseRecoveryCode(userID, "aaaa-1111"); err != nil || !ok {
+		t.Fatalf("recovery code rejected: %v, %v", ok, err)
+	}
+	if ok, err := UseRecoveryCode(userID, "aaaa-1111"); err != nil || ok {
+		t.Errorf("recovery code accepted twice: %v, %v", ok, err)
+	}
+	if ok, _ := UseRecoveryCode(userID, "cccc-3333"); ok {
+		t.Error("unknown recovery code accepted")
+	}
+	if count, err := CountRecoveryCodes(userID); err != nil || count != 1 {
+		t.Errorf("%d recovery codes left, want 1 (%v)", count, err)
+	}
+
+	// New codes replace the old ones
+	if err := ReplaceRecoveryCodes(userID, []string{"dddd-4444"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := UseRecoveryCode(userID, "bbbb-2222"); ok {
+		t.Error("replaced recovery code accepted")
+	}
+	if ok, err := UseRecoveryCode(userID, "dddd-4444"); err != nil || !ok {
+		t.Errorf("new recovery code rejected: %v, %v", ok, err)
+	}
+}
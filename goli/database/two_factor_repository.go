@@ -32,13 +32,16 @@ func CreateTwoFactorCode(userID int64, channel, code string, expiresAt time.Time
 	return tfc, nil
 }
 
+// MaxTwoFactorCodeAttempts is how many wrong codes may be entered for an issued code before it stops working
+const MaxTwoFactorCodeAttempts = 5
+
 // GetValidTwoFactorCode returns a valid, unconsumed code if present
 func GetValidTwoFactorCode(userID int64, channel, code string) (*TwoFactorCode, error) {
 	query := `SELECT id, user_id, channel, code, expires_at, consumed, created_at
 			  FROM two_factor_codes
-			  WHERE user_id = ? AND channel = ? AND code = ? AND consumed = 0`
+			  WHERE user_id = ? AND channel = ? AND code = ? AND consumed = 0 AND COALESCE(attempts, 0) < ?`
 	tfc := &TwoFactorCode{}
-	err := DB.QueryRow(query, userID, channel, code).Scan(
+	err := DB.QueryRow(query, userID, channel, code, MaxTwoFactorCodeAttempts).Scan(
 		&tfc.ID, &tfc.UserID, &tfc.Channel, &tfc.Code, &tfc.ExpiresAt, &tfc.Consumed, &tfc.CreatedAt,
 	)
 	if err != nil {
@@ -50,6 +53,13 @@ func GetValidTwoFactorCode(userID int64, channel, code string) (*TwoFactorCode,
 	return tfc, nil
 }
 
+// RecordTwoFactorCodeFailure counts a wrong code against every pending code of a user on a channel
+func RecordTwoFactorCodeFailure(userID int64, channel string) error {
+	_, err := DB.Exec(`UPDATE two_factor_codes SET attempts = COALESCE(attempts, 0) + 1
+			  WHERE user_id = ? AND channel = ? AND consumed = 0 AND expires_at > ?`, userID, channel, time.Now().UTC())
+	return err
+}
+
 // ConsumeTwoFactorCode marks a code as consumed
 func ConsumeTwoFactorCode(id int64) error {
 	_, err := DB.Exec(`UPDATE two_factor_codes SET consumed = 1 WHERE id = ?`, id)
@@ -58,6 +68,6 @@ func ConsumeTwoFactorCode(id int64) error {
 
 // CleanupExpiredTwoFactorCodes removes expired codes
 func CleanupExpiredTwoFactorCodes() error {
-	_, err := DB.Exec(`DELETE FROM two_factor_codes WHERE expires_at < ? OR consumed = 1`, time.Now().UTC())
+	_, err := DB.Exec(`DELETE FROM two_factor_codes WHERE expires_at < ? OR consumed = 1 OR attempts >= ?`, time.Now().UTC(), MaxTwoFactorCodeAttempts)
 	return err
 }
\ No newline at end of file
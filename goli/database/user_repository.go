@@ -2,6 +2,7 @@ package database
 
 import (
 	"goli/models"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -30,11 +31,11 @@ func CreateUser(user *models.User) (*models.User, error) {
 // GetUser retrieves a user by ID
 func GetUser(id int64) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, phone, password, role, two_fa_email_enabled, two_fa_sms_enabled, created_at, updated_at 
+	query := `SELECT id, username, email, phone, password, role, two_fa_email_enabled, two_fa_sms_enabled, COALESCE(totp_enabled, 0), COALESCE(failed_logins, 0), locked_at, created_at, updated_at 
 			  FROM users WHERE id = ?`
 
 	err := DB.QueryRow(query, id).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Phone, &user.PasswordHash, &user.Role, &user.TwoFAEmailEnabled, &user.TwoFASmsEnabled, &user.CreatedAt, &user.UpdatedAt,
+		&user.ID, &user.Username, &user.Email, &user.Phone, &user.PasswordHash, &user.Role, &user.TwoFAEmailEnabled, &user.TwoFASmsEnabled, &user.TwoFATOTPEnabled, &user.FailedLogins, &user.LockedAt, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -44,7 +45,7 @@ func GetUser(id int64) (*models.User, error) {
 
 // ListUsers retrieves all users (without passwords)
 func ListUsers() ([]*models.User, error) {
-	query := `SELECT id, username, email, phone, role, two_fa_email_enabled, two_fa_sms_enabled, created_at, updated_at 
+	query := `SELECT id, username, email, phone, role, two_fa_email_enabled, two_fa_sms_enabled, COALESCE(totp_enabled, 0), COALESCE(failed_logins, 0), locked_at, created_at, updated_at 
 			  FROM users ORDER BY created_at DESC`
 
 	rows, err := DB.Query(query)
@@ -58,7 +59,7 @@ func ListUsers() ([]*models.User, error) {
 		user := &models.User{}
 		err := rows.Scan(
 			&user.ID, &user.Username, &user.Email, &user.Phone,
-			&user.Role, &user.TwoFAEmailEnabled, &user.TwoFASmsEnabled, &user.CreatedAt, &user.UpdatedAt,
+			&user.Role, &user.TwoFAEmailEnabled, &user.TwoFASmsEnabled, &user.TwoFATOTPEnabled, &user.FailedLogins, &user.LockedAt, &user.CreatedAt, &user.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -95,11 +96,11 @@ func UpdateUser(user *models.User, updatePassword bool) error {
 // GetUserByUsername retrieves a user by username
 func GetUserByUsername(username string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, phone, password, role, two_fa_email_enabled, two_fa_sms_enabled, created_at, updated_at 
+	query := `SELECT id, username, email, phone, password, role, two_fa_email_enabled, two_fa_sms_enabled, COALESCE(totp_enabled, 0), COALESCE(failed_logins, 0), locked_at, created_at, updated_at 
 			  FROM users WHERE username = ?`
 
 	err := DB.QueryRow(query, username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Phone, &user.PasswordHash, &user.Role, &user.TwoFAEmailEnabled, &user.TwoFASmsEnabled, &user.CreatedAt, &user.UpdatedAt,
+		&user.ID, &user.Username, &user.Email, &user.Phone, &user.PasswordHash, &user.Role, &user.TwoFAEmailEnabled, &user.TwoFASmsEnabled, &user.TwoFATOTPEnabled, &user.FailedLogins, &user.LockedAt, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -107,9 +108,70 @@ func GetUserByUsername(username string) (*models.User, error) {
 	return user, nil
 }
 
-// DeleteUser deletes a user by ID
+// DeleteUser deletes a user by ID together with their pipeline grants, API tokens, recovery codes, sessions
+// and password resets
 func DeleteUser(id int64) error {
-	query := `DELETE FROM users WHERE id = ?`
-	_, err := DB.Exec(query, id)
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM pipeline_grants WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM api_tokens WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RecordFailedLogin counts a failed login of a user and locks the account once maxFailures consecutive
+// logins failed (0 never locks). It reports whether this failure locked the account
+func RecordFailedLogin(userID int64, maxFailures int) (bool, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var failures int
+	err = tx.QueryRow(`UPDATE users SET failed_logins = COALESCE(failed_logins, 0) + 1 WHERE id = ? RETURNING failed_logins`, userID).Scan(&failures)
+	if err != nil {
+		return false, err
+	}
+
+	locked := false
+	if maxFailures > 0 && failures >= maxFailures {
+		result, err := tx.Exec(`UPDATE users SET locked_at = ? WHERE id = ? AND locked_at IS NULL`, time.Now().UTC(), userID)
+		if err != nil {
+			return false, err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		locked = rowsAffected > 0
+	}
+
+	return locked, tx.Commit()
+}
+
+// ResetFailedLogins clears the failed login count and unlocks the account of a user
+func ResetFailedLogins(userID int64) error {
+	_, err := DB.Exec(`UPDATE users SET failed_logins = 0, locked_at = NULL WHERE id = ?`, userID)
 	return err
 }
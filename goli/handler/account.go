@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"database/sql"
+	aux "goli/auxiliary"
+	"goli/database"
+	"goli/models"
+	response_util "goli/utils"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultPasswordResetMinutes is how long an emailed reset link is valid, unless password_reset_minutes is set
+const defaultPasswordResetMinutes = 30
+
+// GetMeHandler returns the profile of the current user
+func GetMeHandler(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	response_util.SendJsonResponseGin(c, 200, user)
+}
+
+// UpdateMeHandler updates the email, phone and 2FA channels of the current user
+func UpdateMeHandler(c *gin.Context) {
+	var body models.ProfileUpdateRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	before := *user
+
+	if body.Email != nil {
+		user.Email = strings.TrimSpace(*body.Email)
+	}
+	if body.Phone != nil {
+		user.Phone = strings.TrimSpace(*body.Phone)
+	}
+	for _, flag := range []struct {
+		value *int
+		dest  *int
+		name  string
+	}{{body.TwoFAEmailEnabled, &user.TwoFAEmailEnabled, "two_fa_email_enabled"}, {body.TwoFASmsEnabled, &user.TwoFASmsEnabled, "two_fa_sms_enabled"}} {
+		if flag.value == nil {
+			continue
+		}
+		if *flag.value != 0 && *flag.value != 1 {
+			response_util.SendBadRequestResponseGin(c, flag.name+" must be 0 or 1")
+			return
+		}
+		*flag.dest = *flag.value
+	}
+
+	// A channel without an address would lock the user out at the next login
+	if user.TwoFAEmailEnabled == 1 && user.Email == "" {
+		response_util.SendBadRequestResponseGin(c, "Email 2FA needs an email address")
+		return
+	}
+	if user.TwoFASmsEnabled == 1 && user.Phone == "" {
+		response_util.SendBadRequestResponseGin(c, "SMS 2FA needs a phone number")
+		return
+	}
+
+	if err := database.UpdateUser(user, false); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to update profile: "+err.Error())
+		return
+	}
+
+	auditLog(c, "user.profile.update", "user", user.ID, &before, user)
+
+	response_util.SendJsonResponseGin(c, 200, user)
+}
+
+// ChangePasswordHandler changes the password of the current user after verifying the current one, and
+// revokes the user's other sessions
+func ChangePasswordHandler(c *gin.Context) {
+	var body models.PasswordChangeRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if body.CurrentPassword == "" || body.NewPassword == "" {
+		response_util.SendBadRequestResponseGin(c, "Current and new password are required")
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	// Guessing the current password is throttled like logins, but does not lock the account
+	if checkLoginBackoff(c, user.Username) {
+		return
+	}
+	userProj, err := getUserProjectionByUsername(user.Username)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to load user: "+err.Error())
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(userProj.Password), []byte(body.CurrentPassword)) != nil {
+		loginLimiter.fail(loginLimiterKeys(c, user.Username)...)
+		response_util.SendForbiddenResponseGin(c, "Current password is incorrect")
+		return
+	}
+
+	if body.NewPassword == body.CurrentPassword {
+		response_util.SendBadRequestResponseGin(c, "New password must differ from the current password")
+		return
+	}
+	if err := response_util.ValidatePassword(body.NewPassword, user.Username); err != nil {
+		response_util.SendBadRequestResponseGin(c, err.Error())
+		return
+	}
+
+	revoked, err := database.ChangePassword(user.ID, body.NewPassword, c.GetInt64("session_id"))
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to change password: "+err.Error())
+		return
+	}
+
+	auditLog(c, "user.password.change", "user", user.ID, nil, gin.H{"username": user.Username, "sessions_revoked": revoked})
+
+	response_util.SendJsonResponseGin(c, 200, gin.H{
+		"message":          "Password changed",
+		"sessions_revoked": revoked,
+	})
+}
+
+// ForgotPasswordHandler emails a one-time password reset link to the user with the given username or
+// email address (no auth required). It answers the same whether or not such a user exists
+func ForgotPasswordHandler(c *gin.Context) {
+	var body struct {
+		Login string `json:"login"` // Username or email address
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+	body.Login = strings.TrimSpace(body.Login)
+	if body.Login == "" {
+		response_util.SendBadRequestResponseGin(c, "Username or email is required")
+		return
+	}
+
+	resetURL := strings.TrimSpace(aux.GetFromConfig("constants.password_reset_url"))
+	if resetURL == "" {
+		response_util.SendNotFoundResponseGin(c, "Password reset is not configured")
+		return
+	}
+
+	if throttlePasswordReset(c, body.Login) {
+		return
+	}
+
+	const sent = "If the account exists and has an email address, a reset link was sent to it"
+	user, err := database.GetUserForPasswordReset(body.Login)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to look up %q for a password reset: %v", body.Login, err)
+		}
+		response_util.SendOkResponseGin(c, sent)
+		return
+	}
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed generating reset token")
+		return
+	}
+	validFor := time.Duration(aux.GetIntFromConfig("constants.password_reset_minutes", defaultPasswordResetMinutes)) * time.Minute
+	if err := database.CreatePasswordReset(user.ID, token, time.Now().UTC().Add(validFor)); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to create password reset: "+err.Error())
+		return
+	}
+
+	separator := "?"
+	if strings.Contains(resetURL, "?") {
+		separator = "&"
+	}
+	link := resetURL + separator + "token=" + token
+	// Send email asynchronously, the response must not tell whether the user exists
+	go func(email string) {
+		if err := response_util.SendPasswordResetEmail(email, link, validFor); err != nil {
+			log.Printf("Failed to send password reset email: %v", err)
+		}
+	}(user.Email)
+
+	auditLog(c, "user.password.reset_request", "user", user.ID, nil, gin.H{"username": user.Username})
+
+	response_util.SendOkResponseGin(c, sent)
+}
+
+// ResetPasswordHandler sets a new password with the token of a reset link (no auth required). The token
+// is used up, and the user is unlocked and logged out everywhere
+func ResetPasswordHandler(c *gin.Context) {
+	var body models.PasswordResetRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+	if body.Token == "" || body.NewPassword == "" {
+		response_util.SendBadRequestResponseGin(c, "Token and new password are required")
+		return
+	}
+
+	userID, err := database.GetPasswordResetUser(body.Token)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid or expired reset link")
+		return
+	}
+	user, err := database.GetUser(userID)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid or expired reset link")
+		return
+	}
+	if err := response_util.ValidatePassword(body.NewPassword, user.Username); err != nil {
+		response_util.SendBadRequestResponseGin(c, err.Error())
+		return
+	}
+
+	if _, err := database.ResetPassword(body.Token, body.NewPassword); err != nil {
+		if err == sql.ErrNoRows {
+			response_util.SendBadRequestResponseGin(c, "Invalid or expired reset link")
+			return
+		}
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to reset password: "+err.Error())
+		return
+	}
+	loginLimiter.reset("user:" + strings.ToLower(user.Username))
+
+	auditLog(c, "user.password.reset", "user", user.ID, nil, gin.H{"username": user.Username})
+
+	response_util.SendOkResponseGin(c, "Password reset, log in with the new password")
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"goli/database"
+	"goli/pipeline"
+	response_util "goli/utils"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListJobArtifactsHandler lists the artifacts archived by the steps of a job
+func ListJobArtifactsHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid job ID")
+		return
+	}
+
+	if _, err := database.GetJob(id); err != nil {
+		response_util.SendNotFoundResponseGin(c, "Job not found")
+		return
+	}
+
+	artifacts, err := database.ListJobArtifacts(id)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to list artifacts: "+err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, artifacts)
+}
+
+// DownloadJobArtifactHandler downloads a single artifact of a job
+func DownloadJobArtifactHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid job ID")
+		return
+	}
+	artifactID, err := strconv.ParseInt(c.Param("artifact_id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid artifact ID")
+		return
+	}
+
+	artifact, err := database.GetJobArtifact(id, artifactID)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "Artifact not found")
+		return
+	}
+
+	blob := pipeline.ArtifactBlobPath(artifact.SHA256)
+	if _, err := os.Stat(blob); err != nil {
+		response_util.SendNotFoundResponseGin(c, "Artifact content not found")
+		return
+	}
+
+	c.Header("X-Goli-Artifact-Path", artifact.Path)
+	c.Header("X-Goli-Artifact-Sha256", artifact.SHA256)
+	c.FileAttachment(blob, path.Base(artifact.Path))
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"encoding/json"
+	"goli/database"
+	"goli/middlewares"
+	"goli/models"
+	response_util "goli/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditLog records who performed an action on which target in the audit log. before and after are
+// snapshots of the target, nil for creations and deletions; only changed fields are kept and secrets
+// are redacted
+func auditLog(c *gin.Context, action, targetType string, targetID interface{}, before, after interface{}) {
+	middlewares.RecordAudit(c, action, targetType, targetID, before, after)
+}
+
+// auditedUser is a user snapshot for the audit log. Passwords are never serialized, so a password
+// change shows up as password_changed
+type auditedUser struct {
+	*models.User
+	PasswordChanged bool `json:"password_changed,omitempty"`
+}
+
+// ListAuditEventsHandler returns audit events, newest first, filtered by actor, action (pipeline.* for a
+// prefix), target_type, target_id, since and until. With format=jsonl every matching event is streamed
+// as one JSON object per line for export
+func ListAuditEventsHandler(c *gin.Context) {
+	filter := models.AuditFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+	}
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := c.Query(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				response_util.SendBadRequestResponseGin(c, "Invalid "+param.name+", expected an RFC 3339 time")
+				return
+			}
+			*param.dest = &t
+		}
+	}
+
+	if c.Query("format") == "jsonl" {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", `attachment; filename="goli-audit.jsonl"`)
+		c.Status(200)
+		encoder := json.NewEncoder(c.Writer)
+		if err := database.ForEachAuditEvent(filter, func(event *models.AuditEvent) error {
+			return encoder.Encode(event)
+		}); err != nil {
+			// The status is already sent, end the stream with the error
+			_ = encoder.Encode(gin.H{"status": "error", "description": "Failed to export audit events: " + err.Error()})
+		}
+		return
+	}
+
+	filter.Limit = 100
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > 1000 {
+			response_util.SendBadRequestResponseGin(c, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = n
+	}
+	if offset := c.Query("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			response_util.SendBadRequestResponseGin(c, "Invalid offset")
+			return
+		}
+		filter.Offset = n
+	}
+
+	events, err := database.ListAuditEvents(filter)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to list audit events: "+err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, events)
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"goli/database"
+	"goli/middlewares"
 	"goli/models"
 	response_util "goli/utils"
 	"strings"
@@ -13,6 +14,9 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// totpChallengeChannel marks the pending login of a user with an authenticator app in two_factor_codes
+const totpChallengeChannel = "totp"
+
 func generateRandomToken(nBytes int) (string, error) {
 	b := make([]byte, nBytes)
 	_, err := rand.Read(b)
@@ -22,6 +26,26 @@ func generateRandomToken(nBytes int) (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
+// newSession creates a session of a user for the client of the request and returns its token, for every
+// way of logging in
+func newSession(c *gin.Context, userID int64) (string, time.Time, error) {
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	session, err := database.CreateSession(&database.Session{
+		UserID:    userID,
+		Token:     token,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		ExpiresAt: middlewares.SessionExpiry(time.Now()),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, session.ExpiresAt, nil
+}
+
 // LoginHandler verifies username/password and either issues a session or requires 2FA
 func LoginHandler(c *gin.Context) {
 	var body struct {
@@ -38,15 +62,24 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
+	if checkLoginBackoff(c, body.Username) {
+		return
+	}
+
 	// Get user projection for password and 2FA checks
 	userProj, err := getUserProjectionByUsername(body.Username)
 	if err != nil {
+		recordLoginFailure(c, body.Username, 0)
 		response_util.SendUnauthorizedResponseGin(c, "Invalid credentials")
 		return
 	}
+	if checkAccountLock(c, userProj) {
+		return
+	}
 
 	// Verify password
 	if bcrypt.CompareHashAndPassword([]byte(userProj.Password), []byte(body.Password)) != nil {
+		recordLoginFailure(c, userProj.Username, userProj.ID)
 		response_util.SendUnauthorizedResponseGin(c, "Invalid credentials")
 		return
 	}
@@ -87,6 +120,16 @@ func LoginHandler(c *gin.Context) {
 			sent = true
 		}
 
+		// Authenticator app codes need no delivery, the challenge only records that the password was
+		// verified, Verify2FAHandler accepts authenticator and recovery codes only while it is pending
+		if userProj.twoFATOTPEnabled {
+			if _, err := database.CreateTwoFactorCode(userProj.ID, totpChallengeChannel, "", expires); err != nil {
+				response_util.SendInternalServerErrorResponseGin(c, "Failed creating 2FA challenge")
+				return
+			}
+			sent = true
+		}
+
 		if !sent {
 			response_util.SendBadRequestResponseGin(c, "No valid 2FA delivery channel configured")
 			return
@@ -100,16 +143,12 @@ func LoginHandler(c *gin.Context) {
 	}
 
 	// No 2FA: issue session
-	token, err := generateRandomToken(32)
+	token, expires, err := newSession(c, userProj.ID)
 	if err != nil {
-		response_util.SendInternalServerErrorResponseGin(c, "Failed generating token")
-		return
-	}
-	expires := time.Now().UTC().Add(24 * time.Hour)
-	if _, err := database.CreateSession(userProj.ID, token, expires); err != nil {
 		response_util.SendInternalServerErrorResponseGin(c, "Failed creating session")
 		return
 	}
+	recordLoginSuccess(c, userProj.Username, userProj.ID)
 	response_util.SendJsonResponseGin(c, 200, gin.H{
 		"token":      token,
 		"expires_at": expires,
@@ -137,28 +176,58 @@ func Verify2FAHandler(c *gin.Context) {
 		response_util.SendBadRequestResponseGin(c, "Username, channel and code are required")
 		return
 	}
+	if checkLoginBackoff(c, body.Username) {
+		return
+	}
 	userProj, err := getUserProjectionByUsername(body.Username)
 	if err != nil {
+		recordLoginFailure(c, body.Username, 0)
 		response_util.SendUnauthorizedResponseGin(c, "Invalid credentials")
 		return
 	}
-	tfc, err := database.GetValidTwoFactorCode(userProj.ID, strings.ToLower(body.Channel), body.Code)
-	if err != nil {
-		response_util.SendUnauthorizedResponseGin(c, "Invalid or expired code")
+	if checkAccountLock(c, userProj) {
 		return
 	}
-	_ = database.ConsumeTwoFactorCode(tfc.ID)
 
-	token, err := generateRandomToken(32)
-	if err != nil {
-		response_util.SendInternalServerErrorResponseGin(c, "Failed generating token")
-		return
+	// Wrong codes count against the issued code and the account like wrong passwords
+	fail := func(channel string) {
+		_ = database.RecordTwoFactorCodeFailure(userProj.ID, channel)
+		recordLoginFailure(c, userProj.Username, userProj.ID)
+		response_util.SendUnauthorizedResponseGin(c, "Invalid or expired code")
+	}
+	switch channel := strings.ToLower(body.Channel); channel {
+	case "totp", "recovery":
+		challenge, err := database.GetValidTwoFactorCode(userProj.ID, totpChallengeChannel, "")
+		if err != nil {
+			fail(totpChallengeChannel)
+			return
+		}
+		var valid bool
+		if channel == "totp" {
+			valid, err = checkTOTPCode(userProj.ID, body.Code)
+		} else {
+			valid, err = database.UseRecoveryCode(userProj.ID, normalizeRecoveryCode(body.Code))
+		}
+		if err != nil || !valid {
+			fail(totpChallengeChannel)
+			return
+		}
+		_ = database.ConsumeTwoFactorCode(challenge.ID)
+	default:
+		tfc, err := database.GetValidTwoFactorCode(userProj.ID, channel, body.Code)
+		if err != nil {
+			fail(channel)
+			return
+		}
+		_ = database.ConsumeTwoFactorCode(tfc.ID)
 	}
-	expires := time.Now().UTC().Add(24 * time.Hour)
-	if _, err := database.CreateSession(userProj.ID, token, expires); err != nil {
+
+	token, expires, err := newSession(c, userProj.ID)
+	if err != nil {
 		response_util.SendInternalServerErrorResponseGin(c, "Failed creating session")
 		return
 	}
+	recordLoginSuccess(c, userProj.Username, userProj.ID)
 	response_util.SendJsonResponseGin(c, 200, gin.H{
 		"token":      token,
 		"expires_at": expires,
@@ -197,7 +266,7 @@ func LogoutHandler(c *gin.Context) {
 // Helpers
 
 func userTwoFAEnabled(user *databaseUserProjection) bool {
-	return user.twoFAEmailEnabled || user.twoFASmsEnabled
+	return user.twoFAEmailEnabled || user.twoFASmsEnabled || user.twoFATOTPEnabled
 }
 
 func availableChannels(user *databaseUserProjection) []string {
@@ -208,6 +277,9 @@ func availableChannels(user *databaseUserProjection) []string {
 	if user.twoFASmsEnabled && user.Phone != "" {
 		ch = append(ch, "sms")
 	}
+	if user.twoFATOTPEnabled {
+		ch = append(ch, "totp", "recovery")
+	}
 	return ch
 }
 
@@ -220,6 +292,8 @@ type databaseUserProjection struct {
 	Role              string
 	twoFAEmailEnabled bool
 	twoFASmsEnabled   bool
+	twoFATOTPEnabled  bool
+	LockedAt          *time.Time
 	Password          string
 }
 
@@ -237,6 +311,8 @@ func getUserProjectionByUsername(username string) (*databaseUserProjection, erro
 		Role:              u.Role,
 		twoFAEmailEnabled: uTwoFAEmail(u),
 		twoFASmsEnabled:   uTwoFASms(u),
+		twoFATOTPEnabled:  u.TwoFATOTPEnabled == 1,
+		LockedAt:          u.LockedAt,
 		Password:          u.PasswordHash, // Use PasswordHash from DB
 	}, nil
 }
@@ -245,6 +321,22 @@ func getUserProjectionByUsername(username string) (*databaseUserProjection, erro
 func uTwoFAEmail(u *models.User) bool { return u.TwoFAEmailEnabled == 1 }
 func uTwoFASms(u *models.User) bool   { return u.TwoFASmsEnabled == 1 }
 
+// currentUser loads the authenticated user, responding with an error for the legacy auth key, which
+// belongs to no user
+func currentUser(c *gin.Context) (*models.User, bool) {
+	userID := c.GetInt64("user_id")
+	if userID == 0 {
+		response_util.SendBadRequestResponseGin(c, "This endpoint needs a user, log in as a user")
+		return nil, false
+	}
+	user, err := database.GetUser(userID)
+	if err != nil {
+		response_util.SendUnauthorizedResponseGin(c, "User not found")
+		return nil, false
+	}
+	return user, true
+}
+
 func generateNumericCode(n int) string {
 	const digits = "0123456789"
 	b := make([]byte, n)
@@ -2,7 +2,10 @@ package handler
 
 import (
 	aux "goli/auxiliary"
+	"goli/database"
+	"goli/middlewares"
 	response_util "goli/utils"
+	"log"
 
 	"github.com/gin-gonic/gin"
 )
@@ -10,14 +13,13 @@ import (
 // GetConfigHandler returns the current configuration
 func GetConfigHandler(c *gin.Context) {
 	config := aux.GetAllConfig()
-
-	// Convert setup_complete string to boolean
-	setupComplete := config["setup_complete"] == "true"
+	setupComplete := isSetupComplete()
 
 	response_util.SendJsonResponseGin(c, 200, gin.H{
 		"host":            config["host"],
 		"port":            config["port"],
 		"auth_key":        config["auth_key"],
+		"auth_key_mode":   config["auth_key_mode"],
 		"setup_complete":  setupComplete,
 		"gh_username":     config["gh_username"],
 		"gh_access_token": config["gh_access_token"],
@@ -36,6 +38,7 @@ func UpdateConfigHandler(c *gin.Context) {
 		Host          string `json:"host,omitempty"`
 		Port          string `json:"port,omitempty"`
 		AuthKey       string `json:"auth_key,omitempty"`
+		AuthKeyMode   string `json:"auth_key_mode,omitempty"` // enabled, deprecated or disabled
 		SetupComplete *bool  `json:"setup_complete,omitempty"`
 		GHUsername    string `json:"gh_username,omitempty"`
 		GHAccessToken string `json:"gh_access_token,omitempty"`
@@ -66,14 +69,29 @@ func UpdateConfigHandler(c *gin.Context) {
 		updates["auth_key"] = body.AuthKey
 	}
 
+	if body.AuthKeyMode != "" {
+		switch body.AuthKeyMode {
+		case middlewares.AuthKeyEnabled, middlewares.AuthKeyDeprecated, middlewares.AuthKeyDisabled:
+			updates["auth_key_mode"] = body.AuthKeyMode
+		default:
+			response_util.SendBadRequestResponseGin(c, "auth_key_mode must be enabled, deprecated or disabled")
+			return
+		}
+	}
+
+	// Setup completion is recorded in the database and can not be undone
 	if body.SetupComplete != nil {
-		if *body.SetupComplete {
-			updates["setup_complete"] = "true"
-			// Invalidate setup password after successful setup
-			updates["setup_password"] = ""
-		} else {
-			updates["setup_complete"] = "false"
+		if !*body.SetupComplete {
+			response_util.SendBadRequestResponseGin(c, "Setup can not be reopened once complete")
+			return
 		}
+		if err := database.MarkSetupComplete(); err != nil {
+			response_util.SendInternalServerErrorResponseGin(c, "Failed to complete setup: "+err.Error())
+			return
+		}
+		updates["setup_complete"] = "true"
+		// Invalidate setup password after successful setup
+		updates["setup_password"] = ""
 	}
 
 	if body.GHUsername != "" {
@@ -113,10 +131,12 @@ func UpdateConfigHandler(c *gin.Context) {
 		return
 	}
 
+	before := aux.GetAllConfig()
 	if err := aux.UpdateConfig(updates); err != nil {
 		response_util.SendInternalServerErrorResponseGin(c, "Failed to update config: "+err.Error())
 		return
 	}
+	auditLog(c, "config.update", "config", nil, before, aux.GetAllConfig())
 
 	// If GitHub credentials were updated, automatically authenticate with GitHub Container Registry
 	if body.GHUsername != "" || body.GHAccessToken != "" {
@@ -133,12 +153,13 @@ func UpdateConfigHandler(c *gin.Context) {
 
 	// Return updated config
 	config := aux.GetAllConfig()
-	setupComplete := config["setup_complete"] == "true"
+	setupComplete := isSetupComplete()
 
 	response_util.SendJsonResponseGin(c, 200, gin.H{
 		"host":            config["host"],
 		"port":            config["port"],
 		"auth_key":        config["auth_key"],
+		"auth_key_mode":   config["auth_key_mode"],
 		"setup_complete":  setupComplete,
 		"gh_username":     config["gh_username"],
 		"gh_access_token": config["gh_access_token"],
@@ -150,3 +171,12 @@ func UpdateConfigHandler(c *gin.Context) {
 		"smtp_from_name":  config["smtp_from_name"],
 	})
 }
+
+// isSetupComplete reports whether setup is complete, read errors count as incomplete
+func isSetupComplete() bool {
+	complete, err := database.IsSetupComplete()
+	if err != nil {
+		log.Printf("Failed to read setup state: %v", err)
+	}
+	return complete
+}
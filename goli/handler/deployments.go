@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"goli/database"
+	"goli/models"
+	response_util "goli/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deploymentView describes a container together with the image digest it runs and the job that deployed it
+type deploymentView struct {
+	ContainerID   string             `json:"container_id"`
+	ContainerName string             `json:"container_name"`
+	Image         string             `json:"image"`
+	ImageID       string             `json:"image_id"`
+	Digest        string             `json:"digest,omitempty"`
+	State         string             `json:"state"`
+	Labels        map[string]string  `json:"labels,omitempty"`
+	DeployedBy    *deploymentJobInfo `json:"deployed_by,omitempty"`
+}
+
+// deploymentJobInfo identifies the job and step that started a container
+type deploymentJobInfo struct {
+	JobID       int64     `json:"job_id"`
+	JobName     string    `json:"job_name,omitempty"`
+	StepID      int64     `json:"step_id"`
+	PipelineID  *int64    `json:"pipeline_id,omitempty"`
+	TriggeredBy string    `json:"triggered_by,omitempty"`
+	DeployedAt  time.Time `json:"deployed_at"`
+}
+
+// ListDeploymentsHandler lists containers with the image digest they currently run and the job that deployed them
+// Query: ?all=true includes stopped containers, ?managed=true only returns containers deployed by Goli
+func ListDeploymentsHandler(c *gin.Context) {
+	var filters []string
+	if c.Query("all") != "true" {
+		filters = append(filters, "status=running")
+	}
+
+	containers, err := ListDockerContainers(c.Request.Context(), filters, false)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, err.Error())
+		return
+	}
+
+	deployments, err := database.GetLatestDeploymentsByContainerID()
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to load deployments: "+err.Error())
+		return
+	}
+
+	views := []deploymentView{}
+	if len(containers) == 0 {
+		response_util.SendJsonResponseGin(c, 200, views)
+		return
+	}
+
+	ids := make([]string, 0, len(containers))
+	for _, container := range containers {
+		ids = append(ids, container.ID)
+	}
+	infos, err := response_util.InspectContainers(c.Request.Context(), ids...)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, err.Error())
+		return
+	}
+
+	// Resolve the digest of every distinct image ID once
+	imageRefs := make(map[string]string) // image ID -> reference used to run it
+	for _, info := range infos {
+		imageRefs[info.Image] = info.Config.Image
+	}
+	imageIDs := make([]string, 0, len(imageRefs))
+	for id := range imageRefs {
+		imageIDs = append(imageIDs, id)
+	}
+	digests := make(map[string]string)
+	if images, err := response_util.InspectImages(c.Request.Context(), imageIDs...); err == nil {
+		for _, img := range images {
+			digests[img.ID] = response_util.DigestForImage(imageRefs[img.ID], img)
+		}
+	}
+
+	jobs := make(map[int64]*models.Job)
+	for _, info := range infos {
+		view := deploymentView{
+			ContainerID:   info.ID,
+			ContainerName: info.Name,
+			Image:         info.Config.Image,
+			ImageID:       info.Image,
+			Digest:        digests[info.Image],
+			State:         info.State.Status,
+			Labels:        info.Config.Labels,
+		}
+
+		if d, ok := deployments[info.ID]; ok {
+			view.DeployedBy = &deploymentJobInfo{
+				JobID:      d.JobID,
+				StepID:     d.StepID,
+				PipelineID: d.PipelineID,
+				DeployedAt: d.CreatedAt,
+			}
+			job, loaded := jobs[d.JobID]
+			if !loaded {
+				job, _ = database.GetJob(d.JobID)
+				jobs[d.JobID] = job
+			}
+			if job != nil {
+				view.DeployedBy.JobName = job.Name
+				view.DeployedBy.TriggeredBy = job.TriggeredBy
+			}
+		} else if c.Query("managed") == "true" {
+			continue
+		}
+
+		views = append(views, view)
+	}
+
+	response_util.SendJsonResponseGin(c, 200, views)
+}
@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"goli/types"
+	response_util "goli/utils"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Container states accepted by `docker ps --filter status=...`
+var dockerContainerStatuses = map[string]bool{
+	"created":    true,
+	"restarting": true,
+	"running":    true,
+	"removing":   true,
+	"paused":     true,
+	"exited":     true,
+	"dead":       true,
+}
+
+// ListDockerContainersHandler returns all containers as a JSON array
+// Query: ?label=key[=value] (repeatable), ?name=, ?status=, ?size=true
+func ListDockerContainersHandler(c *gin.Context) {
+	status := strings.TrimSpace(c.Query("status"))
+	if status != "" && !dockerContainerStatuses[status] {
+		response_util.SendBadRequestResponseGin(c, "Invalid status filter: "+status)
+		return
+	}
+
+	var filters []string
+	for _, label := range c.QueryArray("label") {
+		if strings.TrimSpace(label) != "" {
+			filters = append(filters, "label="+label)
+		}
+	}
+	if name := strings.TrimSpace(c.Query("name")); name != "" {
+		filters = append(filters, "name="+name)
+	}
+	if status != "" {
+		filters = append(filters, "status="+status)
+	}
+
+	containers, err := ListDockerContainers(c.Request.Context(), filters, c.Query("size") == "true")
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, containers)
+}
+
+// ListDockerImagesHandler returns all images as a JSON array
+// Query: ?label=key[=value] (repeatable), ?name= (matched as image reference)
+func ListDockerImagesHandler(c *gin.Context) {
+	var filters []string
+	for _, label := range c.QueryArray("label") {
+		if strings.TrimSpace(label) != "" {
+			filters = append(filters, "label="+label)
+		}
+	}
+	if name := strings.TrimSpace(c.Query("name")); name != "" {
+		filters = append(filters, "reference="+name)
+	}
+
+	images, err := ListDockerImages(c.Request.Context(), filters)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, images)
+}
+
+// ListDockerContainers runs `docker ps -a` and parses its JSON lines output, the labels are loaded with
+// a single `docker container inspect`. Each filter is passed through as `--filter <filter>`
+func ListDockerContainers(ctx context.Context, filters []string, withSize bool) ([]types.DockerContainer, error) {
+	args := []string{"ps", "-a", "--no-trunc", "--format", "{{json .}}"}
+	if withSize {
+		args = append(args, "--size")
+	}
+	for _, f := range filters {
+		args = append(args, "--filter", f)
+	}
+
+	stdout, _, err := executeDockerCommand(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	containers := []types.DockerContainer{}
+	err = forEachJSONLine(stdout, func(line []byte) error {
+		var raw struct {
+			ID        string `json:"ID"`
+			Names     string `json:"Names"`
+			Image     string `json:"Image"`
+			Command   string `json:"Command"`
+			State     string `json:"State"`
+			Status    string `json:"Status"`
+			Ports     string `json:"Ports"`
+			CreatedAt string `json:"CreatedAt"`
+			Size      string `json:"Size"`
+		}
+		if err := json.Unmarshal(line, &raw); err != nil {
+			return err
+		}
+		containers = append(containers, types.DockerContainer{
+			ID:      raw.ID,
+			Names:   splitDockerList(raw.Names),
+			Image:   raw.Image,
+			Command: strings.Trim(raw.Command, `"`),
+			State:   raw.State,
+			Status:  raw.Status,
+			Ports:   splitDockerList(raw.Ports),
+			Labels:  map[string]string{},
+			Created: raw.CreatedAt,
+			Size:    raw.Size,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse container list: %w", err)
+	}
+
+	ids := make([]string, len(containers))
+	for i, container := range containers {
+		ids[i] = container.ID
+	}
+	labelsByID := inspectDockerLabels(ctx, "container", ids)
+	for i := range containers {
+		if labels, ok := labelsByID[containers[i].ID]; ok {
+			containers[i].Labels = labels
+		}
+	}
+
+	return containers, nil
+}
+
+// ListDockerImages runs `docker images` and parses its JSON lines output
+// Labels are not part of the list format, so they are loaded with a single `docker image inspect`
+func ListDockerImages(ctx context.Context, filters []string) ([]types.DockerImage, error) {
+	args := []string{"images", "--no-trunc", "--digests", "--format", "{{json .}}"}
+	for _, f := range filters {
+		args = append(args, "--filter", f)
+	}
+
+	stdout, _, err := executeDockerCommand(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	images := []types.DockerImage{}
+	err = forEachJSONLine(stdout, func(line []byte) error {
+		var raw struct {
+			ID         string `json:"ID"`
+			Repository string `json:"Repository"`
+			Tag        string `json:"Tag"`
+			Digest     string `json:"Digest"`
+			CreatedAt  string `json:"CreatedAt"`
+			Size       string `json:"Size"`
+		}
+		if err := json.Unmarshal(line, &raw); err != nil {
+			return err
+		}
+		digest := raw.Digest
+		if digest == "<none>" {
+			digest = ""
+		}
+		images = append(images, types.DockerImage{
+			ID:         raw.ID,
+			Repository: raw.Repository,
+			Tag:        raw.Tag,
+			Digest:     digest,
+			Labels:     map[string]string{},
+			Created:    raw.CreatedAt,
+			Size:       raw.Size,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image list: %w", err)
+	}
+
+	if len(images) == 0 {
+		return images, nil
+	}
+
+	ids := make([]string, len(images))
+	for i, img := range images {
+		ids[i] = img.ID
+	}
+	labelsByID := inspectDockerLabels(ctx, "image", ids)
+	for i := range images {
+		if labels, ok := labelsByID[images[i].ID]; ok {
+			images[i].Labels = labels
+		}
+	}
+
+	return images, nil
+}
+
+// forEachJSONLine calls fn for every non-empty line of docker's `--format '{{json .}}'` output
+func forEachJSONLine(output string, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := fn([]byte(line)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// splitDockerList splits docker's comma-separated list columns (names, ports)
+func splitDockerList(s string) []string {
+	result := []string{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// inspectDockerLabels loads the labels of containers or images (kind) with a single `docker <kind> inspect`.
+// The list formats only have labels joined as "key=value,key2=value2", which is ambiguous for values
+// containing commas. Labels are best effort: IDs that cannot be inspected are missing from the result
+func inspectDockerLabels(ctx context.Context, kind string, ids []string) map[string]map[string]string {
+	seen := make(map[string]bool)
+	args := []string{kind, "inspect", "--format", "{{json .Id}} {{json .Config.Labels}}"}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			args = append(args, id)
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+
+	// docker still prints the IDs it found when some of them are gone
+	stdout, _, _ := executeDockerCommand(ctx, args...)
+	return parseDockerLabels(stdout)
+}
+
+// parseDockerLabels parses the `{{json .Id}} {{json .Config.Labels}}` lines of docker inspect by ID
+func parseDockerLabels(output string) map[string]map[string]string {
+	labelsByID := make(map[string]map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var id string
+		var labels map[string]string
+		if json.Unmarshal([]byte(parts[0]), &id) != nil || json.Unmarshal([]byte(parts[1]), &labels) != nil {
+			continue
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labelsByID[id] = labels
+	}
+	return labelsByID
+}
@@ -0,0 +1,30 @@
+package handler
+
+import "testing"
+
+func TestParseDockerLabels(t *testing.T) {
+	output := `"abc123" {"com.example.hosts":"a.example.com,b.example.com","goli.step":"deploy=prod","empty":""}
+"def456" null
+not json
+"sha256:789" {}
+`
+	labels := parseDockerLabels(output)
+
+	want := map[string]string{"com.example.hosts": "a.example.com,b.example.com", "goli.step": "deploy=prod", "empty": ""}
+	if len(labels["abc123"]) != len(want) {
+		t.Fatalf("labels = %v, want %v", labels["abc123"], want)
+	}
+	for k, v := range want {
+		if got, ok := labels["abc123"][k]; !ok || got != v {
+			t.Errorf("label %s = %q, want %q", k, got, v)
+		}
+	}
+	for _, id := range []string{"def456", "sha256:789"} {
+		if l, ok := labels[id]; !ok || l == nil || len(l) != 0 {
+			t.Errorf("labels of %s = %v, want an empty map", id, l)
+		}
+	}
+	if len(labels) != 3 {
+		t.Errorf("parsed %d IDs, want 3", len(labels))
+	}
+}
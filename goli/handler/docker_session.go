@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	response_util "goli/utils"
+	"io"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// dockerNamePattern matches valid container names and IDs (must not start with '-' to avoid flag injection)
+var dockerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ContainerLogsHandler returns or streams the logs of a container
+// Query: ?follow=true streams the logs as Server-Sent Events, ?since= and ?tail= are passed to `docker logs`
+func ContainerLogsHandler(c *gin.Context) {
+	name := c.Param("name")
+	if !dockerNamePattern.MatchString(name) {
+		response_util.SendBadRequestResponseGin(c, "Invalid container name")
+		return
+	}
+
+	args := []string{"logs", "--timestamps"}
+	if since := strings.TrimSpace(c.Query("since")); since != "" {
+		if strings.HasPrefix(since, "-") {
+			response_util.SendBadRequestResponseGin(c, "Invalid since value")
+			return
+		}
+		args = append(args, "--since", since)
+	}
+	if tail := strings.TrimSpace(c.Query("tail")); tail != "" {
+		if _, err := strconv.Atoi(tail); err != nil && tail != "all" {
+			response_util.SendBadRequestResponseGin(c, "Invalid tail value, must be a number or 'all'")
+			return
+		}
+		args = append(args, "--tail", tail)
+	}
+
+	follow := c.Query("follow") == "true"
+	auditLog(c, "docker.container.logs", "container", name, nil, nil)
+
+	if !follow {
+		args = append(args, name)
+		stdout, stderr, err := executeDockerCommand(c.Request.Context(), args...)
+		if err != nil {
+			response_util.SendInternalServerErrorResponseGin(c, err.Error())
+			return
+		}
+		// docker logs writes the container's stderr to our stderr
+		response_util.SendOkResponseGin(c, stdout+stderr)
+		return
+	}
+
+	args = append(args, "--follow", name)
+
+	// The command lives as long as the client connection
+	cmd := exec.CommandContext(c.Request.Context(), "docker", args...)
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to start docker logs: "+err.Error())
+		return
+	}
+
+	exitCode := make(chan int, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		exitCode <- exitCodeOf(err)
+	}()
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("X-Accel-Buffering", "no")
+
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		c.SSEvent("log", scanner.Text())
+		c.Writer.Flush()
+	}
+
+	c.SSEvent("end", gin.H{"exit_code": <-exitCode})
+	c.Writer.Flush()
+}
+
+// execMessage is a control message sent to the exec WebSocket client
+type execMessage struct {
+	Type     string `json:"type"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// ContainerExecHandler opens an interactive `docker exec` session over WebSocket
+// Query: ?cmd=<command> (repeatable for arguments, defaults to "sh")
+// Client messages are written to the process stdin, stdout/stderr are sent back as binary messages.
+// When the process exits a final {"type":"exit","exit_code":N} text message is sent.
+func ContainerExecHandler(c *gin.Context) {
+	name := c.Param("name")
+	if !dockerNamePattern.MatchString(name) {
+		response_util.SendBadRequestResponseGin(c, "Invalid container name")
+		return
+	}
+
+	command := c.QueryArray("cmd")
+	if len(command) == 0 {
+		command = []string{"sh"}
+	}
+	if strings.HasPrefix(command[0], "-") {
+		response_util.SendBadRequestResponseGin(c, "Invalid command")
+		return
+	}
+
+	if !checkDockerExistence(c.Request.Context(), name) {
+		response_util.SendNotFoundResponseGin(c, "Container not found")
+		return
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	auditLog(c, "docker.container.exec", "container", name, nil, gin.H{"command": strings.Join(command, " ")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	args := append([]string{"exec", "-i", name}, command...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte("Failed to open stdin: "+err.Error()))
+		return
+	}
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	if err := cmd.Start(); err != nil {
+		conn.WriteMessage(websocket.TextMessage, []byte("Failed to start exec session: "+err.Error()))
+		return
+	}
+
+	// gorilla/websocket supports one concurrent writer only
+	var writeMu sync.Mutex
+	write := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteMessage(messageType, data)
+	}
+
+	// Process output -> client
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 4096)
+		for {
+			n, err := pr.Read(buf)
+			if n > 0 {
+				if werr := write(websocket.BinaryMessage, buf[:n]); werr != nil {
+					// Client is gone: kill the session and drain so cmd.Wait can return
+					cancel()
+					io.Copy(io.Discard, pr)
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Client input -> process stdin; a closed connection kills the session
+	go func() {
+		defer stdin.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				cancel()
+				return
+			}
+			if _, err := stdin.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	waitErr := cmd.Wait()
+	pw.Close()
+	<-outputDone
+
+	exit, _ := json.Marshal(execMessage{Type: "exit", ExitCode: exitCodeOf(waitErr)})
+	write(websocket.TextMessage, exit)
+	write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
+
+// exitCodeOf extracts the process exit code from an exec error
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"database/sql"
+	"goli/database"
+	"goli/models"
+	response_util "goli/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListPipelineGrantsHandler lists who was granted access to a pipeline
+func ListPipelineGrantsHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid pipeline ID")
+		return
+	}
+
+	if _, err := database.GetPipeline(id); err != nil {
+		response_util.SendNotFoundResponseGin(c, "Pipeline not found")
+		return
+	}
+
+	grants, err := database.ListPipelineGrants(id)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to list pipeline grants: "+err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, grants)
+}
+
+// SetPipelineGrantHandler grants a user view, run or edit access to a pipeline, replacing an existing grant.
+// The first grant restricts the pipeline to admins and the granted users
+func SetPipelineGrantHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid pipeline ID")
+		return
+	}
+
+	var body struct {
+		UserID   int64  `json:"user_id,omitempty"`
+		Username string `json:"username,omitempty"`
+		Access   string `json:"access"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	if !models.IsValidPipelineAccess(body.Access) {
+		response_util.SendBadRequestResponseGin(c, "Access must be one of "+strings.Join(models.PipelineAccessLevels, ", "))
+		return
+	}
+
+	p, err := database.GetPipeline(id)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "Pipeline not found")
+		return
+	}
+
+	var user *models.User
+	switch {
+	case body.UserID != 0:
+		user, err = database.GetUser(body.UserID)
+	case body.Username != "":
+		user, err = database.GetUserByUsername(body.Username)
+	default:
+		response_util.SendBadRequestResponseGin(c, "user_id or username is required")
+		return
+	}
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "User not found")
+		return
+	}
+
+	var before interface{}
+	if _, access, err := database.GetPipelineAccess(id, user.ID); err == nil && access != "" {
+		before = gin.H{"username": user.Username, "access": access}
+	}
+
+	grant, err := database.SetPipelineGrant(&models.PipelineGrant{
+		PipelineID: id,
+		UserID:     user.ID,
+		Access:     body.Access,
+	})
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to grant pipeline access: "+err.Error())
+		return
+	}
+	grant.Username = user.Username
+
+	auditLog(c, "pipeline.grant", "pipeline", p.ID, before, grant)
+
+	response_util.SendJsonResponseGin(c, 200, grant)
+}
+
+// DeletePipelineGrantHandler revokes a grant; once the last grant is gone the pipeline follows roles again
+func DeletePipelineGrantHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid pipeline ID")
+		return
+	}
+	grantID, err := strconv.ParseInt(c.Param("grant_id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid grant ID")
+		return
+	}
+
+	var before *models.PipelineGrant
+	if grants, err := database.ListPipelineGrants(id); err == nil {
+		for _, grant := range grants {
+			if grant.ID == grantID {
+				before = grant
+			}
+		}
+	}
+
+	if err := database.DeletePipelineGrant(id, grantID); err != nil {
+		if err == sql.ErrNoRows {
+			response_util.SendNotFoundResponseGin(c, "Grant not found")
+			return
+		}
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to revoke pipeline access: "+err.Error())
+		return
+	}
+
+	auditLog(c, "pipeline.revoke", "pipeline", id, before, nil)
+
+	response_util.SendOkResponseGin(c, "Pipeline access revoked")
+}
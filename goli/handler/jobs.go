@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"database/sql"
 	"goli/database"
+	"goli/middlewares"
 	"goli/models"
 	"goli/queue"
 	response_util "goli/utils"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,9 +16,10 @@ import (
 // CreateJobHandler creates a new job
 func CreateJobHandler(c *gin.Context) {
 	var body struct {
-		Name        string `json:"name"`
-		PipelineID  *int64 `json:"pipeline_id,omitempty"`
-		TriggeredBy string `json:"triggered_by,omitempty"`
+		Name        string            `json:"name"`
+		PipelineID  *int64            `json:"pipeline_id,omitempty"`
+		TriggeredBy string            `json:"triggered_by,omitempty"`
+		Parameters  map[string]string `json:"parameters,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&body); err != nil {
@@ -28,9 +32,20 @@ func CreateJobHandler(c *gin.Context) {
 		PipelineID:  nil,
 		Status:      models.JobStatusPending,
 		TriggeredBy: body.TriggeredBy,
+		CreatedBy:   c.GetString("username"),
+		Parameters:  body.Parameters,
 	}
 
 	if body.PipelineID != nil {
+		allowed, err := middlewares.CanAccessPipeline(c, *body.PipelineID, models.PermPipelinesRun, models.PipelineAccessRun)
+		if err != nil {
+			response_util.SendInternalServerErrorResponseGin(c, "Failed to check pipeline permissions: "+err.Error())
+			return
+		}
+		if !allowed {
+			response_util.SendForbiddenResponseGin(c, "Insufficient permissions: "+models.PermPipelinesRun+" is required")
+			return
+		}
 		job.PipelineID = body.PipelineID
 	}
 
@@ -39,6 +54,8 @@ func CreateJobHandler(c *gin.Context) {
 		return
 	}
 
+	auditLog(c, "job.create", "job", job.ID, nil, job)
+
 	response_util.SendOkResponseGin(c, "Job created and enqueued")
 }
 
@@ -56,6 +73,14 @@ func GetJobHandler(c *gin.Context) {
 		return
 	}
 
+	if job.Status == models.JobStatusWaitingApproval {
+		job.Approval, err = database.GetPendingJobApproval(id)
+		if err != nil && err != sql.ErrNoRows {
+			response_util.SendInternalServerErrorResponseGin(c, "Failed to load approval: "+err.Error())
+			return
+		}
+	}
+
 	response_util.SendJsonResponseGin(c, 200, job)
 }
 
@@ -79,7 +104,14 @@ func ListJobsHandler(c *gin.Context) {
 		statusFilter = status
 	}
 
-	jobs, err := database.GetJobs(limit, offset, statusFilter)
+	// Jobs of pipelines restricted by grants are only listed to admins and the granted users. The route
+	// already checked jobs:read, which covers the unrestricted pipelines
+	var visibleTo int64
+	if c.GetString("user_role") != models.RoleAdmin {
+		visibleTo = c.GetInt64("user_id")
+	}
+
+	jobs, err := database.GetJobs(limit, offset, statusFilter, visibleTo)
 	if err != nil {
 		response_util.SendInternalServerErrorResponseGin(c, "Failed to list jobs: "+err.Error())
 		return
@@ -88,7 +120,7 @@ func ListJobsHandler(c *gin.Context) {
 	response_util.SendJsonResponseGin(c, 200, jobs)
 }
 
-// CancelJobHandler cancels a running or pending job
+// CancelJobHandler cancels a running, pending or waiting job
 func CancelJobHandler(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -96,10 +128,84 @@ func CancelJobHandler(c *gin.Context) {
 		return
 	}
 
-	if err := queue.GetQueue().CancelJob(id); err != nil {
+	if err := queue.GetQueue().CancelJob(id, c.GetString("username")); err != nil {
 		response_util.SendBadRequestResponseGin(c, "Failed to cancel job: "+err.Error())
 		return
 	}
 
+	auditLog(c, "job.cancel", "job", id, nil, nil)
+
 	response_util.SendOkResponseGin(c, "Job cancelled successfully")
 }
+
+// ApproveJobHandler approves the approval step a job waits at, the job continues with the next step
+func ApproveJobHandler(c *gin.Context) {
+	decideJobApproval(c, true)
+}
+
+// RejectJobHandler rejects the approval step a job waits at, which fails the job
+func RejectJobHandler(c *gin.Context) {
+	decideJobApproval(c, false)
+}
+
+// decideJobApproval records the decision of the authenticated user on the approval a job waits for.
+// The user must be one of the step's approvers or have one of its roles
+func decideJobApproval(c *gin.Context, approve bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid job ID")
+		return
+	}
+
+	var body struct {
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+			return
+		}
+	}
+	body.Comment = strings.TrimSpace(body.Comment)
+
+	job, err := database.GetJob(id)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "Job not found")
+		return
+	}
+	approval, err := database.GetPendingJobApproval(id)
+	if err == sql.ErrNoRows || (err == nil && job.Status != models.JobStatusWaitingApproval) {
+		response_util.SendBadRequestResponseGin(c, "Job is not waiting for approval")
+		return
+	}
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to load approval: "+err.Error())
+		return
+	}
+
+	username := c.GetString("username")
+	if !approval.AllowsApprover(username, c.GetString("user_role"), job) {
+		response_util.SendForbiddenResponseGin(c, "You are not an approver of step '"+approval.StepName+"'")
+		return
+	}
+
+	action, verb := "job.approve", "approved"
+	if approve {
+		err = queue.GetQueue().ApproveJob(job, approval, username, body.Comment)
+	} else {
+		action, verb = "job.reject", "rejected"
+		err = queue.GetQueue().RejectJob(job, approval, username, body.Comment)
+	}
+	if err == database.ErrApprovalDecided {
+		response_util.SendBadRequestResponseGin(c, "Approval was already decided or has expired")
+		return
+	}
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to record decision: "+err.Error())
+		return
+	}
+
+	auditLog(c, action, "job", id, nil, gin.H{"step": approval.StepName, "comment": body.Comment})
+
+	response_util.SendOkResponseGin(c, "Job "+verb)
+}
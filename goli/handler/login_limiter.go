@@ -0,0 +1,212 @@
+package handler
+
+import (
+	aux "goli/auxiliary"
+	"goli/database"
+	response_util "goli/utils"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Login backoff: after the free failures each further failure doubles the wait before the next attempt
+const (
+	loginFreeFailuresPerUser = 3
+	loginFreeFailuresPerIP   = 10 // Higher, since many users may share an address
+	loginBackoffBase         = time.Second
+	loginBackoffMax          = 15 * time.Minute
+	loginFailureMemory       = time.Hour // Failures are forgotten after this long without a new one
+)
+
+// Account lockout defaults, overridden by login_max_failures and login_lockout_minutes in config.toml
+const (
+	defaultLoginMaxFailures    = 10
+	defaultLoginLockoutMinutes = 15
+)
+
+// loginFailures tracks failed logins and 2FA verifications per username and per client IP
+type loginFailures struct {
+	mu      sync.Mutex
+	entries map[string]*loginFailureEntry
+}
+
+type loginFailureEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+var loginLimiter = &loginFailures{entries: make(map[string]*loginFailureEntry)}
+
+// resetLimiter throttles password reset emails per login and client IP with the same backoff. It is
+// separate from loginLimiter, so that requesting resets for someone cannot lock them out of logging in
+var resetLimiter = &loginFailures{entries: make(map[string]*loginFailureEntry)}
+
+// loginLimiterKeys returns the keys a login attempt for username from the request's client counts against
+func loginLimiterKeys(c *gin.Context, username string) []string {
+	return []string{"user:" + strings.ToLower(username), "ip:" + c.ClientIP()}
+}
+
+// wait returns how long the client has to wait before the next attempt for any of the keys
+func (l *loginFailures) wait(keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var longest time.Duration
+	for _, key := range keys {
+		if entry, ok := l.entries[key]; ok {
+			if wait := entry.blockedUntil.Sub(now); wait > longest {
+				longest = wait
+			}
+		}
+	}
+	return longest
+}
+
+// fail counts a failed attempt for each key and extends their backoff
+func (l *loginFailures) fail(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+	for _, key := range keys {
+		entry, ok := l.entries[key]
+		if !ok {
+			entry = &loginFailureEntry{}
+			l.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+
+		free := loginFreeFailuresPerUser
+		if strings.HasPrefix(key, "ip:") {
+			free = loginFreeFailuresPerIP
+		}
+		if entry.failures > free {
+			delay := loginBackoffMax
+			if exponent := entry.failures - free - 1; exponent < 20 {
+				delay = loginBackoffBase << uint(exponent)
+			}
+			if delay > loginBackoffMax {
+				delay = loginBackoffMax
+			}
+			entry.blockedUntil = now.Add(delay)
+		}
+	}
+}
+
+// reset forgets the failures of a key, after a successful login or an admin unlock
+func (l *loginFailures) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// prune drops entries without a failure for loginFailureMemory, must be called with mu held
+func (l *loginFailures) prune(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastFailure) > loginFailureMemory && now.After(entry.blockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// checkAccountLock responds with 403 and returns true if the account is locked. Locks expire after
+// login_lockout_minutes, with 0 they last until an admin unlocks the account. An expired lock starts
+// the count of failures over
+func checkAccountLock(c *gin.Context, user *databaseUserProjection) bool {
+	if user.LockedAt == nil {
+		return false
+	}
+
+	minutes := aux.GetIntFromConfig("constants.login_lockout_minutes", defaultLoginLockoutMinutes)
+	if minutes == 0 || time.Since(*user.LockedAt) < time.Duration(minutes)*time.Minute {
+		response_util.SendForbiddenResponseGin(c, "Account locked after too many failed logins, ask an administrator to unlock it")
+		return true
+	}
+
+	if err := database.ResetFailedLogins(user.ID); err != nil {
+		log.Printf("Failed to reset failed logins of %s: %v", user.Username, err)
+	}
+	return false
+}
+
+// checkLoginBackoff responds with 429 and returns true if the username or the client IP has to wait
+// before the next attempt
+func checkLoginBackoff(c *gin.Context, username string) bool {
+	wait := loginLimiter.wait(loginLimiterKeys(c, username)...)
+	if wait <= 0 {
+		return false
+	}
+	response_util.SendTooManyRequestsResponseGin(c, "Too many failed attempts, try again later", wait)
+	return true
+}
+
+// throttlePasswordReset counts a password reset request for login and responds with 429 and returns
+// true if the login or the client IP requested too many
+func throttlePasswordReset(c *gin.Context, login string) bool {
+	keys := []string{"login:" + strings.ToLower(login), "ip:" + c.ClientIP()}
+	if wait := resetLimiter.wait(keys...); wait > 0 {
+		response_util.SendTooManyRequestsResponseGin(c, "Too many password reset requests, try again later", wait)
+		return true
+	}
+	resetLimiter.fail(keys...)
+	return false
+}
+
+// recordLoginFailure counts a failed password or 2FA code for a user and audits the lockout it may cause
+func recordLoginFailure(c *gin.Context, username string, userID int64) {
+	loginLimiter.fail(loginLimiterKeys(c, username)...)
+	if userID == 0 {
+		return
+	}
+
+	locked, err := database.RecordFailedLogin(userID, aux.GetIntFromConfig("constants.login_max_failures", defaultLoginMaxFailures))
+	if err != nil {
+		log.Printf("Failed to record failed login of %s: %v", username, err)
+		return
+	}
+	if locked {
+		auditLog(c, "user.lockout", "user", userID, nil, gin.H{"username": username})
+	}
+}
+
+// recordLoginSuccess clears the failures of a user once a session was issued and audits the login
+func recordLoginSuccess(c *gin.Context, username string, userID int64) {
+	auditLog(c, "user.login", "user", userID, nil, gin.H{"username": username})
+	loginLimiter.reset("user:" + strings.ToLower(username))
+	if err := database.ResetFailedLogins(userID); err != nil {
+		log.Printf("Failed to reset failed logins of %s: %v", username, err)
+	}
+}
+
+// UnlockUserHandler lifts the lockout of a user and clears their failed logins (admin)
+func UnlockUserHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid user ID")
+		return
+	}
+
+	user, err := database.GetUser(id)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "User not found")
+		return
+	}
+
+	if err := database.ResetFailedLogins(user.ID); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to unlock user: "+err.Error())
+		return
+	}
+	loginLimiter.reset("user:" + strings.ToLower(user.Username))
+
+	auditLog(c, "user.unlock", "user", user.ID, nil, gin.H{"username": user.Username})
+
+	response_util.SendOkResponseGin(c, "User unlocked")
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPasswordResetsDoNotBlockLogins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newContext := func(ip string) (*gin.Context, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/auth/password/forgot", nil)
+		c.Request.RemoteAddr = ip + ":40000"
+		return c, w
+	}
+
+	throttled := 0
+	for i := 0; i < 10; i++ {
+		c, w := newContext("203.0.113.7")
+		if throttlePasswordReset(c, "Alice") {
+			throttled++
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+			}
+		}
+	}
+	if throttled == 0 {
+		t.Error("reset requests for one login were never throttled")
+	}
+
+	// Other clients requesting resets for the same login are throttled too
+	if c, _ := newContext("198.51.100.1"); !throttlePasswordReset(c, "alice") {
+		t.Error("reset requests for the login from another address were not throttled")
+	}
+
+	// The user can still log in, from any address
+	for _, ip := range []string{"203.0.113.7", "198.51.100.1"} {
+		c, _ := newContext(ip)
+		if wait := loginLimiter.wait(loginLimiterKeys(c, "alice")...); wait > 0 {
+			t.Errorf("login from %s blocked for %s by reset requests", ip, wait)
+		}
+	}
+}
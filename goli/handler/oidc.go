@@ -0,0 +1,384 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	aux "goli/auxiliary"
+	"goli/database"
+	"goli/models"
+	response_util "goli/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDC login defaults, overridden by the oidc_* keys in config.toml
+const (
+	defaultOIDCScopes        = "openid profile email"
+	defaultOIDCUsernameClaim = "preferred_username"
+	defaultOIDCGroupsClaim   = "groups"
+	oidcNoRole               = "none" // oidc_default_role that denies users without a mapped group
+	oidcLoginLifetime        = 10 * time.Minute
+	oidcDiscoveryLifetime    = time.Hour
+	oidcStateCookie          = "goli_oidc_state" // Binds a login's state to the browser that started it
+)
+
+// oidcSettings is the OIDC configuration read from config.toml
+type oidcSettings struct {
+	Issuer            string
+	ClientID          string
+	ClientSecret      string
+	RedirectURL       string
+	Scopes            []string
+	UsernameClaim     string
+	GroupsClaim       string
+	RoleMap           map[string]string // IdP group to Goli role
+	DefaultRole       string            // Role of users without a mapped group, empty to deny them
+	PostLoginRedirect string
+}
+
+// oidcProviderCache keeps the discovered provider, it is discovered again when the configuration
+// changes or the discovery is older than oidcDiscoveryLifetime
+var oidcProviderCache struct {
+	mu           sync.Mutex
+	provider     *response_util.OIDCProvider
+	fingerprint  string
+	discoveredAt time.Time
+}
+
+// loadOIDCSettings reads the OIDC configuration, ok is false if OIDC login is not configured
+func loadOIDCSettings() (*oidcSettings, bool, error) {
+	setting := func(key, fallback string) string {
+		if value := strings.TrimSpace(aux.GetFromConfig("constants." + key)); value != "" {
+			return value
+		}
+		return fallback
+	}
+
+	settings := &oidcSettings{
+		Issuer:            setting("oidc_issuer", ""),
+		ClientID:          setting("oidc_client_id", ""),
+		ClientSecret:      setting("oidc_client_secret", ""),
+		RedirectURL:       setting("oidc_redirect_url", ""),
+		Scopes:            strings.Fields(setting("oidc_scopes", defaultOIDCScopes)),
+		UsernameClaim:     setting("oidc_username_claim", defaultOIDCUsernameClaim),
+		GroupsClaim:       setting("oidc_groups_claim", defaultOIDCGroupsClaim),
+		RoleMap:           make(map[string]string),
+		DefaultRole:       setting("oidc_default_role", models.RoleViewer),
+		PostLoginRedirect: setting("oidc_post_login_redirect", ""),
+	}
+	if settings.Issuer == "" || settings.ClientID == "" {
+		return nil, false, nil
+	}
+	if settings.RedirectURL == "" {
+		return nil, false, fmt.Errorf("oidc_redirect_url is required")
+	}
+	if !containsString(settings.Scopes, "openid") {
+		settings.Scopes = append([]string{"openid"}, settings.Scopes...)
+	}
+
+	if settings.DefaultRole == oidcNoRole {
+		settings.DefaultRole = ""
+	} else if !models.IsValidRole(settings.DefaultRole) {
+		return nil, false, fmt.Errorf("invalid oidc_default_role %q", settings.DefaultRole)
+	}
+
+	// oidc_role_map = "goli-admins=admin,developers=maintainer"
+	for _, entry := range strings.Split(setting("oidc_role_map", ""), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, false, fmt.Errorf("invalid oidc_role_map entry %q, expected group=role", entry)
+		}
+		group, role := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+		if !models.IsValidRole(role) {
+			return nil, false, fmt.Errorf("invalid role %q in oidc_role_map", role)
+		}
+		settings.RoleMap[group] = role
+	}
+
+	return settings, true, nil
+}
+
+// oidcProviderFor returns the discovered provider of the settings
+func oidcProviderFor(c *gin.Context, settings *oidcSettings) (*response_util.OIDCProvider, error) {
+	fingerprint := strings.Join([]string{settings.Issuer, settings.ClientID, settings.ClientSecret, settings.RedirectURL,
+		strings.Join(settings.Scopes, " ")}, "\n")
+
+	oidcProviderCache.mu.Lock()
+	defer oidcProviderCache.mu.Unlock()
+
+	if oidcProviderCache.provider != nil && oidcProviderCache.fingerprint == fingerprint &&
+		time.Since(oidcProviderCache.discoveredAt) < oidcDiscoveryLifetime {
+		return oidcProviderCache.provider, nil
+	}
+
+	provider, err := response_util.DiscoverOIDCProvider(c.Request.Context(), settings.Issuer, settings.ClientID,
+		settings.ClientSecret, settings.RedirectURL, settings.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	oidcProviderCache.provider = provider
+	oidcProviderCache.fingerprint = fingerprint
+	oidcProviderCache.discoveredAt = time.Now()
+	return provider, nil
+}
+
+// oidcProviderFromConfig loads the settings and the provider, and responds with an error if OIDC login
+// is not configured or the provider is unreachable
+func oidcProviderFromConfig(c *gin.Context) (*oidcSettings, *response_util.OIDCProvider, bool) {
+	settings, enabled, err := loadOIDCSettings()
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Invalid OIDC configuration: "+err.Error())
+		return nil, nil, false
+	}
+	if !enabled {
+		response_util.SendNotFoundResponseGin(c, "OIDC login is not configured")
+		return nil, nil, false
+	}
+
+	provider, err := oidcProviderFor(c, settings)
+	if err != nil {
+		log.Printf("OIDC provider %s unavailable: %v", settings.Issuer, err)
+		response_util.SendJsonResponseGin(c, http.StatusBadGateway, gin.H{
+			"status":      "error",
+			"description": "Identity provider unavailable: " + err.Error(),
+		})
+		return nil, nil, false
+	}
+	return settings, provider, true
+}
+
+// OIDCLoginHandler starts a login at the identity provider (authorization code flow with PKCE) by
+// redirecting to its login page
+func OIDCLoginHandler(c *gin.Context) {
+	settings, provider, ok := oidcProviderFromConfig(c)
+	if !ok {
+		return
+	}
+
+	state, err := generateRandomToken(24)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed generating state")
+		return
+	}
+	nonce, err := generateRandomToken(24)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed generating nonce")
+		return
+	}
+	verifier, challenge, err := response_util.NewPKCEVerifier()
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed generating PKCE verifier")
+		return
+	}
+
+	err = database.CreateOIDCLogin(&database.OIDCLogin{
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginLifetime),
+	})
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to store OIDC login: "+err.Error())
+		return
+	}
+
+	setOIDCStateCookie(c, settings, oidcStateHash(state), int(oidcLoginLifetime.Seconds()))
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, nonce, challenge))
+}
+
+// oidcStateHash is the value of the state cookie, a hash so the cookie does not hold the state itself
+func oidcStateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// setOIDCStateCookie sets the state cookie for the callback path, a negative maxAge deletes it. It is
+// SameSite=Lax because the provider's redirect back is a cross-site navigation
+func setOIDCStateCookie(c *gin.Context, settings *oidcSettings, value string, maxAge int) {
+	callbackPath := "/"
+	if u, err := url.Parse(settings.RedirectURL); err == nil && u.Path != "" {
+		callbackPath = u.Path
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     callbackPath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(settings.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// OIDCCallbackHandler completes a login the identity provider redirected back: it verifies the ID token,
+// provisions or updates the user and issues a session like LoginHandler
+func OIDCCallbackHandler(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		response_util.SendUnauthorizedResponseGin(c, "Identity provider login failed: "+providerError+" "+c.Query("error_description"))
+		return
+	}
+
+	settings, provider, ok := oidcProviderFromConfig(c)
+	if !ok {
+		return
+	}
+
+	// The state must come from a login this browser started, otherwise an attacker could have the
+	// victim's browser complete the attacker's login
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(oidcStateHash(c.Query("state")))) != 1 {
+		response_util.SendUnauthorizedResponseGin(c, "Login was not started in this browser, start again")
+		return
+	}
+	setOIDCStateCookie(c, settings, "", -1)
+
+	login, err := database.ConsumeOIDCLogin(c.Query("state"))
+	if err != nil {
+		response_util.SendUnauthorizedResponseGin(c, "Invalid or expired login, start again")
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(c.Request.Context(), c.Query("code"), login.CodeVerifier)
+	if err != nil {
+		response_util.SendUnauthorizedResponseGin(c, "OIDC login failed: "+err.Error())
+		return
+	}
+	claims, err := provider.VerifyIDToken(c.Request.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		response_util.SendUnauthorizedResponseGin(c, "OIDC login failed: "+err.Error())
+		return
+	}
+
+	subject := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		email = ""
+	}
+	role := oidcRole(settings, oidcGroups(claims[settings.GroupsClaim]))
+	if role == "" {
+		response_util.SendForbiddenResponseGin(c, "None of your identity provider groups grants access to Goli")
+		return
+	}
+
+	user, err := database.GetUserByOIDCSubject(subject)
+	switch {
+	case err == sql.ErrNoRows:
+		username := oidcUsername(claims, settings.UsernameClaim)
+		// Never link a provider account to an existing local user by name, whoever controls that
+		// name at the provider would take the user over
+		if _, err := database.GetUserByUsername(username); err == nil {
+			response_util.SendForbiddenResponseGin(c, "Username "+username+" is already taken by a local user")
+			return
+		}
+		user, err = database.CreateOIDCUser(&models.User{Username: username, Email: email, Role: role}, subject)
+		if err != nil {
+			response_util.SendInternalServerErrorResponseGin(c, "Failed to create user: "+err.Error())
+			return
+		}
+		auditLog(c, "user.oidc.provision", "user", user.ID, nil, user)
+	case err != nil:
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to load user: "+err.Error())
+		return
+	default:
+		// The identity provider is the source of truth for the role of its users
+		if user.Role != role || (email != "" && user.Email != email) {
+			if err := database.SyncOIDCUser(user.ID, email, role); err != nil {
+				response_util.SendInternalServerErrorResponseGin(c, "Failed to update user: "+err.Error())
+				return
+			}
+			if user.Role != role {
+				auditLog(c, "user.oidc.role", "user", user.ID, gin.H{"role": user.Role}, gin.H{"role": role})
+			}
+			user.Role = role
+			if email != "" {
+				user.Email = email
+			}
+		}
+	}
+
+	token, expires, err := newSession(c, user.ID)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed creating session")
+		return
+	}
+	auditLog(c, "user.oidc.login", "user", user.ID, nil, gin.H{"username": user.Username})
+
+	// Browsers are sent back to the UI with the session in the fragment, which never reaches a server
+	if settings.PostLoginRedirect != "" {
+		fragment := url.Values{}
+		fragment.Set("token", token)
+		fragment.Set("expires_at", expires.Format(time.RFC3339))
+		c.Redirect(http.StatusFound, settings.PostLoginRedirect+"#"+fragment.Encode())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, gin.H{
+		"token":      token,
+		"expires_at": expires,
+		"user": gin.H{
+			"id":       user.ID,
+			"username": user.Username,
+			"email":    user.Email,
+			"role":     user.Role,
+		},
+	})
+}
+
+// oidcRole returns the highest role mapped from the groups, or the default role if none is mapped
+func oidcRole(settings *oidcSettings, groups []string) string {
+	best := -1
+	for _, group := range groups {
+		role, ok := settings.RoleMap[group]
+		if !ok {
+			continue
+		}
+		for rank, r := range models.Roles {
+			if r == role && rank > best {
+				best = rank
+			}
+		}
+	}
+	if best < 0 {
+		return settings.DefaultRole
+	}
+	return models.Roles[best]
+}
+
+// oidcGroups reads the groups claim, a list of strings or a single string
+func oidcGroups(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return []string{claim}
+	case []interface{}:
+		groups := make([]string, 0, len(claim))
+		for _, group := range claim {
+			if s, ok := group.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	}
+	return nil
+}
+
+// oidcUsername picks the username of a new user: the configured claim, then the email, then the subject
+func oidcUsername(claims map[string]interface{}, usernameClaim string) string {
+	for _, claim := range []string{usernameClaim, "email", "sub"} {
+		if value, ok := claims[claim].(string); ok && strings.TrimSpace(value) != "" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	aux "goli/auxiliary"
+	"goli/database"
+	"goli/models"
+	"goli/utils/oidctest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupOIDCTest opens a temporary database and points the config at a mock identity provider
+func setupOIDCTest(t *testing.T) (*oidctest.Provider, func(defaultRole string)) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	if err := database.OpenDatabase(filepath.Join(dir, "goli.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.CloseDatabase() })
+
+	mock := oidctest.NewProvider(t, "goli", "client-secret")
+	configPath := filepath.Join(dir, "config.toml")
+	writeConfig := func(defaultRole string) {
+		config := fmt.Sprintf(`[constants]
+oidc_issuer = "%s"
+oidc_client_id = "goli"
+oidc_client_secret = "client-secret"
+oidc_redirect_url = "http://goli.test/api/v1/auth/oidc/callback"
+oidc_role_map = "goli-admins=admin, developers=maintainer, ops=operator"
+oidc_default_role = "%s"
+`, mock.Issuer(), defaultRole)
+		if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("none")
+	aux.SetConfigPath(configPath)
+	t.Cleanup(func() { aux.SetConfigPath("") })
+	return mock, writeConfig
+}
+
+// oidcLogin starts a login, lets the user log in at the provider with the claims and returns the
+// response of the callback and its query
+func oidcLogin(t *testing.T, mock *oidctest.Provider, claims map[string]interface{}) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	query, cookies := oidcAuthorize(t, mock, claims)
+	return oidcCallback(query, cookies), query
+}
+
+// oidcAuthorize starts a login and lets the user log in at the provider, it returns the query the provider
+// redirects back with and the cookies the login set
+func oidcAuthorize(t *testing.T, mock *oidctest.Provider, claims map[string]interface{}) (string, []*http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/login", nil)
+	OIDCLoginHandler(c)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body)
+	}
+
+	query, err := mock.Authorize(w.Header().Get("Location"), claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query.Encode(), w.Result().Cookies()
+}
+
+func oidcCallback(query string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?"+query, nil)
+	for _, cookie := range cookies {
+		c.Request.AddCookie(cookie)
+	}
+	OIDCCallbackHandler(c)
+	return w
+}
+
+func TestOIDCCallbackProvisionsUsers(t *testing.T) {
+	mock, _ := setupOIDCTest(t)
+
+	w, query := oidcLogin(t, mock, map[string]interface{}{
+		"sub":                "subject-alice",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"groups":             []string{"staff", "developers"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Token string `json:"token"`
+		User  struct {
+			Username string `json:"username"`
+			Email    string `json:"email"`
+			Role     string `json:"role"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Token == "" || body.User.Username != "alice" || body.User.Email != "alice@example.com" || body.User.Role != models.RoleMaintainer {
+		t.Errorf("callback response = %s", w.Body)
+	}
+	user, err := database.GetUserByOIDCSubject("subject-alice")
+	if err != nil {
+		t.Fatalf("user not linked to the subject: %v", err)
+	}
+	if session, err := database.GetSessionByToken(body.Token); err != nil || session.UserID != user.ID {
+		t.Errorf("no session for the user: %v", err)
+	}
+
+	if w := oidcCallback(query, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed callback status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// A local user's name is never taken over by a provider account
+	if _, err := database.CreateUser(&models.User{Username: "bob", Password: "Secret-pass-1", Role: models.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	w, _ = oidcLogin(t, mock, map[string]interface{}{"sub": "subject-bob", "preferred_username": "bob", "groups": "developers"})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("login as local user bob status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if _, err := database.GetUserByOIDCSubject("subject-bob"); err == nil {
+		t.Error("provider account linked to the local user bob")
+	}
+
+	// Unverified email addresses are not stored, the username falls back to the subject
+	w, _ = oidcLogin(t, mock, map[string]interface{}{"sub": "subject-carol", "email": "carol@example.com", "email_verified": false, "groups": "ops"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	if user, err := database.GetUserByOIDCSubject("subject-carol"); err != nil || user.Username != "carol@example.com" || user.Email != "" || user.Role != models.RoleOperator {
+		t.Errorf("provisioned user = %+v, %v", user, err)
+	}
+
+	// The login's nonce must be in the ID token
+	w, _ = oidcLogin(t, mock, map[string]interface{}{"sub": "subject-dave", "nonce": "other", "groups": "developers"})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("callback with a foreign nonce status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOIDCCallbackMapsRoles(t *testing.T) {
+	mock, writeConfig := setupOIDCTest(t)
+
+	login := func(groups interface{}) int {
+		w, _ := oidcLogin(t, mock, map[string]interface{}{"sub": "subject-alice", "preferred_username": "alice", "groups": groups})
+		return w.Code
+	}
+	role := func() string {
+		user, err := database.GetUserByOIDCSubject("subject-alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Role
+	}
+
+	if code := login([]string{"staff"}); code != http.StatusForbidden {
+		t.Errorf("login without a mapped group status = %d, want %d", code, http.StatusForbidden)
+	}
+	if _, err := database.GetUserByOIDCSubject("subject-alice"); err == nil {
+		t.Error("user provisioned without a mapped group")
+	}
+
+	tests := []struct {
+		groups interface{}
+		want   string
+	}{
+		{[]string{"ops", "goli-admins", "developers"}, models.RoleAdmin},
+		{"developers", models.RoleMaintainer},
+		{[]interface{}{"ops", 42}, models.RoleOperator},
+	}
+	for _, tt := range tests {
+		if code := login(tt.groups); code != http.StatusOK {
+			t.Fatalf("login with groups %v status = %d", tt.groups, code)
+		}
+		if got := role(); got != tt.want {
+			t.Errorf("role with groups %v = %s, want %s", tt.groups, got, tt.want)
+		}
+	}
+
+	// The provider is the source of truth, losing all groups falls back to the default role
+	writeConfig(models.RoleViewer)
+	if code := login(nil); code != http.StatusOK {
+		t.Fatalf("login without groups status = %d", code)
+	}
+	if got := role(); got != models.RoleViewer {
+		t.Errorf("role without groups = %s, want %s", got, models.RoleViewer)
+	}
+
+	writeConfig("none")
+	if code := login([]string{"staff"}); code != http.StatusForbidden {
+		t.Errorf("login of an existing user without a mapped group status = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	mock, _ := setupOIDCTest(t)
+	claims := map[string]interface{}{"sub": "subject-alice", "preferred_username": "alice", "groups": "developers"}
+
+	query, cookies := oidcAuthorize(t, mock, claims)
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie || !cookies[0].HttpOnly ||
+		cookies[0].SameSite != http.SameSiteLaxMode || cookies[0].Path != "/api/v1/auth/oidc/callback" {
+		t.Fatalf("login cookies = %+v", cookies)
+	}
+	if strings.Contains(cookies[0].Value, mustParseQuery(t, query).Get("state")) {
+		t.Error("state cookie holds the state itself")
+	}
+
+	// A callback for a login started elsewhere, as in a login CSRF, is refused
+	if w := oidcCallback(query, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("callback without the state cookie status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	otherQuery, otherCookies := oidcAuthorize(t, mock, claims)
+	if w := oidcCallback(query, otherCookies); w.Code != http.StatusUnauthorized {
+		t.Errorf("callback with another login's state cookie status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if _, err := database.GetUserByOIDCSubject("subject-alice"); err == nil {
+		t.Error("user provisioned by a callback without the state cookie")
+	}
+
+	// Refused callbacks leave the login usable by the browser that started it
+	w := oidcCallback(query, cookies)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	if cleared := w.Result().Cookies(); len(cleared) != 1 || cleared[0].Name != oidcStateCookie || cleared[0].MaxAge >= 0 {
+		t.Errorf("state cookie not deleted: %+v", cleared)
+	}
+	if w := oidcCallback(otherQuery, otherCookies); w.Code != http.StatusOK {
+		t.Errorf("callback of the other login status = %d: %s", w.Code, w.Body)
+	}
+}
+
+func mustParseQuery(t *testing.T, query string) url.Values {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
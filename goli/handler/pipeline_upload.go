@@ -50,12 +50,12 @@ func UploadPipelineHandler(c *gin.Context) {
 	// Parse and validate pipeline definition
 	pipelineDef, err := pipeline.ParsePipelineDefinition(yamlContent)
 	if err != nil {
-		response_util.SendBadRequestResponseGin(c, "Invalid pipeline definition: "+err.Error())
+		sendPipelineValidationErrors(c, "Invalid pipeline definition", err)
 		return
 	}
 
 	if err := pipeline.ValidatePipelineDefinition(pipelineDef); err != nil {
-		response_util.SendBadRequestResponseGin(c, "Pipeline validation failed: "+err.Error())
+		sendPipelineValidationErrors(c, "Pipeline validation failed", err)
 		return
 	}
 
@@ -87,6 +87,8 @@ func UploadPipelineHandler(c *gin.Context) {
 		return
 	}
 
+	auditLog(c, "pipeline.create", "pipeline", createdPipeline.ID, nil, createdPipeline)
+
 	// Optionally run the pipeline immediately if "run" parameter is set
 	if c.PostForm("run") == "true" {
 		job := &models.Job{
@@ -94,6 +96,7 @@ func UploadPipelineHandler(c *gin.Context) {
 			PipelineID:  &createdPipeline.ID,
 			Status:      models.JobStatusPending,
 			TriggeredBy: "UI Upload",
+			CreatedBy:   c.GetString("username"),
 		}
 
 		if err := queue.GetQueue().Enqueue(job); err != nil {
@@ -101,6 +104,7 @@ func UploadPipelineHandler(c *gin.Context) {
 			response_util.SendOkResponseGin(c, "Pipeline created but failed to start: "+err.Error())
 			return
 		}
+		auditLog(c, "pipeline.run", "pipeline", createdPipeline.ID, nil, gin.H{"job_id": job.ID, "name": job.Name})
 
 		response_util.SendJsonResponseGin(c, 201, gin.H{
 			"pipeline":    createdPipeline,
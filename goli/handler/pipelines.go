@@ -2,6 +2,7 @@ package handler
 
 import (
 	"goli/database"
+	"goli/middlewares"
 	"goli/models"
 	"goli/pipeline"
 	"goli/queue"
@@ -18,6 +19,10 @@ func CreatePipelineHandler(c *gin.Context) {
 		Description string                 `json:"description"`
 		Definition  string                 `json:"definition"`          // YAML content
 		Variables   map[string]interface{} `json:"variables,omitempty"` // Map of variable name to {value, is_secret}
+
+		Repository     string `json:"repository,omitempty"` // Load the definition from a git repository instead
+		RepositoryRef  string `json:"repository_ref,omitempty"`
+		DefinitionPath string `json:"definition_path,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&body); err != nil {
@@ -25,23 +30,44 @@ func CreatePipelineHandler(c *gin.Context) {
 		return
 	}
 
+	if body.Repository != "" {
+		if body.Definition != "" {
+			response_util.SendBadRequestResponseGin(c, "A repository-backed pipeline cannot have an inline definition")
+			return
+		}
+		creds := pipeline.GitCredentialsFromVariables(variableValues(body.Variables))
+		definition, _, err := pipeline.LoadRepositoryDefinition(body.Repository, body.RepositoryRef, body.DefinitionPath, creds)
+		if err != nil {
+			response_util.SendBadRequestResponseGin(c, "Failed to load pipeline definition from repository: "+err.Error())
+			return
+		}
+		body.Definition = definition
+	}
+
 	// Parse and validate pipeline definition
 	pipelineDef, err := pipeline.ParsePipelineDefinition(body.Definition)
 	if err != nil {
-		response_util.SendBadRequestResponseGin(c, "Invalid pipeline definition: "+err.Error())
+		sendPipelineValidationErrors(c, "Invalid pipeline definition", err)
 		return
 	}
 
-	if err := pipeline.ValidatePipelineDefinition(pipelineDef); err != nil {
-		response_util.SendBadRequestResponseGin(c, "Pipeline validation failed: "+err.Error())
+	if err := pipeline.ValidatePipelineDefinition(pipelineDef, mapKeys(body.Variables)...); err != nil {
+		sendPipelineValidationErrors(c, "Pipeline validation failed", err)
 		return
 	}
 
+	if body.Name == "" {
+		body.Name = pipelineDef.Name
+	}
+
 	// Create pipeline in database
 	p := &models.Pipeline{
-		Name:        body.Name,
-		Description: body.Description,
-		Definition:  body.Definition,
+		Name:           body.Name,
+		Description:    body.Description,
+		Definition:     body.Definition,
+		Repository:     body.Repository,
+		RepositoryRef:  body.RepositoryRef,
+		DefinitionPath: body.DefinitionPath,
 	}
 
 	createdPipeline, err := database.CreatePipeline(p)
@@ -85,6 +111,8 @@ func CreatePipelineHandler(c *gin.Context) {
 		return
 	}
 
+	auditLog(c, "pipeline.create", "pipeline", finalPipeline.ID, nil, finalPipeline)
+
 	response_util.SendJsonResponseGin(c, 201, finalPipeline)
 }
 
@@ -113,7 +141,20 @@ func ListPipelinesHandler(c *gin.Context) {
 		return
 	}
 
-	response_util.SendJsonResponseGin(c, 200, pipelines)
+	// Leave out pipelines restricted by grants the user has no access to
+	visible := pipelines[:0]
+	for _, p := range pipelines {
+		allowed, err := middlewares.CanAccessPipeline(c, p.ID, models.PermPipelinesRead, models.PipelineAccessView)
+		if err != nil {
+			response_util.SendInternalServerErrorResponseGin(c, "Failed to check pipeline permissions: "+err.Error())
+			return
+		}
+		if allowed {
+			visible = append(visible, p)
+		}
+	}
+
+	response_util.SendJsonResponseGin(c, 200, visible)
 }
 
 // RunPipelineHandler creates a job to run a pipeline
@@ -125,15 +166,16 @@ func RunPipelineHandler(c *gin.Context) {
 	}
 
 	// Verify pipeline exists
-	_, err = database.GetPipeline(id)
+	p, err := database.GetPipeline(id)
 	if err != nil {
 		response_util.SendNotFoundResponseGin(c, "Pipeline not found")
 		return
 	}
 
 	var body struct {
-		Name        string `json:"name,omitempty"`
-		TriggeredBy string `json:"triggered_by,omitempty"`
+		Name        string            `json:"name,omitempty"`
+		TriggeredBy string            `json:"triggered_by,omitempty"`
+		Parameters  map[string]string `json:"parameters,omitempty"`
 	}
 
 	c.ShouldBindJSON(&body)
@@ -148,6 +190,8 @@ func RunPipelineHandler(c *gin.Context) {
 		PipelineID:  &id,
 		Status:      models.JobStatusPending,
 		TriggeredBy: body.TriggeredBy,
+		CreatedBy:   c.GetString("username"),
+		Parameters:  body.Parameters,
 	}
 
 	if err := queue.GetQueue().Enqueue(job); err != nil {
@@ -155,9 +199,82 @@ func RunPipelineHandler(c *gin.Context) {
 		return
 	}
 
+	auditLog(c, "pipeline.run", "pipeline", p.ID, nil, gin.H{"job_id": job.ID, "name": job.Name, "parameters": job.Parameters})
+
 	response_util.SendJsonResponseGin(c, 201, job)
 }
 
+// PlanPipelineHandler performs a dry run of a pipeline: it expands templates and matrices, validates,
+// substitutes variables (secrets masked), evaluates conditions and returns the commands each step would run.
+// Nothing is executed and no job is created.
+func PlanPipelineHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid pipeline ID")
+		return
+	}
+
+	var body struct {
+		Name        string            `json:"name,omitempty"`
+		TriggeredBy string            `json:"triggered_by,omitempty"`
+		Parameters  map[string]string `json:"parameters,omitempty"`
+	}
+	c.ShouldBindJSON(&body)
+
+	// Masked variables are substituted into the plan, the real values are only used to evaluate conditions
+	masked, err := database.GetPipeline(id)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "Pipeline not found")
+		return
+	}
+	withSecrets, err := database.GetPipelineWithSecrets(id)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to load pipeline: "+err.Error())
+		return
+	}
+
+	// Repository-backed pipelines are planned with the definition a run would load
+	if withSecrets.Repository != "" {
+		definition, _, err := pipeline.LoadPipelineDefinition(withSecrets)
+		if err != nil {
+			response_util.SendBadRequestResponseGin(c, "Failed to load pipeline definition from repository: "+err.Error())
+			return
+		}
+		masked.Definition = definition
+	}
+
+	pipelineDef, err := pipeline.ParsePipelineDefinition(masked.Definition)
+	if err != nil {
+		sendPipelineValidationErrors(c, "Invalid pipeline definition", err)
+		return
+	}
+	if err := pipeline.ValidatePipelineDefinition(pipelineDef, mapKeys(masked.Variables)...); err != nil {
+		sendPipelineValidationErrors(c, "Pipeline validation failed", err)
+		return
+	}
+
+	maskedVariables := pipeline.ResolveVariables(pipelineDef, masked.Variables)
+	pipeline.SubstituteVariables(pipelineDef, maskedVariables)
+	pipelineDef.Variables = pipeline.ResolveVariables(pipelineDef, withSecrets.Variables)
+
+	jobName := body.Name
+	if jobName == "" {
+		jobName = "Pipeline Run"
+	}
+	job := &models.Job{
+		Name:        jobName,
+		PipelineID:  &id,
+		Status:      models.JobStatusPending,
+		TriggeredBy: body.TriggeredBy,
+		Parameters:  body.Parameters,
+	}
+
+	plan := pipeline.PlanPipeline(job, pipelineDef)
+	plan.Variables = maskedVariables
+
+	response_util.SendJsonResponseGin(c, 200, plan)
+}
+
 // UpdatePipelineHandler updates an existing pipeline
 func UpdatePipelineHandler(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
@@ -167,17 +284,27 @@ func UpdatePipelineHandler(c *gin.Context) {
 	}
 
 	// Verify pipeline exists
-	_, err = database.GetPipeline(id)
+	existing, err := database.GetPipelineWithSecrets(id)
 	if err != nil {
 		response_util.SendNotFoundResponseGin(c, "Pipeline not found")
 		return
 	}
+	// Audited as returned by the API, with secrets masked
+	before, err := database.GetPipeline(id)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to retrieve pipeline: "+err.Error())
+		return
+	}
 
 	var body struct {
 		Name        string                 `json:"name"`
 		Description string                 `json:"description"`
 		Definition  string                 `json:"definition"`          // YAML content
 		Variables   map[string]interface{} `json:"variables,omitempty"` // Map of variable name to {value, is_secret}
+
+		Repository     *string `json:"repository,omitempty"` // "" turns a repository-backed pipeline into an inline one
+		RepositoryRef  string  `json:"repository_ref,omitempty"`
+		DefinitionPath string  `json:"definition_path,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&body); err != nil {
@@ -185,26 +312,69 @@ func UpdatePipelineHandler(c *gin.Context) {
 		return
 	}
 
+	// Sending the repository reloads the definition from it
+	repository, repositoryRef, definitionPath := existing.Repository, existing.RepositoryRef, existing.DefinitionPath
+	if body.Repository != nil {
+		repository, repositoryRef, definitionPath = *body.Repository, body.RepositoryRef, body.DefinitionPath
+	}
+	if repository != "" && body.Definition != "" {
+		response_util.SendBadRequestResponseGin(c, "A repository-backed pipeline cannot have an inline definition")
+		return
+	}
+	if repository != "" && body.Repository != nil {
+		variables := existing.Variables
+		if body.Variables != nil {
+			variables = variableValues(body.Variables)
+			for name, value := range variables {
+				if value == "***MASKED***" {
+					variables[name] = existing.Variables[name]
+				}
+			}
+		}
+		definition, _, err := pipeline.LoadRepositoryDefinition(repository, repositoryRef, definitionPath, pipeline.GitCredentialsFromVariables(variables))
+		if err != nil {
+			response_util.SendBadRequestResponseGin(c, "Failed to load pipeline definition from repository: "+err.Error())
+			return
+		}
+		body.Definition = definition
+	}
+
 	// If definition is provided, parse and validate it
 	if body.Definition != "" {
 		pipelineDef, err := pipeline.ParsePipelineDefinition(body.Definition)
 		if err != nil {
-			response_util.SendBadRequestResponseGin(c, "Invalid pipeline definition: "+err.Error())
+			sendPipelineValidationErrors(c, "Invalid pipeline definition", err)
 			return
 		}
 
-		if err := pipeline.ValidatePipelineDefinition(pipelineDef); err != nil {
-			response_util.SendBadRequestResponseGin(c, "Pipeline validation failed: "+err.Error())
+		// Variables sent with the update replace the stored ones
+		variableNames := mapKeys(body.Variables)
+		if body.Variables == nil {
+			storedVars, err := database.GetPipelineVariables(id)
+			if err != nil {
+				response_util.SendInternalServerErrorResponseGin(c, "Failed to load existing variables: "+err.Error())
+				return
+			}
+			for _, v := range storedVars {
+				variableNames = append(variableNames, v.Name)
+			}
+		}
+
+		if err := pipeline.ValidatePipelineDefinition(pipelineDef, variableNames...); err != nil {
+			sendPipelineValidationErrors(c, "Pipeline validation failed", err)
 			return
 		}
 	}
 
 	// Update pipeline
 	p := &models.Pipeline{
-		ID:          id,
-		Name:        body.Name,
-		Description: body.Description,
-		Definition:  body.Definition,
+		ID:             id,
+		Name:           body.Name,
+		Description:    body.Description,
+		Definition:     body.Definition,
+		Repository:     repository,
+		RepositoryRef:  repositoryRef,
+		DefinitionPath: definitionPath,
 	}
 
 	// Only update fields that are provided
@@ -317,6 +487,8 @@ func UpdatePipelineHandler(c *gin.Context) {
 		return
 	}
 
+	auditLog(c, "pipeline.update", "pipeline", id, before, updatedPipeline)
+
 	response_util.SendJsonResponseGin(c, 200, updatedPipeline)
 }
 
@@ -329,7 +501,7 @@ func DeletePipelineHandler(c *gin.Context) {
 	}
 
 	// Verify pipeline exists before deletion
-	_, err = database.GetPipeline(id)
+	p, err := database.GetPipeline(id)
 	if err != nil {
 		response_util.SendNotFoundResponseGin(c, "Pipeline not found")
 		return
@@ -341,5 +513,41 @@ func DeletePipelineHandler(c *gin.Context) {
 		return
 	}
 
+	auditLog(c, "pipeline.delete", "pipeline", id, p, nil)
+
 	response_util.SendOkResponseGin(c, "Pipeline and all related jobs deleted successfully")
 }
+
+// sendPipelineValidationErrors responds with 400 and every problem found in a pipeline definition,
+// each with the file, line and column it was found at
+func sendPipelineValidationErrors(c *gin.Context, description string, err error) {
+	response_util.SendJsonResponseGin(c, 400, gin.H{
+		"status":      "error",
+		"description": description + ": " + err.Error(),
+		"errors":      pipeline.AsValidationErrors(err),
+	})
+}
+
+// mapKeys returns the keys of a map
+func mapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// variableValues returns the values of variables sent as plain strings or {value, is_secret} objects
+func variableValues(variables map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(variables))
+	for name, varData := range variables {
+		if varStr, ok := varData.(string); ok {
+			values[name] = varStr
+		} else if varMap, ok := varData.(map[string]interface{}); ok {
+			if val, ok := varMap["value"].(string); ok {
+				values[name] = val
+			}
+		}
+	}
+	return values
+}
@@ -1,20 +1,27 @@
 package handler
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	"database/sql"
+	"goli/database"
+	"goli/models"
 	response_util "goli/utils"
 	"log"
-	"os/exec"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthenticateContainerRegistryHandler handles GitHub Container Registry authentication
+// AuthenticateContainerRegistryHandler logs Docker in to a container registry
+// Accepts {host, username, token}; the legacy {gh_username, gh_access_token} body logs in to ghcr.io.
+// If only a host is given, the stored credentials for that host are used.
 func AuthenticateContainerRegistryHandler(c *gin.Context) {
 	var body struct {
+		Host          string `json:"host"`
+		Username      string `json:"username"`
+		Token         string `json:"token"`
 		GHUsername    string `json:"gh_username"`
 		GHAccessToken string `json:"gh_access_token"`
 	}
@@ -24,34 +31,185 @@ func AuthenticateContainerRegistryHandler(c *gin.Context) {
 		return
 	}
 
-	if body.GHUsername == "" || body.GHAccessToken == "" {
-		response_util.SendBadRequestResponseGin(c, "GitHub username and access token are required")
+	// Legacy GitHub-only payload
+	if body.GHUsername != "" || body.GHAccessToken != "" {
+		body.Host = "ghcr.io"
+		body.Username = body.GHUsername
+		body.Token = body.GHAccessToken
+	}
+
+	host := response_util.NormalizeRegistryHost(body.Host)
+
+	if body.Username == "" && body.Token == "" {
+		cred, err := database.GetRegistryCredentialByHost(host)
+		if err != nil {
+			response_util.SendNotFoundResponseGin(c, "No stored credentials for registry "+host)
+			return
+		}
+		body.Username = cred.Username
+		body.Token = cred.Token
+	}
+
+	if body.Username == "" || body.Token == "" {
+		response_util.SendBadRequestResponseGin(c, "Username and token are required")
 		return
 	}
 
-	// Execute docker login with GitHub Container Registry
+	auditLog(c, "registry.login", "registry", host, nil, nil)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Create docker login command with password from stdin for security
-	cmd := exec.CommandContext(ctx, "docker", "login", "ghcr.io", "-u", body.GHUsername, "--password-stdin")
+	if err := response_util.DockerLogin(ctx, host, body.Username, body.Token); err != nil {
+		log.Printf("Docker login failed: %v", err)
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to authenticate with registry: "+err.Error())
+		return
+	}
+
+	log.Printf("Successfully authenticated to registry %s as %s", host, body.Username)
+	response_util.SendOkResponseGin(c, "Successfully authenticated to registry "+host)
+}
+
+// ListRegistryCredentialsHandler lists all stored registry credentials (tokens are never returned)
+func ListRegistryCredentialsHandler(c *gin.Context) {
+	creds, err := database.ListRegistryCredentials()
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to list registries: "+err.Error())
+		return
+	}
+	for _, cred := range creds {
+		cred.Token = "***MASKED***"
+	}
+	if creds == nil {
+		creds = []*models.RegistryCredential{}
+	}
+
+	response_util.SendJsonResponseGin(c, 200, creds)
+}
+
+// CreateRegistryCredentialHandler stores credentials for a registry host
+func CreateRegistryCredentialHandler(c *gin.Context) {
+	var body struct {
+		Host     string `json:"host"`
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
 
-	// Write password to stdin
-	cmd.Stdin = bytes.NewBufferString(body.GHAccessToken)
+	if strings.TrimSpace(body.Host) == "" || body.Username == "" || body.Token == "" {
+		response_util.SendBadRequestResponseGin(c, "Host, username and token are required")
+		return
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	cred := &models.RegistryCredential{
+		Host:     response_util.NormalizeRegistryHost(body.Host),
+		Username: body.Username,
+		Token:    body.Token,
+	}
 
-	err := cmd.Run()
-	stderrStr := stderr.String()
+	if _, err := database.GetRegistryCredentialByHost(cred.Host); err == nil {
+		response_util.SendBadRequestResponseGin(c, "Credentials for registry "+cred.Host+" already exist")
+		return
+	}
+
+	created, err := database.CreateRegistryCredential(cred)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to create registry credentials: "+err.Error())
+		return
+	}
+
+	auditLog(c, "registry.create", "registry", created.ID, nil, created)
+
+	created.Token = "***MASKED***"
+	response_util.SendJsonResponseGin(c, 201, created)
+}
 
+// UpdateRegistryCredentialHandler updates stored registry credentials
+// A missing or masked token keeps the existing one
+func UpdateRegistryCredentialHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		log.Printf("Docker login failed: %v, stderr: %s", err, stderrStr)
-		response_util.SendInternalServerErrorResponseGin(c, fmt.Sprintf("Failed to authenticate with GitHub Container Registry: %s", stderrStr))
+		response_util.SendBadRequestResponseGin(c, "Invalid registry ID")
 		return
 	}
 
-	log.Printf("Successfully authenticated to GitHub Container Registry as %s", body.GHUsername)
-	response_util.SendOkResponseGin(c, "Successfully authenticated to GitHub Container Registry")
+	var body struct {
+		Host     string `json:"host,omitempty"`
+		Username string `json:"username,omitempty"`
+		Token    string `json:"token,omitempty"`
+	}
+
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	cred, err := database.GetRegistryCredential(id)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "Registry credentials not found")
+		return
+	}
+	before := *cred
+	oldHost := cred.Host
+
+	if strings.TrimSpace(body.Host) != "" {
+		cred.Host = response_util.NormalizeRegistryHost(body.Host)
+	}
+	if body.Username != "" {
+		cred.Username = body.Username
+	}
+	updateToken := body.Token != "" && body.Token != "***MASKED***"
+	if updateToken {
+		cred.Token = body.Token
+	}
+
+	if err := database.UpdateRegistryCredential(cred, updateToken); err != nil {
+		if err == sql.ErrNoRows {
+			response_util.SendNotFoundResponseGin(c, "Registry credentials not found")
+			return
+		}
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to update registry credentials: "+err.Error())
+		return
+	}
+
+	response_util.ForgetRegistryLogin(oldHost)
+	response_util.ForgetRegistryLogin(cred.Host)
+	auditLog(c, "registry.update", "registry", cred.ID, before, cred)
+
+	updated, err := database.GetRegistryCredential(id)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to retrieve updated registry credentials: "+err.Error())
+		return
+	}
+	updated.Token = "***MASKED***"
+	response_util.SendJsonResponseGin(c, 200, updated)
+}
+
+// DeleteRegistryCredentialHandler deletes stored registry credentials
+func DeleteRegistryCredentialHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid registry ID")
+		return
+	}
+
+	cred, err := database.GetRegistryCredential(id)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "Registry credentials not found")
+		return
+	}
+
+	if err := database.DeleteRegistryCredential(id); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to delete registry credentials: "+err.Error())
+		return
+	}
+
+	response_util.ForgetRegistryLogin(cred.Host)
+	auditLog(c, "registry.delete", "registry", cred.ID, cred, nil)
+
+	response_util.SendOkResponseGin(c, "Registry credentials deleted successfully")
 }
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"database/sql"
+	"goli/database"
+	"goli/models"
+	response_util "goli/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSessionsHandler lists the sessions of the current user, admins get all sessions with ?all=true
+func ListSessionsHandler(c *gin.Context) {
+	userID := c.GetInt64("user_id")
+	if c.Query("all") == "true" && c.GetString("user_role") == models.RoleAdmin {
+		userID = 0
+	} else if userID == 0 {
+		response_util.SendBadRequestResponseGin(c, "Sessions belong to users, log in as a user")
+		return
+	}
+
+	sessions, err := database.ListSessions(userID)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to list sessions: "+err.Error())
+		return
+	}
+
+	currentID := c.GetInt64("session_id")
+	for _, session := range sessions {
+		session.Current = session.ID == currentID
+	}
+
+	response_util.SendJsonResponseGin(c, 200, sessions)
+}
+
+// DeleteSessionHandler revokes a session of the current user, admins can revoke any session
+func DeleteSessionHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid session ID")
+		return
+	}
+
+	session, err := database.GetSession(id)
+	if err != nil || (session.UserID != c.GetInt64("user_id") && c.GetString("user_role") != models.RoleAdmin) {
+		response_util.SendNotFoundResponseGin(c, "Session not found")
+		return
+	}
+
+	if err := database.DeleteSessionByID(id); err != nil {
+		if err == sql.ErrNoRows {
+			response_util.SendNotFoundResponseGin(c, "Session not found")
+			return
+		}
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to revoke session: "+err.Error())
+		return
+	}
+
+	auditLog(c, "session.revoke", "session", session.ID, session, nil)
+
+	response_util.SendOkResponseGin(c, "Session revoked")
+}
+
+// RevokeUserSessionsHandler revokes every session of a user, logging them out everywhere (admin)
+func RevokeUserSessionsHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid user ID")
+		return
+	}
+
+	user, err := database.GetUser(id)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "User not found")
+		return
+	}
+
+	revoked, err := database.DeleteUserSessions(user.ID, 0)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to revoke sessions: "+err.Error())
+		return
+	}
+
+	auditLog(c, "session.revoke_all", "user", user.ID, nil, gin.H{"username": user.Username, "revoked": revoked})
+
+	response_util.SendJsonResponseGin(c, 200, gin.H{
+		"message": "Sessions revoked",
+		"revoked": revoked,
+	})
+}
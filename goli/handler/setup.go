@@ -1,60 +1,118 @@
 package handler
 
 import (
+	"crypto/subtle"
 	aux "goli/auxiliary"
+	"goli/database"
+	"goli/models"
 	response_util "goli/utils"
+	"log"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// VerifySetupPasswordHandler verifies the setup password (no auth required)
-func VerifySetupPasswordHandler(c *gin.Context) {
+// setupLimiterKey counts wrong setup passwords in the login backoff
+const setupLimiterKey = "setup"
+
+// BootstrapHandler creates the first admin user and completes setup (no auth required). It needs the
+// one-time setup_password from config.toml, works once and answers 403 afterwards. The new admin is
+// logged in right away
+func BootstrapHandler(c *gin.Context) {
 	var body struct {
 		SetupPassword string `json:"setup_password"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		Email         string `json:"email,omitempty"`
 	}
-
 	if err := c.ShouldBindJSON(&body); err != nil {
 		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
 		return
 	}
 
-	config := aux.GetAllConfig()
-	setupComplete := config["setup_complete"] == "true"
-	storedPassword := config["setup_password"]
-
-	// If setup is already complete, reject any setup password
-	if setupComplete {
-		response_util.SendUnauthorizedResponseGin(c, "Setup has already been completed")
+	complete, err := database.IsSetupComplete()
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to read setup state: "+err.Error())
+		return
+	}
+	if complete {
+		response_util.SendForbiddenResponseGin(c, "Setup has already been completed")
 		return
 	}
 
-	// If no setup password is stored, reject
+	storedPassword := aux.GetFromConfig("constants.setup_password")
 	if storedPassword == "" {
-		response_util.SendUnauthorizedResponseGin(c, "Setup password not configured")
+		response_util.SendForbiddenResponseGin(c, "Setup password not configured, create the first admin with `goli admin create`")
 		return
 	}
-
-	// Verify the password
-	if body.SetupPassword != storedPassword {
+	if checkLoginBackoff(c, setupLimiterKey) {
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(body.SetupPassword), []byte(storedPassword)) != 1 {
+		loginLimiter.fail(loginLimiterKeys(c, setupLimiterKey)...)
 		response_util.SendUnauthorizedResponseGin(c, "Invalid setup password")
 		return
 	}
 
-	// Password is valid - return the auth key for setup completion
-	authKey := config["auth_key"]
-	response_util.SendJsonResponseGin(c, 200, gin.H{
-		"message":  "Setup password verified",
-		"auth_key": authKey,
+	body.Username = strings.TrimSpace(body.Username)
+	if body.Username == "" || body.Password == "" {
+		response_util.SendBadRequestResponseGin(c, "Username and password are required")
+		return
+	}
+	if err := response_util.ValidatePassword(body.Password, body.Username); err != nil {
+		response_util.SendBadRequestResponseGin(c, err.Error())
+		return
+	}
+
+	user, err := database.BootstrapAdmin(&models.User{
+		Username: body.Username,
+		Email:    strings.TrimSpace(body.Email),
+		Password: body.Password,
+	})
+	if err != nil {
+		if err == database.ErrSetupComplete {
+			response_util.SendForbiddenResponseGin(c, "Setup has already been completed")
+			return
+		}
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to create admin user: "+err.Error())
+		return
+	}
+	ClearSetupPassword()
+
+	auditLog(c, "setup.bootstrap", "user", user.ID, nil, user)
+
+	token, expires, err := newSession(c, user.ID)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Admin user created, but failed to create session: "+err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 201, gin.H{
+		"message":    "Setup complete",
+		"token":      token,
+		"expires_at": expires,
+		"user":       user,
 	})
 }
 
 // GetSetupStatusHandler returns whether setup is complete (no auth required)
 func GetSetupStatusHandler(c *gin.Context) {
-	config := aux.GetAllConfig()
-	setupComplete := config["setup_complete"] == "true"
+	complete, err := database.IsSetupComplete()
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to read setup state: "+err.Error())
+		return
+	}
 
 	response_util.SendJsonResponseGin(c, 200, gin.H{
-		"setup_complete": setupComplete,
+		"setup_complete": complete,
 	})
 }
 
+// ClearSetupPassword removes the one-time setup password from config.toml once setup is complete, and
+// keeps setup_complete there for tools reading the config file. Failures are logged, the database
+// already disables the bootstrap
+func ClearSetupPassword() {
+	if err := aux.UpdateConfig(map[string]string{"setup_complete": "true", "setup_password": ""}); err != nil {
+		log.Printf("Failed to clear the setup password in config.toml: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"database/sql"
+	"goli/database"
+	"goli/models"
+	response_util "goli/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiTokenDisplayLength is how many characters of a token are kept to recognize it
+const apiTokenDisplayLength = len(models.APITokenPrefix) + 8
+
+// ListAPITokensHandler lists the API tokens of the current user, admins get all tokens with ?all=true
+func ListAPITokensHandler(c *gin.Context) {
+	userID := c.GetInt64("user_id")
+	if c.Query("all") == "true" && c.GetString("user_role") == models.RoleAdmin {
+		userID = 0
+	} else if userID == 0 {
+		response_util.SendBadRequestResponseGin(c, "API tokens belong to users, log in as a user")
+		return
+	}
+
+	tokens, err := database.ListAPITokens(userID)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to list API tokens: "+err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, tokens)
+}
+
+// CreateAPITokenHandler creates an API token for the current user. The token is only returned in this response
+func CreateAPITokenHandler(c *gin.Context) {
+	var body struct {
+		Name          string     `json:"name"`
+		Scopes        []string   `json:"scopes"`
+		ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+		ExpiresInDays int        `json:"expires_in_days,omitempty"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	userID := c.GetInt64("user_id")
+	if userID == 0 {
+		response_util.SendBadRequestResponseGin(c, "API tokens belong to users, log in as a user")
+		return
+	}
+	if body.Name == "" {
+		response_util.SendBadRequestResponseGin(c, "Name is required")
+		return
+	}
+	if len(body.Scopes) == 0 {
+		response_util.SendBadRequestResponseGin(c, "At least one scope is required")
+		return
+	}
+
+	// A token can not do more than its user, nor more than the token used to create it
+	role := c.GetString("user_role")
+	parentScopes, scoped := c.Get("token_scopes")
+	for _, scope := range body.Scopes {
+		if !models.IsValidPermission(scope) {
+			response_util.SendBadRequestResponseGin(c, "Unknown scope: "+scope)
+			return
+		}
+		if !models.RoleHasPermission(role, scope) || (scoped && !containsString(parentScopes.([]string), scope)) {
+			response_util.SendForbiddenResponseGin(c, "Scope "+scope+" exceeds your permissions")
+			return
+		}
+	}
+
+	expiresAt := body.ExpiresAt
+	if body.ExpiresInDays < 0 {
+		response_util.SendBadRequestResponseGin(c, "expires_in_days must not be negative")
+		return
+	}
+	if body.ExpiresInDays > 0 {
+		expires := time.Now().UTC().AddDate(0, 0, body.ExpiresInDays)
+		expiresAt = &expires
+	}
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		response_util.SendBadRequestResponseGin(c, "expires_at must be in the future")
+		return
+	}
+
+	secret, err := generateRandomToken(32)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed generating token")
+		return
+	}
+	plain := models.APITokenPrefix + secret
+
+	token, err := database.CreateAPIToken(&models.APIToken{
+		UserID:    userID,
+		Username:  c.GetString("username"),
+		Name:      body.Name,
+		Prefix:    plain[:apiTokenDisplayLength],
+		Token:     plain,
+		Scopes:    body.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to create API token: "+err.Error())
+		return
+	}
+
+	auditLog(c, "token.create", "token", token.ID, nil, token)
+
+	response_util.SendJsonResponseGin(c, 201, token)
+}
+
+// DeleteAPITokenHandler revokes an API token of the current user, admins can revoke any token
+func DeleteAPITokenHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid token ID")
+		return
+	}
+
+	token, err := database.GetAPIToken(id)
+	if err != nil || (token.UserID != c.GetInt64("user_id") && c.GetString("user_role") != models.RoleAdmin) {
+		response_util.SendNotFoundResponseGin(c, "API token not found")
+		return
+	}
+
+	if err := database.DeleteAPIToken(id); err != nil {
+		if err == sql.ErrNoRows {
+			response_util.SendNotFoundResponseGin(c, "API token not found")
+			return
+		}
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to revoke API token: "+err.Error())
+		return
+	}
+
+	auditLog(c, "token.revoke", "token", token.ID, token, nil)
+
+	response_util.SendOkResponseGin(c, "API token revoked")
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
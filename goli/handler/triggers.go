@@ -37,6 +37,8 @@ func handleContainerAction(c *gin.Context, action string) {
 		return
 	}
 
+	auditLog(c, "docker.container."+action, "container", body.Name, nil, nil)
+
 	res, err := DoDockerContainerAction(body.Name, action)
 	if err != nil {
 		response_util.SendInternalServerErrorResponseGin(c, err.Error())
@@ -57,6 +59,8 @@ func handleImageAction(c *gin.Context, action string) {
 		return
 	}
 
+	auditLog(c, "docker.image."+action, "image", body.Image, nil, nil)
+
 	res, err := DoDockerImageAction(body.Image, action)
 	if err != nil {
 		response_util.SendInternalServerErrorResponseGin(c, err.Error())
@@ -160,6 +164,10 @@ func PullAnDockerImage(c *gin.Context) {
 	handleImageAction(c, "pull")
 }
 
+func PushAnDockerImage(c *gin.Context) {
+	handleImageAction(c, "push")
+}
+
 func RunDockerContainer(c *gin.Context) {
 	body, ok := decodeAndValidateBodyGin(c)
 	if !ok {
@@ -290,9 +298,9 @@ func DoDockerImageAction(image string, action string) (string, error) {
 		return "", errors.New("image name cannot be empty")
 	}
 
-	// Ensure GitHub Container Registry authentication if pulling from ghcr.io
-	if action == "pull" {
-		response_util.EnsureGitHubAuthForImage(image)
+	// Log in to the image's registry if credentials are stored for it
+	if action == "pull" || action == "push" {
+		response_util.EnsureRegistryAuthForImage(image)
 	}
 
 	var args []string
@@ -301,6 +309,8 @@ func DoDockerImageAction(image string, action string) (string, error) {
 		args = []string{"rmi", "-f", image}
 	case "pull":
 		args = []string{"pull", image}
+	case "push":
+		args = []string{"push", image}
 	default:
 		return "", fmt.Errorf("unknown action: %s", action)
 	}
@@ -354,6 +364,9 @@ func createContainer(ctx context.Context, image string, name string, network str
 	// Add image name at the end
 	args = append(args, image)
 
+	// docker run pulls missing images, so make sure the registry login is in place
+	response_util.EnsureRegistryAuthForImage(image)
+
 	log.Printf("Executing: docker %s", strings.Join(args, " "))
 
 	stdout, stderr, err := executeDockerCommand(ctx, args...)
@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"goli/database"
+	response_util "goli/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets
+const recoveryCodeCount = 10
+
+// GetTwoFactorStatusHandler returns which second factors the current user has enabled
+func GetTwoFactorStatusHandler(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	remaining, err := database.CountRecoveryCodes(user.ID)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to count recovery codes: "+err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, gin.H{
+		"email":                    user.TwoFAEmailEnabled == 1,
+		"sms":                      user.TwoFASmsEnabled == 1,
+		"totp":                     user.TwoFATOTPEnabled == 1,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// EnrollTOTPHandler starts authenticator app enrollment: it returns a new secret and the otpauth://
+// provisioning URI to show as QR code. The secret is only used after ConfirmTOTPHandler
+func EnrollTOTPHandler(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TwoFATOTPEnabled == 1 {
+		response_util.SendBadRequestResponseGin(c, "An authenticator app is already enabled, disable it first")
+		return
+	}
+
+	secret, err := response_util.GenerateTOTPSecret()
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed generating TOTP secret")
+		return
+	}
+	if err := database.SetPendingTOTPSecret(user.ID, secret); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to store TOTP secret: "+err.Error())
+		return
+	}
+
+	response_util.SendJsonResponseGin(c, 200, gin.H{
+		"secret":           secret,
+		"provisioning_uri": response_util.TOTPProvisioningURI(user.Username, secret),
+		"issuer":           response_util.TOTPIssuer,
+		"account":          user.Username,
+	})
+}
+
+// ConfirmTOTPHandler enables the enrolled authenticator app once it produced a valid code, and returns
+// the recovery codes. They are only shown in this response
+func ConfirmTOTPHandler(c *gin.Context) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+
+	state, err := database.GetTOTPState(user.ID)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to load TOTP enrollment: "+err.Error())
+		return
+	}
+	if state.Enabled {
+		response_util.SendBadRequestResponseGin(c, "The authenticator app is already enabled")
+		return
+	}
+	if state.Secret == "" {
+		response_util.SendBadRequestResponseGin(c, "No enrollment in progress, start one with POST /api/v1/2fa/totp/enroll")
+		return
+	}
+
+	step, valid := response_util.ValidateTOTP(state.Secret, body.Code, time.Now())
+	if !valid {
+		response_util.SendBadRequestResponseGin(c, "Invalid code")
+		return
+	}
+	if err := database.EnableTOTP(user.ID, step); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to enable TOTP: "+err.Error())
+		return
+	}
+
+	codes, err := newRecoveryCodes(user.ID)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to create recovery codes: "+err.Error())
+		return
+	}
+
+	auditLog(c, "user.2fa.totp.enable", "user", user.ID, nil, gin.H{"username": user.Username})
+
+	response_util.SendJsonResponseGin(c, 200, gin.H{
+		"message":        "Authenticator app enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTPHandler removes the authenticator app of the current user, confirmed with a code from it
+// or a recovery code
+func DisableTOTPHandler(c *gin.Context) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TwoFATOTPEnabled != 1 {
+		response_util.SendBadRequestResponseGin(c, "No authenticator app is enabled")
+		return
+	}
+
+	if !verifyTOTPOrRecoveryCode(c, user.ID, body.Code) {
+		return
+	}
+	if err := database.DisableTOTP(user.ID); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to disable TOTP: "+err.Error())
+		return
+	}
+
+	auditLog(c, "user.2fa.totp.disable", "user", user.ID, nil, gin.H{"username": user.Username})
+
+	response_util.SendOkResponseGin(c, "Authenticator app disabled")
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes of the current user, confirmed with a code
+// from the authenticator app or a recovery code
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid request body: "+err.Error())
+		return
+	}
+
+	user, ok := currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TwoFATOTPEnabled != 1 {
+		response_util.SendBadRequestResponseGin(c, "Recovery codes require an authenticator app")
+		return
+	}
+
+	if !verifyTOTPOrRecoveryCode(c, user.ID, body.Code) {
+		return
+	}
+	codes, err := newRecoveryCodes(user.ID)
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to create recovery codes: "+err.Error())
+		return
+	}
+
+	auditLog(c, "user.2fa.recovery_codes", "user", user.ID, nil, gin.H{"username": user.Username})
+
+	response_util.SendJsonResponseGin(c, 200, gin.H{
+		"recovery_codes": codes,
+	})
+}
+
+// ResetUserTwoFactorHandler turns off every second factor of a user, for users who lost their device (admin)
+func ResetUserTwoFactorHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response_util.SendBadRequestResponseGin(c, "Invalid user ID")
+		return
+	}
+
+	user, err := database.GetUser(id)
+	if err != nil {
+		response_util.SendNotFoundResponseGin(c, "User not found")
+		return
+	}
+
+	if err := database.ResetTwoFactor(user.ID); err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to reset 2FA: "+err.Error())
+		return
+	}
+
+	auditLog(c, "user.2fa.reset", "user", user.ID, nil, gin.H{"username": user.Username})
+
+	response_util.SendOkResponseGin(c, "2FA reset, the user can log in with their password")
+}
+
+// verifyTOTPOrRecoveryCode checks a code from the authenticator app or a recovery code of a user and
+// responds with an error if it is neither
+func verifyTOTPOrRecoveryCode(c *gin.Context, userID int64, code string) bool {
+	valid, err := checkTOTPCode(userID, code)
+	if err == nil && !valid {
+		valid, err = database.UseRecoveryCode(userID, normalizeRecoveryCode(code))
+	}
+	if err != nil {
+		response_util.SendInternalServerErrorResponseGin(c, "Failed to verify code: "+err.Error())
+		return false
+	}
+	if !valid {
+		response_util.SendBadRequestResponseGin(c, "Invalid code")
+		return false
+	}
+	return true
+}
+
+// checkTOTPCode reports whether code is a current code of the user's enabled authenticator app that was
+// not used before
+func checkTOTPCode(userID int64, code string) (bool, error) {
+	state, err := database.GetTOTPState(userID)
+	if err != nil {
+		return false, err
+	}
+	if !state.Enabled {
+		return false, nil
+	}
+
+	step, valid := response_util.ValidateTOTP(state.Secret, code, time.Now())
+	if !valid {
+		return false, nil
+	}
+	// Codes stay valid for the whole step (and the allowed skew), only accept each one once
+	return database.UseTOTPStep(userID, step) == nil, nil
+}
+
+// newRecoveryCodes generates a fresh set of recovery codes for a user and returns them formatted as xxxxx-xxxxx
+func newRecoveryCodes(userID int64) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	normalized := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		normalized = append(normalized, code)
+	}
+
+	if err := database.ReplaceRecoveryCodes(userID, normalized); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode accepts recovery codes with or without the dash and in any case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -5,6 +5,7 @@ import (
 	"goli/models"
 	response_util "goli/utils"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -33,8 +34,8 @@ func CreateUserHandler(c *gin.Context) {
 		return
 	}
 
-	if len(body.Password) < 6 {
-		response_util.SendBadRequestResponseGin(c, "Password must be at least 6 characters")
+	if err := response_util.ValidatePassword(body.Password, body.Username); err != nil {
+		response_util.SendBadRequestResponseGin(c, err.Error())
 		return
 	}
 
@@ -49,7 +50,11 @@ func CreateUserHandler(c *gin.Context) {
 	}
 
 	if user.Role == "" {
-		user.Role = "user"
+		user.Role = models.RoleViewer
+	}
+	if !models.IsValidRole(user.Role) {
+		response_util.SendBadRequestResponseGin(c, "Role must be one of "+strings.Join(models.Roles, ", "))
+		return
 	}
 
 	createdUser, err := database.CreateUser(user)
@@ -61,6 +66,7 @@ func CreateUserHandler(c *gin.Context) {
 	// Don't return password
 	createdUser.Password = ""
 	createdUser.PasswordHash = ""
+	auditLog(c, "user.create", "user", createdUser.ID, nil, createdUser)
 
 	response_util.SendJsonResponseGin(c, 201, createdUser)
 }
@@ -85,6 +91,7 @@ func UpdateUserHandler(c *gin.Context) {
 		response_util.SendNotFoundResponseGin(c, "User not found")
 		return
 	}
+	before := *user
 
 	// Update fields
 	if body.Email != "" {
@@ -94,6 +101,10 @@ func UpdateUserHandler(c *gin.Context) {
 		user.Phone = body.Phone
 	}
 	if body.Role != "" {
+		if !models.IsValidRole(body.Role) {
+			response_util.SendBadRequestResponseGin(c, "Role must be one of "+strings.Join(models.Roles, ", "))
+			return
+		}
 		user.Role = body.Role
 	}
 	if body.TwoFAEmailEnabled != nil {
@@ -105,8 +116,8 @@ func UpdateUserHandler(c *gin.Context) {
 
 	updatePassword := body.Password != ""
 	if updatePassword {
-		if len(body.Password) < 6 {
-			response_util.SendBadRequestResponseGin(c, "Password must be at least 6 characters")
+		if err := response_util.ValidatePassword(body.Password, user.Username); err != nil {
+			response_util.SendBadRequestResponseGin(c, err.Error())
 			return
 		}
 		user.Password = body.Password
@@ -120,6 +131,7 @@ func UpdateUserHandler(c *gin.Context) {
 	// Don't return password
 	user.Password = ""
 	user.PasswordHash = ""
+	auditLog(c, "user.update", "user", user.ID, auditedUser{&before, false}, auditedUser{user, updatePassword})
 
 	response_util.SendJsonResponseGin(c, 200, user)
 }
@@ -132,10 +144,13 @@ func DeleteUserHandler(c *gin.Context) {
 		return
 	}
 
+	before, _ := database.GetUser(id)
+
 	if err := database.DeleteUser(id); err != nil {
 		response_util.SendInternalServerErrorResponseGin(c, "Failed to delete user: "+err.Error())
 		return
 	}
+	auditLog(c, "user.delete", "user", id, before, nil)
 
 	response_util.SendOkResponseGin(c, "User deleted successfully")
 }
@@ -1,18 +1,51 @@
 package handler
 
 import (
+	aux "goli/auxiliary"
 	ws "goli/websocket"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for now
-	},
+	CheckOrigin: checkOrigin,
+}
+
+// checkOrigin accepts WebSocket connections from pages served by Goli itself and from the origins
+// listed in allowed_origins in config.toml, so other sites cannot open connections with a user's token
+func checkOrigin(r *http.Request) bool {
+	var allowed string
+	if _, err := os.Stat(aux.GetConfigPath()); err == nil {
+		allowed = aux.GetFromConfig("constants.allowed_origins")
+	}
+	return originAllowed(r.Header.Get("Origin"), r.Host, allowed)
+}
+
+// originAllowed reports whether a WebSocket connection from origin to host may be opened. Requests
+// without an Origin do not come from a browser. allowed is a comma separated list of origins
+func originAllowed(origin, host, allowed string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, host) {
+		return true
+	}
+	for _, entry := range strings.Split(allowed, ",") {
+		if entry = strings.TrimSuffix(strings.TrimSpace(entry), "/"); entry != "" && strings.EqualFold(entry, origin) {
+			return true
+		}
+	}
+	return false
 }
 
 // ServeWebSocket handles websocket requests from clients
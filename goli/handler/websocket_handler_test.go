@@ -0,0 +1,25 @@
+package handler
+
+import "testing"
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		origin, host, allowed string
+		want                  bool
+	}{
+		{"", "goli.example.com", "", true},
+		{"https://goli.example.com", "goli.example.com", "", true},
+		{"http://localhost:5173", "localhost:5173", "", true},
+		{"https://evil.example.com", "goli.example.com", "", false},
+		{"https://goli.example.com.evil.com", "goli.example.com", "", false},
+		{"null", "goli.example.com", "", false},
+		{"https://ui.example.com", "goli.example.com", "https://ui.example.com", true},
+		{"https://ui.example.com", "goli.example.com", " https://other.example.com , https://ui.example.com/ ", true},
+		{"http://ui.example.com", "goli.example.com", "https://ui.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := originAllowed(tt.origin, tt.host, tt.allowed); got != tt.want {
+			t.Errorf("originAllowed(%q, %q, %q) = %v, want %v", tt.origin, tt.host, tt.allowed, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"goli/database"
 	"goli/handler"
 	"goli/middlewares"
+	"goli/pipeline"
 	"goli/queue"
 	response_util "goli/utils"
 	"goli/websocket"
@@ -14,6 +15,7 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,12 +24,24 @@ var port = aux.GetFromConfig("constants.port")
 var host = aux.GetFromConfig("constants.host")
 
 func main() {
+	// Commands such as goli admin create run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	// Initialize database
 	if err := database.InitDatabase(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer database.CloseDatabase()
 
+	// Installations completed before setup was recorded in the database only have the flag in config.toml
+	if aux.GetAllConfig()["setup_complete"] == "true" {
+		if err := database.MarkSetupComplete(); err != nil {
+			log.Fatalf("Failed to record setup state: %v", err)
+		}
+	}
+
 	// Initialize WebSocket hub
 	wsHub := websocket.NewHub()
 	go wsHub.Run()
@@ -38,7 +52,17 @@ func main() {
 	jobQueue.Start()
 	defer jobQueue.Stop()
 
-	// Authenticate with GitHub Container Registry if credentials are configured
+	// Fail jobs whose approval steps were not decided in time
+	go jobQueue.CheckApprovalsPeriodically(time.Minute)
+
+	// Remove expired artifacts and unreferenced artifact contents
+	go pipeline.CleanupArtifactsPeriodically(time.Hour)
+
+	// Remove expired sessions, two-factor codes, OIDC logins and password resets
+	go database.CleanupExpiredPeriodically(10 * time.Minute)
+
+	// Authenticate with GitHub Container Registry if legacy credentials are configured
+	// Registries stored via /api/v1/registries are logged in on demand before pull/push/run
 	if err := response_util.AuthenticateGitHubContainerRegistry(); err != nil {
 		log.Printf("Warning: Failed to authenticate with GitHub Container Registry at startup: %v", err)
 		log.Println("You can configure GitHub credentials in the Settings page")
@@ -56,11 +80,13 @@ func main() {
 		os.Exit(0)
 	}()
 
-	// Create Gin router
-	r := gin.Default()
+	// Create Gin router. RequestLogger replaces gin's logger, which would log tokens in query strings
+	r := gin.New()
+	r.Use(gin.Recovery())
 
-	// Add logging middleware
+	// Add logging and audit middleware
 	r.Use(middlewares.RequestLogger())
+	r.Use(middlewares.AuditRequests())
 
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
@@ -81,24 +107,32 @@ func main() {
 	public := r.Group("/api/v1")
 	{
 		// Setup endpoints
-		public.POST("/setup/verify", handler.VerifySetupPasswordHandler)
+		public.POST("/setup/bootstrap", handler.BootstrapHandler)
 		public.GET("/setup/status", handler.GetSetupStatusHandler)
 
 		// Auth endpoints
 		public.POST("/auth/login", handler.LoginHandler)
 		public.POST("/auth/2fa/verify", handler.Verify2FAHandler)
 		public.POST("/auth/logout", handler.LogoutHandler)
+		public.GET("/auth/oidc/login", handler.OIDCLoginHandler)
+		public.GET("/auth/oidc/callback", handler.OIDCCallbackHandler)
+		public.POST("/auth/password/forgot", handler.ForgotPasswordHandler)
+		public.POST("/auth/password/reset", handler.ResetPasswordHandler)
 	}
 
-	// Protected API routes (auth required)
+	// Protected API routes (auth required, the permission of each route is defined in middlewares/rbac.go)
 	api := r.Group("/api/v1")
-	api.Use(middlewares.AuthMiddleware())
+	api.Use(middlewares.AuthMiddleware(), middlewares.Authorize())
 	{
 		// Job management endpoints
 		api.GET("/jobs", handler.ListJobsHandler)
 		api.POST("/jobs", handler.CreateJobHandler)
 		api.GET("/jobs/:id", handler.GetJobHandler)
 		api.POST("/jobs/:id/cancel", handler.CancelJobHandler)
+		api.POST("/jobs/:id/approve", handler.ApproveJobHandler)
+		api.POST("/jobs/:id/reject", handler.RejectJobHandler)
+		api.GET("/jobs/:id/artifacts", handler.ListJobArtifactsHandler)
+		api.GET("/jobs/:id/artifacts/:artifact_id", handler.DownloadJobArtifactHandler)
 
 		// Pipeline management endpoints
 		api.GET("/pipelines", handler.ListPipelinesHandler)
@@ -107,7 +141,28 @@ func main() {
 		api.GET("/pipelines/:id", handler.GetPipelineHandler)
 		api.PUT("/pipelines/:id", handler.UpdatePipelineHandler)
 		api.POST("/pipelines/:id/run", handler.RunPipelineHandler)
+		api.POST("/pipelines/:id/plan", handler.PlanPipelineHandler)
 		api.DELETE("/pipelines/:id", handler.DeletePipelineHandler)
+		api.GET("/pipelines/:id/grants", handler.ListPipelineGrantsHandler)
+		api.PUT("/pipelines/:id/grants", handler.SetPipelineGrantHandler)
+		api.DELETE("/pipelines/:id/grants/:grant_id", handler.DeletePipelineGrantHandler)
+
+		// Personal API tokens
+		api.GET("/tokens", handler.ListAPITokensHandler)
+		api.POST("/tokens", handler.CreateAPITokenHandler)
+		api.DELETE("/tokens/:id", handler.DeleteAPITokenHandler)
+
+		// Profile of the current user
+		api.GET("/me", handler.GetMeHandler)
+		api.PUT("/me", handler.UpdateMeHandler)
+		api.POST("/me/password", handler.ChangePasswordHandler)
+
+		// Sessions of the current user
+		api.GET("/sessions", handler.ListSessionsHandler)
+		api.DELETE("/sessions/:id", handler.DeleteSessionHandler)
+
+		// Deployment provenance
+		api.GET("/deployments", handler.ListDeploymentsHandler)
 
 		// Config management endpoints
 		api.GET("/config", handler.GetConfigHandler)
@@ -118,6 +173,26 @@ func main() {
 		api.POST("/users", handler.CreateUserHandler)
 		api.PUT("/users/:id", handler.UpdateUserHandler)
 		api.DELETE("/users/:id", handler.DeleteUserHandler)
+		api.POST("/users/:id/2fa/reset", handler.ResetUserTwoFactorHandler)
+		api.POST("/users/:id/unlock", handler.UnlockUserHandler)
+		api.DELETE("/users/:id/sessions", handler.RevokeUserSessionsHandler)
+
+		// Audit log
+		api.GET("/audit", handler.ListAuditEventsHandler)
+
+		// Second factors of the current user
+		api.GET("/2fa", handler.GetTwoFactorStatusHandler)
+		api.POST("/2fa/totp/enroll", handler.EnrollTOTPHandler)
+		api.POST("/2fa/totp/confirm", handler.ConfirmTOTPHandler)
+		api.DELETE("/2fa/totp", handler.DisableTOTPHandler)
+		api.POST("/2fa/recovery-codes", handler.RegenerateRecoveryCodesHandler)
+
+		// Container registry credentials
+		api.GET("/registries", handler.ListRegistryCredentialsHandler)
+		api.POST("/registries", handler.CreateRegistryCredentialHandler)
+		api.PUT("/registries/:id", handler.UpdateRegistryCredentialHandler)
+		api.DELETE("/registries/:id", handler.DeleteRegistryCredentialHandler)
+		api.POST("/docker/registry/login", handler.AuthenticateContainerRegistryHandler)
 
 		// Docker endpoints
 		api.POST("/docker/container/start", handler.StartADocker)
@@ -126,8 +201,17 @@ func main() {
 		api.POST("/docker/container/run", handler.RunDockerContainer)
 		api.POST("/docker/image/pull", handler.PullAnDockerImage)
 		api.POST("/docker/image/rm", handler.RemoveAnDockerImage)
-		api.POST("/docker/ps", handler.GetDockerPS)
-		api.POST("/docker/images", handler.GetDockerImages)
+		api.POST("/docker/image/push", handler.PushAnDockerImage)
+		api.GET("/docker/containers", handler.ListDockerContainersHandler)
+		api.GET("/docker/images", handler.ListDockerImagesHandler)
+		api.POST("/docker/ps", handler.GetDockerPS)         // Legacy: raw `docker ps -a` text
+		api.POST("/docker/images", handler.GetDockerImages) // Legacy: raw `docker images` text
+		api.POST("/docker/container/pause", handler.PauseADocker)
+		api.POST("/docker/container/unpause", handler.UnPauseADocker)
+		api.POST("/docker/container/inspect", handler.InspectADocker)
+		api.POST("/docker/container/logs", handler.GetADockerLogs)
+		api.GET("/docker/containers/:name/logs", handler.ContainerLogsHandler)
+		api.GET("/docker/containers/:name/exec", handler.ContainerExecHandler)
 		api.POST("/docker/compose/up", handler.StartADockerOrchestra)
 		api.POST("/docker/compose/down", handler.StopADockerOrchestra)
 	}
@@ -0,0 +1,207 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"goli/database"
+	"goli/models"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// auditRecordedKey marks requests whose handler recorded an audit event, so AuditRequests does not add another
+const auditRecordedKey = "audit_recorded"
+
+// auditBodyLimit is the largest request body AuditRequests records
+const auditBodyLimit = 64 << 10
+
+// auditRedacted replaces secret values in audit events
+const auditRedacted = "[REDACTED]"
+
+// auditReadOnlyRoutes use POST without changing anything, AuditRequests skips them
+var auditReadOnlyRoutes = map[string]bool{
+	"POST /api/v1/pipelines/:id/plan":       true,
+	"POST /api/v1/docker/ps":                true,
+	"POST /api/v1/docker/images":            true,
+	"POST /api/v1/docker/container/inspect": true,
+	"POST /api/v1/docker/container/logs":    true,
+}
+
+// auditSecretWords mark keys whose values are redacted in audit events
+var auditSecretWords = []string{"password", "pass", "secret", "token", "key", "code"}
+
+// RecordAudit appends an event for the current request to the audit log. before and after are snapshots
+// of the target (structs or maps), only the fields that differ between them are kept and secrets are
+// redacted. Either may be nil for creations and deletions
+func RecordAudit(c *gin.Context, action, targetType string, targetID interface{}, before, after interface{}) {
+	event := &models.AuditEvent{
+		ActorID:    c.GetInt64("user_id"),
+		Actor:      c.GetString("username"),
+		ActorRole:  c.GetString("user_role"),
+		Action:     action,
+		TargetType: targetType,
+		IP:         c.ClientIP(),
+	}
+	if event.Actor == "" {
+		event.Actor = "anonymous"
+	}
+	if targetID != nil {
+		event.TargetID = fmt.Sprint(targetID)
+	}
+	// Diff before redacting, so that a changed secret shows up as changed
+	b, a := auditDiff(auditSnapshot(before), auditSnapshot(after))
+	event.Before, event.After = auditJSON(b), auditJSON(a)
+
+	c.Set(auditRecordedKey, true)
+	writeAuditEvent(event)
+}
+
+// AuditRequests returns a Gin middleware that records every API request changing something whose
+// handler did not record a more specific event, with its (redacted) JSON body and response status
+func AuditRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := c.Request.Method
+		if method == "GET" || method == "HEAD" || method == "OPTIONS" || !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Next()
+			return
+		}
+
+		var body interface{}
+		if c.Request.Body != nil && strings.HasPrefix(c.ContentType(), "application/json") {
+			data, err := io.ReadAll(io.LimitReader(c.Request.Body, auditBodyLimit+1))
+			if err == nil {
+				// Hand the body on to the handler unchanged
+				c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), c.Request.Body))
+				if len(data) <= auditBodyLimit {
+					_ = json.Unmarshal(data, &body)
+				}
+			}
+		}
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" || c.GetBool(auditRecordedKey) || auditReadOnlyRoutes[method+" "+route] {
+			return
+		}
+
+		event := &models.AuditEvent{
+			ActorID:   c.GetInt64("user_id"),
+			Actor:     c.GetString("username"),
+			ActorRole: c.GetString("user_role"),
+			Action:    method + " " + route,
+			Status:    c.Writer.Status(),
+			IP:        c.ClientIP(),
+		}
+		if event.Actor == "" {
+			event.Actor = "anonymous"
+		}
+		if id := c.Param("id"); id != "" {
+			event.TargetType = "route"
+			event.TargetID = id
+		}
+		event.After = auditJSON(body)
+		writeAuditEvent(event)
+	}
+}
+
+// writeAuditEvent stores an event and echoes it to the log. Failures are logged, they must not fail
+// the request that already happened
+func writeAuditEvent(event *models.AuditEvent) {
+	log.Printf("[AUDIT] user=%s role=%s ip=%s action=%s target=%s/%s",
+		event.Actor, event.ActorRole, event.IP, event.Action, event.TargetType, event.TargetID)
+	if err := database.InsertAuditEvent(event); err != nil {
+		log.Printf("Failed to write audit event %s: %v", event.Action, err)
+	}
+}
+
+// auditSnapshot converts a value to decoded JSON, nil stays nil
+func auditSnapshot(v interface{}) interface{} {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// auditDiff keeps only the fields that differ when both snapshots are objects, plus the id
+func auditDiff(before, after interface{}) (interface{}, interface{}) {
+	b, okBefore := before.(map[string]interface{})
+	a, okAfter := after.(map[string]interface{})
+	if !okBefore || !okAfter {
+		return before, after
+	}
+
+	for key, value := range b {
+		if key != "id" && reflect.DeepEqual(value, a[key]) {
+			delete(b, key)
+			delete(a, key)
+		}
+	}
+	return b, a
+}
+
+// auditJSON encodes a snapshot with its secrets redacted
+func auditJSON(snapshot interface{}) json.RawMessage {
+	if snapshot == nil {
+		return nil
+	}
+	data, err := json.Marshal(redactSecrets(snapshot))
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// redactSecrets replaces the values of secret-looking keys, and the values of {value, is_secret: true}
+// variables, in decoded JSON
+func redactSecrets(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if secret, _ := v["is_secret"].(bool); secret {
+			if _, ok := v["value"]; ok {
+				v["value"] = auditRedacted
+			}
+		}
+		for key, value := range v {
+			if isSecretKey(key) && value != nil && value != "" {
+				if _, isFlag := value.(bool); !isFlag {
+					v[key] = auditRedacted
+					continue
+				}
+			}
+			v[key] = redactSecrets(value)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactSecrets(item)
+		}
+	}
+	return v
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	// IDs and modes such as token_id and auth_key_mode name secrets without being one
+	if strings.HasSuffix(key, "_id") || strings.HasSuffix(key, "_mode") {
+		return false
+	}
+	for _, word := range auditSecretWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,19 +3,49 @@ package middlewares
 import (
 	aux "goli/auxiliary"
 	"goli/database"
+	"goli/models"
 	response_util "goli/utils"
+	"log"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-var auth_key = aux.GetFromConfig("constants.auth_key")
+// Modes of the legacy Goli-Auth-Key, set with auth_key_mode in config.toml
+const (
+	AuthKeyEnabled    = "enabled"
+	AuthKeyDeprecated = "deprecated" // Accepted, but responses carry a Deprecation header and uses are logged
+	AuthKeyDisabled   = "disabled"   // Always rejected, the first admin is created in the setup wizard (setup_password) or with goli admin create
+)
+
+// Session lifetime defaults, overridden by session_idle_hours and session_max_days in config.toml
+const (
+	defaultSessionIdleHours = 24
+	defaultSessionMaxDays   = 30
+)
+
+// SessionExpiry returns when a session created at createdAt expires if it is used now. Every use
+// extends the session by session_idle_hours, up to session_max_days after the login
+func SessionExpiry(createdAt time.Time) time.Time {
+	idle := time.Duration(aux.GetIntFromConfig("constants.session_idle_hours", defaultSessionIdleHours)) * time.Hour
+	maxLifetime := time.Duration(aux.GetIntFromConfig("constants.session_max_days", defaultSessionMaxDays)) * 24 * time.Hour
+
+	expires := time.Now().UTC().Add(idle)
+	if limit := createdAt.UTC().Add(maxLifetime); expires.After(limit) {
+		expires = limit
+	}
+	return expires
+}
 
 // AuthMiddleware returns a Gin middleware that verifies authentication
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && isWebSocketUpgrade(c) && c.Query("token") != "" {
+			// Browsers cannot set headers on WebSocket connections, accept the session token as query parameter
+			authHeader = "Bearer " + c.Query("token")
+		}
 		if authHeader == "" {
 			response_util.SendUnauthorizedResponseGin(c, "Missing Authorization header")
 			c.Abort()
@@ -30,6 +60,37 @@ func AuthMiddleware() gin.HandlerFunc {
 		scheme := parts[0]
 		cred := parts[1]
 
+		// Personal API token
+		if strings.EqualFold(scheme, "Bearer") && strings.HasPrefix(cred, models.APITokenPrefix) {
+			token, err := database.GetAPITokenByToken(cred)
+			if err != nil {
+				response_util.SendUnauthorizedResponseGin(c, "Invalid API token")
+				c.Abort()
+				return
+			}
+			if token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt) {
+				response_util.SendUnauthorizedResponseGin(c, "API token expired")
+				c.Abort()
+				return
+			}
+			user, err := database.GetUser(token.UserID)
+			if err != nil {
+				response_util.SendUnauthorizedResponseGin(c, "Invalid API token")
+				c.Abort()
+				return
+			}
+			if err := database.TouchAPIToken(token.ID); err != nil {
+				log.Printf("Failed to record use of API token %d: %v", token.ID, err)
+			}
+			c.Set("api_token_id", token.ID)
+			c.Set("token_scopes", token.Scopes)
+			c.Set("user_id", user.ID)
+			c.Set("username", user.Username)
+			c.Set("user_role", user.Role)
+			c.Next()
+			return
+		}
+
 		// New: Bearer session token
 		if strings.EqualFold(scheme, "Bearer") {
 			session, err := database.GetSessionByToken(cred)
@@ -44,15 +105,42 @@ func AuthMiddleware() gin.HandlerFunc {
 				c.Abort()
 				return
 			}
+			user, err := database.GetUser(session.UserID)
+			if err != nil {
+				response_util.SendUnauthorizedResponseGin(c, "Invalid session")
+				c.Abort()
+				return
+			}
+			if err := database.TouchSession(session.ID, c.ClientIP(), SessionExpiry(session.CreatedAt)); err != nil {
+				log.Printf("Failed to record use of session %d: %v", session.ID, err)
+			}
 			// Store session info in context for handlers to use
 			c.Set("session_token", cred)
+			c.Set("session_id", session.ID)
 			c.Set("user_id", session.UserID)
+			c.Set("username", user.Username)
+			c.Set("user_role", user.Role)
 			c.Next()
 			return
 		}
 
 		// Legacy support
-		if strings.EqualFold(scheme, "Goli-Auth-Key") && cred == auth_key {
+		if strings.EqualFold(scheme, "Goli-Auth-Key") && cred != "" && cred == aux.GetFromConfig("constants.auth_key") {
+			config := aux.GetAllConfig()
+			switch authKeyMode(config) {
+			case AuthKeyDisabled:
+				response_util.SendUnauthorizedResponseGin(c, "The Goli-Auth-Key is disabled, use an API token")
+				c.Abort()
+				return
+			case AuthKeyDeprecated:
+				log.Printf("Deprecated Goli-Auth-Key used by %s for %s %s", c.ClientIP(), c.Request.Method, c.Request.URL.Path)
+				c.Header("Deprecation", "true")
+				c.Header("Warning", `299 - "Goli-Auth-Key is deprecated, use a personal API token"`)
+			}
+
+			// The shared key has full access
+			c.Set("username", "auth-key")
+			c.Set("user_role", "admin")
 			c.Next()
 			return
 		}
@@ -61,3 +149,18 @@ func AuthMiddleware() gin.HandlerFunc {
 		c.Abort()
 	}
 }
+
+// authKeyMode returns how the legacy Goli-Auth-Key is handled, unknown values count as deprecated
+func authKeyMode(config map[string]string) string {
+	switch mode := strings.ToLower(strings.TrimSpace(config["auth_key_mode"])); mode {
+	case AuthKeyEnabled, AuthKeyDisabled:
+		return mode
+	default:
+		return AuthKeyDeprecated
+	}
+}
+
+// isWebSocketUpgrade reports whether the request asks for a WebSocket upgrade
+func isWebSocketUpgrade(c *gin.Context) bool {
+	return strings.EqualFold(c.GetHeader("Upgrade"), "websocket")
+}
@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"log"
+	"net/url"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,7 +14,7 @@ func RequestLogger() gin.HandlerFunc {
 		// Start timer
 		start := time.Now()
 		path := c.Request.URL.Path
-		raw := c.Request.URL.RawQuery
+		raw := redactQuery(c.Request.URL.RawQuery)
 
 		// Process request
 		c.Next()
@@ -56,3 +57,29 @@ func RequestLogger() gin.HandlerFunc {
 		}
 	}
 }
+
+// redactedParams are query parameters that carry credentials, such as the session token of WebSocket
+// connections, reset links and OIDC authorization codes
+var redactedParams = []string{"token", "code"}
+
+// redactQuery replaces the values of credential parameters in a raw query so they are not logged
+func redactQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return "<unparsable query>"
+	}
+	redacted := false
+	for _, name := range redactedParams {
+		if _, ok := values[name]; ok {
+			values[name] = []string{"REDACTED"}
+			redacted = true
+		}
+	}
+	if !redacted {
+		return raw
+	}
+	return values.Encode()
+}
@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"", ""},
+		{"cmd=sh&cmd=-l", "cmd=sh&cmd=-l"},
+		{"cmd=sh&token=abc123", "cmd=sh&token=REDACTED"},
+		{"token=a&token=b", "token=REDACTED"},
+		{"code=xyz&state=s", "code=REDACTED&state=s"},
+		{"token=%zz", "<unparsable query>"},
+	}
+	for _, tt := range tests {
+		if got := redactQuery(tt.raw); got != tt.want {
+			t.Errorf("redactQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestRequestLoggerRedactsTokens(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestLogger())
+	router.GET("/ws", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ws?token=s3cret-session", nil))
+
+	if strings.Contains(buf.String(), "s3cret-session") || !strings.Contains(buf.String(), "/ws?token=REDACTED") {
+		t.Errorf("log = %q", buf.String())
+	}
+}
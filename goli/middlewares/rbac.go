@@ -0,0 +1,198 @@
+package middlewares
+
+import (
+	"goli/database"
+	"goli/models"
+	response_util "goli/utils"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeRule is the permission a route requires. Routes on a single pipeline or job also name the
+// pipeline access level, which grants on the pipeline are checked against
+type routeRule struct {
+	permission string
+	access     string // view, run or edit; empty for routes not tied to a pipeline
+	job        bool   // :id is a job ID instead of a pipeline ID
+}
+
+// routePermissions maps "METHOD route" of every protected route to the permission it requires.
+// Routes missing from the map are denied
+var routePermissions = map[string]routeRule{
+	// Jobs
+	"GET /api/v1/jobs":                            {permission: models.PermJobsRead},
+	"POST /api/v1/jobs":                           {permission: models.PermJobsRun},
+	"GET /api/v1/jobs/:id":                        {permission: models.PermJobsRead, access: models.PipelineAccessView, job: true},
+	"POST /api/v1/jobs/:id/cancel":                {permission: models.PermJobsRun, access: models.PipelineAccessRun, job: true},
+	"POST /api/v1/jobs/:id/approve":               {permission: models.PermJobsRun, access: models.PipelineAccessRun, job: true},
+	"POST /api/v1/jobs/:id/reject":                {permission: models.PermJobsRun, access: models.PipelineAccessRun, job: true},
+	"GET /api/v1/jobs/:id/artifacts":              {permission: models.PermJobsRead, access: models.PipelineAccessView, job: true},
+	"GET /api/v1/jobs/:id/artifacts/:artifact_id": {permission: models.PermJobsRead, access: models.PipelineAccessView, job: true},
+
+	// Pipelines
+	"GET /api/v1/pipelines":                         {permission: models.PermPipelinesRead},
+	"POST /api/v1/pipelines":                        {permission: models.PermPipelinesWrite},
+	"POST /api/v1/pipelines/upload":                 {permission: models.PermPipelinesWrite},
+	"GET /api/v1/pipelines/:id":                     {permission: models.PermPipelinesRead, access: models.PipelineAccessView},
+	"PUT /api/v1/pipelines/:id":                     {permission: models.PermPipelinesWrite, access: models.PipelineAccessEdit},
+	"POST /api/v1/pipelines/:id/run":                {permission: models.PermPipelinesRun, access: models.PipelineAccessRun},
+	"POST /api/v1/pipelines/:id/plan":               {permission: models.PermPipelinesRead, access: models.PipelineAccessView},
+	"DELETE /api/v1/pipelines/:id":                  {permission: models.PermPipelinesWrite, access: models.PipelineAccessEdit},
+	"GET /api/v1/pipelines/:id/grants":              {permission: models.PermGrantsManage},
+	"PUT /api/v1/pipelines/:id/grants":              {permission: models.PermGrantsManage},
+	"DELETE /api/v1/pipelines/:id/grants/:grant_id": {permission: models.PermGrantsManage},
+
+	// API tokens, users manage their own
+	"GET /api/v1/tokens":        {permission: models.PermTokensManage},
+	"POST /api/v1/tokens":       {permission: models.PermTokensManage},
+	"DELETE /api/v1/tokens/:id": {permission: models.PermTokensManage},
+
+	// Second factors of the current user
+	"GET /api/v1/2fa":                 {permission: models.PermAccountManage},
+	"POST /api/v1/2fa/totp/enroll":    {permission: models.PermAccountManage},
+	"POST /api/v1/2fa/totp/confirm":   {permission: models.PermAccountManage},
+	"DELETE /api/v1/2fa/totp":         {permission: models.PermAccountManage},
+	"POST /api/v1/2fa/recovery-codes": {permission: models.PermAccountManage},
+
+	// Profile, password and sessions, users manage their own
+	"GET /api/v1/me":              {permission: models.PermAccountManage},
+	"PUT /api/v1/me":              {permission: models.PermAccountManage},
+	"POST /api/v1/me/password":    {permission: models.PermAccountManage},
+	"GET /api/v1/sessions":        {permission: models.PermAccountManage},
+	"DELETE /api/v1/sessions/:id": {permission: models.PermAccountManage},
+
+	// Deployments
+	"GET /api/v1/deployments": {permission: models.PermDockerRead},
+
+	// Configuration, users, registries and the audit log
+	"GET /api/v1/config":                 {permission: models.PermConfigRead},
+	"POST /api/v1/config":                {permission: models.PermConfigWrite},
+	"GET /api/v1/users":                  {permission: models.PermUsersManage},
+	"POST /api/v1/users":                 {permission: models.PermUsersManage},
+	"PUT /api/v1/users/:id":              {permission: models.PermUsersManage},
+	"DELETE /api/v1/users/:id":           {permission: models.PermUsersManage},
+	"POST /api/v1/users/:id/2fa/reset":   {permission: models.PermUsersManage},
+	"POST /api/v1/users/:id/unlock":      {permission: models.PermUsersManage},
+	"DELETE /api/v1/users/:id/sessions":  {permission: models.PermUsersManage},
+	"GET /api/v1/registries":             {permission: models.PermRegistries},
+	"POST /api/v1/registries":            {permission: models.PermRegistries},
+	"PUT /api/v1/registries/:id":         {permission: models.PermRegistries},
+	"DELETE /api/v1/registries/:id":      {permission: models.PermRegistries},
+	"POST /api/v1/docker/registry/login": {permission: models.PermRegistries},
+	"GET /api/v1/audit":                  {permission: models.PermAuditRead},
+
+	// Docker
+	"GET /api/v1/docker/containers":            {permission: models.PermDockerRead},
+	"GET /api/v1/docker/images":                {permission: models.PermDockerRead},
+	"POST /api/v1/docker/ps":                   {permission: models.PermDockerRead},
+	"POST /api/v1/docker/images":               {permission: models.PermDockerRead},
+	"POST /api/v1/docker/container/inspect":    {permission: models.PermDockerRead},
+	"POST /api/v1/docker/container/logs":       {permission: models.PermDockerRead},
+	"GET /api/v1/docker/containers/:name/logs": {permission: models.PermDockerRead},
+	"POST /api/v1/docker/container/start":      {permission: models.PermDockerOperate},
+	"POST /api/v1/docker/container/stop":       {permission: models.PermDockerOperate},
+	"POST /api/v1/docker/container/pause":      {permission: models.PermDockerOperate},
+	"POST /api/v1/docker/container/unpause":    {permission: models.PermDockerOperate},
+	"POST /api/v1/docker/container/rm":         {permission: models.PermDockerManage},
+	"POST /api/v1/docker/container/run":        {permission: models.PermDockerManage},
+	"POST /api/v1/docker/image/pull":           {permission: models.PermDockerManage},
+	"POST /api/v1/docker/image/rm":             {permission: models.PermDockerManage},
+	"POST /api/v1/docker/image/push":           {permission: models.PermDockerManage},
+	"POST /api/v1/docker/compose/up":           {permission: models.PermDockerManage},
+	"POST /api/v1/docker/compose/down":         {permission: models.PermDockerManage},
+	"GET /api/v1/docker/containers/:name/exec": {permission: models.PermDockerExec},
+}
+
+// Authorize returns a Gin middleware that checks the permission routePermissions requires for the route
+// against the user's role and, for routes on a single pipeline or job, the grants of that pipeline.
+// Must be used after AuthMiddleware
+func Authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.Request.Method + " " + c.FullPath()
+		rule, ok := routePermissions[route]
+		if !ok {
+			log.Printf("No permission defined for route %s, denying access", route)
+			response_util.SendForbiddenResponseGin(c, "Insufficient permissions")
+			c.Abort()
+			return
+		}
+
+		allowed := models.RoleHasPermission(c.GetString("user_role"), rule.permission) && scopeAllows(c, rule.permission)
+		if rule.access != "" {
+			if pipelineID, found := routePipelineID(c, rule); found {
+				var err error
+				allowed, err = CanAccessPipeline(c, pipelineID, rule.permission, rule.access)
+				if err != nil {
+					response_util.SendInternalServerErrorResponseGin(c, "Failed to check pipeline permissions: "+err.Error())
+					c.Abort()
+					return
+				}
+			}
+		}
+
+		if !allowed {
+			response_util.SendForbiddenResponseGin(c, "Insufficient permissions: "+rule.permission+" is required")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CanAccessPipeline reports whether the authenticated user may access a pipeline. Admins always may;
+// on a pipeline with grants only the access level granted to the user counts, otherwise the role
+// must have the permission. An API token must have the permission in its scopes in any case
+func CanAccessPipeline(c *gin.Context, pipelineID int64, permission, access string) (bool, error) {
+	if !scopeAllows(c, permission) {
+		return false, nil
+	}
+
+	role := c.GetString("user_role")
+	if role == models.RoleAdmin {
+		return true, nil
+	}
+
+	restricted, granted, err := database.GetPipelineAccess(pipelineID, c.GetInt64("user_id"))
+	if err != nil {
+		return false, err
+	}
+	if restricted {
+		return models.PipelineAccessAllows(granted, access), nil
+	}
+	return models.RoleHasPermission(role, permission), nil
+}
+
+// scopeAllows reports whether the scopes of the API token the request was authenticated with include
+// permission. Sessions and the legacy key are not scoped
+func scopeAllows(c *gin.Context, permission string) bool {
+	scopes, ok := c.Get("token_scopes")
+	if !ok {
+		return true
+	}
+	for _, scope := range scopes.([]string) {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// routePipelineID returns the pipeline a route operates on. It is not found for invalid IDs, unknown
+// jobs and jobs without a pipeline, the handler then responds as usual
+func routePipelineID(c *gin.Context, rule routeRule) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if !rule.job {
+		return id, true
+	}
+
+	job, err := database.GetJob(id)
+	if err != nil || job.PipelineID == nil {
+		return 0, false
+	}
+	return *job.PipelineID, true
+}
@@ -0,0 +1,352 @@
+package middlewares
+
+import (
+	"fmt"
+	"goli/database"
+	"goli/models"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minimumRoles is the least privileged role each route is meant for, kept by hand so that changes to
+// routePermissions or the role permissions show up here
+var minimumRoles = map[string]string{
+	// Jobs
+	"GET /api/v1/jobs":                            models.RoleViewer,
+	"POST /api/v1/jobs":                           models.RoleOperator,
+	"GET /api/v1/jobs/:id":                        models.RoleViewer,
+	"POST /api/v1/jobs/:id/cancel":                models.RoleOperator,
+	"POST /api/v1/jobs/:id/approve":               models.RoleOperator,
+	"POST /api/v1/jobs/:id/reject":                models.RoleOperator,
+	"GET /api/v1/jobs/:id/artifacts":              models.RoleViewer,
+	"GET /api/v1/jobs/:id/artifacts/:artifact_id": models.RoleViewer,
+
+	// Pipelines
+	"GET /api/v1/pipelines":                         models.RoleViewer,
+	"POST /api/v1/pipelines":                        models.RoleMaintainer,
+	"POST /api/v1/pipelines/upload":                 models.RoleMaintainer,
+	"GET /api/v1/pipelines/:id":                     models.RoleViewer,
+	"PUT /api/v1/pipelines/:id":                     models.RoleMaintainer,
+	"POST /api/v1/pipelines/:id/run":                models.RoleOperator,
+	"POST /api/v1/pipelines/:id/plan":               models.RoleViewer,
+	"DELETE /api/v1/pipelines/:id":                  models.RoleMaintainer,
+	"GET /api/v1/pipelines/:id/grants":              models.RoleAdmin,
+	"PUT /api/v1/pipelines/:id/grants":              models.RoleAdmin,
+	"DELETE /api/v1/pipelines/:id/grants/:grant_id": models.RoleAdmin,
+
+	// The user's own tokens, second factors, profile and sessions
+	"GET /api/v1/tokens":              models.RoleViewer,
+	"POST /api/v1/tokens":             models.RoleViewer,
+	"DELETE /api/v1/tokens/:id":       models.RoleViewer,
+	"GET /api/v1/2fa":                 models.RoleViewer,
+	"POST /api/v1/2fa/totp/enroll":    models.RoleViewer,
+	"POST /api/v1/2fa/totp/confirm":   models.RoleViewer,
+	"DELETE /api/v1/2fa/totp":         models.RoleViewer,
+	"POST /api/v1/2fa/recovery-codes": models.RoleViewer,
+	"GET /api/v1/me":                  models.RoleViewer,
+	"PUT /api/v1/me":                  models.RoleViewer,
+	"POST /api/v1/me/password":        models.RoleViewer,
+	"GET /api/v1/sessions":            models.RoleViewer,
+	"DELETE /api/v1/sessions/:id":     models.RoleViewer,
+
+	// Deployments
+	"GET /api/v1/deployments": models.RoleViewer,
+
+	// Configuration, users, registries and the audit log
+	"GET /api/v1/config":                 models.RoleAdmin,
+	"POST /api/v1/config":                models.RoleAdmin,
+	"GET /api/v1/users":                  models.RoleAdmin,
+	"POST /api/v1/users":                 models.RoleAdmin,
+	"PUT /api/v1/users/:id":              models.RoleAdmin,
+	"DELETE /api/v1/users/:id":           models.RoleAdmin,
+	"POST /api/v1/users/:id/2fa/reset":   models.RoleAdmin,
+	"POST /api/v1/users/:id/unlock":      models.RoleAdmin,
+	"DELETE /api/v1/users/:id/sessions":  models.RoleAdmin,
+	"GET /api/v1/registries":             models.RoleAdmin,
+	"POST /api/v1/registries":            models.RoleAdmin,
+	"PUT /api/v1/registries/:id":         models.RoleAdmin,
+	"DELETE /api/v1/registries/:id":      models.RoleAdmin,
+	"POST /api/v1/docker/registry/login": models.RoleAdmin,
+	"GET /api/v1/audit":                  models.RoleAdmin,
+
+	// Docker
+	"GET /api/v1/docker/containers":            models.RoleViewer,
+	"GET /api/v1/docker/images":                models.RoleViewer,
+	"POST /api/v1/docker/ps":                   models.RoleViewer,
+	"POST /api/v1/docker/images":               models.RoleViewer,
+	"POST /api/v1/docker/container/inspect":    models.RoleViewer,
+	"POST /api/v1/docker/container/logs":       models.RoleViewer,
+	"GET /api/v1/docker/containers/:name/logs": models.RoleViewer,
+	"POST /api/v1/docker/container/start":      models.RoleOperator,
+	"POST /api/v1/docker/container/stop":       models.RoleOperator,
+	"POST /api/v1/docker/container/pause":      models.RoleOperator,
+	"POST /api/v1/docker/container/unpause":    models.RoleOperator,
+	"POST /api/v1/docker/container/rm":         models.RoleMaintainer,
+	"POST /api/v1/docker/container/run":        models.RoleMaintainer,
+	"POST /api/v1/docker/image/pull":           models.RoleMaintainer,
+	"POST /api/v1/docker/image/rm":             models.RoleMaintainer,
+	"POST /api/v1/docker/image/push":           models.RoleMaintainer,
+	"POST /api/v1/docker/compose/up":           models.RoleMaintainer,
+	"POST /api/v1/docker/compose/down":         models.RoleMaintainer,
+	"GET /api/v1/docker/containers/:name/exec": models.RoleAdmin,
+}
+
+// Users of the test database, authenticate sets their IDs on the request
+const (
+	grantedViewer   int64 = 1001
+	grantedOperator int64 = 1002
+	ungrantedUser   int64 = 1003
+)
+
+// testPipelines holds the pipelines and jobs created by openTestDatabase
+type testPipelines struct {
+	open, restricted       int64 // pipeline IDs
+	openJob, restrictedJob int64 // job IDs
+}
+
+// openTestDatabase opens a database in a temporary directory with an unrestricted pipeline and a
+// pipeline restricted to grantedViewer (view) and grantedOperator (run), each with a pending job
+func openTestDatabase(t *testing.T) *testPipelines {
+	t.Helper()
+	if err := database.OpenDatabase(filepath.Join(t.TempDir(), "goli.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.CloseDatabase() })
+
+	create := func(name string) (int64, int64) {
+		pipeline, err := database.CreatePipeline(&models.Pipeline{Name: name, Definition: "name: " + name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, err := database.CreateJob(&models.Job{PipelineID: &pipeline.ID, Name: name, Status: models.JobStatusPending, TriggeredBy: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pipeline.ID, job.ID
+	}
+
+	p := &testPipelines{}
+	p.open, p.openJob = create("open")
+	p.restricted, p.restrictedJob = create("restricted")
+	for userID, access := range map[int64]string{grantedViewer: models.PipelineAccessView, grantedOperator: models.PipelineAccessRun} {
+		if _, err := database.SetPipelineGrant(&models.PipelineGrant{PipelineID: p.restricted, UserID: userID, Access: access}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+// routePath fills in the parameters of a route for a request on pipeline or job id
+func routePath(route string, id int64) string {
+	_, path, _ := strings.Cut(route, " ")
+	path = strings.ReplaceAll(path, ":id", fmt.Sprint(id))
+	path = strings.ReplaceAll(path, ":name", "web")
+	path = strings.ReplaceAll(path, ":grant_id", "1")
+	return strings.ReplaceAll(path, ":artifact_id", "1")
+}
+
+// testRouter registers every route of routePermissions behind Authorize, authenticated as the user
+// in the X-Test-* headers of the request the way AuthMiddleware sets the context
+func testRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	authenticate := func(c *gin.Context) {
+		var userID int64
+		fmt.Sscan(c.GetHeader("X-Test-User"), &userID)
+		c.Set("user_id", userID)
+		c.Set("user_role", c.GetHeader("X-Test-Role"))
+		if scopes, ok := c.Request.Header["X-Test-Scopes"]; ok {
+			c.Set("token_scopes", strings.Fields(strings.Join(scopes, " ")))
+		}
+		c.Next()
+	}
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	for route := range routePermissions {
+		method, path, _ := strings.Cut(route, " ")
+		router.Handle(method, path, authenticate, Authorize(), ok)
+	}
+	return router
+}
+
+// request sends a request on route as a user and returns the status code
+func request(router *gin.Engine, route string, id, userID int64, role string, scopes ...string) int {
+	method, _, _ := strings.Cut(route, " ")
+	req := httptest.NewRequest(method, routePath(route, id), nil)
+	req.Header.Set("X-Test-User", fmt.Sprint(userID))
+	req.Header.Set("X-Test-Role", role)
+	if scopes != nil {
+		req.Header["X-Test-Scopes"] = scopes
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestEveryRouteHasExpectedRole(t *testing.T) {
+	for route := range routePermissions {
+		if _, ok := minimumRoles[route]; !ok {
+			t.Errorf("%s is missing from minimumRoles", route)
+		}
+	}
+	for route := range minimumRoles {
+		if _, ok := routePermissions[route]; !ok {
+			t.Errorf("%s is not in routePermissions", route)
+		}
+	}
+}
+
+func TestAuthorizeRoles(t *testing.T) {
+	p := openTestDatabase(t)
+	router := testRouter()
+
+	for route, minimum := range minimumRoles {
+		id := p.open
+		if routePermissions[route].job {
+			id = p.openJob
+		}
+		for _, role := range append(models.Roles, "", "unknown") {
+			want := http.StatusForbidden
+			if models.RoleAtLeast(role, minimum) {
+				want = http.StatusOK
+			}
+			if got := request(router, route, id, ungrantedUser, role); got != want {
+				t.Errorf("%s as %q = %d, want %d", route, role, got, want)
+			}
+		}
+	}
+}
+
+func TestAuthorizeDeniesUnknownRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v1/unlisted", func(c *gin.Context) { c.Set("user_role", models.RoleAdmin) }, Authorize(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/unlisted", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestAuthorizeRestrictedPipelines(t *testing.T) {
+	p := openTestDatabase(t)
+	router := testRouter()
+
+	tests := []struct {
+		name   string
+		route  string
+		userID int64
+		role   string
+		want   int
+	}{
+		{"grant gives a viewer access below their role", "GET /api/v1/pipelines/:id", grantedViewer, models.RoleViewer, http.StatusOK},
+		{"view grant does not allow running", "POST /api/v1/pipelines/:id/run", grantedViewer, models.RoleMaintainer, http.StatusForbidden},
+		{"run grant allows running above the role", "POST /api/v1/pipelines/:id/run", grantedOperator, models.RoleViewer, http.StatusOK},
+		{"run grant does not allow editing", "PUT /api/v1/pipelines/:id", grantedOperator, models.RoleMaintainer, http.StatusForbidden},
+		{"no grant denies viewing", "GET /api/v1/pipelines/:id", ungrantedUser, models.RoleMaintainer, http.StatusForbidden},
+		{"no grant denies deleting", "DELETE /api/v1/pipelines/:id", ungrantedUser, models.RoleMaintainer, http.StatusForbidden},
+		{"admins need no grant", "DELETE /api/v1/pipelines/:id", ungrantedUser, models.RoleAdmin, http.StatusOK},
+		{"grants are managed by admins only", "GET /api/v1/pipelines/:id/grants", grantedOperator, models.RoleMaintainer, http.StatusForbidden},
+		{"no grant denies the jobs", "GET /api/v1/jobs/:id", ungrantedUser, models.RoleMaintainer, http.StatusForbidden},
+		{"no grant denies cancelling jobs", "POST /api/v1/jobs/:id/cancel", ungrantedUser, models.RoleMaintainer, http.StatusForbidden},
+		{"no grant denies approving jobs", "POST /api/v1/jobs/:id/approve", ungrantedUser, models.RoleMaintainer, http.StatusForbidden},
+		{"view grant allows the jobs", "GET /api/v1/jobs/:id/artifacts", grantedViewer, models.RoleViewer, http.StatusOK},
+		{"view grant does not allow approving jobs", "POST /api/v1/jobs/:id/approve", grantedViewer, models.RoleOperator, http.StatusForbidden},
+		{"run grant allows approving jobs", "POST /api/v1/jobs/:id/approve", grantedOperator, models.RoleViewer, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := p.restricted
+			if routePermissions[tt.route].job {
+				id = p.restrictedJob
+			}
+			if got := request(router, tt.route, id, tt.userID, tt.role); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeScopedTokens(t *testing.T) {
+	p := openTestDatabase(t)
+	router := testRouter()
+
+	tests := []struct {
+		name   string
+		route  string
+		id     int64
+		userID int64
+		role   string
+		scopes []string
+		want   int
+	}{
+		{"scope within the role", "GET /api/v1/jobs", 0, ungrantedUser, models.RoleViewer, []string{models.PermJobsRead}, http.StatusOK},
+		{"scope missing", "POST /api/v1/jobs", 0, ungrantedUser, models.RoleAdmin, []string{models.PermJobsRead}, http.StatusForbidden},
+		{"scope beyond the role", "POST /api/v1/jobs", 0, ungrantedUser, models.RoleViewer, []string{models.PermJobsRun}, http.StatusForbidden},
+		{"no scopes", "GET /api/v1/me", 0, ungrantedUser, models.RoleAdmin, []string{}, http.StatusForbidden},
+		{"admin token without the scope on a pipeline", "PUT /api/v1/pipelines/:id", p.open, ungrantedUser, models.RoleAdmin, []string{models.PermPipelinesRead}, http.StatusForbidden},
+		{"admin token with the scope on a pipeline", "PUT /api/v1/pipelines/:id", p.restricted, ungrantedUser, models.RoleAdmin, []string{models.PermPipelinesWrite}, http.StatusOK},
+		{"grant with the scope", "POST /api/v1/pipelines/:id/run", p.restricted, grantedOperator, models.RoleViewer, []string{models.PermPipelinesRun}, http.StatusOK},
+		{"grant without the scope", "POST /api/v1/pipelines/:id/run", p.restricted, grantedOperator, models.RoleViewer, []string{models.PermPipelinesRead}, http.StatusForbidden},
+		{"scope without a grant", "GET /api/v1/pipelines/:id", p.restricted, ungrantedUser, models.RoleMaintainer, []string{models.PermPipelinesRead}, http.StatusForbidden},
+		{"job of a restricted pipeline without the scope", "GET /api/v1/jobs/:id", p.restrictedJob, grantedViewer, models.RoleViewer, []string{models.PermPipelinesRead}, http.StatusForbidden},
+		{"job of a restricted pipeline with the scope", "GET /api/v1/jobs/:id", p.restrictedJob, grantedViewer, models.RoleViewer, []string{models.PermJobsRead}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := request(router, tt.route, tt.id, tt.userID, tt.role, tt.scopes...); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanAccessPipeline(t *testing.T) {
+	p := openTestDatabase(t)
+
+	tests := []struct {
+		name       string
+		pipeline   int64
+		userID     int64
+		role       string
+		scopes     []string
+		permission string
+		access     string
+		want       bool
+	}{
+		{"role on an open pipeline", p.open, ungrantedUser, models.RoleOperator, nil, models.PermPipelinesRun, models.PipelineAccessRun, true},
+		{"role below the permission on an open pipeline", p.open, ungrantedUser, models.RoleViewer, nil, models.PermPipelinesRun, models.PipelineAccessRun, false},
+		{"grant at the level", p.restricted, grantedViewer, models.RoleViewer, nil, models.PermPipelinesRead, models.PipelineAccessView, true},
+		{"grant below the level", p.restricted, grantedViewer, models.RoleMaintainer, nil, models.PermPipelinesRun, models.PipelineAccessRun, false},
+		{"grant above the level", p.restricted, grantedOperator, models.RoleViewer, nil, models.PermPipelinesRead, models.PipelineAccessView, true},
+		{"no grant", p.restricted, ungrantedUser, models.RoleMaintainer, nil, models.PermPipelinesRead, models.PipelineAccessView, false},
+		{"admin", p.restricted, ungrantedUser, models.RoleAdmin, nil, models.PermPipelinesWrite, models.PipelineAccessEdit, true},
+		{"admin token without the scope", p.restricted, ungrantedUser, models.RoleAdmin, []string{models.PermPipelinesRead}, models.PermPipelinesWrite, models.PipelineAccessEdit, false},
+		{"unknown pipeline", 999, ungrantedUser, models.RoleViewer, nil, models.PermPipelinesRead, models.PipelineAccessView, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Set("user_id", tt.userID)
+			c.Set("user_role", tt.role)
+			if tt.scopes != nil {
+				c.Set("token_scopes", tt.scopes)
+			}
+
+			got, err := CanAccessPipeline(c, tt.pipeline, tt.permission, tt.access)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("CanAccessPipeline = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
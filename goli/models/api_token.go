@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// APITokenPrefix starts every personal API token, so they can be told apart from session tokens
+const APITokenPrefix = "goli_"
+
+// APIToken is a personal access token for scripts and CI integrations. Only a hash of the token is
+// stored, the token itself is returned once when it is created
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Username   string     `json:"username,omitempty"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`          // First characters of the token, to recognize it
+	Token      string     `json:"token,omitempty"` // Only set in the response that created the token
+	TokenHash  string     `json:"-"`
+	Scopes     []string   `json:"scopes"` // Permissions the token may use, limited by the user's role
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Artifact is a file a job step archived from its workspace
+// The content is kept once per SHA-256 in the artifact store, so identical files share storage
+type Artifact struct {
+	ID        int64      `json:"id"`
+	JobID     int64      `json:"job_id"`
+	StepID    int64      `json:"step_id"`
+	StepName  string     `json:"step_name"`
+	Path      string     `json:"path"` // Relative to the job workspace
+	SHA256    string     `json:"sha256"`
+	Size      int64      `json:"size"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Unset when artifacts are kept forever
+}
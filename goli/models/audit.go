@@ -0,0 +1,34 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEvent records who changed what. Events are append-only, the database rejects updates and deletes
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	ActorID    int64           `json:"actor_id"` // 0 for anonymous requests and the legacy auth key
+	Actor      string          `json:"actor"`
+	ActorRole  string          `json:"actor_role,omitempty"`
+	Action     string          `json:"action"`                // e.g. pipeline.update, or METHOD /route for requests without a specific event
+	TargetType string          `json:"target_type,omitempty"` // e.g. pipeline, user, container
+	TargetID   string          `json:"target_id,omitempty"`   // ID, or name for targets without one
+	Before     json.RawMessage `json:"before,omitempty"`      // Changed fields before the action, secrets redacted
+	After      json.RawMessage `json:"after,omitempty"`       // Changed fields after the action, secrets redacted
+	Status     int             `json:"status,omitempty"`      // HTTP status of the request
+	IP         string          `json:"ip"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter selects audit events, empty fields match everything
+type AuditFilter struct {
+	Actor      string
+	Action     string // Exact action, or a prefix ending in * such as pipeline.*
+	TargetType string
+	TargetID   string
+	Since      *time.Time
+	Until      *time.Time
+	Limit      int // 0 for no limit
+	Offset     int
+}
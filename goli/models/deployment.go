@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Deployment records which job started a container from which image digest
+type Deployment struct {
+	ID            int64     `json:"id"`
+	JobID         int64     `json:"job_id"`
+	StepID        int64     `json:"step_id"`
+	PipelineID    *int64    `json:"pipeline_id,omitempty"`
+	ContainerID   string    `json:"container_id"`
+	ContainerName string    `json:"container_name,omitempty"`
+	Image         string    `json:"image"`
+	ImageID       string    `json:"image_id,omitempty"`
+	Digest        string    `json:"digest,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -11,33 +11,94 @@ const (
 	JobStatusCompleted JobStatus = "completed"
 	JobStatusFailed    JobStatus = "failed"
 	JobStatusCancelled JobStatus = "cancelled"
+	JobStatusSkipped   JobStatus = "skipped" // Step whose `if:` condition evaluated to false
+
+	// Job and approval step paused until the approval step is approved or rejected, no worker is held
+	JobStatusWaitingApproval JobStatus = "waiting_approval"
 )
 
 // Job represents a deployment job
 type Job struct {
-	ID           int64      `json:"id"`
-	PipelineID   *int64     `json:"pipeline_id,omitempty"`
-	Name         string     `json:"name"`
-	Status       JobStatus  `json:"status"`
-	TriggeredBy  string     `json:"triggered_by,omitempty"`
-	StartedAt    *time.Time `json:"started_at,omitempty"`
-	CompletedAt  *time.Time `json:"completed_at,omitempty"`
-	ErrorMessage string     `json:"error_message,omitempty"`
-	Logs         string     `json:"logs,omitempty"`
-	CreatedAt    time.Time  `json:"created_at"`
-	Steps        []JobStep  `json:"steps,omitempty"`
+	ID           int64             `json:"id"`
+	PipelineID   *int64            `json:"pipeline_id,omitempty"`
+	Name         string            `json:"name"`
+	Status       JobStatus         `json:"status"`
+	TriggeredBy  string            `json:"triggered_by,omitempty"`
+	CreatedBy    string            `json:"created_by,omitempty"` // User who started the job, unlike triggered_by not chosen by the client
+	Parameters   map[string]string `json:"parameters,omitempty"` // Run parameters, conditions read them as parameters.NAME
+	Secrets      []string          `json:"-"`                    // Values of secret variables, masked in step logs
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	CompletedAt  *time.Time        `json:"completed_at,omitempty"`
+	ErrorMessage string            `json:"error_message,omitempty"`
+	Logs         string            `json:"logs,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	Steps        []JobStep         `json:"steps,omitempty"`
+	Approval     *JobApproval      `json:"approval,omitempty"` // Pending approval of a waiting job; a decided one makes the queue resume the job
 }
 
 // JobStep represents a single step in a job
 type JobStep struct {
-	ID           int64      `json:"id"`
-	JobID        int64      `json:"job_id"`
-	StepName     string     `json:"step_name"`
-	StepOrder    int        `json:"step_order"`
-	Status       JobStatus  `json:"status"`
-	StartedAt    *time.Time `json:"started_at,omitempty"`
-	CompletedAt  *time.Time `json:"completed_at,omitempty"`
-	ErrorMessage string     `json:"error_message,omitempty"`
-	Logs         string     `json:"logs,omitempty"`
-	CreatedAt    time.Time  `json:"created_at"`
+	ID           int64             `json:"id"`
+	JobID        int64             `json:"job_id"`
+	StepName     string            `json:"step_name"`
+	StepOrder    int               `json:"step_order"`
+	Status       JobStatus         `json:"status"`
+	StartedAt    *time.Time        `json:"started_at,omitempty"`
+	CompletedAt  *time.Time        `json:"completed_at,omitempty"`
+	ErrorMessage string            `json:"error_message,omitempty"`
+	Logs         string            `json:"logs,omitempty"`
+	Outputs      map[string]string `json:"outputs,omitempty"` // Structured results, e.g. image digest or container ID
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// Approval statuses
+const (
+	ApprovalPending   = "pending"
+	ApprovalApproved  = "approved"
+	ApprovalRejected  = "rejected"
+	ApprovalExpired   = "expired"   // Not decided before the timeout
+	ApprovalCancelled = "cancelled" // The job was cancelled while waiting
+)
+
+// JobApproval is the decision an approval step waits for
+type JobApproval struct {
+	ID        int64      `json:"id"`
+	JobID     int64      `json:"job_id"`
+	StepID    int64      `json:"step_id"`
+	StepName  string     `json:"step_name"`
+	StepOrder int        `json:"step_order"`
+	Message   string     `json:"message,omitempty"`
+	Approvers []string   `json:"approvers,omitempty"`           // Usernames allowed to decide
+	Roles     []string   `json:"roles,omitempty"`               // Roles allowed to decide, including the roles above them
+	AllowSelf bool       `json:"allow_self_approval,omitempty"` // The user who started the job may decide too
+	Status    string     `json:"status"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	State     string     `json:"-"` // Definition and secrets the job resumes with, encrypted at rest
+}
+
+// AllowsApprover reports whether a user may decide the approval of a job. Without approvers and roles
+// everyone who may run the job's pipeline may. The user who started the job, or is named as its
+// trigger, may not unless the approval allows self approval
+func (a *JobApproval) AllowsApprover(username, role string, job *Job) bool {
+	if !a.AllowSelf && username != "" && (username == job.CreatedBy || username == job.TriggeredBy) {
+		return false
+	}
+	if len(a.Approvers) == 0 && len(a.Roles) == 0 {
+		return true
+	}
+	for _, approver := range a.Approvers {
+		if approver == username {
+			return true
+		}
+	}
+	for _, required := range a.Roles {
+		if RoleAtLeast(role, required) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestJobApprovalAllowsApprover(t *testing.T) {
+	job := &Job{CreatedBy: "carol", TriggeredBy: "dave"}
+
+	tests := []struct {
+		name     string
+		approval JobApproval
+		username string
+		role     string
+		want     bool
+	}{
+		{"anyone without approvers and roles", JobApproval{}, "alice", RoleOperator, true},
+		{"listed approver", JobApproval{Approvers: []string{"alice"}}, "alice", RoleViewer, true},
+		{"unlisted user", JobApproval{Approvers: []string{"alice"}}, "bob", RoleOperator, false},
+		{"required role", JobApproval{Roles: []string{RoleMaintainer}}, "bob", RoleMaintainer, true},
+		{"higher role", JobApproval{Roles: []string{RoleMaintainer}}, "bob", RoleAdmin, true},
+		{"lower role", JobApproval{Roles: []string{RoleMaintainer}}, "bob", RoleOperator, false},
+		{"user who started the job", JobApproval{}, "carol", RoleAdmin, false},
+		{"user named as the trigger", JobApproval{}, "dave", RoleAdmin, false},
+		{"listed approver who started the job", JobApproval{Approvers: []string{"carol"}}, "carol", RoleAdmin, false},
+		{"self approval allowed", JobApproval{AllowSelf: true}, "carol", RoleOperator, true},
+		{"self approval allowed for listed approvers only", JobApproval{Approvers: []string{"alice"}, AllowSelf: true}, "carol", RoleOperator, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.approval.AllowsApprover(tt.username, tt.role, job); got != tt.want {
+				t.Errorf("AllowsApprover = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
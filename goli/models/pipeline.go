@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 // Pipeline represents a deployment pipeline definition
 type Pipeline struct {
@@ -9,25 +12,90 @@ type Pipeline struct {
 	Description string                 `json:"description,omitempty"`
 	Definition  string                 `json:"definition"`          // YAML or JSON string
 	Variables   map[string]interface{} `json:"variables,omitempty"` // Variables and secrets (secrets are masked)
-	CreatedAt   time.Time              `json:"created_at"`
-	UpdatedAt   time.Time              `json:"updated_at"`
+
+	// Repository-backed pipelines load their definition from a file in a git repository on every run
+	Repository     string `json:"repository,omitempty"`      // URL or local path of the repository
+	RepositoryRef  string `json:"repository_ref,omitempty"`  // Branch, tag or commit, empty for the default branch
+	DefinitionPath string `json:"definition_path,omitempty"` // Definition file in the repository, defaults to .goli.yml
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // PipelineDefinition represents the parsed pipeline structure
 type PipelineDefinition struct {
-	Name        string                 `yaml:"name" json:"name"`
-	Description string                 `yaml:"description" json:"description,omitempty"`
-	Steps       []PipelineStep         `yaml:"steps" json:"steps"`
-	Variables   map[string]interface{} `yaml:"variables" json:"variables,omitempty"`
+	Name        string                  `yaml:"name" json:"name"`
+	Description string                  `yaml:"description" json:"description,omitempty"`
+	Steps       []PipelineStep          `yaml:"steps" json:"steps"`
+	Variables   map[string]interface{}  `yaml:"variables" json:"variables,omitempty"`
+	Include     []PipelineInclude       `yaml:"include" json:"include,omitempty"`     // Stored pipelines or template files whose steps are prepended
+	Templates   map[string]StepTemplate `yaml:"templates" json:"templates,omitempty"` // Named step templates, referenced with uses
+
+	KeyPositions map[string]SourcePosition `yaml:"-" json:"-"` // Positions of the top-level keys, for validation errors
+}
+
+// PipelineInclude references a stored pipeline (by name or ID) or a template file
+type PipelineInclude struct {
+	Pipeline string `yaml:"pipeline" json:"pipeline,omitempty"`
+	File     string `yaml:"file" json:"file,omitempty"`
+}
+
+// StepTemplate is a reusable sequence of steps with inputs
+type StepTemplate struct {
+	Description string                   `yaml:"description" json:"description,omitempty"`
+	Inputs      map[string]TemplateInput `yaml:"inputs" json:"inputs,omitempty"`
+	Steps       []PipelineStep           `yaml:"steps" json:"steps"`
+}
+
+// TemplateInput describes a parameter of a step template, referenced as ${inputs.NAME}
+type TemplateInput struct {
+	Description string      `yaml:"description" json:"description,omitempty"`
+	Required    bool        `yaml:"required" json:"required,omitempty"`
+	Default     interface{} `yaml:"default" json:"default,omitempty"`
+}
+
+// SourcePosition locates a step in the YAML document it was defined in
+type SourcePosition struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// String formats the position as file:line:column
+func (p SourcePosition) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
 
 // PipelineStep represents a single step in a pipeline
 type PipelineStep struct {
 	Name        string                 `yaml:"name" json:"name"`
 	Description string                 `yaml:"description" json:"description,omitempty"`
-	Type        string                 `yaml:"type" json:"type"`     // docker, script, etc.
-	Action      string                 `yaml:"action" json:"action"` // run, pull, start, stop, etc.
+	Type        string                 `yaml:"type" json:"type"`     // docker, script, shell, git, artifacts, approval
+	Action      string                 `yaml:"action" json:"action"` // run, pull, start, stop, checkout, etc.
 	Config      map[string]interface{} `yaml:"config" json:"config"`
-	OnFailure   string                 `yaml:"on_failure" json:"on_failure,omitempty"` // continue, stop, rollback
+	OnFailure   string                 `yaml:"on_failure" json:"on_failure,omitempty"` // stop or continue
 	Retry       int                    `yaml:"retry" json:"retry,omitempty"`
+	If          string                 `yaml:"if" json:"if,omitempty"`               // Condition, the step is skipped when it evaluates to false
+	Matrix      map[string]interface{} `yaml:"matrix" json:"matrix,omitempty"`       // Value lists per key plus include, exclude and parallel
+	Uses        string                 `yaml:"uses" json:"uses,omitempty"`           // Step template to expand, e.g. templates/redeploy-container
+	With        map[string]interface{} `yaml:"with" json:"with,omitempty"`           // Inputs for the step template
+	Container   *StepContainer         `yaml:"container" json:"container,omitempty"` // Run a script or shell step in a throwaway container
+	Artifacts   []string               `yaml:"artifacts" json:"artifacts,omitempty"` // Workspace globs archived after the step succeeded
+	Env         map[string]string      `yaml:"env" json:"env,omitempty"`             // Environment of the step, GOLI_* variables are added by Goli
+
+	// Set by the parser on steps expanded from a matrix
+	MatrixValues  map[string]string `yaml:"-" json:"matrix_values,omitempty"`
+	ParallelGroup string            `yaml:"-" json:"parallel_group,omitempty"` // Consecutive steps of the same group run concurrently
+	Source        *SourcePosition   `yaml:"-" json:"source,omitempty"`         // Where the step was defined, for error messages
+
+	// Positions of the step keys and of its config and container keys (as "config.KEY", "container.KEY"), for validation errors
+	KeyPositions map[string]SourcePosition `yaml:"-" json:"-"`
+}
+
+// StepContainer is the container a script or shell step runs in, the job workspace is mounted at the same path
+type StepContainer struct {
+	Image   string            `yaml:"image" json:"image"`
+	Volumes []string          `yaml:"volumes" json:"volumes,omitempty"` // Extra host:container mounts
+	Env     map[string]string `yaml:"env" json:"env,omitempty"`
+	User    string            `yaml:"user" json:"user,omitempty"` // user[:group] inside the container
 }
@@ -0,0 +1,124 @@
+package models
+
+import "time"
+
+// Roles, each includes the permissions of the roles before it
+const (
+	RoleViewer     = "viewer"     // Read pipelines, jobs, deployments and containers
+	RoleOperator   = "operator"   // Run pipelines, cancel jobs, start and stop containers
+	RoleMaintainer = "maintainer" // Edit pipelines, run, remove and pull containers and images
+	RoleAdmin      = "admin"      // Manage users, grants, registries and the configuration, read the audit log
+)
+
+// Roles lists the roles from least to most privileged
+var Roles = []string{RoleViewer, RoleOperator, RoleMaintainer, RoleAdmin}
+
+// Permissions checked by the API, see middlewares.routePermissions for the routes they guard
+const (
+	PermPipelinesRead  = "pipelines:read"
+	PermPipelinesRun   = "pipelines:run"
+	PermPipelinesWrite = "pipelines:write"
+	PermJobsRead       = "jobs:read"
+	PermJobsRun        = "jobs:run"
+	PermDockerRead     = "docker:read"
+	PermDockerOperate  = "docker:operate"
+	PermDockerManage   = "docker:manage"
+	PermDockerExec     = "docker:exec"
+	PermConfigRead     = "config:read"
+	PermConfigWrite    = "config:write"
+	PermUsersManage    = "users:manage"
+	PermGrantsManage   = "grants:manage"
+	PermRegistries     = "registries:manage"
+	PermAuditRead      = "audit:read"
+	PermTokensManage   = "tokens:manage"  // The user's own API tokens
+	PermAccountManage  = "account:manage" // The user's own account and second factors
+)
+
+// rolePermissions holds the permissions a role adds to the roles before it
+var rolePermissions = map[string][]string{
+	RoleViewer:     {PermPipelinesRead, PermJobsRead, PermDockerRead, PermTokensManage, PermAccountManage},
+	RoleOperator:   {PermPipelinesRun, PermJobsRun, PermDockerOperate},
+	RoleMaintainer: {PermPipelinesWrite, PermDockerManage},
+	RoleAdmin:      {PermDockerExec, PermConfigRead, PermConfigWrite, PermUsersManage, PermGrantsManage, PermRegistries, PermAuditRead},
+}
+
+// IsValidPermission reports whether permission is granted by any role
+func IsValidPermission(permission string) bool {
+	return RoleHasPermission(RoleAdmin, permission)
+}
+
+// IsValidRole reports whether role is one of Roles
+func IsValidRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
+// RoleHasPermission reports whether role grants permission, directly or through a less privileged role
+func RoleHasPermission(role, permission string) bool {
+	rank := roleRank(role)
+	for i := 0; i <= rank; i++ {
+		for _, p := range rolePermissions[Roles[i]] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RoleAtLeast reports whether role is minimum or a more privileged role
+func RoleAtLeast(role, minimum string) bool {
+	rank := roleRank(minimum)
+	return rank >= 0 && roleRank(role) >= rank
+}
+
+// roleRank returns the position of role in Roles, or -1 for unknown roles
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// Access levels of a pipeline grant, each includes the levels before it
+const (
+	PipelineAccessView = "view"
+	PipelineAccessRun  = "run"
+	PipelineAccessEdit = "edit"
+)
+
+// PipelineAccessLevels lists the grant levels from least to most privileged
+var PipelineAccessLevels = []string{PipelineAccessView, PipelineAccessRun, PipelineAccessEdit}
+
+// PipelineGrant gives a user access to one pipeline. Once a pipeline has grants, only admins and the
+// granted users can access it, regardless of their role
+type PipelineGrant struct {
+	ID         int64     `json:"id"`
+	PipelineID int64     `json:"pipeline_id"`
+	UserID     int64     `json:"user_id"`
+	Username   string    `json:"username,omitempty"`
+	Access     string    `json:"access"` // view, run or edit
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// IsValidPipelineAccess reports whether access is one of PipelineAccessLevels
+func IsValidPipelineAccess(access string) bool {
+	return accessRank(access) >= 0
+}
+
+// PipelineAccessAllows reports whether a grant with access level granted covers the level required
+func PipelineAccessAllows(granted, required string) bool {
+	rank := accessRank(granted)
+	return rank >= 0 && rank >= accessRank(required)
+}
+
+// accessRank returns the position of access in PipelineAccessLevels, or -1 for unknown levels
+func accessRank(access string) int {
+	for i, a := range PipelineAccessLevels {
+		if a == access {
+			return i
+		}
+	}
+	return -1
+}
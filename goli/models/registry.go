@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RegistryCredential holds the login for a container registry
+type RegistryCredential struct {
+	ID        int64     `json:"id"`
+	Host      string    `json:"host"` // e.g. docker.io, ghcr.io, registry.gitlab.com, harbor.example.com:5000
+	Username  string    `json:"username"`
+	Token     string    `json:"token,omitempty"` // Decrypted token (masked in API responses)
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -3,17 +3,20 @@ package models
 import "time"
 
 type User struct {
-	ID                int64     `json:"id"`
-	Username          string    `json:"username"`
-	Email             string    `json:"email,omitempty"`
-	Role              string    `json:"role"` // "admin" or "user"
-	Phone             string    `json:"phone,omitempty"`
-	Password          string    `json:"-"`                    // Never serialize password
-	PasswordHash      string    `json:"-"`                    // Hashed password stored in DB
-	TwoFAEmailEnabled int       `json:"two_fa_email_enabled"` // 0/1
-	TwoFASmsEnabled   int       `json:"two_fa_sms_enabled"`   // 0/1
-	CreatedAt         time.Time `json:"created_at"`
-	UpdatedAt         time.Time `json:"updated_at"`
+	ID                int64      `json:"id"`
+	Username          string     `json:"username"`
+	Email             string     `json:"email,omitempty"`
+	Role              string     `json:"role"` // viewer, operator, maintainer or admin
+	Phone             string     `json:"phone,omitempty"`
+	Password          string     `json:"-"`                    // Never serialize password
+	PasswordHash      string     `json:"-"`                    // Hashed password stored in DB
+	TwoFAEmailEnabled int        `json:"two_fa_email_enabled"` // 0/1
+	TwoFASmsEnabled   int        `json:"two_fa_sms_enabled"`   // 0/1
+	TwoFATOTPEnabled  int        `json:"two_fa_totp_enabled"`  // 0/1, enabled through TOTP enrollment only
+	FailedLogins      int        `json:"failed_logins"`        // Consecutive failed logins
+	LockedAt          *time.Time `json:"locked_at,omitempty"`  // Set once failed logins reached the limit
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
 }
 
 // UserCreateRequest represents a request to create a user
@@ -36,3 +39,24 @@ type UserUpdateRequest struct {
 	TwoFAEmailEnabled *int   `json:"two_fa_email_enabled,omitempty"`
 	TwoFASmsEnabled   *int   `json:"two_fa_sms_enabled,omitempty"`
 }
+
+// ProfileUpdateRequest represents a request of a user to update their own profile, omitted fields
+// stay unchanged
+type ProfileUpdateRequest struct {
+	Email             *string `json:"email,omitempty"`
+	Phone             *string `json:"phone,omitempty"`
+	TwoFAEmailEnabled *int    `json:"two_fa_email_enabled,omitempty"`
+	TwoFASmsEnabled   *int    `json:"two_fa_sms_enabled,omitempty"`
+}
+
+// PasswordChangeRequest represents a request of a user to change their own password
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// PasswordResetRequest sets a new password with the token of an emailed reset link
+type PasswordResetRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
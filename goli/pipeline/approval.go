@@ -0,0 +1,254 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"goli/database"
+	"goli/models"
+	"strings"
+	"time"
+)
+
+// defaultApprovalTimeout is how long an approval step waits when it sets no timeout
+const defaultApprovalTimeout = 24 * time.Hour
+
+// ErrWaitingApproval is returned by ExecutePipeline and ResumePipeline when the job paused at an approval step
+var ErrWaitingApproval = &PipelineError{Message: "Waiting for approval"}
+
+// approvalState is what a paused job resumes with. The definition is the one resolved when the job
+// started, so that changes to the pipeline while the job waits do not change what gets approved
+type approvalState struct {
+	Definition *models.PipelineDefinition `json:"definition"`
+	Secrets    []string                   `json:"secrets,omitempty"`
+}
+
+// requestApproval pauses the job at an approval step. It stores the approval with the state needed to
+// resume and returns ErrWaitingApproval, the worker is free until someone decides
+func requestApproval(job *models.Job, pipelineDef *models.PipelineDefinition, step *models.JobStep, stepDef models.PipelineStep) error {
+	logToStep(step.ID, fmt.Sprintf("Starting step execution: %s", stepDef.Name))
+	if stepDef.Description != "" {
+		logToStep(step.ID, fmt.Sprintf("Description: %s", stepDef.Description))
+	}
+	database.UpdateJobStepStatus(step.ID, models.JobStatusRunning, "")
+
+	fail := func(err error) error {
+		logToStep(step.ID, "ERROR: "+err.Error())
+		database.UpdateJobStepStatus(step.ID, models.JobStatusFailed, err.Error())
+		step.Status = models.JobStatusFailed
+		return err
+	}
+
+	if stepDef.ParallelGroup != "" {
+		return fail(fmt.Errorf("approval steps cannot run in a parallel matrix"))
+	}
+	timeout, err := approvalTimeout(stepDef.Config["timeout"])
+	if err != nil {
+		return fail(err)
+	}
+	state, err := json.Marshal(approvalState{Definition: pipelineDef, Secrets: job.Secrets})
+	if err != nil {
+		return fail(fmt.Errorf("failed to save the pipeline state: %w", err))
+	}
+
+	approval := &models.JobApproval{
+		JobID:     job.ID,
+		StepID:    step.ID,
+		StepName:  stepDef.Name,
+		StepOrder: step.StepOrder,
+		Message:   toString(stepDef.Config["message"]),
+		Approvers: configStrings(stepDef.Config["approvers"]),
+		Roles:     configStrings(stepDef.Config["roles"]),
+		AllowSelf: configBool(stepDef.Config["allow_self_approval"]),
+		ExpiresAt: time.Now().Add(timeout),
+		State:     string(state),
+	}
+	if err := database.CreateJobApproval(approval); err != nil {
+		return fail(fmt.Errorf("failed to create approval: %w", err))
+	}
+
+	if approval.Message != "" {
+		logToStep(step.ID, maskSecrets(job, "Waiting for approval: "+approval.Message))
+	} else {
+		logToStep(step.ID, "Waiting for approval")
+	}
+	logToStep(step.ID, "Can be approved by: "+describeApprovers(approval))
+	logToStep(step.ID, fmt.Sprintf("Expires at %s", approval.ExpiresAt.Local().Format("2006-01-02 15:04:05")))
+
+	database.UpdateJobStepStatus(step.ID, models.JobStatusWaitingApproval, "")
+	step.Status = models.JobStatusWaitingApproval
+	return ErrWaitingApproval
+}
+
+// DecideApproval records a decision on a pending approval in the log and outputs of the approval step.
+// An approved step completes and the job is pending until the caller resumes it with ResumePipeline.
+// Rejected and expired approvals fail the step and the job; cancelled ones only close the step, the
+// caller cancels the job. Returns database.ErrApprovalDecided if someone else decided first
+func DecideApproval(job *models.Job, approval *models.JobApproval, status, decidedBy, comment string) error {
+	if err := database.DecideJobApproval(approval, status, decidedBy, comment); err != nil {
+		return err
+	}
+
+	var message string
+	switch status {
+	case models.ApprovalApproved:
+		message = "Approved by " + decidedBy
+	case models.ApprovalRejected:
+		message = "Rejected by " + decidedBy
+	case models.ApprovalCancelled:
+		message = "Approval cancelled, the job was cancelled by " + decidedBy
+	default:
+		message = "Approval timed out, nobody decided before " + approval.ExpiresAt.Local().Format("2006-01-02 15:04:05")
+	}
+	if comment != "" {
+		message += ": " + comment
+	}
+	logToStep(approval.StepID, message)
+	setStepOutputs(&models.JobStep{ID: approval.StepID}, map[string]string{"decision": status, "decided_by": decidedBy})
+
+	switch status {
+	case models.ApprovalApproved:
+		database.UpdateJobStepStatus(approval.StepID, models.JobStatusCompleted, "")
+		logToJob(job.ID, fmt.Sprintf("Step '%s' approved by %s", approval.StepName, decidedBy))
+		if err := database.UpdateJobStatus(job.ID, models.JobStatusPending, ""); err != nil {
+			return err
+		}
+		job.Status = models.JobStatusPending
+	case models.ApprovalCancelled:
+		database.UpdateJobStepStatus(approval.StepID, models.JobStatusCancelled, "")
+		CleanupWorkspace(job, false)
+	default:
+		database.UpdateJobStepStatus(approval.StepID, models.JobStatusFailed, message)
+		logToJob(job.ID, fmt.Sprintf("ERROR: Step '%s' failed: %s", approval.StepName, message))
+		if err := database.UpdateJobStatus(job.ID, models.JobStatusFailed, fmt.Sprintf("Step '%s': %s", approval.StepName, message)); err != nil {
+			return err
+		}
+		job.Status = models.JobStatusFailed
+		completedAt := time.Now()
+		job.CompletedAt = &completedAt
+		CleanupWorkspace(job, false)
+	}
+	return nil
+}
+
+// ResumePipeline continues a job after its approval step was approved, with the definition and
+// secrets it paused with and the status and outputs of the steps before
+func ResumePipeline(job *models.Job, approval *models.JobApproval) error {
+	var state approvalState
+	if err := json.Unmarshal([]byte(approval.State), &state); err != nil || state.Definition == nil {
+		err = fmt.Errorf("failed to restore the pipeline state: %v", err)
+		logToJob(job.ID, fmt.Sprintf("ERROR: %v", err))
+		database.UpdateJobStatus(job.ID, models.JobStatusFailed, err.Error())
+		return err
+	}
+	job.Secrets = state.Secrets
+
+	steps, err := database.GetJobSteps(job.ID)
+	if err != nil {
+		err = fmt.Errorf("failed to load the job steps: %w", err)
+		logToJob(job.ID, fmt.Sprintf("ERROR: %v", err))
+		database.UpdateJobStatus(job.ID, models.JobStatusFailed, err.Error())
+		return err
+	}
+	var executedSteps []*models.JobStep
+	for i := range steps {
+		if steps[i].StepOrder <= approval.StepOrder {
+			executedSteps = append(executedSteps, &steps[i])
+		}
+	}
+
+	logToJob(job.ID, fmt.Sprintf("Resuming pipeline execution after step '%s'", approval.StepName))
+	return runSteps(job, state.Definition, approval.StepOrder, executedSteps)
+}
+
+// approvalTimeout parses the timeout of an approval step, a duration such as 30m or 24h
+func approvalTimeout(value interface{}) (time.Duration, error) {
+	if value == nil {
+		return defaultApprovalTimeout, nil
+	}
+	raw := toString(value)
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q, expected a duration such as 30m or 24h", raw)
+	}
+	return timeout, nil
+}
+
+// describeApprovers lists who may decide an approval, for the step log and the plan
+func describeApprovers(approval *models.JobApproval) string {
+	var parts []string
+	if len(approval.Approvers) > 0 {
+		parts = append(parts, "users "+strings.Join(approval.Approvers, ", "))
+	}
+	if len(approval.Roles) > 0 {
+		parts = append(parts, "roles "+strings.Join(approval.Roles, ", ")+" and above")
+	}
+	description := "any user who may run the pipeline"
+	if len(parts) > 0 {
+		description = strings.Join(parts, "; ")
+	}
+	if !approval.AllowSelf {
+		description += ", except the user who started the job"
+	}
+	return description
+}
+
+// configBool reads a boolean config value, also accepting "true" from a substituted variable
+func configBool(value interface{}) bool {
+	if b, ok := value.(bool); ok {
+		return b
+	}
+	return toString(value) == "true"
+}
+
+// configStrings converts a list config value to strings, skipping empty items
+func configStrings(value interface{}) []string {
+	list, _ := value.([]interface{})
+	var values []string
+	for _, item := range list {
+		if s := strings.TrimSpace(toString(item)); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// validateApprovalStep checks the settings specific to approval steps
+func validateApprovalStep(step models.PipelineStep, label string) ValidationErrors {
+	var errs ValidationErrors
+	fail := func(key, message string) {
+		errs = append(errs, stepPositionError(step, key, label, message))
+	}
+
+	if len(step.Matrix) > 0 || len(step.MatrixValues) > 0 {
+		fail("matrix", "approval steps cannot use a matrix")
+	}
+	if step.OnFailure == "continue" {
+		fail("on_failure", "approval steps always stop the job when they are rejected, on_failure: continue is not supported")
+	}
+	if step.Retry > 0 {
+		fail("retry", "approval steps cannot be retried")
+	}
+	if len(step.Artifacts) > 0 {
+		fail("artifacts", "approval steps have no artifacts")
+	}
+
+	if value, ok := step.Config["timeout"]; ok && isScalar(value) && len(configReferences(value)) == 0 {
+		if _, err := approvalTimeout(value); err != nil {
+			fail("config.timeout", err.Error())
+		}
+	}
+	if isScalarList(step.Config["roles"]) {
+		for _, role := range configStrings(step.Config["roles"]) {
+			if len(configReferences(role)) > 0 {
+				continue
+			}
+			if !models.IsValidRole(role) {
+				fail("config.roles", fmt.Sprintf("unknown role %q, expected one of %s", role, strings.Join(models.Roles, ", ")))
+			} else if !models.RoleAtLeast(role, models.RoleOperator) {
+				// Deciding requires jobs:run, which viewers never have
+				fail("config.roles", fmt.Sprintf("role %q cannot approve, approvers need run access to the pipeline (%s or above)", role, models.RoleOperator))
+			}
+		}
+	}
+	return errs
+}
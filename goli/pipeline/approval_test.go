@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"goli/database"
+	"goli/models"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startApprovalJob runs a pipeline that builds a file, waits for approval and then reads the file, and
+// returns the job paused at the approval step
+func startApprovalJob(t *testing.T, timeout string) (*models.Job, *models.JobApproval) {
+	t.Helper()
+	openTestDatabase(t)
+	workspaceRootOverride = t.TempDir()
+	t.Cleanup(func() { workspaceRootOverride = "" })
+
+	def, err := ParsePipelineDefinition(`name: release
+steps:
+  - name: build
+    type: script
+    action: run
+    config:
+      script: echo built > build.txt
+  - name: Approve
+    type: approval
+    action: request
+    config:
+      message: Release?
+      approvers: [alice, bob]
+      timeout: "` + timeout + `"
+  - name: deploy
+    type: script
+    action: run
+    config:
+      script: grep built build.txt
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := database.CreateJob(&models.Job{Name: "release", Status: models.JobStatusRunning, CreatedBy: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.UpdateJobStatus(job.ID, models.JobStatusRunning, "")
+
+	if err := ExecutePipeline(job, def); err != ErrWaitingApproval {
+		t.Fatalf("ExecutePipeline = %v, want %v", err, ErrWaitingApproval)
+	}
+	if _, err := os.Stat(JobWorkspace(job.ID)); err != nil {
+		t.Errorf("workspace of the waiting job was removed: %v", err)
+	}
+	approval, err := database.GetPendingJobApproval(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approval.StepName != "Approve" || approval.Message != "Release?" || len(approval.Approvers) != 2 {
+		t.Errorf("approval = %+v", approval)
+	}
+	return job, approval
+}
+
+// jobState loads the status of a job and its steps by name
+func jobState(t *testing.T, jobID int64) (*models.Job, map[string]models.JobStep) {
+	t.Helper()
+	job, err := database.GetJob(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := map[string]models.JobStep{}
+	for _, step := range job.Steps {
+		steps[step.StepName] = step
+	}
+	return job, steps
+}
+
+func TestApprovalApproved(t *testing.T) {
+	job, approval := startApprovalJob(t, "1h")
+	if _, steps := jobState(t, job.ID); steps["Approve"].Status != models.JobStatusWaitingApproval {
+		t.Errorf("approval step status = %s", steps["Approve"].Status)
+	}
+
+	if err := DecideApproval(job, approval, models.ApprovalApproved, "alice", "ship it"); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.JobStatusPending {
+		t.Errorf("job status = %s, want pending until it resumes", job.Status)
+	}
+	if err := DecideApproval(job, approval, models.ApprovalRejected, "bob", ""); err != database.ErrApprovalDecided {
+		t.Errorf("second decision = %v, want %v", err, database.ErrApprovalDecided)
+	}
+
+	if err := ResumePipeline(job, approval); err != nil {
+		t.Fatal(err)
+	}
+	done, steps := jobState(t, job.ID)
+	if done.Status != models.JobStatusCompleted {
+		t.Errorf("job status = %s (%s), want completed", done.Status, done.ErrorMessage)
+	}
+	if steps["deploy"].Status != models.JobStatusCompleted {
+		t.Errorf("deploy did not run in the workspace of the build: %s", steps["deploy"].Logs)
+	}
+	outputs := steps["Approve"].Outputs
+	if steps["Approve"].Status != models.JobStatusCompleted || outputs["decision"] != "approved" || outputs["decided_by"] != "alice" {
+		t.Errorf("approval step = %s %v", steps["Approve"].Status, outputs)
+	}
+	if !strings.Contains(steps["Approve"].Logs, "Approved by alice: ship it") {
+		t.Errorf("approval step log = %q", steps["Approve"].Logs)
+	}
+	if _, err := os.Stat(JobWorkspace(job.ID)); !os.IsNotExist(err) {
+		t.Errorf("workspace was kept after the job completed")
+	}
+}
+
+func TestApprovalRejected(t *testing.T) {
+	job, approval := startApprovalJob(t, "1h")
+
+	if err := DecideApproval(job, approval, models.ApprovalRejected, "bob", "not today"); err != nil {
+		t.Fatal(err)
+	}
+	done, steps := jobState(t, job.ID)
+	if done.Status != models.JobStatusFailed || !strings.Contains(done.ErrorMessage, "Rejected by bob: not today") {
+		t.Errorf("job = %s %q, want failed by the rejection", done.Status, done.ErrorMessage)
+	}
+	if steps["Approve"].Status != models.JobStatusFailed || steps["Approve"].Outputs["decision"] != "rejected" {
+		t.Errorf("approval step = %s %v", steps["Approve"].Status, steps["Approve"].Outputs)
+	}
+	if _, ran := steps["deploy"]; ran {
+		t.Error("deploy ran after the rejection")
+	}
+	if err := DecideApproval(job, approval, models.ApprovalApproved, "alice", ""); err != database.ErrApprovalDecided {
+		t.Errorf("approving a rejected job = %v, want %v", err, database.ErrApprovalDecided)
+	}
+	if _, err := os.Stat(JobWorkspace(job.ID)); !os.IsNotExist(err) {
+		t.Errorf("workspace was kept after the rejection")
+	}
+}
+
+func TestApprovalExpired(t *testing.T) {
+	job, approval := startApprovalJob(t, "50ms")
+	time.Sleep(100 * time.Millisecond)
+
+	if err := DecideApproval(job, approval, models.ApprovalApproved, "alice", ""); err != database.ErrApprovalDecided {
+		t.Errorf("approving an expired approval = %v, want %v", err, database.ErrApprovalDecided)
+	}
+	expired, err := database.GetExpiredJobApprovals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].ID != approval.ID {
+		t.Fatalf("expired approvals = %v, want approval %d", expired, approval.ID)
+	}
+
+	if err := DecideApproval(job, expired[0], models.ApprovalExpired, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	done, steps := jobState(t, job.ID)
+	if done.Status != models.JobStatusFailed || !strings.Contains(done.ErrorMessage, "timed out") {
+		t.Errorf("job = %s %q, want failed by the timeout", done.Status, done.ErrorMessage)
+	}
+	if steps["Approve"].Outputs["decision"] != "expired" {
+		t.Errorf("approval step outputs = %v", steps["Approve"].Outputs)
+	}
+	if expired, _ := database.GetExpiredJobApprovals(); len(expired) != 0 {
+		t.Errorf("approval is still pending after it expired")
+	}
+}
+
+func TestApprovalSelfApprovalSetting(t *testing.T) {
+	def, err := ParsePipelineDefinition(`name: p
+steps:
+  - name: Approve
+    type: approval
+    action: request
+    config:
+      allow_self_approval: maybe
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePipelineDefinition(def); err == nil || !strings.Contains(err.Error(), "allow_self_approval") {
+		t.Errorf("err = %v, want allow_self_approval rejected", err)
+	}
+
+	approval := &models.JobApproval{}
+	if got := describeApprovers(approval); !strings.Contains(got, "except the user who started the job") {
+		t.Errorf("describeApprovers = %q", got)
+	}
+	approval.AllowSelf = configBool(true)
+	if got := describeApprovers(approval); strings.Contains(got, "except") {
+		t.Errorf("describeApprovers with self approval = %q", got)
+	}
+}
+
+func TestValidateApprovalRoles(t *testing.T) {
+	tests := []struct {
+		roles   string
+		wantErr string
+	}{
+		{`["operator", "admin"]`, ""},
+		{`["${APPROVER_ROLE}"]`, ""},
+		{`["viewer"]`, `role "viewer" cannot approve`},
+		{`["owner"]`, `unknown role "owner"`},
+	}
+	for _, tt := range tests {
+		def, err := ParsePipelineDefinition(`name: release
+steps:
+  - name: Approve
+    type: approval
+    action: request
+    config:
+      roles: ` + tt.roles + `
+`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ValidatePipelineDefinition(def, "APPROVER_ROLE")
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("roles %s: %v", tt.roles, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("roles %s: err = %v, want %q", tt.roles, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goli/database"
+	"goli/models"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultArtifactsDir is the content-addressed artifact store when constants.artifacts_dir is not configured
+const defaultArtifactsDir = "/goli/artifacts"
+
+// defaultArtifactRetentionDays is how long artifacts are kept when constants.artifact_retention_days is not configured
+const defaultArtifactRetentionDays = 30
+
+// artifactCleanupGracePeriod protects recently stored contents from the cleanup
+const artifactCleanupGracePeriod = time.Hour
+
+// ArtifactsDir returns the directory of the artifact store
+func ArtifactsDir() string {
+	if dir := configSetting("artifacts_dir"); dir != "" {
+		return dir
+	}
+	return defaultArtifactsDir
+}
+
+// ArtifactRetention returns how long artifacts are kept, 0 keeps them forever
+func ArtifactRetention() time.Duration {
+	days := defaultArtifactRetentionDays
+	if raw := configSetting("artifact_retention_days"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			days = n
+		} else {
+			log.Printf("WARNING: Invalid artifact_retention_days %q, keeping artifacts %d days", raw, defaultArtifactRetentionDays)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ArtifactBlobPath returns where the content with the given SHA-256 is stored
+func ArtifactBlobPath(hash string) string {
+	return filepath.Join(ArtifactsDir(), hash[:2], hash)
+}
+
+// ValidateArtifactPattern checks that an artifact glob stays inside the workspace
+func ValidateArtifactPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("artifact pattern must not be empty")
+	}
+	if strings.HasPrefix(pattern, "/") || !filepath.IsLocal(filepath.FromSlash(strings.ReplaceAll(pattern, "**", "x"))) {
+		return fmt.Errorf("artifact pattern %q must be relative to the workspace", pattern)
+	}
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return fmt.Errorf("invalid artifact pattern %q: %v", pattern, err)
+	}
+	return nil
+}
+
+// matchArtifactPattern reports whether a slash-separated relative path matches a glob
+// Besides the path.Match syntax, ** matches any number of directories.
+func matchArtifactPattern(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchingFiles returns the files below dir matching any of the patterns, as sorted slash-separated relative paths
+// A pattern matching a directory selects all files below it.
+func matchingFiles(dir string, patterns []string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if matchesAny(patterns, rel) {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// storeArtifactBlob copies a file into the artifact store and returns its SHA-256 and size
+func storeArtifactBlob(file string) (string, int64, error) {
+	src, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(ArtifactsDir(), 0755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(ArtifactsDir(), ".upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	blob := ArtifactBlobPath(hash)
+	if _, err := os.Stat(blob); err == nil {
+		// Mark the content as recently stored so the cleanup does not remove it before the artifact is recorded
+		now := time.Now()
+		os.Chtimes(blob, now, now)
+		return hash, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), blob); err != nil {
+		return "", 0, err
+	}
+	return hash, size, nil
+}
+
+// collectArtifacts archives the workspace files matching the artifact patterns of a step
+func collectArtifacts(step *models.JobStep, stepDef models.PipelineStep, job *models.Job) error {
+	workspace := JobWorkspace(job.ID)
+	files, err := matchingFiles(workspace, stepDef.Artifacts)
+	if err != nil {
+		return fmt.Errorf("failed to collect artifacts: %w", err)
+	}
+	if len(files) == 0 {
+		logToStep(step.ID, fmt.Sprintf("WARNING: No files match the artifact patterns %s", strings.Join(stepDef.Artifacts, ", ")))
+		return nil
+	}
+
+	var expiresAt *time.Time
+	if retention := ArtifactRetention(); retention > 0 {
+		t := time.Now().Add(retention)
+		expiresAt = &t
+	}
+
+	var total int64
+	for _, rel := range files {
+		hash, size, err := storeArtifactBlob(filepath.Join(workspace, filepath.FromSlash(rel)))
+		if err != nil {
+			return fmt.Errorf("failed to store artifact %s: %w", rel, err)
+		}
+		artifact := &models.Artifact{
+			JobID:     job.ID,
+			StepID:    step.ID,
+			StepName:  step.StepName,
+			Path:      rel,
+			SHA256:    hash,
+			Size:      size,
+			ExpiresAt: expiresAt,
+		}
+		if _, err := database.CreateArtifact(artifact); err != nil {
+			return fmt.Errorf("failed to record artifact %s: %w", rel, err)
+		}
+		total += size
+	}
+
+	logToStep(step.ID, fmt.Sprintf("Archived %d artifact(s), %d bytes", len(files), total))
+	return nil
+}
+
+// CleanupArtifacts deletes expired artifacts and removes stored contents no artifact references anymore
+func CleanupArtifacts() {
+	deleted, err := database.DeleteExpiredArtifacts()
+	if err != nil {
+		log.Printf("Error deleting expired artifacts: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired artifact(s)", deleted)
+	}
+
+	hashes, err := database.ListArtifactHashes()
+	if err != nil {
+		log.Printf("Error listing artifact hashes: %v", err)
+		return
+	}
+
+	dirs, err := os.ReadDir(ArtifactsDir())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading artifact store: %v", err)
+		}
+		return
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		blobs, err := os.ReadDir(filepath.Join(ArtifactsDir(), dir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, blob := range blobs {
+			if hashes[blob.Name()] {
+				continue
+			}
+			// Contents stored within the grace period may belong to an artifact that is being recorded
+			if info, err := blob.Info(); err != nil || time.Since(info.ModTime()) < artifactCleanupGracePeriod {
+				continue
+			}
+			if err := os.Remove(ArtifactBlobPath(blob.Name())); err != nil {
+				log.Printf("Error removing artifact content %s: %v", blob.Name(), err)
+			}
+		}
+	}
+}
+
+// CleanupArtifactsPeriodically runs CleanupArtifacts at the given interval, it never returns
+func CleanupArtifactsPeriodically(interval time.Duration) {
+	for {
+		CleanupArtifacts()
+		time.Sleep(interval)
+	}
+}
+
+// executeArtifactsStep executes an artifacts step
+func executeArtifactsStep(step *models.JobStep, stepDef models.PipelineStep, job *models.Job) error {
+	switch stepDef.Action {
+	case "fetch":
+		return executeArtifactsFetch(stepDef.Config, step, job)
+	default:
+		logToStep(step.ID, fmt.Sprintf("ERROR: Unsupported artifacts action: %s", stepDef.Action))
+		return ErrUnsupportedAction
+	}
+}
+
+// executeArtifactsFetch copies artifacts of a job of another pipeline into the workspace
+// Without a job ID the latest completed job of the pipeline is used.
+func executeArtifactsFetch(config map[string]interface{}, step *models.JobStep, job *models.Job) error {
+	ref := toString(config["pipeline"])
+	if ref == "" {
+		logToStep(step.ID, "ERROR: Missing or invalid 'pipeline' configuration")
+		return ErrInvalidConfig
+	}
+	var source *models.Pipeline
+	var err error
+	if id, convErr := strconv.ParseInt(ref, 10, 64); convErr == nil {
+		source, err = database.GetPipeline(id)
+	} else {
+		source, err = database.GetPipelineByName(ref)
+	}
+	if err != nil {
+		logToStep(step.ID, fmt.Sprintf("ERROR: Pipeline %q not found", ref))
+		return fmt.Errorf("pipeline %q not found", ref)
+	}
+	// Jobs do not run as a user, so grants cannot be checked; only a pipeline's own jobs may fetch its artifacts
+	if job.PipelineID == nil || *job.PipelineID != source.ID {
+		restricted, err := database.IsPipelineRestricted(source.ID)
+		if err != nil {
+			logToStep(step.ID, fmt.Sprintf("ERROR: Failed to check grants of pipeline %s: %v", source.Name, err))
+			return err
+		}
+		if restricted {
+			logToStep(step.ID, fmt.Sprintf("ERROR: Pipeline %s is restricted by grants, its artifacts can only be fetched by its own jobs", source.Name))
+			return fmt.Errorf("pipeline %s is restricted by grants", source.Name)
+		}
+	}
+
+	var sourceJob *models.Job
+	if raw := toString(config["job"]); raw != "" {
+		jobID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			logToStep(step.ID, fmt.Sprintf("ERROR: Invalid job ID: %s", raw))
+			return ErrInvalidConfig
+		}
+		sourceJob, err = database.GetJob(jobID)
+		if err != nil || sourceJob.PipelineID == nil || *sourceJob.PipelineID != source.ID {
+			logToStep(step.ID, fmt.Sprintf("ERROR: Job %d of pipeline %s not found", jobID, source.Name))
+			return fmt.Errorf("job %d of pipeline %s not found", jobID, source.Name)
+		}
+	} else {
+		sourceJob, err = database.GetLatestPipelineJob(source.ID, models.JobStatusCompleted)
+		if err != nil {
+			logToStep(step.ID, fmt.Sprintf("ERROR: Pipeline %s has no completed job", source.Name))
+			return fmt.Errorf("pipeline %s has no completed job", source.Name)
+		}
+	}
+
+	dest := toString(config["path"])
+	if dest == "" {
+		dest = "."
+	}
+	if !filepath.IsLocal(dest) {
+		logToStep(step.ID, fmt.Sprintf("ERROR: path %q must be relative to the workspace", dest))
+		return ErrInvalidConfig
+	}
+	destDir := filepath.Join(JobWorkspace(job.ID), dest)
+
+	var patterns []string
+	if list, ok := config["paths"].([]interface{}); ok {
+		for _, item := range list {
+			patterns = append(patterns, toString(item))
+		}
+	}
+	stepName := toString(config["step"])
+
+	artifacts, err := database.ListJobArtifacts(sourceJob.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list artifacts of job %d: %w", sourceJob.ID, err)
+	}
+
+	logToStep(step.ID, fmt.Sprintf("Fetching artifacts of job %d (pipeline %s)", sourceJob.ID, source.Name))
+	count := 0
+	for _, artifact := range artifacts {
+		if stepName != "" && artifact.StepName != stepName {
+			continue
+		}
+		if len(patterns) > 0 && !matchesAny(patterns, artifact.Path) {
+			continue
+		}
+		if err := copyArtifact(artifact, JobWorkspace(job.ID), filepath.Join(dest, filepath.FromSlash(artifact.Path))); err != nil {
+			logToStep(step.ID, fmt.Sprintf("ERROR: Failed to fetch %s: %v", artifact.Path, err))
+			return fmt.Errorf("failed to fetch artifact %s: %w", artifact.Path, err)
+		}
+		logToStep(step.ID, fmt.Sprintf("Fetched %s (%d bytes)", artifact.Path, artifact.Size))
+		count++
+	}
+
+	if count == 0 {
+		logToStep(step.ID, fmt.Sprintf("ERROR: Job %d has no matching artifacts", sourceJob.ID))
+		return fmt.Errorf("job %d has no matching artifacts", sourceJob.ID)
+	}
+
+	setStepOutputs(step, map[string]string{
+		"job_id": strconv.FormatInt(sourceJob.ID, 10),
+		"count":  strconv.Itoa(count),
+		"path":   destDir,
+	})
+	return nil
+}
+
+// matchesAny reports whether an artifact path matches one of the patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchArtifactPattern(pattern, name) || matchArtifactPattern(strings.TrimSuffix(pattern, "/")+"/**", name) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyArtifact copies the stored content of an artifact to the path rel in the workspace
+func copyArtifact(artifact *models.Artifact, workspace, rel string) error {
+	target, err := workspaceTarget(workspace, rel)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(ArtifactBlobPath(artifact.SHA256))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	dst, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// workspaceTarget resolves the path rel in the workspace to write to. Earlier steps control the workspace,
+// e.g. a checked out repository may contain dist -> /etc, so symlinks on the way are followed only as long
+// as they stay inside the workspace, and dangling symlinks are refused
+func workspaceTarget(workspace, rel string) (string, error) {
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s is outside the workspace", rel)
+	}
+	root, err := filepath.EvalSymlinks(workspace)
+	if err != nil {
+		return "", err
+	}
+
+	current := root
+	parts := strings.Split(filepath.Clean(rel), string(filepath.Separator))
+	for i, part := range parts {
+		next := filepath.Join(current, part)
+		resolved, err := filepath.EvalSymlinks(next)
+		if errors.Is(err, fs.ErrNotExist) {
+			if _, lerr := os.Lstat(next); lerr == nil {
+				return "", fmt.Errorf("%s is a symlink to a missing target", filepath.Join(parts[:i+1]...))
+			}
+			// Nothing below exists yet, so there are no more symlinks to follow
+			return filepath.Join(append([]string{current}, parts[i:]...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if inside, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(inside) {
+			return "", fmt.Errorf("%s leaves the workspace through a symlink", filepath.Join(parts[:i+1]...))
+		}
+		current = resolved
+	}
+	return current, nil
+}
@@ -0,0 +1,169 @@
+package pipeline
+
+import (
+	aux "goli/auxiliary"
+	"goli/database"
+	"goli/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDatabase opens a database in a temporary directory for the test
+func openTestDatabase(t *testing.T) {
+	t.Helper()
+	if err := database.OpenDatabase(filepath.Join(t.TempDir(), "goli.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.CloseDatabase() })
+}
+
+// createTestPipeline stores a pipeline, restricted by a grant if restricted is set
+func createTestPipeline(t *testing.T, name, definition string, restricted bool) *models.Pipeline {
+	t.Helper()
+	pipeline, err := database.CreatePipeline(&models.Pipeline{Name: name, Definition: definition})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restricted {
+		if _, err := database.SetPipelineGrant(&models.PipelineGrant{PipelineID: pipeline.ID, UserID: 1, Access: models.PipelineAccessView}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pipeline
+}
+
+func TestArtifactsFetchFromRestrictedPipeline(t *testing.T) {
+	openTestDatabase(t)
+	open := createTestPipeline(t, "open", "name: open", false)
+	restricted := createTestPipeline(t, "restricted", "name: restricted", true)
+
+	tests := []struct {
+		name    string
+		jobOf   *int64
+		source  string
+		wantErr string
+	}{
+		{"other pipeline", &open.ID, "restricted", "restricted by grants"},
+		{"job without pipeline", nil, "restricted", "restricted by grants"},
+		{"own pipeline", &restricted.ID, "restricted", "has no completed job"},
+		{"open pipeline", &restricted.ID, "open", "has no completed job"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := database.CreateJob(&models.Job{PipelineID: tt.jobOf, Name: tt.name, Status: models.JobStatusRunning})
+			if err != nil {
+				t.Fatal(err)
+			}
+			step := &models.JobStep{JobID: job.ID, StepName: "fetch", Status: models.JobStatusRunning}
+			if err := database.CreateJobStep(step); err != nil {
+				t.Fatal(err)
+			}
+
+			err = executeArtifactsFetch(map[string]interface{}{"pipeline": tt.source}, step, job)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestArtifactsFetchRefusesSymlinks(t *testing.T) {
+	openTestDatabase(t)
+	workspaceRootOverride = t.TempDir()
+	t.Cleanup(func() { workspaceRootOverride = "" })
+	config := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(config, []byte("[constants]\nartifacts_dir = \""+t.TempDir()+"\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	aux.SetConfigPath(config)
+	t.Cleanup(func() { aux.SetConfigPath("") })
+
+	// A completed job of the build pipeline archived dist/app.txt
+	build := createTestPipeline(t, "build", "name: build", false)
+	deploy := createTestPipeline(t, "deploy", "name: deploy", false)
+	source, err := database.CreateJob(&models.Job{PipelineID: &build.ID, Name: "build", Status: models.JobStatusRunning})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sourceStep := &models.JobStep{JobID: source.ID, StepName: "build", Status: models.JobStatusRunning}
+	if err := database.CreateJobStep(sourceStep); err != nil {
+		t.Fatal(err)
+	}
+	workspace, err := PrepareWorkspace(source.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(workspace, "dist"), 0755)
+	os.WriteFile(filepath.Join(workspace, "dist", "app.txt"), []byte("artifact"), 0644)
+	if err := collectArtifacts(sourceStep, models.PipelineStep{Artifacts: []string{"dist/**"}}, source); err != nil {
+		t.Fatal(err)
+	}
+	database.UpdateJobStatus(source.ID, models.JobStatusCompleted, "")
+
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "app.txt"), []byte("host file"), 0644)
+
+	tests := []struct {
+		name    string
+		plant   func(workspace string)
+		path    string
+		wantErr string
+		written string // path relative to the workspace the artifact must be written to
+	}{
+		{"symlinked directory", func(ws string) {
+			os.Symlink(outside, filepath.Join(ws, "dist"))
+		}, ".", "leaves the workspace", ""},
+		{"symlinked destination", func(ws string) {
+			os.Symlink(outside, filepath.Join(ws, "out"))
+		}, "out", "leaves the workspace", ""},
+		{"dangling symlink", func(ws string) {
+			os.Symlink(filepath.Join(outside, "missing"), filepath.Join(ws, "dist"))
+		}, ".", "symlink to a missing target", ""},
+		{"symlinked file", func(ws string) {
+			os.MkdirAll(filepath.Join(ws, "dist"), 0755)
+			os.Symlink(filepath.Join(outside, "app.txt"), filepath.Join(ws, "dist", "app.txt"))
+		}, ".", "leaves the workspace", ""},
+		{"symlink inside the workspace", func(ws string) {
+			os.MkdirAll(filepath.Join(ws, "build"), 0755)
+			os.Symlink("build", filepath.Join(ws, "dist"))
+		}, ".", "", "build/app.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := database.CreateJob(&models.Job{PipelineID: &deploy.ID, Name: tt.name, Status: models.JobStatusRunning})
+			if err != nil {
+				t.Fatal(err)
+			}
+			step := &models.JobStep{JobID: job.ID, StepName: "fetch", Status: models.JobStatusRunning}
+			if err := database.CreateJobStep(step); err != nil {
+				t.Fatal(err)
+			}
+			workspace, err := PrepareWorkspace(job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.plant(workspace)
+
+			err = executeArtifactsFetch(map[string]interface{}{"pipeline": "build", "path": tt.path}, step, job)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data, err := os.ReadFile(filepath.Join(workspace, tt.written)); err != nil || string(data) != "artifact" {
+					t.Errorf("%s = %q, %v", tt.written, data, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+
+			if data, _ := os.ReadFile(filepath.Join(outside, "app.txt")); string(data) != "host file" {
+				t.Errorf("file outside the workspace overwritten with %q", data)
+			}
+			if _, err := os.Stat(filepath.Join(outside, "missing")); err == nil {
+				t.Error("directory created outside the workspace")
+			}
+		})
+	}
+}
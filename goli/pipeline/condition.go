@@ -0,0 +1,555 @@
+package pipeline
+
+import (
+	"fmt"
+	"goli/models"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Conditions are small boolean expressions used by the step `if:` field, e.g.
+//
+//	${BRANCH} == "main" && steps.test.status == "completed"
+//
+// Supported: string/number/boolean literals, identifiers (dotted paths, ${NAME} or {{NAME}}),
+// ==, !=, <, <=, >, >=, &&, ||, !, parentheses and the functions
+// contains(a, b), startsWith(a, b), endsWith(a, b).
+// Identifiers are looked up in the context map only; nothing is ever executed.
+
+// ValidateCondition reports syntax errors in a condition expression
+func ValidateCondition(expr string) error {
+	_, err := parseCondition(expr)
+	return err
+}
+
+// parseCondition parses a condition expression into an evaluable tree
+func parseCondition(expr string) (conditionNode, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	return node, nil
+}
+
+// EvaluateCondition parses and evaluates a condition against the given context
+// Unknown identifiers evaluate to an empty value
+func EvaluateCondition(expr string, context map[string]interface{}) (bool, error) {
+	node, err := parseCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	value, err := node.eval(context)
+	if err != nil {
+		return false, err
+	}
+	return truthy(value), nil
+}
+
+// conditionIdentifiers returns the names referenced by a condition, invalid conditions yield nil
+func conditionIdentifiers(expr string) []string {
+	node, err := parseCondition(expr)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	var walk func(n conditionNode)
+	walk = func(n conditionNode) {
+		switch v := n.(type) {
+		case *identNode:
+			names = append(names, v.name)
+		case *notNode:
+			walk(v.operand)
+		case *logicalNode:
+			walk(v.left)
+			walk(v.right)
+		case *compareNode:
+			walk(v.left)
+			walk(v.right)
+		case *callNode:
+			for _, arg := range v.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(node)
+	return names
+}
+
+// conditionContext builds the values visible to step conditions: resolved variables, run parameters
+// (parameters.*, they never shadow variables), matrix values of the step (matrix.*), status and outputs of earlier steps (steps.<name>.status) and
+// trigger metadata (job.*, pipeline.*, trigger.*)
+func conditionContext(job *models.Job, def *models.PipelineDefinition, stepDef models.PipelineStep, executedSteps []*models.JobStep) map[string]interface{} {
+	context := make(map[string]interface{})
+	for k, v := range def.Variables {
+		context[k] = v
+	}
+	for k, v := range job.Parameters {
+		context["parameters."+k] = v
+	}
+	for k, v := range stepDef.MatrixValues {
+		context["matrix."+k] = v
+	}
+	for k, v := range stepContextVariables(executedSteps) {
+		context[k] = v
+	}
+	context["job.id"] = job.ID
+	context["job.name"] = job.Name
+	context["trigger.triggered_by"] = job.TriggeredBy
+	context["pipeline.name"] = def.Name
+	if job.PipelineID != nil {
+		context["pipeline.id"] = *job.PipelineID
+	}
+	return context
+}
+
+// Tokenizer
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type conditionToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, conditionToken{tokenLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, conditionToken{tokenRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, conditionToken{tokenComma, ",", i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, conditionToken{tokenString, sb.String(), start})
+		case (r == '$' || r == '{') && i+1 < len(runes) && runes[i+1] == '{':
+			// ${NAME} and {{NAME}} are plain identifier references, never text substitution
+			closing := "}"
+			if r == '{' {
+				closing = "}}"
+			}
+			start := i
+			i += 2
+			for i < len(runes) && !strings.HasPrefix(string(runes[i:]), closing) {
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated %s at position %d", string(runes[start:start+2]), start)
+			}
+			tokens = append(tokens, conditionToken{tokenIdent, strings.TrimSpace(string(runes[start+2 : i])), start})
+			i += len(closing)
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, conditionToken{tokenNumber, string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || strings.ContainsRune("_.-", runes[i])) {
+				i++
+			}
+			tokens = append(tokens, conditionToken{tokenIdent, string(runes[start:i]), start})
+		default:
+			start := i
+			two := ""
+			if i+1 < len(runes) {
+				two = string(runes[i : i+2])
+			}
+			switch two {
+			case "==", "!=", "<=", ">=", "&&", "||":
+				tokens = append(tokens, conditionToken{tokenOperator, two, start})
+				i += 2
+				continue
+			}
+			switch r {
+			case '<', '>', '!':
+				tokens = append(tokens, conditionToken{tokenOperator, string(r), start})
+				i++
+			default:
+				return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+			}
+		}
+	}
+	tokens = append(tokens, conditionToken{tokenEOF, "end of expression", len(runes)})
+	return tokens, nil
+}
+
+// Parser (recursive descent)
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+}
+
+func (p *conditionParser) peek() conditionToken { return p.tokens[p.pos] }
+
+func (p *conditionParser) next() conditionToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOperator && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOperator && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	if p.peek().kind == tokenOperator && p.peek().text == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (conditionNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokenOperator {
+		switch t.text {
+		case "==", "!=", "<", "<=", ">", ">=":
+			p.next()
+			right, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return &compareNode{op: t.text, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parsePrimary() (conditionNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return &literalNode{value: t.text}, nil
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", t.text, t.pos)
+		}
+		return &literalNode{value: f}, nil
+	case tokenLParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, fmt.Errorf("missing ) for ( at position %d", t.pos)
+		}
+		return node, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(t)
+		}
+		return &identNode{name: t.text}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+func (p *conditionParser) parseCall(name conditionToken) (conditionNode, error) {
+	if _, ok := conditionFunctions[name.text]; !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	p.next() // (
+	var args []conditionNode
+	if p.peek().kind != tokenRParen {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.next().kind != tokenRParen {
+		return nil, fmt.Errorf("missing ) for call to %s at position %d", name.text, name.pos)
+	}
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s expects 2 arguments, got %d", name.text, len(args))
+	}
+	return &callNode{name: name.text, args: args}, nil
+}
+
+// AST
+
+type conditionNode interface {
+	eval(context map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) { return n.value, nil }
+
+type identNode struct{ name string }
+
+func (n *identNode) eval(context map[string]interface{}) (interface{}, error) {
+	return context[n.name], nil
+}
+
+type notNode struct{ operand conditionNode }
+
+func (n *notNode) eval(context map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(context)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right conditionNode
+}
+
+func (n *logicalNode) eval(context map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(context)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit
+	if n.op == "&&" && !truthy(l) {
+		return false, nil
+	}
+	if n.op == "||" && truthy(l) {
+		return true, nil
+	}
+	r, err := n.right.eval(context)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(r), nil
+}
+
+type compareNode struct {
+	op          string
+	left, right conditionNode
+}
+
+func (n *compareNode) eval(context map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(context)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(context)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare numerically when one side is a number, i.e. an unquoted literal or a numeric variable, and
+	// the other is a number too or a string holding a plain decimal. Two strings always compare as
+	// strings, so "1.0" != "1" and "010" != "10"
+	lf, lNum := toNumber(l)
+	rf, rNum := toNumber(r)
+	if lNum && rNum && (isNumber(l) || isNumber(r)) {
+		switch n.op {
+		case "==":
+			return lf == rf, nil
+		case "!=":
+			return lf != rf, nil
+		case "<":
+			return lf < rf, nil
+		case "<=":
+			return lf <= rf, nil
+		case ">":
+			return lf > rf, nil
+		case ">=":
+			return lf >= rf, nil
+		}
+	}
+
+	ls, rs := conditionString(l), conditionString(r)
+	switch n.op {
+	case "==":
+		return ls == rs, nil
+	case "!=":
+		return ls != rs, nil
+	case "<":
+		return ls < rs, nil
+	case "<=":
+		return ls <= rs, nil
+	case ">":
+		return ls > rs, nil
+	case ">=":
+		return ls >= rs, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type callNode struct {
+	name string
+	args []conditionNode
+}
+
+var conditionFunctions = map[string]func(a, b string) bool{
+	"contains":   strings.Contains,
+	"startsWith": strings.HasPrefix,
+	"endsWith":   strings.HasSuffix,
+}
+
+func (n *callNode) eval(context map[string]interface{}) (interface{}, error) {
+	a, err := n.args[0].eval(context)
+	if err != nil {
+		return nil, err
+	}
+	b, err := n.args[1].eval(context)
+	if err != nil {
+		return nil, err
+	}
+	return conditionFunctions[n.name](conditionString(a), conditionString(b)), nil
+}
+
+// Value helpers
+
+func truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case float64:
+		return val != 0
+	case int:
+		return val != 0
+	case int64:
+		return val != 0
+	case string:
+		lower := strings.ToLower(strings.TrimSpace(val))
+		return lower != "" && lower != "false" && lower != "0"
+	default:
+		return true
+	}
+}
+
+// decimalNumber matches the strings toNumber accepts, the same form as number literals
+var decimalNumber = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// isNumber reports whether v is a number rather than a string
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case float64, int, int64:
+		return true
+	}
+	return false
+}
+
+// toNumber converts numbers and plain decimal strings such as "42" or "-1.5", not "1e3", "0x10" or "NaN"
+func toNumber(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case string:
+		val = strings.TrimSpace(val)
+		if !decimalNumber.MatchString(val) {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(val, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func conditionString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return toString(v)
+}
@@ -0,0 +1,53 @@
+package pipeline
+
+import "testing"
+
+func TestConditionComparisons(t *testing.T) {
+	context := map[string]interface{}{
+		"RETRIES":                     3,
+		"VERSION":                     "1.0",
+		"parameters.COUNT":            "10",
+		"parameters.ZIP":              "010",
+		"parameters.BIG":              "1e3",
+		"steps.test.outputs.FAILURES": "0",
+		"steps.test.outputs.DURATION": " 9.5 ",
+		"job.id":                      int64(42),
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// Numbers against unquoted literals
+		{`RETRIES == 3`, true},
+		{`RETRIES > 2.5`, true},
+		{`parameters.COUNT > 9`, true},
+		{`parameters.COUNT == 10.0`, true},
+		{`steps.test.outputs.FAILURES == 0`, true},
+		{`steps.test.outputs.DURATION < 10`, true},
+		{`job.id >= 42`, true},
+		{`-1 < 0`, true},
+		// Strings compare as text, even when they look like numbers
+		{`VERSION == "1"`, false},
+		{`VERSION == "1.0"`, true},
+		{`parameters.COUNT > "9"`, false},
+		{`parameters.ZIP == "10"`, false},
+		{`parameters.ZIP == parameters.COUNT`, false},
+		{`"1.0" == "1"`, false},
+		// Only plain decimals are numbers
+		{`parameters.BIG == 1000`, false},
+		{`parameters.BIG == "1e3"`, true},
+		{`parameters.MISSING == 0`, false},
+		{`parameters.MISSING == ""`, true},
+	}
+	for _, tt := range tests {
+		got, err := EvaluateCondition(tt.expr, context)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
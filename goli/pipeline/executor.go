@@ -1,13 +1,18 @@
 package pipeline
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"goli/database"
 	"goli/models"
 	response_util "goli/utils"
 	"log"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -39,35 +44,120 @@ func ExecutePipeline(job *models.Job, pipelineDef *models.PipelineDefinition) er
 	}
 	logToJob(job.ID, fmt.Sprintf("Total steps: %d", len(pipelineDef.Steps)))
 
-	// Create job steps from pipeline definition
-	for i, stepDef := range pipelineDef.Steps {
-		step := &models.JobStep{
-			JobID:     job.ID,
-			StepName:  stepDef.Name,
-			StepOrder: i + 1,
-			Status:    models.JobStatusPending,
+	return runSteps(job, pipelineDef, 0, nil)
+}
+
+// runSteps executes the steps of a pipeline definition from index start on. executedSteps are the
+// steps executed so far, later steps can reference their status and outputs
+func runSteps(job *models.Job, pipelineDef *models.PipelineDefinition, start int, executedSteps []*models.JobStep) (err error) {
+	// Every job runs its steps in its own workspace, it is kept while the job waits for approval
+	workspace, err := PrepareWorkspace(job.ID)
+	if err != nil {
+		logToJob(job.ID, fmt.Sprintf("ERROR: %v", err))
+		database.UpdateJobStatus(job.ID, models.JobStatusFailed, err.Error())
+		return err
+	}
+	logToJob(job.ID, fmt.Sprintf("Workspace: %s", workspace))
+	defer func() {
+		if err != ErrWaitingApproval {
+			CleanupWorkspace(job, err == nil)
 		}
+	}()
+
+	total := len(pipelineDef.Steps)
+	for i := start; i < total; {
+		// Consecutive matrix expansions of a parallel matrix run as one batch
+		end := i + 1
+		if group := pipelineDef.Steps[i].ParallelGroup; group != "" {
+			for end < total && pipelineDef.Steps[end].ParallelGroup == group {
+				end++
+			}
+		}
+		batch := pipelineDef.Steps[i:end]
+
+		// Create job steps from pipeline definition
+		steps := make([]*models.JobStep, len(batch))
+		for j, stepDef := range batch {
+			step := &models.JobStep{
+				JobID:     job.ID,
+				StepName:  stepDef.Name,
+				StepOrder: i + j + 1,
+				Status:    models.JobStatusPending,
+			}
 
-		if err := database.CreateJobStep(step); err != nil {
-			logToJob(job.ID, fmt.Sprintf("ERROR: Failed to create job step '%s': %v", stepDef.Name, err))
-			continue
+			if err := database.CreateJobStep(step); err != nil {
+				logToJob(job.ID, fmt.Sprintf("ERROR: Failed to create job step '%s': %v", stepDef.Name, err))
+				continue
+			}
+
+			logToJob(job.ID, fmt.Sprintf("Created step %d/%d: %s (type: %s, action: %s)", i+j+1, total, stepDef.Name, stepDef.Type, stepDef.Action))
+			steps[j] = step
 		}
 
-		logToJob(job.ID, fmt.Sprintf("Created step %d/%d: %s (type: %s, action: %s)", i+1, len(pipelineDef.Steps), stepDef.Name, stepDef.Type, stepDef.Action))
+		// Execute the steps
+		errs := make([]error, len(batch))
+		if len(batch) > 1 {
+			logToJob(job.ID, fmt.Sprintf("Running %d matrix steps of '%s' in parallel", len(batch), batch[0].ParallelGroup))
+			var wg sync.WaitGroup
+			for j := range batch {
+				if steps[j] == nil {
+					continue
+				}
+				wg.Add(1)
+				go func(j int) {
+					defer wg.Done()
+					errs[j] = runPipelineStep(job, pipelineDef, steps[j], batch[j], executedSteps)
+				}(j)
+			}
+			wg.Wait()
+		} else if steps[0] != nil {
+			errs[0] = runPipelineStep(job, pipelineDef, steps[0], batch[0], executedSteps)
+		}
 
-		// Execute the step
-		if err := executeStep(step, stepDef, job); err != nil {
-			logToJob(job.ID, fmt.Sprintf("ERROR: Step '%s' failed: %v", stepDef.Name, err))
-			if stepDef.OnFailure == "stop" {
-				logToJob(job.ID, "Pipeline execution stopped due to step failure (on_failure: stop)")
-				database.UpdateJobStatus(job.ID, models.JobStatusFailed, err.Error())
-				return err
+		var stopErr error
+		var waiting *models.PipelineStep
+		for j, stepDef := range batch {
+			step := steps[j]
+			if step == nil {
+				continue
 			}
-			logToJob(job.ID, "Continuing to next step (on_failure: continue)")
-			// Continue to next step if on_failure is "continue"
-		} else {
-			logToJob(job.ID, fmt.Sprintf("Step '%s' completed successfully", stepDef.Name))
+			executedSteps = append(executedSteps, step)
+
+			if err := errs[j]; err == ErrWaitingApproval {
+				waiting = &batch[j]
+			} else if err != nil {
+				logToJob(job.ID, fmt.Sprintf("ERROR: Step '%s' failed: %v", stepDef.Name, err))
+				if stepDef.OnFailure == "stop" {
+					if stopErr == nil {
+						stopErr = err
+					}
+					continue
+				}
+				logToJob(job.ID, "Continuing to next step (on_failure: continue)")
+				// Continue to next step if on_failure is "continue"
+			} else if step.Status == models.JobStatusSkipped {
+				logToJob(job.ID, fmt.Sprintf("Step '%s' skipped (if: %s)", stepDef.Name, stepDef.If))
+			} else {
+				logToJob(job.ID, fmt.Sprintf("Step '%s' completed successfully", stepDef.Name))
+			}
+		}
+
+		if stopErr != nil {
+			logToJob(job.ID, "Pipeline execution stopped due to step failure (on_failure: stop)")
+			database.UpdateJobStatus(job.ID, models.JobStatusFailed, stopErr.Error())
+			return stopErr
+		}
+
+		if waiting != nil {
+			logToJob(job.ID, fmt.Sprintf("Step '%s' is waiting for approval, the job continues once it is approved", waiting.Name))
+			if err := database.UpdateJobStatus(job.ID, models.JobStatusWaitingApproval, ""); err != nil {
+				logToJob(job.ID, fmt.Sprintf("ERROR: Failed to update job status: %v", err))
+			}
+			job.Status = models.JobStatusWaitingApproval
+			return ErrWaitingApproval
 		}
+
+		i = end
 	}
 
 	// All steps completed successfully
@@ -82,6 +172,51 @@ func ExecutePipeline(job *models.Job, pipelineDef *models.PipelineDefinition) er
 	return nil
 }
 
+// runPipelineStep resolves references to earlier steps, evaluates the step condition and executes the step
+// executedSteps must not be modified while the step runs
+func runPipelineStep(job *models.Job, pipelineDef *models.PipelineDefinition, step *models.JobStep, stepDef models.PipelineStep, executedSteps []*models.JobStep) error {
+	// Resolve references to outputs of earlier steps and to built-in variables like ${GOLI_WORKSPACE}
+	builtins := builtinVariables(job, pipelineDef, stepDef.Name)
+	variables := stepContextVariables(executedSteps)
+	for k, v := range builtins {
+		variables[k] = v
+	}
+	SubstituteStepVariables(&stepDef, variables)
+
+	// The step environment holds the step's env plus the built-in variables, which cannot be overridden
+	env := make(map[string]string, len(stepDef.Env)+len(builtins))
+	for k, v := range stepDef.Env {
+		env[k] = v
+	}
+	for k, v := range builtins {
+		env[k] = toString(v)
+	}
+	stepDef.Env = env
+
+	// Skip the step when its condition is false
+	if stepDef.If != "" {
+		run, err := EvaluateCondition(stepDef.If, conditionContext(job, pipelineDef, stepDef, executedSteps))
+		if err != nil {
+			err = fmt.Errorf("invalid if condition: %w", err)
+			logToStep(step.ID, "ERROR: "+err.Error())
+			database.UpdateJobStepStatus(step.ID, models.JobStatusFailed, err.Error())
+			step.Status = models.JobStatusFailed
+			return err
+		}
+		if !run {
+			logToStep(step.ID, fmt.Sprintf("Step skipped, condition is false: %s", stepDef.If))
+			database.UpdateJobStepStatus(step.ID, models.JobStatusSkipped, "")
+			step.Status = models.JobStatusSkipped
+			return nil
+		}
+	}
+
+	if stepDef.Type == "approval" {
+		return requestApproval(job, pipelineDef, step, stepDef)
+	}
+	return executeStep(step, stepDef, job)
+}
+
 // executeStep executes a single pipeline step
 func executeStep(step *models.JobStep, stepDef models.PipelineStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Starting step execution: %s", stepDef.Name))
@@ -113,15 +248,27 @@ func executeStep(step *models.JobStep, stepDef models.PipelineStep, job *models.
 			err = executeScriptStep(step, stepDef, job)
 		case "shell":
 			err = executeShellStep(step, stepDef, job)
+		case "git":
+			err = executeGitStep(step, stepDef, job)
+		case "artifacts":
+			err = executeArtifactsStep(step, stepDef, job)
 		default:
-			logToStep(step.ID, fmt.Sprintf("WARNING: Unknown step type '%s', defaulting to docker", stepDef.Type))
-			err = executeDockerStep(step, stepDef, job) // Default to docker
+			logToStep(step.ID, fmt.Sprintf("ERROR: Unknown step type '%s'", stepDef.Type))
+			err = ErrUnsupportedAction
+		}
+		if err == ErrUnsupportedAction {
+			break // Retrying cannot help
+		}
+
+		if err == nil && len(stepDef.Artifacts) > 0 {
+			err = collectArtifacts(step, stepDef, job)
 		}
 
 		if err == nil {
 			// Step succeeded
 			logToStep(step.ID, "Step completed successfully")
 			database.UpdateJobStepStatus(step.ID, models.JobStatusCompleted, "")
+			step.Status = models.JobStatusCompleted
 			return nil
 		}
 
@@ -131,6 +278,7 @@ func executeStep(step *models.JobStep, stepDef models.PipelineStep, job *models.
 	// All retries failed
 	logToStep(step.ID, fmt.Sprintf("All retry attempts exhausted. Step failed with error: %v", err))
 	database.UpdateJobStepStatus(step.ID, models.JobStatusFailed, err.Error())
+	step.Status = models.JobStatusFailed
 	return err
 }
 
@@ -140,7 +288,7 @@ func executeDockerStep(step *models.JobStep, stepDef models.PipelineStep, job *m
 	config := stepDef.Config
 
 	logToStep(step.ID, fmt.Sprintf("Executing Docker action: %s", action))
-	logToStep(step.ID, fmt.Sprintf("Configuration: %v", config))
+	logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Configuration: %v", config)))
 
 	// Map pipeline step to Docker operations
 	switch action {
@@ -150,30 +298,37 @@ func executeDockerStep(step *models.JobStep, stepDef models.PipelineStep, job *m
 			logToStep(step.ID, "ERROR: Missing or invalid 'image' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerPull(image, step)
+		return executeDockerPull(image, step, job)
 	case "run":
-		return executeDockerRun(config, step)
+		return executeDockerRun(config, stepDef.Env, step, job)
+	case "push":
+		image, ok := config["image"].(string)
+		if !ok {
+			logToStep(step.ID, "ERROR: Missing or invalid 'image' configuration")
+			return ErrInvalidConfig
+		}
+		return executeDockerPush(image, step, job)
 	case "start":
 		container, ok := config["container"].(string)
 		if !ok {
 			logToStep(step.ID, "ERROR: Missing or invalid 'container' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerStart(container, step)
+		return executeDockerStart(container, step, job)
 	case "stop":
 		container, ok := config["container"].(string)
 		if !ok {
 			logToStep(step.ID, "ERROR: Missing or invalid 'container' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerStop(container, step)
+		return executeDockerStop(container, step, job)
 	case "rm":
 		container, ok := config["container"].(string)
 		if !ok {
 			logToStep(step.ID, "ERROR: Missing or invalid 'container' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerRemove(container, step)
+		return executeDockerRemove(container, step, job)
 
 	case "rmi":
 		image, ok := config["image"].(string)
@@ -181,35 +336,35 @@ func executeDockerStep(step *models.JobStep, stepDef models.PipelineStep, job *m
 			logToStep(step.ID, "ERROR: Missing or invalid 'image' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerRemoveImage(image, step)
+		return executeDockerRemoveImage(image, step, job)
 	case "pause":
 		container, ok := config["container"].(string)
 		if !ok {
 			logToStep(step.ID, "ERROR: Missing or invalid 'container' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerPause(container, step)
+		return executeDockerPause(container, step, job)
 	case "unpause":
 		container, ok := config["container"].(string)
 		if !ok {
 			logToStep(step.ID, "ERROR: Missing or invalid 'container' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerUnpause(container, step)
+		return executeDockerUnpause(container, step, job)
 	case "inspect":
 		container, ok := config["container"].(string)
 		if !ok {
 			logToStep(step.ID, "ERROR: Missing or invalid 'container' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerInspect(container, step)
+		return executeDockerInspect(container, step, job)
 	case "logs":
 		container, ok := config["container"].(string)
 		if !ok {
 			logToStep(step.ID, "ERROR: Missing or invalid 'container' configuration")
 			return ErrInvalidConfig
 		}
-		return executeDockerLogs(container, step)
+		return executeDockerLogs(container, step, job)
 	case "exec":
 		container, ok := config["container"].(string)
 		if !ok {
@@ -232,7 +387,7 @@ func executeDockerStep(step *models.JobStep, stepDef models.PipelineStep, job *m
 				argsString = append(argsString, argStr)
 			}
 		}
-		return executeDockerExec(container, command, argsString, step)
+		return executeDockerExec(container, command, argsString, step, job)
 	default:
 		logToStep(step.ID, fmt.Sprintf("ERROR: Unsupported Docker action: %s", action))
 		return ErrUnsupportedAction
@@ -253,9 +408,9 @@ func executeScriptStep(step *models.JobStep, stepDef models.PipelineStep, job *m
 
 	// Log script content (truncated if too long)
 	if len(script) > 1000 {
-		logToStep(step.ID, fmt.Sprintf("Script preview (first 1000 chars):\n%s...", script[:1000]))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Script preview (first 1000 chars):\n%s...", script[:1000])))
 	} else {
-		logToStep(step.ID, fmt.Sprintf("Script content:\n%s", script))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Script content:\n%s", script)))
 	}
 
 	// Execute the script
@@ -268,11 +423,10 @@ func executeScriptStep(step *models.JobStep, stepDef models.PipelineStep, job *m
 
 	logToStep(step.ID, fmt.Sprintf("Executing script using: %s", shell))
 
-	cmd := exec.Command(shell, "-c", script)
-	output, err := cmd.CombinedOutput()
+	output, err := runStepCommand(job, step, stepDef, shell, "-c", script)
 
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Script output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Script output:\n%s", string(output))))
 	}
 
 	if err != nil {
@@ -306,15 +460,16 @@ func executeShellStep(step *models.JobStep, stepDef models.PipelineStep, job *mo
 		}
 	}
 
-	logToStep(step.ID, fmt.Sprintf("Executing shell command: %s", command))
+	logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Executing shell command: %s", command)))
 	if len(args) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Command arguments: %v", args))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Command arguments: %v", args)))
 	}
 
-	output, err := executeShellCommand(command, args...)
+	outputBytes, err := runStepCommand(job, step, stepDef, command, args...)
+	output := string(outputBytes)
 
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Command output:\n%s", output))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Command output:\n%s", output)))
 	}
 
 	if err != nil {
@@ -326,18 +481,129 @@ func executeShellStep(step *models.JobStep, stepDef models.PipelineStep, job *mo
 	return nil
 }
 
+// builtinVariables returns the variables Goli provides to every step, as ${GOLI_*} references and in its environment
+func builtinVariables(job *models.Job, pipelineDef *models.PipelineDefinition, stepName string) map[string]interface{} {
+	return map[string]interface{}{
+		"GOLI_JOB_ID":    strconv.FormatInt(job.ID, 10),
+		"GOLI_PIPELINE":  pipelineDef.Name,
+		"GOLI_STEP":      stepName,
+		"GOLI_WORKSPACE": JobWorkspace(job.ID),
+	}
+}
+
+// maskSecrets replaces the values of the job's secret variables in a log message
+func maskSecrets(job *models.Job, message string) string {
+	for _, secret := range job.Secrets {
+		if secret != "" {
+			message = strings.ReplaceAll(message, secret, "***MASKED***")
+		}
+	}
+	return message
+}
+
+// runStepCommand runs the command of a script or shell step and collects its outputs
+// It runs in the job workspace with the step environment and $GOLI_OUTPUT, inside a throwaway container when the
+// step has one. The container gets its environment through a temporary env file, so values do not show up in the
+// docker command line.
+func runStepCommand(job *models.Job, step *models.JobStep, stepDef models.PipelineStep, name string, args ...string) ([]byte, error) {
+	if stepDef.Container == nil {
+		return runCommandWithOutputs(step, func(outputPath string) *exec.Cmd {
+			cmd := exec.Command(name, args...)
+			cmd.Dir = JobWorkspace(job.ID)
+			cmd.Env = os.Environ()
+			for _, k := range sortedKeys(stepDef.Env) {
+				cmd.Env = append(cmd.Env, k+"="+stepDef.Env[k])
+			}
+			cmd.Env = append(cmd.Env, "GOLI_OUTPUT="+outputPath)
+			return cmd
+		})
+	}
+
+	logToStep(step.ID, fmt.Sprintf("Running in container: %s", stepDef.Container.Image))
+
+	var env []string
+	for _, k := range sortedKeys(stepDef.Container.Env) {
+		env = append(env, k+"="+stepDef.Container.Env[k])
+	}
+	for _, k := range sortedKeys(stepDef.Env) {
+		env = append(env, k+"="+stepDef.Env[k])
+	}
+	envFile, err := writeEnvFile(env)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(envFile)
+
+	// docker run pulls missing images, so make sure the registry login is in place
+	response_util.EnsureRegistryAuthForImage(stepDef.Container.Image)
+	containerName := fmt.Sprintf("goli-job-%d-step-%d", job.ID, step.ID)
+	return runCommandWithOutputs(step, func(outputPath string) *exec.Cmd {
+		return exec.Command("docker", containerRunArgs(containerName, stepDef.Container, JobWorkspace(job.ID), outputPath, envFile, append([]string{name}, args...))...)
+	})
+}
+
+// writeEnvFile writes KEY=VALUE entries to a temporary docker env file, the caller removes it
+func writeEnvFile(entries []string) (string, error) {
+	for _, entry := range entries {
+		if strings.ContainsAny(entry, "\r\n") {
+			key, _, _ := strings.Cut(entry, "=")
+			return "", fmt.Errorf("environment variable %s contains a line break, which docker env files do not support", key)
+		}
+	}
+
+	file, err := os.CreateTemp("", "goli-env-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create env file: %w", err)
+	}
+	content := strings.Join(entries, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write env file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write env file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// containerOutputPath is where the step output file is mounted inside step containers
+const containerOutputPath = "/goli/output"
+
+// containerRunArgs builds the docker run arguments for a step running in a container
+// The workspace is mounted at the same path as on the host, so ${GOLI_WORKSPACE} is valid inside the container.
+func containerRunArgs(containerName string, container *models.StepContainer, workspace, outputPath, envFile string, command []string) []string {
+	args := []string{"run", "--rm", "--name", containerName,
+		"-v", workspace + ":" + workspace, "-w", workspace,
+		"-v", outputPath + ":" + containerOutputPath, "-e", "GOLI_OUTPUT=" + containerOutputPath,
+		"--env-file", envFile,
+	}
+	if container.User != "" {
+		args = append(args, "--user", container.User)
+	}
+	for _, volume := range container.Volumes {
+		args = append(args, "-v", volume)
+	}
+	args = append(args, container.Image)
+	return append(args, command...)
+}
+
 // Helper functions for Docker operations
-func executeDockerPull(image string, step *models.JobStep) error {
+func executeDockerPull(image string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Pulling Docker image: %s", image))
 
-	// Ensure GitHub Container Registry authentication if needed
-	response_util.EnsureGitHubAuthForImage(image)
+	// Log in to the image's registry if credentials are stored for it
+	response_util.EnsureRegistryAuthForImage(image)
 
 	cmd := exec.Command("docker", "pull", image)
 	output, err := cmd.CombinedOutput()
 
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker pull output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker pull output:\n%s", string(output))))
 	}
 
 	if err != nil {
@@ -346,10 +612,33 @@ func executeDockerPull(image string, step *models.JobStep) error {
 	}
 
 	logToStep(step.ID, "Docker image pulled successfully")
+	recordImageOutputs(image, step)
 	return nil
 }
 
-func executeDockerRun(config map[string]interface{}, step *models.JobStep) error {
+func executeDockerPush(image string, step *models.JobStep, job *models.Job) error {
+	logToStep(step.ID, fmt.Sprintf("Pushing Docker image: %s", image))
+
+	// Log in to the image's registry if credentials are stored for it
+	response_util.EnsureRegistryAuthForImage(image)
+
+	cmd := exec.Command("docker", "push", image)
+	output, err := cmd.CombinedOutput()
+
+	if len(output) > 0 {
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker push output:\n%s", string(output))))
+	}
+
+	if err != nil {
+		logToStep(step.ID, fmt.Sprintf("Docker push failed: %v", err))
+		return fmt.Errorf("docker push failed: %w", err)
+	}
+
+	logToStep(step.ID, "Docker image pushed successfully")
+	return nil
+}
+
+func executeDockerRun(config map[string]interface{}, stepEnv map[string]string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, "Running Docker container")
 
 	image, ok := config["image"].(string)
@@ -358,27 +647,103 @@ func executeDockerRun(config map[string]interface{}, step *models.JobStep) error
 		return ErrInvalidConfig
 	}
 
-	// Build docker run command
-	// Docker command structure: docker run [OPTIONS] IMAGE [COMMAND] [ARG...]
-	args := []string{"run", "--detach"}
+	// docker run pulls missing images, so make sure the registry login is in place
+	response_util.EnsureRegistryAuthForImage(image)
+
+	// Pin the image to the digest of the locally available image if requested
+	runImage := image
+	if pin, ok := config["pin_digest"].(bool); ok && pin {
+		if images, err := response_util.InspectImages(context.Background(), image); err == nil && len(images) > 0 {
+			if digest := response_util.DigestForImage(image, images[0]); digest != "" {
+				runImage = response_util.ImageRepository(image) + "@" + digest
+				logToStep(step.ID, fmt.Sprintf("Pinned image %s to %s", image, runImage))
+			} else {
+				logToStep(step.ID, fmt.Sprintf("WARNING: %s has no digest in %s, running by tag", image, response_util.ImageRepository(image)))
+			}
+		} else {
+			logToStep(step.ID, fmt.Sprintf("WARNING: Could not resolve digest for %s, running by tag", image))
+		}
+	}
 
-	// Add container name if specified
-	if container, ok := config["container"].(string); ok {
-		args = append(args, "--name", container)
+	// Provenance labels, used by the deployments view
+	labels := []string{fmt.Sprintf("goli.job_id=%d", job.ID), "goli.step=" + step.StepName}
+	if job.PipelineID != nil {
+		labels = append(labels, fmt.Sprintf("goli.pipeline_id=%d", *job.PipelineID))
 	}
 
-	// Add environment variables
+	// The environment goes through a temporary env file so values do not show up in the command line
+	envFile, err := writeEnvFile(dockerRunEnv(config, stepEnv))
+	if err != nil {
+		logToStep(step.ID, fmt.Sprintf("ERROR: %v", err))
+		return err
+	}
+	defer os.Remove(envFile)
+	args := dockerRunArgs(config, runImage, labels, envFile)
+
+	logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Executing: docker %s", strings.Join(args, " "))))
+
+	cmd := exec.Command("docker", args...)
+	output, err := cmd.CombinedOutput()
+
+	if len(output) > 0 {
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker run output:\n%s", string(output))))
+	}
+
+	if err != nil {
+		logToStep(step.ID, fmt.Sprintf("Docker run failed: %v", err))
+		return fmt.Errorf("docker run failed: %w", err)
+	}
+
+	logToStep(step.ID, "Docker container ran successfully")
+
+	// docker run --detach prints the container ID as last line (pull progress may come before it)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	containerID := strings.TrimSpace(lines[len(lines)-1])
+	recordContainerDeployment(image, containerID, step, job)
+	return nil
+}
+
+// dockerRunEnv returns the environment of a docker run step as env file entries: the env of the run config
+// followed by the step environment. Entries of a config env list without "=" take the value from the host.
+func dockerRunEnv(config map[string]interface{}, stepEnv map[string]string) []string {
+	var entries []string
 	if env, ok := config["env"].(map[string]interface{}); ok {
-		for key, value := range env {
-			args = append(args, "-e", fmt.Sprintf("%s=%v", key, value))
+		for _, key := range sortedKeys(env) {
+			entries = append(entries, fmt.Sprintf("%s=%v", key, env[key]))
 		}
 	} else if envList, ok := config["env"].([]interface{}); ok {
 		for _, envItem := range envList {
 			if envStr, ok := envItem.(string); ok {
-				args = append(args, "-e", envStr)
+				entries = append(entries, envStr)
 			}
 		}
 	}
+	for _, key := range sortedKeys(stepEnv) {
+		entries = append(entries, key+"="+stepEnv[key])
+	}
+	return entries
+}
+
+// dockerRunArgs builds the docker run arguments for a run step config
+// Shared by the executor and the plan so a dry run shows the exact command
+func dockerRunArgs(config map[string]interface{}, runImage string, labels []string, envFile string) []string {
+	// Build docker run command
+	// Docker command structure: docker run [OPTIONS] IMAGE [COMMAND] [ARG...]
+	args := []string{"run", "--detach"}
+
+	for _, label := range labels {
+		args = append(args, "--label", label)
+	}
+
+	// Add container name if specified
+	if container, ok := config["container"].(string); ok {
+		args = append(args, "--name", container)
+	}
+
+	// Add environment variables
+	if envFile != "" {
+		args = append(args, "--env-file", envFile)
+	}
 
 	// Add volumes
 	if volumes, ok := config["volumes"].([]interface{}); ok {
@@ -415,7 +780,7 @@ func executeDockerRun(config map[string]interface{}, step *models.JobStep) error
 	}
 
 	// Add image name (must come after all options)
-	args = append(args, image)
+	args = append(args, runImage)
 
 	// Add command if specified (must come after image)
 	if cmd, ok := config["cmd"].(string); ok {
@@ -428,32 +793,93 @@ func executeDockerRun(config map[string]interface{}, step *models.JobStep) error
 		}
 	}
 
-	logToStep(step.ID, fmt.Sprintf("Executing: docker %s", strings.Join(args, " ")))
+	return args
+}
 
-	cmd := exec.Command("docker", args...)
-	output, err := cmd.CombinedOutput()
+// setStepOutputs merges outputs into the step and persists them
+func setStepOutputs(step *models.JobStep, outputs map[string]string) {
+	if step.Outputs == nil {
+		step.Outputs = make(map[string]string)
+	}
+	for key, value := range outputs {
+		step.Outputs[key] = value
+	}
+	if err := database.SetJobStepOutputs(step.ID, step.Outputs); err != nil {
+		logToStep(step.ID, fmt.Sprintf("WARNING: Failed to save step outputs: %v", err))
+	}
+}
 
-	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker run output:\n%s", string(output)))
+// recordImageOutputs resolves the image ID and digest of a pulled image and stores them as step outputs
+func recordImageOutputs(image string, step *models.JobStep) {
+	images, err := response_util.InspectImages(context.Background(), image)
+	if err != nil || len(images) == 0 {
+		logToStep(step.ID, fmt.Sprintf("WARNING: Could not resolve image digest: %v", err))
+		return
 	}
 
-	if err != nil {
-		logToStep(step.ID, fmt.Sprintf("Docker run failed: %v", err))
-		return fmt.Errorf("docker run failed: %w", err)
+	digest := response_util.DigestForImage(image, images[0])
+	outputs := map[string]string{
+		"image":    image,
+		"image_id": images[0].ID,
+		"digest":   digest,
+	}
+	if digest != "" {
+		outputs["pinned_image"] = response_util.ImageRepository(image) + "@" + digest
+	}
+	setStepOutputs(step, outputs)
+	logToStep(step.ID, fmt.Sprintf("Image %s resolved to %s (id: %s)", image, digest, images[0].ID))
+}
+
+// recordContainerDeployment stores the container ID, image digest and labels of a started container
+// as step outputs and records the deployment for the deployments view
+func recordContainerDeployment(image string, containerID string, step *models.JobStep, job *models.Job) {
+	containers, err := response_util.InspectContainers(context.Background(), containerID)
+	if err != nil || len(containers) == 0 {
+		logToStep(step.ID, fmt.Sprintf("WARNING: Could not inspect started container: %v", err))
+		return
 	}
+	container := containers[0]
 
-	logToStep(step.ID, "Docker container ran successfully")
-	return nil
+	digest := ""
+	if images, err := response_util.InspectImages(context.Background(), container.Image); err == nil && len(images) > 0 {
+		digest = response_util.DigestForImage(image, images[0])
+	}
+
+	labels, _ := json.Marshal(container.Config.Labels)
+	setStepOutputs(step, map[string]string{
+		"container_id":   container.ID,
+		"container_name": container.Name,
+		"image":          image,
+		"image_id":       container.Image,
+		"digest":         digest,
+		"labels":         string(labels),
+	})
+
+	deployment := &models.Deployment{
+		JobID:         job.ID,
+		StepID:        step.ID,
+		PipelineID:    job.PipelineID,
+		ContainerID:   container.ID,
+		ContainerName: container.Name,
+		Image:         image,
+		ImageID:       container.Image,
+		Digest:        digest,
+	}
+	if _, err := database.CreateDeployment(deployment); err != nil {
+		logToStep(step.ID, fmt.Sprintf("WARNING: Failed to record deployment: %v", err))
+		return
+	}
+	logToStep(step.ID, fmt.Sprintf("Deployed container %s (%s) from %s@%s", container.Name, container.ID, image, digest))
 }
 
-func executeDockerStart(container string, step *models.JobStep) error {
+func executeDockerStart(container string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Starting Docker container: %s", container))
 
 	cmd := exec.Command("docker", "start", container)
 	output, err := cmd.CombinedOutput()
 
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker start output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker start output:\n%s", string(output))))
 	}
 
 	if err != nil {
@@ -465,14 +891,14 @@ func executeDockerStart(container string, step *models.JobStep) error {
 	return nil
 }
 
-func executeDockerStop(container string, step *models.JobStep) error {
+func executeDockerStop(container string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Stopping Docker container: %s", container))
 
 	cmd := exec.Command("docker", "stop", container)
 	output, err := cmd.CombinedOutput()
 
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker stop output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker stop output:\n%s", string(output))))
 	}
 
 	if err != nil {
@@ -484,13 +910,13 @@ func executeDockerStop(container string, step *models.JobStep) error {
 	return nil
 }
 
-func executeDockerRemove(container string, step *models.JobStep) error {
+func executeDockerRemove(container string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Removing Docker container: %s", container))
 
 	cmd := exec.Command("docker", "rm", "-f", container)
 	output, err := cmd.CombinedOutput()
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker remove output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker remove output:\n%s", string(output))))
 	}
 	if err != nil {
 		logToStep(step.ID, fmt.Sprintf("Docker remove failed: %v", err))
@@ -501,13 +927,13 @@ func executeDockerRemove(container string, step *models.JobStep) error {
 	return nil
 }
 
-func executeDockerRemoveImage(image string, step *models.JobStep) error {
+func executeDockerRemoveImage(image string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Removing Docker image: %s", image))
 
 	cmd := exec.Command("docker", "rmi", "-f", image)
 	output, err := cmd.CombinedOutput()
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker remove image output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker remove image output:\n%s", string(output))))
 	}
 	if err != nil {
 		logToStep(step.ID, fmt.Sprintf("Docker remove image failed: %v", err))
@@ -518,13 +944,13 @@ func executeDockerRemoveImage(image string, step *models.JobStep) error {
 	return nil
 }
 
-func executeDockerPause(container string, step *models.JobStep) error {
+func executeDockerPause(container string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Pausing Docker container: %s", container))
 
 	cmd := exec.Command("docker", "pause", container)
 	output, err := cmd.CombinedOutput()
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker pause output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker pause output:\n%s", string(output))))
 	}
 	if err != nil {
 		logToStep(step.ID, fmt.Sprintf("Docker pause failed: %v", err))
@@ -535,13 +961,13 @@ func executeDockerPause(container string, step *models.JobStep) error {
 	return nil
 }
 
-func executeDockerUnpause(container string, step *models.JobStep) error {
+func executeDockerUnpause(container string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Unpausing Docker container: %s", container))
 
 	cmd := exec.Command("docker", "unpause", container)
 	output, err := cmd.CombinedOutput()
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker unpause output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker unpause output:\n%s", string(output))))
 	}
 	if err != nil {
 		logToStep(step.ID, fmt.Sprintf("Docker unpause failed: %v", err))
@@ -552,13 +978,13 @@ func executeDockerUnpause(container string, step *models.JobStep) error {
 	return nil
 }
 
-func executeDockerInspect(container string, step *models.JobStep) error {
+func executeDockerInspect(container string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Inspecting Docker container: %s", container))
 
 	cmd := exec.Command("docker", "inspect", container)
 	output, err := cmd.CombinedOutput()
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker inspect output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker inspect output:\n%s", string(output))))
 	}
 	if err != nil {
 		logToStep(step.ID, fmt.Sprintf("Docker inspect failed: %v", err))
@@ -569,13 +995,13 @@ func executeDockerInspect(container string, step *models.JobStep) error {
 	return nil
 }
 
-func executeDockerLogs(container string, step *models.JobStep) error {
+func executeDockerLogs(container string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Getting Docker container logs: %s", container))
 
 	cmd := exec.Command("docker", "logs", container)
 	output, err := cmd.CombinedOutput()
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker logs output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker logs output:\n%s", string(output))))
 	}
 	if err != nil {
 		logToStep(step.ID, fmt.Sprintf("Docker logs failed: %v", err))
@@ -586,13 +1012,26 @@ func executeDockerLogs(container string, step *models.JobStep) error {
 	return nil
 }
 
-func executeDockerExec(container string, command string, args []string, step *models.JobStep) error {
+func executeDockerExec(container string, command string, args []string, step *models.JobStep, job *models.Job) error {
 	logToStep(step.ID, fmt.Sprintf("Executing command in Docker container: %s", container))
 
-	cmd := exec.Command("docker", "exec", container, command)
+	execArgs, env := dockerExecArgs(job, container, command, args)
+	if len(env) > 0 {
+		// Arguments with secrets go through a temporary env file so they do not show up in the host's process list
+		envFile, err := writeEnvFile(env)
+		if err != nil {
+			logToStep(step.ID, maskSecrets(job, fmt.Sprintf("ERROR: %v", err)))
+			return err
+		}
+		defer os.Remove(envFile)
+		execArgs = append([]string{"exec", "--env-file", envFile}, execArgs[1:]...)
+		logToStep(step.ID, "The command contains secrets, it is passed to the container's sh through the environment")
+	}
+
+	cmd := exec.Command("docker", execArgs...)
 	output, err := cmd.CombinedOutput()
 	if len(output) > 0 {
-		logToStep(step.ID, fmt.Sprintf("Docker exec output:\n%s", string(output)))
+		logToStep(step.ID, maskSecrets(job, fmt.Sprintf("Docker exec output:\n%s", string(output))))
 	}
 	if err != nil {
 		logToStep(step.ID, fmt.Sprintf("Docker exec failed: %v", err))
@@ -603,10 +1042,22 @@ func executeDockerExec(container string, command string, args []string, step *mo
 	return nil
 }
 
-func executeShellCommand(command string, args ...string) (string, error) {
-	cmd := exec.Command(command, args...)
-	output, err := cmd.CombinedOutput()
-	return string(output), err
+// dockerExecArgs builds the docker exec arguments. When the command or its arguments contain a secret of
+// the job, they are moved into env entries GOLI_EXEC_0, GOLI_EXEC_1, ... that the caller passes with
+// --env-file, and the container's sh runs them: exec "$GOLI_EXEC_0" "$GOLI_EXEC_1" ...
+func dockerExecArgs(job *models.Job, container, command string, args []string) ([]string, []string) {
+	argv := append([]string{command}, args...)
+	if maskSecrets(job, strings.Join(argv, "\x00")) == strings.Join(argv, "\x00") {
+		return append([]string{"exec", container}, argv...), nil
+	}
+
+	env := make([]string, len(argv))
+	refs := make([]string, len(argv))
+	for i, arg := range argv {
+		env[i] = fmt.Sprintf("GOLI_EXEC_%d=%s", i, arg)
+		refs[i] = fmt.Sprintf(`"$GOLI_EXEC_%d"`, i)
+	}
+	return []string{"exec", container, "sh", "-c", "exec " + strings.Join(refs, " ")}, env
 }
 
 // Errors
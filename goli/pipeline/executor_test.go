@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"goli/database"
+	"goli/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnknownStepTypeFails(t *testing.T) {
+	openTestDatabase(t)
+	workspaceRootOverride = t.TempDir()
+	t.Cleanup(func() { workspaceRootOverride = "" })
+
+	// Definitions are validated when they are saved, this one was not
+	def := &models.PipelineDefinition{Name: "typo", Steps: []models.PipelineStep{{
+		Name:      "deploy",
+		Type:      "dokcer",
+		Action:    "run",
+		Retry:     3,
+		OnFailure: "stop",
+		Config:    map[string]interface{}{"image": "nginx"},
+	}}}
+	job, err := database.CreateJob(&models.Job{Name: "typo", Status: models.JobStatusRunning})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ExecutePipeline(job, def); err != ErrUnsupportedAction {
+		t.Fatalf("ExecutePipeline = %v, want %v", err, ErrUnsupportedAction)
+	}
+	_, steps := jobState(t, job.ID)
+	step := steps["deploy"]
+	if step.Status != models.JobStatusFailed {
+		t.Errorf("step status = %s, want %s", step.Status, models.JobStatusFailed)
+	}
+	if !strings.Contains(step.Logs, "Unknown step type 'dokcer'") || strings.Contains(step.Logs, "docker") {
+		t.Errorf("step logs = %s", step.Logs)
+	}
+	if strings.Contains(step.Logs, "Retrying") {
+		t.Errorf("unsupported step was retried: %s", step.Logs)
+	}
+}
+
+// fakeDocker puts a docker script first on PATH that prints its arguments and the env file it gets
+func fakeDocker(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "argv: $*"
+while [ $# -gt 0 ]; do
+  if [ "$1" = "--env-file" ]; then echo "env:"; cat "$2"; fi
+  shift
+done
+`
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestDockerExecKeepsSecretsOutOfArgv(t *testing.T) {
+	openTestDatabase(t)
+	fakeDocker(t)
+
+	job, err := database.CreateJob(&models.Job{Name: "exec", Status: models.JobStatusRunning, Secrets: []string{"s3cret-token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Secrets = []string{"s3cret-token"}
+	step := &models.JobStep{JobID: job.ID, StepName: "exec", Status: models.JobStatusRunning}
+	if err := database.CreateJobStep(step); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := executeDockerExec("app", "curl", []string{"-H", "Authorization: Bearer s3cret-token", "https://api.example.com"}, step, job); err != nil {
+		t.Fatal(err)
+	}
+	_, steps := jobState(t, job.ID)
+	logs := steps["exec"].Logs
+	if strings.Contains(logs, "s3cret-token") {
+		t.Errorf("secret in the step log:\n%s", logs)
+	}
+	if !strings.Contains(logs, `argv: exec --env-file`) || !strings.Contains(logs, `app sh -c exec "$GOLI_EXEC_0" "$GOLI_EXEC_1" "$GOLI_EXEC_2" "$GOLI_EXEC_3"`) {
+		t.Errorf("secret arguments not moved to the env file:\n%s", logs)
+	}
+	if !strings.Contains(logs, "GOLI_EXEC_2=Authorization: Bearer ***MASKED***") {
+		t.Errorf("output of docker not masked:\n%s", logs)
+	}
+
+	// Commands without secrets run as they are
+	args, env := dockerExecArgs(job, "app", "ls", []string{"-la"})
+	if strings.Join(args, " ") != "exec app ls -la" || env != nil {
+		t.Errorf("dockerExecArgs = %q, %q", args, env)
+	}
+}
@@ -0,0 +1,324 @@
+package pipeline
+
+import (
+	"fmt"
+	"goli/models"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultDefinitionPath is the pipeline definition file of repository-backed pipelines
+const DefaultDefinitionPath = ".goli.yml"
+
+// Pipeline variables used as credentials when loading a definition from a repository
+const (
+	gitUsernameVariable = "GIT_USERNAME"
+	gitPasswordVariable = "GIT_PASSWORD"
+	gitSSHKeyVariable   = "GIT_SSH_KEY"
+)
+
+// defaultGitUsername is sent with a password or token when no username is configured
+const defaultGitUsername = "git"
+
+// gitAskPassScript answers git's credential prompts from the environment, so credentials never end up in URLs or logs
+const gitAskPassScript = `#!/bin/sh
+case "$1" in
+Username*) printf '%s\n' "$GOLI_GIT_USERNAME" ;;
+*) printf '%s\n' "$GOLI_GIT_PASSWORD" ;;
+esac
+`
+
+// GitCredentials authenticate against a repository, over HTTPS with a username and password/token or over SSH with a private key
+type GitCredentials struct {
+	Username string
+	Password string
+	SSHKey   string
+}
+
+// GitCredentialsFromVariables reads GIT_USERNAME, GIT_PASSWORD and GIT_SSH_KEY from pipeline variables
+func GitCredentialsFromVariables(variables map[string]interface{}) GitCredentials {
+	return GitCredentials{
+		Username: toString(variables[gitUsernameVariable]),
+		Password: toString(variables[gitPasswordVariable]),
+		SSHKey:   toString(variables[gitSSHKeyVariable]),
+	}
+}
+
+// gitSession runs git commands with the credentials of a repository
+type gitSession struct {
+	env     []string
+	tempDir string
+	secrets []string
+}
+
+// newGitSession prepares the askpass helper and SSH key for the credentials
+func newGitSession(creds GitCredentials) (*gitSession, error) {
+	session := &gitSession{env: append(os.Environ(), "GIT_TERMINAL_PROMPT=0")}
+	if creds.Password == "" && creds.SSHKey == "" {
+		return session, nil
+	}
+
+	tempDir, err := os.MkdirTemp("", "goli-git-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create git credentials directory: %w", err)
+	}
+	session.tempDir = tempDir
+
+	if creds.Password != "" {
+		askPass := filepath.Join(tempDir, "askpass.sh")
+		if err := os.WriteFile(askPass, []byte(gitAskPassScript), 0700); err != nil {
+			session.close()
+			return nil, fmt.Errorf("failed to write git askpass helper: %w", err)
+		}
+		username := creds.Username
+		if username == "" {
+			username = defaultGitUsername
+		}
+		session.env = append(session.env, "GIT_ASKPASS="+askPass, "GOLI_GIT_USERNAME="+username, "GOLI_GIT_PASSWORD="+creds.Password)
+		session.secrets = append(session.secrets, creds.Password)
+	}
+
+	if creds.SSHKey != "" {
+		keyFile := filepath.Join(tempDir, "id_key")
+		key := strings.TrimSpace(creds.SSHKey) + "\n"
+		if err := os.WriteFile(keyFile, []byte(key), 0600); err != nil {
+			session.close()
+			return nil, fmt.Errorf("failed to write git SSH key: %w", err)
+		}
+		session.env = append(session.env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new", keyFile))
+	}
+
+	return session, nil
+}
+
+// close removes the credential files
+func (s *gitSession) close() {
+	if s.tempDir != "" {
+		os.RemoveAll(s.tempDir)
+	}
+}
+
+// run runs a git command in dir and returns its combined output with credentials masked
+func (s *gitSession) run(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = s.env
+	output, err := cmd.CombinedOutput()
+
+	out := strings.TrimSpace(string(output))
+	for _, secret := range s.secrets {
+		out = strings.ReplaceAll(out, secret, "***MASKED***")
+	}
+	if err != nil {
+		if out != "" {
+			return out, fmt.Errorf("git %s failed: %w: %s", args[0], err, out)
+		}
+		return out, fmt.Errorf("git %s failed: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// checkout clones or fetches repository into dir and checks out ref (a branch, tag or commit, empty for the default branch)
+// A depth > 0 makes a shallow fetch. logf receives the progress. Returns the checked out commit.
+func (s *gitSession) checkout(dir, repository, ref string, depth int, logf func(string)) (string, error) {
+	if err := checkGitArgument("repository", repository); err != nil {
+		return "", err
+	}
+	if err := checkGitArgument("ref", ref); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create checkout directory: %w", err)
+	}
+
+	existing := false
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		existing = true
+	}
+
+	if existing {
+		logf(fmt.Sprintf("Updating existing checkout in %s", dir))
+		if _, err := s.run(dir, "remote", "set-url", "--", "origin", repository); err != nil {
+			return "", err
+		}
+	} else {
+		logf(fmt.Sprintf("Initializing checkout in %s", dir))
+		if _, err := s.run(dir, "init", "--quiet"); err != nil {
+			return "", err
+		}
+		if _, err := s.run(dir, "remote", "add", "--", "origin", repository); err != nil {
+			return "", err
+		}
+	}
+
+	fetchRef := ref
+	if fetchRef == "" {
+		fetchRef = "HEAD"
+	}
+	fetchArgs := []string{"fetch", "--force", "--no-tags"}
+	if depth > 0 {
+		fetchArgs = append(fetchArgs, "--depth", strconv.Itoa(depth))
+	}
+
+	logf(fmt.Sprintf("Fetching %s from %s", fetchRef, displayRepository(repository)))
+	revision := "FETCH_HEAD"
+	if _, err := s.run(dir, append(fetchArgs, "--", "origin", fetchRef)...); err != nil {
+		if ref == "" {
+			return "", err
+		}
+		// Servers may refuse to fetch a commit that is not a branch or tag tip, fall back to fetching everything
+		logf(fmt.Sprintf("Fetching %s directly failed, fetching all branches and tags", ref))
+		if _, err := s.run(dir, "fetch", "--force", "--tags", "--", "origin", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+			return "", err
+		}
+		revision = ref
+	}
+
+	commit, err := s.run(dir, "rev-parse", "--verify", "--end-of-options", revision+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("ref %q not found in %s", fetchRef, displayRepository(repository))
+	}
+
+	if _, err := s.run(dir, "checkout", "--force", "--quiet", "--detach", commit); err != nil {
+		return "", err
+	}
+	if existing {
+		if _, err := s.run(dir, "clean", "-ffdxq"); err != nil {
+			return "", err
+		}
+	}
+
+	logf(fmt.Sprintf("Checked out %s at %s", fetchRef, commit))
+	return commit, nil
+}
+
+// checkGitArgument rejects repositories and refs git would read as an option, such as --upload-pack=CMD
+func checkGitArgument(name, value string) error {
+	if strings.HasPrefix(value, "-") {
+		return fmt.Errorf("invalid %s %q, it must not start with \"-\"", name, value)
+	}
+	return nil
+}
+
+// displayRepository removes a password embedded in a repository URL
+func displayRepository(repository string) string {
+	u, err := url.Parse(repository)
+	if err != nil || u.User == nil {
+		return repository
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.User(u.User.Username())
+	}
+	return u.String()
+}
+
+// LoadRepositoryDefinition reads a pipeline definition file from a repository at ref
+// Returns the definition and the commit it was read from.
+func LoadRepositoryDefinition(repository, ref, path string, creds GitCredentials) (string, string, error) {
+	if path == "" {
+		path = DefaultDefinitionPath
+	}
+	if !filepath.IsLocal(path) {
+		return "", "", fmt.Errorf("definition path %q must be relative to the repository root", path)
+	}
+
+	session, err := newGitSession(creds)
+	if err != nil {
+		return "", "", err
+	}
+	defer session.close()
+
+	dir, err := os.MkdirTemp("", "goli-definition-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create checkout directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	commit, err := session.checkout(dir, repository, ref, 1, func(string) {})
+	if err != nil {
+		return "", "", err
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, path))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", fmt.Errorf("%s not found in %s at %s", path, displayRepository(repository), commit)
+		}
+		return "", "", err
+	}
+	return string(content), commit, nil
+}
+
+// LoadPipelineDefinition returns the current definition of a repository-backed pipeline, loaded with the
+// GIT_* credentials among its variables (which must hold the real secret values)
+func LoadPipelineDefinition(record *models.Pipeline) (string, string, error) {
+	return LoadRepositoryDefinition(record.Repository, record.RepositoryRef, record.DefinitionPath, GitCredentialsFromVariables(record.Variables))
+}
+
+// executeGitStep executes a git step
+func executeGitStep(step *models.JobStep, stepDef models.PipelineStep, job *models.Job) error {
+	switch stepDef.Action {
+	case "checkout":
+		return executeGitCheckout(stepDef.Config, step, job)
+	default:
+		logToStep(step.ID, fmt.Sprintf("ERROR: Unsupported git action: %s", stepDef.Action))
+		return ErrUnsupportedAction
+	}
+}
+
+// executeGitCheckout clones or fetches a repository into the job workspace
+func executeGitCheckout(config map[string]interface{}, step *models.JobStep, job *models.Job) error {
+	repository, ok := config["repository"].(string)
+	if !ok || repository == "" {
+		logToStep(step.ID, "ERROR: Missing or invalid 'repository' configuration")
+		return ErrInvalidConfig
+	}
+	ref := toString(config["ref"])
+
+	path := toString(config["path"])
+	if path == "" {
+		path = "."
+	}
+	if !filepath.IsLocal(path) {
+		logToStep(step.ID, fmt.Sprintf("ERROR: path %q must be relative to the workspace", path))
+		return ErrInvalidConfig
+	}
+
+	depth := 0
+	if raw := toString(config["depth"]); raw != "" {
+		d, err := strconv.Atoi(raw)
+		if err != nil || d < 0 {
+			logToStep(step.ID, fmt.Sprintf("ERROR: Invalid depth: %s", raw))
+			return ErrInvalidConfig
+		}
+		depth = d
+	}
+
+	session, err := newGitSession(GitCredentials{
+		Username: toString(config["username"]),
+		Password: toString(config["password"]),
+		SSHKey:   toString(config["ssh_key"]),
+	})
+	if err != nil {
+		logToStep(step.ID, fmt.Sprintf("ERROR: %v", err))
+		return err
+	}
+	defer session.close()
+
+	dir := filepath.Join(JobWorkspace(job.ID), path)
+	commit, err := session.checkout(dir, repository, ref, depth, func(message string) {
+		logToStep(step.ID, message)
+	})
+	if err != nil {
+		logToStep(step.ID, fmt.Sprintf("Git checkout failed: %v", err))
+		return fmt.Errorf("git checkout failed: %w", err)
+	}
+
+	setStepOutputs(step, map[string]string{"commit": commit, "ref": ref, "path": dir})
+	return nil
+}
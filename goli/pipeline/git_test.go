@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitRepo creates a bare repository with a tagged commit, two more commits on main and a feature branch,
+// and returns its path and the commits by name
+func gitRepo(t *testing.T) (string, map[string]string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+	bare := filepath.Join(root, "repo.git")
+	work := filepath.Join(root, "work")
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=goli", "-c", "user.email=goli@example.com", "-c", "init.defaultBranch=main"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commit := func(file, content string) string {
+		if err := os.WriteFile(filepath.Join(work, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git(work, "add", file)
+		git(work, "commit", "-q", "-m", file+" "+content)
+		return git(work, "rev-parse", "HEAD")
+	}
+
+	git(root, "init", "-q", "--bare", bare)
+	git(root, "init", "-q", work)
+	commits := map[string]string{}
+	commits["v1"] = commit(".goli.yml", "name: v1\n")
+	git(work, "tag", "v1")
+	commits["middle"] = commit("file.txt", "middle")
+	commits["main"] = commit("file.txt", "main")
+	git(work, "checkout", "-q", "-b", "feature", commits["v1"])
+	commits["feature"] = commit("file.txt", "feature")
+	git(work, "push", "-q", bare, "main", "feature", "v1")
+	return bare, commits
+}
+
+func TestGitCheckoutFromBareRepository(t *testing.T) {
+	bare, commits := gitRepo(t)
+
+	tests := []struct {
+		name, ref, want, file string
+	}{
+		{"default branch", "", commits["main"], "main"},
+		{"branch", "feature", commits["feature"], "feature"},
+		{"tag", "v1", commits["v1"], ""},
+		{"commit", commits["middle"], commits["middle"], "middle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := newGitSession(GitCredentials{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.close()
+
+			dir := t.TempDir()
+			commit, err := session.checkout(dir, bare, tt.ref, 0, func(string) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if commit != tt.want {
+				t.Errorf("commit = %s, want %s", commit, tt.want)
+			}
+			content, _ := os.ReadFile(filepath.Join(dir, "file.txt"))
+			if string(content) != tt.file {
+				t.Errorf("file.txt = %q, want %q", content, tt.file)
+			}
+		})
+	}
+}
+
+func TestGitCheckoutUpdatesExistingCheckout(t *testing.T) {
+	bare, commits := gitRepo(t)
+	session, err := newGitSession(GitCredentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.close()
+
+	dir := t.TempDir()
+	if _, err := session.checkout(dir, bare, "main", 1, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+	commit, err := session.checkout(dir, bare, "feature", 1, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != commits["feature"] {
+		t.Errorf("commit = %s, want %s", commit, commits["feature"])
+	}
+}
+
+func TestLoadRepositoryDefinitionAtTag(t *testing.T) {
+	bare, commits := gitRepo(t)
+
+	definition, commit, err := LoadRepositoryDefinition(bare, "v1", "", GitCredentials{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition != "name: v1\n" || commit != commits["v1"] {
+		t.Errorf("got %q at %s, want the definition of v1 at %s", definition, commit, commits["v1"])
+	}
+}
+
+func TestGitCheckoutRejectsOptions(t *testing.T) {
+	bare, _ := gitRepo(t)
+	marker := filepath.Join(t.TempDir(), "pwned")
+
+	tests := []struct {
+		name, repository, ref string
+	}{
+		{"ref", bare, "--upload-pack=touch " + marker},
+		{"repository", "--upload-pack=touch " + marker, "main"},
+		{"ssh option", "-oProxyCommand=touch " + marker, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := newGitSession(GitCredentials{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer session.close()
+
+			if _, err := session.checkout(t.TempDir(), tt.repository, tt.ref, 0, func(string) {}); err == nil {
+				t.Error("checkout succeeded")
+			}
+			if _, err := os.Stat(marker); err == nil {
+				t.Fatal("git ran the injected command")
+			}
+		})
+	}
+}
+
+func TestValidateRejectsGitOptions(t *testing.T) {
+	def, err := ParsePipelineDefinition(`name: p
+steps:
+  - name: checkout
+    type: git
+    action: checkout
+    config:
+      repository: https://example.com/app.git
+      ref: --upload-pack=id
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ValidatePipelineDefinition(def)
+	if err == nil || !strings.Contains(err.Error(), "invalid ref") {
+		t.Errorf("err = %v, want an invalid ref error", err)
+	}
+}
@@ -0,0 +1,293 @@
+package pipeline
+
+import (
+	"fmt"
+	"goli/models"
+	"sort"
+	"strings"
+)
+
+// maxMatrixCombinations limits how many steps a single matrix may expand to
+const maxMatrixCombinations = 256
+
+// Reserved keys of a matrix block, all other keys are value lists
+const (
+	matrixInclude  = "include"
+	matrixExclude  = "exclude"
+	matrixParallel = "parallel"
+)
+
+// ExpandMatrixSteps replaces every step with a matrix by one step per combination of values
+// Matrix values are substituted into the step name and config as ${matrix.KEY}; expanded step names get the
+// values appended, e.g. "Deploy (host-a, 8080)", unless the name already references the matrix.
+func ExpandMatrixSteps(def *models.PipelineDefinition) error {
+	var steps []models.PipelineStep
+	for _, step := range def.Steps {
+		if len(step.Matrix) == 0 {
+			steps = append(steps, step)
+			continue
+		}
+		expanded, err := expandMatrixStep(step)
+		if err != nil {
+			return stepError(step, "invalid matrix: "+err.Error())
+		}
+		steps = append(steps, expanded...)
+	}
+
+	// Expanded names must stay unique so step references remain unambiguous
+	seen := make(map[string]bool)
+	for _, step := range steps {
+		if step.MatrixValues != nil && seen[step.Name] {
+			return stepError(step, "matrix expansion produced duplicate step name")
+		}
+		seen[step.Name] = true
+	}
+
+	def.Steps = steps
+	return nil
+}
+
+// expandMatrixStep expands a single step with a matrix
+func expandMatrixStep(step models.PipelineStep) ([]models.PipelineStep, error) {
+	combinations, keys, parallel, err := matrixCombinations(step.Matrix)
+	if err != nil {
+		return nil, err
+	}
+	if len(combinations) == 0 {
+		return nil, fmt.Errorf("matrix has no combinations")
+	}
+
+	group := ""
+	if parallel && len(combinations) > 1 {
+		group = step.Name
+	}
+
+	steps := make([]models.PipelineStep, 0, len(combinations))
+	for _, values := range combinations {
+		variables := make(map[string]interface{}, len(values))
+		for k, v := range values {
+			variables["matrix."+k] = v
+		}
+
+		expanded := step
+		expanded.Matrix = nil
+		expanded.MatrixValues = values
+		expanded.ParallelGroup = group
+		expanded.Config = copyConfig(step.Config)
+		substituteInMap(expanded.Config, variables)
+		expanded.Container = copyContainer(step.Container)
+		substituteInContainer(expanded.Container, variables)
+		expanded.Artifacts = append([]string(nil), step.Artifacts...)
+		substituteInList(expanded.Artifacts, variables)
+		expanded.Env = copyStringMap(step.Env)
+		substituteInStringMap(expanded.Env, variables)
+		expanded.Description = substituteString(step.Description, variables)
+
+		if strings.Contains(step.Name, "matrix.") {
+			expanded.Name = substituteString(step.Name, variables)
+		} else {
+			var parts []string
+			for _, k := range keys {
+				if v, ok := values[k]; ok {
+					parts = append(parts, v)
+				}
+			}
+			expanded.Name = fmt.Sprintf("%s (%s)", step.Name, strings.Join(parts, ", "))
+		}
+
+		steps = append(steps, expanded)
+	}
+	return steps, nil
+}
+
+// matrixCombinations returns the value combinations of a matrix after applying exclude and include,
+// the sorted list of all keys and whether the expansions may run in parallel
+func matrixCombinations(matrix map[string]interface{}) ([]map[string]string, []string, bool, error) {
+	var keys []string
+	lists := make(map[string][]string)
+	parallel := false
+
+	for key, raw := range matrix {
+		switch key {
+		case matrixInclude, matrixExclude:
+			continue
+		case matrixParallel:
+			p, ok := raw.(bool)
+			if !ok {
+				return nil, nil, false, fmt.Errorf("parallel must be true or false")
+			}
+			parallel = p
+			continue
+		}
+		items, ok := raw.([]interface{})
+		if !ok {
+			return nil, nil, false, fmt.Errorf("values of %q must be a list", key)
+		}
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			values = append(values, toString(item))
+		}
+		keys = append(keys, key)
+		lists[key] = values
+	}
+	sort.Strings(keys)
+
+	include, err := matrixEntries(matrix[matrixInclude], matrixInclude)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	exclude, err := matrixEntries(matrix[matrixExclude], matrixExclude)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	// Cartesian product in key order
+	var combinations []map[string]string
+	if len(keys) > 0 {
+		combinations = []map[string]string{{}}
+		for _, key := range keys {
+			var next []map[string]string
+			for _, combination := range combinations {
+				for _, value := range lists[key] {
+					c := make(map[string]string, len(combination)+1)
+					for k, v := range combination {
+						c[k] = v
+					}
+					c[key] = value
+					next = append(next, c)
+				}
+			}
+			combinations = next
+			if len(combinations) > maxMatrixCombinations {
+				return nil, nil, false, fmt.Errorf("matrix expands to more than %d steps", maxMatrixCombinations)
+			}
+		}
+	}
+
+	// Drop combinations matching any exclude entry
+	filtered := combinations[:0]
+	for _, combination := range combinations {
+		excluded := false
+		for _, entry := range exclude {
+			if matrixMatches(combination, entry) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filtered = append(filtered, combination)
+		}
+	}
+	combinations = filtered
+
+	// Include entries are added as extra combinations
+	for _, entry := range include {
+		duplicate := false
+		for _, combination := range combinations {
+			if len(combination) == len(entry) && matrixMatches(combination, entry) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		for k := range entry {
+			if _, ok := lists[k]; !ok {
+				lists[k] = nil
+				keys = append(keys, k)
+			}
+		}
+		combinations = append(combinations, entry)
+	}
+	sort.Strings(keys)
+
+	if len(combinations) > maxMatrixCombinations {
+		return nil, nil, false, fmt.Errorf("matrix expands to more than %d steps", maxMatrixCombinations)
+	}
+	return combinations, keys, parallel, nil
+}
+
+// matrixEntries converts an include/exclude list into value maps
+func matrixEntries(raw interface{}, name string) ([]map[string]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of key/value maps", name)
+	}
+	entries := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok || len(m) == 0 {
+			return nil, fmt.Errorf("%s must be a list of key/value maps", name)
+		}
+		entry := make(map[string]string, len(m))
+		for k, v := range m {
+			entry[k] = toString(v)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// matrixMatches reports whether a combination has all values of the entry
+func matrixMatches(combination, entry map[string]string) bool {
+	for k, v := range entry {
+		if combination[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// copyConfig deep copies a step config so expanded steps do not share maps or slices
+func copyConfig(config map[string]interface{}) map[string]interface{} {
+	if config == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		out[k] = copyConfigValue(v)
+	}
+	return out
+}
+
+// copyContainer copies a step container so expanded steps can be substituted independently
+func copyContainer(container *models.StepContainer) *models.StepContainer {
+	if container == nil {
+		return nil
+	}
+	out := *container
+	out.Volumes = append([]string(nil), container.Volumes...)
+	out.Env = copyStringMap(container.Env)
+	return &out
+}
+
+// copyStringMap copies a string map, nil stays nil
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func copyConfigValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyConfig(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = copyConfigValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
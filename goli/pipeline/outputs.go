@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"goli/models"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// setOutputPattern matches `::set-output name=KEY::value` lines in step output
+var setOutputPattern = regexp.MustCompile(`^::set-output name=([A-Za-z_][A-Za-z0-9_.-]*)::(.*)$`)
+
+// runCommandWithOutputs runs a step command, exposing $GOLI_OUTPUT and collecting the outputs it emits.
+// command builds the command for the path of the output file and must expose it as $GOLI_OUTPUT.
+// Outputs are read from `::set-output name=KEY::value` lines and from KEY=VALUE lines
+// (or KEY<<DELIMITER heredocs) written to the $GOLI_OUTPUT file.
+func runCommandWithOutputs(step *models.JobStep, command func(outputPath string) *exec.Cmd) ([]byte, error) {
+	outputFile, err := os.CreateTemp("", fmt.Sprintf("goli-step-%d-output-*", step.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	outputPath := outputFile.Name()
+	outputFile.Close()
+	defer os.Remove(outputPath)
+
+	// Steps running in a container may use a different user
+	if err := os.Chmod(outputPath, 0666); err != nil {
+		return nil, fmt.Errorf("failed to prepare output file: %w", err)
+	}
+
+	output, runErr := command(outputPath).CombinedOutput()
+
+	outputs := parseSetOutputLines(string(output))
+	if fileOutputs, err := parseOutputFile(outputPath); err != nil {
+		logToStep(step.ID, fmt.Sprintf("WARNING: Failed to read $GOLI_OUTPUT: %v", err))
+	} else {
+		for key, value := range fileOutputs {
+			outputs[key] = value
+		}
+	}
+
+	if len(outputs) > 0 {
+		keys := make([]string, 0, len(outputs))
+		for key := range outputs {
+			keys = append(keys, key)
+		}
+		logToStep(step.ID, fmt.Sprintf("Step outputs set: %s", strings.Join(keys, ", ")))
+		setStepOutputs(step, outputs)
+	}
+
+	return output, runErr
+}
+
+// parseSetOutputLines extracts `::set-output name=KEY::value` commands from command output
+func parseSetOutputLines(output string) map[string]string {
+	outputs := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if m := setOutputPattern.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			outputs[m[1]] = m[2]
+		}
+	}
+	return outputs
+}
+
+// parseOutputFile reads KEY=VALUE lines and KEY<<DELIMITER multi-line values from the $GOLI_OUTPUT file
+func parseOutputFile(path string) (map[string]string, error) {
+	outputs := make(map[string]string)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return outputs, err
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if idx := strings.Index(line, "<<"); idx > 0 && !strings.Contains(line[:idx], "=") {
+			key := strings.TrimSpace(line[:idx])
+			delimiter := strings.TrimSpace(line[idx+2:])
+			var value []string
+			for i++; i < len(lines) && lines[i] != delimiter; i++ {
+				value = append(value, lines[i])
+			}
+			outputs[key] = strings.Join(value, "\n")
+			continue
+		}
+		if idx := strings.Index(line, "="); idx > 0 {
+			outputs[strings.TrimSpace(line[:idx])] = line[idx+1:]
+		}
+	}
+	return outputs, nil
+}
+
+// stepContextVariables exposes finished steps to later steps as
+// steps.<name>.status and steps.<name>.outputs.<KEY>
+func stepContextVariables(steps []*models.JobStep) map[string]interface{} {
+	variables := make(map[string]interface{})
+	for _, step := range steps {
+		prefix := "steps." + step.StepName
+		variables[prefix+".status"] = string(step.Status)
+		for key, value := range step.Outputs {
+			variables[prefix+".outputs."+key] = value
+		}
+	}
+	return variables
+}
@@ -5,44 +5,27 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-
-	"gopkg.in/yaml.v3"
 )
 
 // ParsePipelineDefinition parses a YAML pipeline definition
+// Includes, step templates (uses) and matrices are expanded, so the result only contains concrete steps
 func ParsePipelineDefinition(yamlContent string) (*models.PipelineDefinition, error) {
-	var def models.PipelineDefinition
+	loader := newDefinitionLoader()
 
-	if err := yaml.Unmarshal([]byte(yamlContent), &def); err != nil {
+	def, err := loader.load(yamlContent, rootSource, 0)
+	if err != nil {
 		return nil, err
 	}
 
-	return &def, nil
-}
-
-// ValidatePipelineDefinition validates a pipeline definition
-func ValidatePipelineDefinition(def *models.PipelineDefinition) error {
-	if def.Name == "" {
-		return &PipelineError{Message: "Pipeline name is required"}
-	}
-
-	if len(def.Steps) == 0 {
-		return &PipelineError{Message: "Pipeline must have at least one step"}
+	if def.Steps, err = loader.expandTemplates(def, def.Steps, 0); err != nil {
+		return nil, err
 	}
 
-	for i, step := range def.Steps {
-		if step.Name == "" {
-			return &PipelineError{Message: "Step name is required for step " + strconv.Itoa(i+1)}
-		}
-		if step.Type == "" {
-			return &PipelineError{Message: "Step type is required for step " + step.Name}
-		}
-		if step.Action == "" {
-			return &PipelineError{Message: "Step action is required for step " + step.Name}
-		}
+	if err := ExpandMatrixSteps(def); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return def, nil
 }
 
 // SubstituteVariables substitutes variables in a pipeline definition
@@ -51,7 +34,59 @@ func SubstituteVariables(def *models.PipelineDefinition, variables map[string]in
 	// Substitute in step configs
 	for i := range def.Steps {
 		substituteInMap(def.Steps[i].Config, variables)
+		substituteInContainer(def.Steps[i].Container, variables)
+		substituteInList(def.Steps[i].Artifacts, variables)
+		substituteInStringMap(def.Steps[i].Env, variables)
+	}
+}
+
+// ResolveVariables merges the variables visible to a run
+// Precedence (lowest first): variables in the YAML definition, stored pipeline variables and secrets.
+// Run parameters are not variables: they are never substituted into step configs and only conditions
+// can read them, as parameters.NAME
+func ResolveVariables(def *models.PipelineDefinition, stored map[string]interface{}) map[string]interface{} {
+	variables := make(map[string]interface{}, len(def.Variables)+len(stored))
+	for k, v := range def.Variables {
+		variables[k] = v
+	}
+	for k, v := range stored {
+		variables[k] = v
+	}
+	return variables
+}
+
+// SubstituteStepVariables substitutes variables in a single step's config
+// Used at execution time to resolve references to earlier steps like ${steps.build.outputs.VERSION}
+func SubstituteStepVariables(step *models.PipelineStep, variables map[string]interface{}) {
+	substituteInMap(step.Config, variables)
+	substituteInContainer(step.Container, variables)
+	substituteInList(step.Artifacts, variables)
+	substituteInStringMap(step.Env, variables)
+}
+
+// substituteInStringMap substitutes variables in the values of a string map
+func substituteInStringMap(m map[string]string, variables map[string]interface{}) {
+	for k, v := range m {
+		m[k] = substituteString(v, variables)
+	}
+}
+
+// substituteInList substitutes variables in a list of strings
+func substituteInList(list []string, variables map[string]interface{}) {
+	for i, item := range list {
+		list[i] = substituteString(item, variables)
+	}
+}
+
+// substituteInContainer substitutes variables in the container settings of a step
+func substituteInContainer(container *models.StepContainer, variables map[string]interface{}) {
+	if container == nil {
+		return
 	}
+	container.Image = substituteString(container.Image, variables)
+	container.User = substituteString(container.User, variables)
+	substituteInList(container.Volumes, variables)
+	substituteInStringMap(container.Env, variables)
 }
 
 // substituteInMap recursively substitutes variables in a map
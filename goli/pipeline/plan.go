@@ -0,0 +1,258 @@
+package pipeline
+
+import (
+	"fmt"
+	"goli/models"
+	"strings"
+)
+
+// Plan statuses of a step
+const (
+	PlanRun     = "run"     // the step would run
+	PlanSkip    = "skip"    // the if condition is false
+	PlanUnknown = "unknown" // the if condition depends on outputs only known at run time
+)
+
+// Plan is the result of a dry run: the resolved steps in execution order, nothing is executed
+type Plan struct {
+	Pipeline  string                 `json:"pipeline"`
+	Variables map[string]interface{} `json:"variables,omitempty"` // Secrets are masked
+	Steps     []PlannedStep          `json:"steps"`
+}
+
+// PlannedStep is a fully resolved step with the command it would run
+type PlannedStep struct {
+	Order         int                    `json:"order"`
+	Name          string                 `json:"name"`
+	Type          string                 `json:"type"`
+	Action        string                 `json:"action"`
+	Status        string                 `json:"status"`
+	If            string                 `json:"if,omitempty"`
+	Reason        string                 `json:"reason,omitempty"`
+	Command       []string               `json:"command,omitempty"` // argv, e.g. ["docker", "run", "--detach", ...]
+	Script        string                 `json:"script,omitempty"`
+	Config        map[string]interface{} `json:"config,omitempty"`
+	Env           map[string]string      `json:"env,omitempty"` // Step environment including the GOLI_* variables
+	OnFailure     string                 `json:"on_failure,omitempty"`
+	Retry         int                    `json:"retry,omitempty"`
+	ParallelGroup string                 `json:"parallel_group,omitempty"`
+	MatrixValues  map[string]string      `json:"matrix_values,omitempty"`
+	Notes         []string               `json:"notes,omitempty"`
+	Source        *models.SourcePosition `json:"source,omitempty"`
+}
+
+// PlanPipeline resolves a parsed and substituted definition into the commands a run would execute
+// pipelineDef.Variables must hold the resolved variables used to evaluate conditions. Earlier steps are
+// assumed to succeed; their outputs are unknown, so references to them stay as placeholders.
+func PlanPipeline(job *models.Job, pipelineDef *models.PipelineDefinition) *Plan {
+	plan := &Plan{Pipeline: pipelineDef.Name, Steps: []PlannedStep{}}
+
+	var executedSteps []*models.JobStep
+	for i, stepDef := range pipelineDef.Steps {
+		planned := PlannedStep{
+			Order:         i + 1,
+			Name:          stepDef.Name,
+			Type:          stepDef.Type,
+			Action:        stepDef.Action,
+			Status:        PlanRun,
+			If:            stepDef.If,
+			OnFailure:     stepDef.OnFailure,
+			Retry:         stepDef.Retry,
+			ParallelGroup: stepDef.ParallelGroup,
+			MatrixValues:  stepDef.MatrixValues,
+			Source:        stepDef.Source,
+		}
+
+		// Built-in variables that depend on the job are shown as placeholders
+		builtins := map[string]interface{}{
+			"GOLI_JOB_ID":    "<job id>",
+			"GOLI_PIPELINE":  pipelineDef.Name,
+			"GOLI_STEP":      stepDef.Name,
+			"GOLI_WORKSPACE": "<workspace>",
+		}
+		variables := stepContextVariables(executedSteps)
+		for k, v := range builtins {
+			variables[k] = v
+		}
+		stepDef.Config = copyConfig(stepDef.Config)
+		stepDef.Container = copyContainer(stepDef.Container)
+		stepDef.Artifacts = append([]string(nil), stepDef.Artifacts...)
+		stepDef.Env = copyStringMap(stepDef.Env)
+		SubstituteStepVariables(&stepDef, variables)
+		if stepDef.Env == nil {
+			stepDef.Env = make(map[string]string, len(builtins))
+		}
+		for k, v := range builtins {
+			stepDef.Env[k] = toString(v)
+		}
+		planned.Config = stepDef.Config
+		planned.Env = stepDef.Env
+
+		if stepDef.If != "" {
+			planned.Status, planned.Reason = planCondition(job, pipelineDef, stepDef, executedSteps)
+		}
+
+		planned.Command, planned.Script, planned.Notes = plannedCommand(job, stepDef)
+		if len(stepDef.Artifacts) > 0 {
+			planned.Notes = append(planned.Notes, "archives artifacts matching "+strings.Join(stepDef.Artifacts, ", "))
+		}
+
+		status := models.JobStatusCompleted
+		if planned.Status == PlanSkip {
+			status = models.JobStatusSkipped
+		}
+		executedSteps = append(executedSteps, &models.JobStep{StepName: stepDef.Name, Status: status})
+		plan.Steps = append(plan.Steps, planned)
+	}
+	return plan
+}
+
+// planCondition evaluates the if condition of a step for the plan
+func planCondition(job *models.Job, pipelineDef *models.PipelineDefinition, stepDef models.PipelineStep, executedSteps []*models.JobStep) (string, string) {
+	for _, name := range conditionIdentifiers(stepDef.If) {
+		if strings.HasPrefix(name, "steps.") && strings.Contains(name, ".outputs.") {
+			return PlanUnknown, "condition depends on " + name + ", only known at run time"
+		}
+	}
+
+	run, err := EvaluateCondition(stepDef.If, conditionContext(job, pipelineDef, stepDef, executedSteps))
+	if err != nil {
+		return PlanUnknown, "invalid if condition: " + err.Error()
+	}
+	if !run {
+		return PlanSkip, "condition is false"
+	}
+	return PlanRun, "condition is true"
+}
+
+// plannedCommand returns the command a step would run, mirroring the executor
+func plannedCommand(job *models.Job, stepDef models.PipelineStep) ([]string, string, []string) {
+	config := stepDef.Config
+	str := func(key string) string {
+		value, _ := config[key].(string)
+		return value
+	}
+	var notes []string
+
+	switch stepDef.Type {
+	case "docker":
+		switch stepDef.Action {
+		case "pull", "push":
+			return []string{"docker", stepDef.Action, str("image")}, "", []string{"logs in to the image registry first if credentials are stored"}
+		case "rmi":
+			return []string{"docker", "rmi", "-f", str("image")}, "", nil
+		case "rm":
+			return []string{"docker", "rm", "-f", str("container")}, "", nil
+		case "start", "stop", "pause", "unpause", "inspect", "logs":
+			return []string{"docker", stepDef.Action, str("container")}, "", nil
+		case "exec":
+			argv := []string{"docker", "exec", str("container"), str("command")}
+			if args, ok := config["args"].([]interface{}); ok {
+				for _, arg := range args {
+					if s, ok := arg.(string); ok {
+						argv = append(argv, s)
+					}
+				}
+			}
+			return argv, "", nil
+		case "run":
+			image := str("image")
+			if pin, ok := config["pin_digest"].(bool); ok && pin {
+				notes = append(notes, "pin_digest: the image is replaced by its local digest at run time")
+			}
+			labels := []string{"goli.job_id=<job id>", "goli.step=" + stepDef.Name}
+			if job.PipelineID != nil {
+				labels = append(labels, fmt.Sprintf("goli.pipeline_id=%d", *job.PipelineID))
+			}
+			envFile := ""
+			if entries := dockerRunEnv(config, stepDef.Env); len(entries) > 0 {
+				envFile = "<env file>"
+				notes = append(notes, "env file: "+strings.Join(envNames(entries), ", "))
+			}
+			return append([]string{"docker"}, dockerRunArgs(config, image, labels, envFile)...), "", notes
+		}
+	case "shell":
+		argv := []string{str("command")}
+		if args, ok := config["args"].([]interface{}); ok {
+			for _, arg := range args {
+				if s, ok := arg.(string); ok {
+					argv = append(argv, s)
+				}
+			}
+		}
+		return plannedStepCommand(stepDef, argv), "", nil
+	case "git":
+		if stepDef.Action == "checkout" {
+			ref := str("ref")
+			if ref == "" {
+				ref = "HEAD"
+			}
+			argv := []string{"git", "fetch", "--force", "--no-tags"}
+			if depth := toString(config["depth"]); depth != "" && depth != "0" {
+				argv = append(argv, "--depth", depth)
+			}
+			path := str("path")
+			if path == "" {
+				path = "."
+			}
+			notes = append(notes, fmt.Sprintf("checks out %s into the job workspace at %s", displayRepository(str("repository")), path))
+			return append(argv, "origin", ref), "", notes
+		}
+	case "artifacts":
+		if stepDef.Action == "fetch" {
+			source := "the latest completed job"
+			if job := toString(config["job"]); job != "" {
+				source = "job " + job
+			}
+			path := str("path")
+			if path == "" {
+				path = "."
+			}
+			return nil, "", []string{fmt.Sprintf("copies the artifacts of %s of pipeline %s into the job workspace at %s", source, toString(config["pipeline"]), path)}
+		}
+	case "approval":
+		if stepDef.Action == "request" {
+			approval := &models.JobApproval{
+				Approvers: configStrings(config["approvers"]),
+				Roles:     configStrings(config["roles"]),
+				AllowSelf: configBool(config["allow_self_approval"]),
+			}
+			timeout, err := approvalTimeout(config["timeout"])
+			if err != nil {
+				return nil, "", []string{err.Error()}
+			}
+			notes = append(notes, fmt.Sprintf("pauses the job for approval by %s, fails it if nobody decides within %s", describeApprovers(approval), timeout))
+			if message := str("message"); message != "" {
+				notes = append(notes, "message: "+maskSecrets(job, message))
+			}
+			return nil, "", notes
+		}
+	case "script":
+		shell := "sh"
+		if s := str("shell"); s != "" {
+			shell = s
+		}
+		return plannedStepCommand(stepDef, []string{shell, "-c", "<script>"}), str("script"), nil
+	}
+
+	return nil, "", []string{fmt.Sprintf("unsupported step %s %s", stepDef.Type, stepDef.Action)}
+}
+
+// plannedStepCommand wraps the command of a script or shell step in docker run when it runs in a container
+func plannedStepCommand(stepDef models.PipelineStep, argv []string) []string {
+	if stepDef.Container == nil {
+		return argv
+	}
+	args := containerRunArgs("goli-job-<job id>-step-<step id>", stepDef.Container, "<workspace>", "<output file>", "<env file>", argv)
+	return append([]string{"docker"}, args...)
+}
+
+// envNames returns the variable names of env file entries
+func envNames(entries []string) []string {
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name, _, _ := strings.Cut(entry, "=")
+		names = append(names, name)
+	}
+	return names
+}
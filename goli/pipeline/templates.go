@@ -0,0 +1,448 @@
+package pipeline
+
+import (
+	"fmt"
+	aux "goli/auxiliary"
+	"goli/database"
+	"goli/models"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultTemplatesDir holds template files when constants.pipeline_templates_dir is not configured
+const defaultTemplatesDir = "/goli/templates"
+
+// maxIncludeDepth limits nested includes and template uses
+const maxIncludeDepth = 10
+
+// rootSource names the definition being parsed in error messages
+const rootSource = "pipeline"
+
+// inputPattern matches ${inputs.NAME} and {{inputs.NAME}} references
+var inputPattern = regexp.MustCompile(`\$\{\s*inputs\.([^}\s]+)\s*\}|\{\{\s*inputs\.([^}\s]+)\s*\}\}`)
+
+// TemplatesDir returns the directory containing pipeline template files
+func TemplatesDir() string {
+	if _, err := os.Stat(aux.GetConfigPath()); err == nil {
+		if dir := aux.GetFromConfig("constants.pipeline_templates_dir"); dir != "" {
+			return dir
+		}
+	}
+	return defaultTemplatesDir
+}
+
+// definitionLoader resolves includes and step templates of a pipeline definition
+type definitionLoader struct {
+	loading   map[string]bool // sources on the current include chain, to detect cycles
+	fileCache map[string]*models.StepTemplate
+}
+
+func newDefinitionLoader() *definitionLoader {
+	return &definitionLoader{
+		loading:   make(map[string]bool),
+		fileCache: make(map[string]*models.StepTemplate),
+	}
+}
+
+// load parses a definition and recursively resolves its includes
+// Steps of included definitions are prepended, their variables and templates apply unless redefined
+func (l *definitionLoader) load(content, source string, depth int) (*models.PipelineDefinition, error) {
+	if depth > maxIncludeDepth {
+		return nil, ValidationErrors{{File: source, Message: "includes are nested more than " + strconv.Itoa(maxIncludeDepth) + " levels deep"}}
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &root); err != nil {
+		return nil, sourceError(source, err)
+	}
+
+	var def models.PipelineDefinition
+	if err := root.Decode(&def); err != nil {
+		return nil, sourceError(source, err)
+	}
+	annotateSources(&root, &def, source)
+	def.KeyPositions = keyPositions(documentRoot(&root), source, "")
+
+	if len(def.Include) == 0 {
+		return &def, nil
+	}
+
+	l.loading[source] = true
+	defer delete(l.loading, source)
+
+	includePositions := sequencePositions(mappingValue(documentRoot(&root), "include"), source)
+
+	var includedSteps []models.PipelineStep
+	for i, include := range def.Include {
+		pos := positionAt(includePositions, i, source)
+
+		var includedContent, includedSource string
+		var err error
+		switch {
+		case include.Pipeline != "" && include.File != "":
+			return nil, ValidationErrors{positionError(pos, "", "include must set either pipeline or file, not both")}
+		case include.Pipeline != "":
+			includedContent, includedSource, err = loadStoredPipeline(include.Pipeline)
+		case include.File != "":
+			var path string
+			path, includedSource, err = resolveTemplateFile(include.File)
+			if err == nil {
+				var data []byte
+				data, err = os.ReadFile(path)
+				includedContent = string(data)
+			}
+		default:
+			return nil, ValidationErrors{positionError(pos, "", "include must set pipeline or file")}
+		}
+		if err != nil {
+			return nil, ValidationErrors{positionError(pos, "", err.Error())}
+		}
+		if l.loading[includedSource] {
+			return nil, ValidationErrors{positionError(pos, "", "include cycle through "+includedSource)}
+		}
+
+		included, err := l.load(includedContent, includedSource, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		includedSteps = append(includedSteps, included.Steps...)
+		for name, tmpl := range included.Templates {
+			if def.Templates == nil {
+				def.Templates = make(map[string]models.StepTemplate)
+			}
+			if _, exists := def.Templates[name]; !exists {
+				def.Templates[name] = tmpl
+			}
+		}
+		for name, value := range included.Variables {
+			if def.Variables == nil {
+				def.Variables = make(map[string]interface{})
+			}
+			if _, exists := def.Variables[name]; !exists {
+				def.Variables[name] = value
+			}
+		}
+	}
+
+	def.Steps = append(includedSteps, def.Steps...)
+	def.Include = nil
+	return &def, nil
+}
+
+// expandTemplates replaces steps with uses by the steps of the referenced template
+func (l *definitionLoader) expandTemplates(def *models.PipelineDefinition, steps []models.PipelineStep, depth int) ([]models.PipelineStep, error) {
+	var expanded []models.PipelineStep
+	for _, step := range steps {
+		if step.Uses == "" {
+			if len(step.With) > 0 {
+				return nil, stepError(step, "with requires uses")
+			}
+			expanded = append(expanded, step)
+			continue
+		}
+
+		if depth >= maxIncludeDepth {
+			return nil, stepError(step, "templates are nested more than "+strconv.Itoa(maxIncludeDepth)+" levels deep")
+		}
+		if step.Type != "" || step.Action != "" || len(step.Config) > 0 || step.Container != nil {
+			return nil, stepError(step, "uses cannot be combined with type, action, config or container")
+		}
+
+		tmpl, err := l.resolveTemplate(def, step.Uses)
+		if err != nil {
+			return nil, stepError(step, err.Error())
+		}
+
+		inputs, err := templateInputs(tmpl, step.With)
+		if err != nil {
+			return nil, stepError(step, fmt.Sprintf("template %s: %v", step.Uses, err))
+		}
+
+		var templateSteps []models.PipelineStep
+		for _, ts := range tmpl.Steps {
+			ts.Name = step.Name + " / " + substituteString(ts.Name, inputs)
+			ts.Description = substituteString(ts.Description, inputs)
+			ts.Config = copyConfig(ts.Config)
+			substituteInMap(ts.Config, inputs)
+			ts.With = copyConfig(ts.With)
+			substituteInMap(ts.With, inputs)
+			ts.Container = copyContainer(ts.Container)
+			substituteInContainer(ts.Container, inputs)
+			ts.Artifacts = append([]string(nil), ts.Artifacts...)
+			substituteInList(ts.Artifacts, inputs)
+			ts.Env = copyStringMap(ts.Env)
+			substituteInStringMap(ts.Env, inputs)
+			for k, v := range step.Env {
+				if _, ok := ts.Env[k]; !ok {
+					if ts.Env == nil {
+						ts.Env = make(map[string]string)
+					}
+					ts.Env[k] = v
+				}
+			}
+			ts.If = combineConditions(step.If, substituteInputsInCondition(ts.If, inputs))
+			if ts.OnFailure == "" {
+				ts.OnFailure = step.OnFailure
+			}
+			if ts.Retry == 0 {
+				ts.Retry = step.Retry
+			}
+			if ts.Matrix == nil && step.Matrix != nil {
+				ts.Matrix = copyConfig(step.Matrix)
+			}
+			templateSteps = append(templateSteps, ts)
+		}
+
+		// Templates may themselves use other templates
+		templateSteps, err = l.expandTemplates(def, templateSteps, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, templateSteps...)
+	}
+	return expanded, nil
+}
+
+// resolveTemplate looks up a template defined in the pipeline (or its includes) and falls back to template files
+func (l *definitionLoader) resolveTemplate(def *models.PipelineDefinition, uses string) (*models.StepTemplate, error) {
+	name := strings.TrimPrefix(uses, "templates/")
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".yml"), ".yaml")
+	for _, key := range []string{uses, name} {
+		if tmpl, ok := def.Templates[key]; ok {
+			return &tmpl, nil
+		}
+	}
+
+	path, source, err := resolveTemplateFile(uses)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl, ok := l.fileCache[path]; ok {
+		return tmpl, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template %s: %v", source, err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, sourceError(source, err)
+	}
+	var tmpl models.StepTemplate
+	if err := root.Decode(&tmpl); err != nil {
+		return nil, sourceError(source, err)
+	}
+	if len(tmpl.Steps) == 0 {
+		return nil, fmt.Errorf("template %s has no steps", source)
+	}
+	annotateStepSources(mappingValue(documentRoot(&root), "steps"), tmpl.Steps, source)
+
+	l.fileCache[path] = &tmpl
+	return &tmpl, nil
+}
+
+// templateInputs validates the with values of a step against the template inputs
+// Returns the substitution variables inputs.NAME
+func templateInputs(tmpl *models.StepTemplate, with map[string]interface{}) (map[string]interface{}, error) {
+	for key := range with {
+		if _, ok := tmpl.Inputs[key]; !ok {
+			return nil, fmt.Errorf("unknown input %q", key)
+		}
+	}
+
+	variables := make(map[string]interface{}, len(tmpl.Inputs))
+	for name, input := range tmpl.Inputs {
+		value, ok := with[name]
+		if !ok {
+			if input.Required {
+				return nil, fmt.Errorf("missing required input %q", name)
+			}
+			value = input.Default
+		}
+		if value != nil {
+			variables["inputs."+name] = value
+		}
+	}
+	return variables, nil
+}
+
+// substituteInputsInCondition replaces input references in a condition by quoted string literals
+func substituteInputsInCondition(condition string, inputs map[string]interface{}) string {
+	return inputPattern.ReplaceAllStringFunc(condition, func(match string) string {
+		groups := inputPattern.FindStringSubmatch(match)
+		name := groups[1]
+		if name == "" {
+			name = groups[2]
+		}
+		return strconv.Quote(toString(inputs["inputs."+name]))
+	})
+}
+
+// combineConditions joins two optional conditions with &&
+func combineConditions(outer, inner string) string {
+	switch {
+	case outer == "":
+		return inner
+	case inner == "":
+		return outer
+	default:
+		return "(" + outer + ") && (" + inner + ")"
+	}
+}
+
+// loadStoredPipeline loads the definition of a stored pipeline by name or ID
+func loadStoredPipeline(ref string) (string, string, error) {
+	var pipeline *models.Pipeline
+	var err error
+	if id, convErr := strconv.ParseInt(ref, 10, 64); convErr == nil {
+		pipeline, err = database.GetPipeline(id)
+	} else {
+		pipeline, err = database.GetPipelineByName(ref)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("included pipeline %q not found", ref)
+	}
+	// Grants are checked against the user of a request, which a definition is not tied to
+	restricted, err := database.IsPipelineRestricted(pipeline.ID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to check grants of included pipeline %q: %w", ref, err)
+	}
+	if restricted {
+		return "", "", fmt.Errorf("pipeline %q is restricted by grants and cannot be included", ref)
+	}
+	return pipeline.Definition, "pipeline " + strconv.Quote(pipeline.Name), nil
+}
+
+// resolveTemplateFile maps a template reference like templates/redeploy-container to a file in the templates directory
+// Returns the file path and the name used in error messages
+func resolveTemplateFile(ref string) (string, string, error) {
+	rel := filepath.Clean(strings.TrimPrefix(strings.TrimSpace(ref), "templates/"))
+	if rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", "", fmt.Errorf("invalid template path %q", ref)
+	}
+
+	dir := TemplatesDir()
+	candidates := []string{rel}
+	if filepath.Ext(rel) == "" {
+		candidates = []string{rel + ".yml", rel + ".yaml"}
+	}
+	for _, candidate := range candidates {
+		path := filepath.Join(dir, candidate)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, "templates/" + candidate, nil
+		}
+	}
+	return "", "", fmt.Errorf("template %q not found in %s", ref, dir)
+}
+
+// annotateSources records the source position of every step and template step
+func annotateSources(root *yaml.Node, def *models.PipelineDefinition, source string) {
+	doc := documentRoot(root)
+	annotateStepSources(mappingValue(doc, "steps"), def.Steps, source)
+
+	templates := mappingValue(doc, "templates")
+	for name, tmpl := range def.Templates {
+		annotateStepSources(mappingValue(mappingValue(templates, name), "steps"), tmpl.Steps, source)
+	}
+}
+
+// annotateStepSources sets Source and KeyPositions on decoded steps from the matching sequence node
+func annotateStepSources(node *yaml.Node, steps []models.PipelineStep, source string) {
+	positions := sequencePositions(node, source)
+	for i := range steps {
+		pos := positionAt(positions, i, source)
+		steps[i].Source = &pos
+		if node != nil && node.Kind == yaml.SequenceNode && i < len(node.Content) {
+			item := node.Content[i]
+			steps[i].KeyPositions = keyPositions(item, source, "")
+			for key, p := range keyPositions(mappingValue(item, "config"), source, "config.") {
+				steps[i].KeyPositions[key] = p
+			}
+			for key, p := range keyPositions(mappingValue(item, "container"), source, "container.") {
+				steps[i].KeyPositions[key] = p
+			}
+		}
+	}
+}
+
+// keyPositions returns the positions of the keys of a mapping node, prefixed with prefix
+func keyPositions(node *yaml.Node, source, prefix string) map[string]models.SourcePosition {
+	positions := make(map[string]models.SourcePosition)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return positions
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		positions[prefix+key.Value] = models.SourcePosition{File: source, Line: key.Line, Column: key.Column}
+	}
+	return positions
+}
+
+// sequencePositions returns the positions of the items of a sequence node
+func sequencePositions(node *yaml.Node, source string) []models.SourcePosition {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	positions := make([]models.SourcePosition, len(node.Content))
+	for i, item := range node.Content {
+		positions[i] = models.SourcePosition{File: source, Line: item.Line, Column: item.Column}
+	}
+	return positions
+}
+
+func positionAt(positions []models.SourcePosition, i int, source string) models.SourcePosition {
+	if i < len(positions) {
+		return positions[i]
+	}
+	return models.SourcePosition{File: source}
+}
+
+// documentRoot unwraps the document node of a parsed YAML file
+func documentRoot(node *yaml.Node) *yaml.Node {
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+	return node
+}
+
+// mappingValue returns the value node of key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sourceError reports a YAML error of a file, with the line number if yaml.v3 included one
+func sourceError(source string, err error) error {
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	verr := ValidationError{File: source, Message: message}
+	if m := yamlLinePattern.FindStringSubmatch(message); m != nil {
+		verr.Line, _ = strconv.Atoi(m[1])
+	}
+	return ValidationErrors{verr}
+}
+
+// stepError reports an error at the position of a step
+func stepError(step models.PipelineStep, message string) error {
+	name := step.Name
+	if name == "" {
+		name = "(unnamed)"
+	}
+	if step.Source != nil {
+		return ValidationErrors{positionError(*step.Source, name, message)}
+	}
+	return ValidationErrors{{File: rootSource, Step: name, Message: message}}
+}
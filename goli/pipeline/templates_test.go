@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIncludeStoredPipeline(t *testing.T) {
+	openTestDatabase(t)
+	common := `name: common
+variables:
+  TOKEN: from-common
+steps:
+  - name: setup
+    type: shell
+    action: run
+    config:
+      command: "true"
+`
+	createTestPipeline(t, "common", common, false)
+	createTestPipeline(t, "restricted", strings.Replace(common, "common", "restricted", 1), true)
+
+	def, err := ParsePipelineDefinition(`name: deploy
+include:
+  - pipeline: common
+steps:
+  - name: deploy
+    type: shell
+    action: run
+    config:
+      command: "true"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(def.Steps) != 2 || def.Steps[0].Name != "setup" || def.Variables["TOKEN"] != "from-common" {
+		t.Errorf("include of an open pipeline was not expanded: %+v", def)
+	}
+
+	_, err = ParsePipelineDefinition(`name: deploy
+include:
+  - pipeline: restricted
+steps: []
+`)
+	if err == nil || !strings.Contains(err.Error(), "restricted by grants") {
+		t.Errorf("err = %v, want the restricted pipeline refused", err)
+	}
+}
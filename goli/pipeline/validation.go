@@ -0,0 +1,623 @@
+package pipeline
+
+import (
+	"fmt"
+	"goli/models"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError is a single problem in a pipeline definition, located in the YAML it was found in
+type ValidationError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Step    string `json:"step,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error formats the error as file:line:column: step NAME: message
+func (e ValidationError) Error() string {
+	var sb strings.Builder
+	if e.File != "" {
+		sb.WriteString(e.File)
+		if e.Line > 0 {
+			sb.WriteString(fmt.Sprintf(":%d:%d", e.Line, e.Column))
+		}
+		sb.WriteString(": ")
+	}
+	if e.Step != "" {
+		sb.WriteString("step " + e.Step + ": ")
+	}
+	sb.WriteString(e.Message)
+	return sb.String()
+}
+
+// ValidationErrors lists all problems found in a pipeline definition
+type ValidationErrors []ValidationError
+
+// Error joins all errors
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// AsValidationErrors converts a parse or validation error into a list of validation errors
+func AsValidationErrors(err error) ValidationErrors {
+	if err == nil {
+		return nil
+	}
+	if list, ok := err.(ValidationErrors); ok {
+		return list
+	}
+	return ValidationErrors{{Message: err.Error()}}
+}
+
+// yamlLinePattern extracts the line number from yaml.v3 error messages
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// positionError reports an error at a position
+func positionError(pos models.SourcePosition, step, message string) ValidationError {
+	return ValidationError{File: pos.File, Line: pos.Line, Column: pos.Column, Step: step, Message: message}
+}
+
+// configKind is the expected type of a config value
+type configKind int
+
+const (
+	kindScalar     configKind = iota // string, number or boolean
+	kindBool                         // boolean or a ${VAR} reference
+	kindList                         // list of scalars
+	kindMap                          // map of scalars
+	kindScalarList                   // scalar or list of scalars
+	kindMapOrList                    // map or list of KEY=VALUE strings
+)
+
+func (k configKind) String() string {
+	switch k {
+	case kindBool:
+		return "a boolean"
+	case kindList:
+		return "a list"
+	case kindMap:
+		return "a map"
+	case kindScalarList:
+		return "a string or a list"
+	case kindMapOrList:
+		return "a map or a list"
+	default:
+		return "a string"
+	}
+}
+
+// configField describes one config key of a step action
+type configField struct {
+	kind     configKind
+	required bool
+}
+
+// actionSchema lists the config keys accepted by an action
+type actionSchema map[string]configField
+
+var (
+	containerSchema = actionSchema{"container": {kind: kindScalar, required: true}}
+	imageSchema     = actionSchema{"image": {kind: kindScalar, required: true}}
+	shellSchema     = actionSchema{
+		"command": {kind: kindScalar, required: true},
+		"args":    {kind: kindList},
+	}
+	scriptSchema = actionSchema{
+		"script": {kind: kindScalar, required: true},
+		"shell":  {kind: kindScalar},
+	}
+)
+
+// stepSchemas maps step type and action to the accepted config keys
+var stepSchemas = map[string]map[string]actionSchema{
+	"docker": {
+		"pull": imageSchema,
+		"push": imageSchema,
+		"rmi":  imageSchema,
+		"run": {
+			"image":      {kind: kindScalar, required: true},
+			"container":  {kind: kindScalar},
+			"ports":      {kind: kindList},
+			"env":        {kind: kindMapOrList},
+			"volumes":    {kind: kindList},
+			"network":    {kind: kindScalar},
+			"cmd":        {kind: kindScalarList},
+			"opts":       {kind: kindScalar},
+			"restart":    {kind: kindScalar},
+			"pin_digest": {kind: kindBool},
+		},
+		"start":   containerSchema,
+		"stop":    containerSchema,
+		"rm":      containerSchema,
+		"pause":   containerSchema,
+		"unpause": containerSchema,
+		"inspect": containerSchema,
+		"logs":    containerSchema,
+		"exec": {
+			"container": {kind: kindScalar, required: true},
+			"command":   {kind: kindScalar, required: true},
+			"args":      {kind: kindList, required: true},
+		},
+	},
+	"shell": {
+		"run":   shellSchema,
+		"check": shellSchema,
+	},
+	"script": {
+		"run": scriptSchema,
+	},
+	"git": {
+		"checkout": {
+			"repository": {kind: kindScalar, required: true},
+			"ref":        {kind: kindScalar},
+			"path":       {kind: kindScalar},
+			"depth":      {kind: kindScalar},
+			"username":   {kind: kindScalar},
+			"password":   {kind: kindScalar},
+			"ssh_key":    {kind: kindScalar},
+		},
+	},
+	"approval": {
+		"request": {
+			"message":             {kind: kindScalar},
+			"approvers":           {kind: kindList},
+			"roles":               {kind: kindList},
+			"timeout":             {kind: kindScalar},
+			"allow_self_approval": {kind: kindBool},
+		},
+	},
+	"artifacts": {
+		"fetch": {
+			"pipeline": {kind: kindScalar, required: true},
+			"job":      {kind: kindScalar},
+			"step":     {kind: kindScalar},
+			"paths":    {kind: kindList},
+			"path":     {kind: kindScalar},
+		},
+	},
+}
+
+// uncheckedReferenceKeys are config keys whose ${...} references may be shell variables
+var uncheckedReferenceKeys = map[string]bool{"script": true, "cmd": true}
+
+// onFailureValues are the accepted values of on_failure
+var onFailureValues = []string{"stop", "continue"}
+
+// envNamePattern matches valid environment variable names
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// builtinVariablePrefix marks variables provided by Goli at run time
+const builtinVariablePrefix = "GOLI_"
+
+// referencePattern matches ${NAME} and {{NAME}} references, like substituteString
+var referencePattern = regexp.MustCompile(`\$\{([^}]+)\}|\{\{([^}]+)\}\}`)
+
+// ValidatePipelineDefinition validates a parsed pipeline definition against the step schemas
+// variableNames are the stored variables and secrets of the pipeline; references to other names
+// (except variables declared in the definition and GOLI_* built-ins) are reported as undefined.
+// Returns ValidationErrors listing every problem found.
+func ValidatePipelineDefinition(def *models.PipelineDefinition, variableNames ...string) error {
+	var errs ValidationErrors
+	rootPos := func(key string) models.SourcePosition {
+		if pos, ok := def.KeyPositions[key]; ok {
+			return pos
+		}
+		return models.SourcePosition{File: rootSource}
+	}
+
+	for _, key := range unknownKeys(def.KeyPositions, reflect.TypeOf(models.PipelineDefinition{})) {
+		errs = append(errs, positionError(def.KeyPositions[key], "", "unknown key "+strconv.Quote(key)+suggestion(key, yamlKeys(reflect.TypeOf(models.PipelineDefinition{})))))
+	}
+
+	if def.Name == "" {
+		errs = append(errs, positionError(rootPos("name"), "", "pipeline name is required"))
+	}
+
+	if len(def.Steps) == 0 {
+		errs = append(errs, positionError(rootPos("steps"), "", "pipeline must have at least one step"))
+	}
+
+	known := make(map[string]bool)
+	for name := range def.Variables {
+		known[name] = true
+	}
+	for _, name := range variableNames {
+		known[name] = true
+	}
+
+	earlier := make(map[string]bool)
+	for i, step := range def.Steps {
+		label := step.Name
+		if label == "" {
+			label = "#" + strconv.Itoa(i+1)
+		}
+		errs = append(errs, validateStep(step, label, known, earlier)...)
+		if step.Name != "" {
+			if earlier[step.Name] {
+				errs = append(errs, stepPositionError(step, "name", label, "duplicate step name"))
+			}
+			earlier[step.Name] = true
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateStep validates a single step; earlier holds the names of the preceding steps
+func validateStep(step models.PipelineStep, label string, known, earlier map[string]bool) ValidationErrors {
+	var errs ValidationErrors
+	fail := func(key, message string) {
+		errs = append(errs, stepPositionError(step, key, label, message))
+	}
+
+	for _, key := range unknownKeys(step.KeyPositions, reflect.TypeOf(models.PipelineStep{})) {
+		fail(key, "unknown key "+strconv.Quote(key)+suggestion(key, yamlKeys(reflect.TypeOf(models.PipelineStep{}))))
+	}
+
+	if step.Name == "" {
+		fail("", "step name is required")
+	}
+
+	if step.OnFailure != "" && !containsString(onFailureValues, step.OnFailure) {
+		fail("on_failure", fmt.Sprintf("on_failure must be one of %s, got %q", strings.Join(onFailureValues, ", "), step.OnFailure))
+	}
+	if step.Retry < 0 {
+		fail("retry", "retry must not be negative")
+	}
+
+	if step.If != "" {
+		if err := ValidateCondition(step.If); err != nil {
+			fail("if", "invalid if condition: "+err.Error())
+		} else {
+			for _, name := range conditionIdentifiers(step.If) {
+				if !conditionNameDefined(name, step, known, earlier) {
+					fail("if", fmt.Sprintf("if condition references undefined name %q", name))
+				}
+			}
+		}
+	}
+
+	if step.Container != nil {
+		if step.Type != "script" && step.Type != "shell" {
+			fail("container", "container is only supported for script and shell steps")
+		}
+		containerKeys := yamlKeys(reflect.TypeOf(models.StepContainer{}))
+		for key := range step.KeyPositions {
+			if name, ok := strings.CutPrefix(key, "container."); ok && !containsString(containerKeys, name) {
+				fail(key, "unknown container key "+strconv.Quote(name)+suggestion(name, containerKeys))
+			}
+		}
+		if step.Container.Image == "" {
+			fail("container", "container image is required")
+		}
+		values := append([]string{step.Container.Image, step.Container.User}, step.Container.Volumes...)
+		for _, v := range step.Container.Env {
+			values = append(values, v)
+		}
+		for _, value := range values {
+			for _, name := range configReferences(value) {
+				if !referenceDefined(name, known, earlier) {
+					fail("container", fmt.Sprintf("container references undefined variable %q", name))
+				}
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(step.Env) {
+		if !envNamePattern.MatchString(name) {
+			fail("env", fmt.Sprintf("invalid environment variable name %q", name))
+		} else if strings.HasPrefix(name, builtinVariablePrefix) {
+			fail("env", fmt.Sprintf("environment variable %s is reserved, %s* variables are set by Goli", name, builtinVariablePrefix))
+		}
+		for _, ref := range configReferences(step.Env[name]) {
+			if !referenceDefined(ref, known, earlier) {
+				fail("env", fmt.Sprintf("env %s references undefined variable %q", name, ref))
+			}
+		}
+	}
+
+	for _, pattern := range step.Artifacts {
+		if err := ValidateArtifactPattern(pattern); err != nil {
+			fail("artifacts", err.Error())
+		}
+		for _, name := range configReferences(pattern) {
+			if !referenceDefined(name, known, earlier) {
+				fail("artifacts", fmt.Sprintf("artifacts reference undefined variable %q", name))
+			}
+		}
+	}
+
+	if step.Type == "" {
+		fail("type", "step type is required")
+		return errs
+	}
+	actions, ok := stepSchemas[step.Type]
+	if !ok {
+		fail("type", fmt.Sprintf("unknown step type %q, expected one of %s", step.Type, strings.Join(sortedKeys(stepSchemas), ", ")))
+		return errs
+	}
+	if step.Action == "" {
+		fail("action", "step action is required")
+		return errs
+	}
+	schema, ok := actions[step.Action]
+	if !ok {
+		fail("action", fmt.Sprintf("unknown %s action %q, expected one of %s", step.Type, step.Action, strings.Join(sortedKeys(actions), ", ")))
+		return errs
+	}
+
+	configKeys := make([]string, 0, len(step.Config))
+	for key := range step.Config {
+		configKeys = append(configKeys, key)
+	}
+	sort.Strings(configKeys)
+
+	for _, key := range configKeys {
+		value := step.Config[key]
+		field, ok := schema[key]
+		if !ok {
+			fail("config."+key, fmt.Sprintf("unknown config key %q for %s %s%s", key, step.Type, step.Action, suggestion(key, sortedKeys(schema))))
+			continue
+		}
+		if !field.kind.matches(value) {
+			fail("config."+key, fmt.Sprintf("config %s must be %s", key, field.kind))
+		}
+		if !uncheckedReferenceKeys[key] {
+			for _, name := range configReferences(value) {
+				if !referenceDefined(name, known, earlier) {
+					fail("config."+key, fmt.Sprintf("config %s references undefined variable %q", key, name))
+				}
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(schema) {
+		if _, ok := step.Config[key]; schema[key].required && !ok {
+			fail("config", fmt.Sprintf("config %s is required for %s %s", key, step.Type, step.Action))
+		}
+	}
+
+	if step.Type == "approval" {
+		errs = append(errs, validateApprovalStep(step, label)...)
+	}
+	if step.Type == "git" {
+		for _, key := range []string{"repository", "ref"} {
+			if value, ok := step.Config[key].(string); ok {
+				if err := checkGitArgument(key, value); err != nil {
+					fail("config."+key, err.Error())
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// matches reports whether a decoded YAML value has the expected kind
+func (k configKind) matches(value interface{}) bool {
+	switch k {
+	case kindBool:
+		if _, ok := value.(bool); ok {
+			return true
+		}
+		s, ok := value.(string)
+		return ok && referencePattern.MatchString(s)
+	case kindList:
+		return isScalarList(value)
+	case kindMap:
+		return isScalarMap(value)
+	case kindScalarList:
+		return isScalar(value) || isScalarList(value)
+	case kindMapOrList:
+		return isScalarMap(value) || isScalarList(value)
+	default:
+		return isScalar(value)
+	}
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case string, int, int64, float64, bool:
+		return true
+	}
+	return false
+}
+
+func isScalarList(value interface{}) bool {
+	list, ok := value.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range list {
+		if !isScalar(item) {
+			return false
+		}
+	}
+	return true
+}
+
+func isScalarMap(value interface{}) bool {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range m {
+		if !isScalar(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// configReferences returns the names referenced with ${NAME} or {{NAME}} in a config value
+func configReferences(value interface{}) []string {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		for _, match := range referencePattern.FindAllStringSubmatch(v, -1) {
+			name := match[1]
+			if name == "" {
+				name = match[2]
+			}
+			names = append(names, name)
+		}
+	case []interface{}:
+		for _, item := range v {
+			names = append(names, configReferences(item)...)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			names = append(names, configReferences(item)...)
+		}
+	}
+	return names
+}
+
+// referenceDefined reports whether a config reference resolves at run time
+func referenceDefined(name string, known, earlier map[string]bool) bool {
+	if known[name] || strings.HasPrefix(name, builtinVariablePrefix) {
+		return true
+	}
+	return stepReferenceDefined(name, earlier)
+}
+
+// stepReferenceDefined reports whether name is steps.<earlier step>.status or steps.<earlier step>.outputs.KEY
+func stepReferenceDefined(name string, earlier map[string]bool) bool {
+	rest, ok := strings.CutPrefix(name, "steps.")
+	if !ok {
+		return false
+	}
+	for stepName := range earlier {
+		field, ok := strings.CutPrefix(rest, stepName+".")
+		if !ok {
+			continue
+		}
+		if field == "status" || (strings.HasPrefix(field, "outputs.") && len(field) > len("outputs.")) {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionNameDefined reports whether an identifier in an if condition resolves at run time
+func conditionNameDefined(name string, step models.PipelineStep, known, earlier map[string]bool) bool {
+	if referenceDefined(name, known, earlier) {
+		return true
+	}
+	if key, ok := strings.CutPrefix(name, "matrix."); ok {
+		_, defined := step.MatrixValues[key]
+		return defined
+	}
+	for _, prefix := range []string{"parameters.", "job.", "pipeline.", "trigger."} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stepPositionError reports an error at a step key, falling back to the step position
+func stepPositionError(step models.PipelineStep, key, label, message string) ValidationError {
+	if pos, ok := step.KeyPositions[key]; ok {
+		return positionError(pos, label, message)
+	}
+	if step.Source != nil {
+		return positionError(*step.Source, label, message)
+	}
+	return ValidationError{File: rootSource, Step: label, Message: message}
+}
+
+// unknownKeys returns the recorded top-level keys that are not fields of the given struct type
+func unknownKeys(positions map[string]models.SourcePosition, t reflect.Type) []string {
+	allowed := make(map[string]bool)
+	for _, key := range yamlKeys(t) {
+		allowed[key] = true
+	}
+	var unknown []string
+	for key := range positions {
+		if strings.HasPrefix(key, "config.") || strings.HasPrefix(key, "container.") || allowed[key] {
+			continue
+		}
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// yamlKeys returns the YAML keys of a struct type
+func yamlKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag != "" && tag != "-" {
+			keys = append(keys, tag)
+		}
+	}
+	return keys
+}
+
+// suggestion proposes a known key close to a misspelled one
+func suggestion(key string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(key, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance computes the Levenshtein distance of two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
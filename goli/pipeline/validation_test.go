@@ -0,0 +1,203 @@
+package pipeline
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidatePipelineDefinition(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		variables []string
+		want      ValidationErrors
+	}{
+		{
+			name: "valid",
+			yaml: `name: p
+variables:
+  TARGET: all
+steps:
+  - name: build
+    type: shell
+    action: run
+    on_failure: continue
+    env:
+      TOKEN: ${API_TOKEN}
+    config:
+      command: make ${TARGET}
+  - name: deploy
+    type: script
+    action: run
+    if: 'steps.build.status == "completed" && parameters.ENV == "prod"'
+    config:
+      script: ./deploy.sh ${steps.build.outputs.VERSION} $HOME
+`,
+			variables: []string{"API_TOKEN"},
+		},
+		{
+			name: "unknown keys with suggestions",
+			yaml: `name: p
+stepz: []
+steps:
+  - name: build
+    type: shell
+    action: run
+    on_failur: stop
+    container:
+      image: golang
+      volume: [a]
+    config:
+      command: make
+      arg: [all]
+`,
+			want: ValidationErrors{
+				{File: "pipeline", Line: 2, Column: 1, Message: `unknown key "stepz", did you mean "steps"?`},
+				{File: "pipeline", Line: 7, Column: 5, Step: "build", Message: `unknown key "on_failur", did you mean "on_failure"?`},
+				{File: "pipeline", Line: 10, Column: 7, Step: "build", Message: `unknown container key "volume", did you mean "volumes"?`},
+				{File: "pipeline", Line: 13, Column: 7, Step: "build", Message: `unknown config key "arg" for shell run, did you mean "args"?`},
+			},
+		},
+		{
+			name: "unknown key without a close match",
+			yaml: `name: p
+steps:
+  - name: build
+    type: shell
+    action: run
+    timeout_minutes: 5
+    config:
+      command: make
+`,
+			want: ValidationErrors{
+				{File: "pipeline", Line: 6, Column: 5, Step: "build", Message: `unknown key "timeout_minutes"`},
+			},
+		},
+		{
+			name: "unknown types and actions",
+			yaml: `name: p
+steps:
+  - name: a
+    type: kubernetes
+    action: apply
+  - name: b
+    type: docker
+    action: build
+  - type: shell
+`,
+			want: ValidationErrors{
+				{File: "pipeline", Line: 4, Column: 5, Step: "a", Message: `unknown step type "kubernetes", expected one of approval, artifacts, docker, git, script, shell`},
+				{File: "pipeline", Line: 8, Column: 5, Step: "b", Message: `unknown docker action "build", expected one of exec, inspect, logs, pause, pull, push, rm, rmi, run, start, stop, unpause`},
+				{File: "pipeline", Line: 9, Column: 5, Step: "#3", Message: "step name is required"},
+				{File: "pipeline", Line: 9, Column: 5, Step: "#3", Message: "step action is required"},
+			},
+		},
+		{
+			name: "required, unexpected and mistyped config",
+			yaml: `name: p
+steps:
+  - name: run
+    type: docker
+    action: run
+    config:
+      imagee: app
+      ports: "8080:80"
+      pin_digest: yes please
+      env: 42
+  - name: exec
+    type: docker
+    action: exec
+    config:
+      container: app
+      command: ls
+`,
+			want: ValidationErrors{
+				{File: "pipeline", Line: 10, Column: 7, Step: "run", Message: "config env must be a map or a list"},
+				{File: "pipeline", Line: 7, Column: 7, Step: "run", Message: `unknown config key "imagee" for docker run, did you mean "image"?`},
+				{File: "pipeline", Line: 9, Column: 7, Step: "run", Message: "config pin_digest must be a boolean"},
+				{File: "pipeline", Line: 8, Column: 7, Step: "run", Message: "config ports must be a list"},
+				{File: "pipeline", Line: 6, Column: 5, Step: "run", Message: "config image is required for docker run"},
+				{File: "pipeline", Line: 14, Column: 5, Step: "exec", Message: "config args is required for docker exec"},
+			},
+		},
+		{
+			name: "on_failure values",
+			yaml: `name: p
+steps:
+  - name: build
+    type: shell
+    action: run
+    on_failure: retry
+    config:
+      command: make
+  - name: test
+    type: shell
+    action: run
+    on_failure: stop
+    config:
+      command: make test
+`,
+			want: ValidationErrors{
+				{File: "pipeline", Line: 6, Column: 5, Step: "build", Message: `on_failure must be one of stop, continue, got "retry"`},
+			},
+		},
+		{
+			name: "undefined references",
+			yaml: `name: p
+steps:
+  - name: build
+    type: shell
+    action: run
+    if: 'MISSING == "x"'
+    env:
+      TOKEN: ${API_TOKEN}
+      OTHER: ${SECRET}
+    config:
+      command: make ${TARGET}
+      args: ["${steps.deploy.outputs.URL}", "${GOLI_JOB_ID}"]
+  - name: deploy
+    type: script
+    action: run
+    config:
+      script: echo $UNCHECKED ${ALSO_UNCHECKED}
+`,
+			variables: []string{"API_TOKEN"},
+			want: ValidationErrors{
+				{File: "pipeline", Line: 6, Column: 5, Step: "build", Message: `if condition references undefined name "MISSING"`},
+				{File: "pipeline", Line: 7, Column: 5, Step: "build", Message: `env OTHER references undefined variable "SECRET"`},
+				{File: "pipeline", Line: 12, Column: 7, Step: "build", Message: `config args references undefined variable "steps.deploy.outputs.URL"`},
+				{File: "pipeline", Line: 11, Column: 7, Step: "build", Message: `config command references undefined variable "TARGET"`},
+			},
+		},
+		{
+			name: "missing name and steps",
+			yaml: `variables:
+  A: b
+`,
+			want: ValidationErrors{
+				{File: "pipeline", Message: "pipeline name is required"},
+				{File: "pipeline", Message: "pipeline must have at least one step"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			def, err := ParsePipelineDefinition(tt.yaml)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := AsValidationErrors(ValidatePipelineDefinition(def, tt.variables...))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %d errors:", len(got))
+				for _, e := range got {
+					t.Errorf("  %#v", e)
+				}
+				t.Errorf("want %d errors:", len(tt.want))
+				for _, e := range tt.want {
+					t.Errorf("  %#v", e)
+				}
+			}
+		})
+	}
+}
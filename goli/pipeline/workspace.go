@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"fmt"
+	aux "goli/auxiliary"
+	"goli/database"
+	"goli/models"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultWorkspaceRoot is the directory holding the per-job workspaces
+const defaultWorkspaceRoot = "/goli/workspaces"
+
+// Workspace retention policies
+const (
+	RetentionAlways    = "always"     // remove the workspace when the job finishes
+	RetentionOnSuccess = "on_success" // remove the workspace of successful jobs, keep failed ones for debugging
+	RetentionKeepLast  = "keep_last"  // keep the workspaces of the last N jobs
+)
+
+// defaultKeepLast is the number of workspaces kept by the keep_last policy when none is configured
+const defaultKeepLast = 5
+
+// workspacePrefix is the name prefix of job workspace directories
+const workspacePrefix = "job-"
+
+// workspaceRootOverride replaces the configured workspace root when set, tests use a temporary directory
+var workspaceRootOverride string
+
+// WorkspaceRoot returns the directory holding the per-job workspaces
+func WorkspaceRoot() string {
+	if workspaceRootOverride != "" {
+		return workspaceRootOverride
+	}
+	if dir := configSetting("workspace_root"); dir != "" {
+		return dir
+	}
+	return defaultWorkspaceRoot
+}
+
+// WorkspaceRetention returns the configured retention policy and the number of workspaces kept by keep_last
+func WorkspaceRetention() (string, int) {
+	policy := configSetting("workspace_retention")
+	switch policy {
+	case RetentionAlways, RetentionOnSuccess, RetentionKeepLast:
+	case "":
+		policy = RetentionAlways
+	default:
+		log.Printf("WARNING: Unknown workspace_retention %q, using %q", policy, RetentionAlways)
+		policy = RetentionAlways
+	}
+
+	keepLast := defaultKeepLast
+	if raw := configSetting("workspace_keep_last"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			keepLast = n
+		} else {
+			log.Printf("WARNING: Invalid workspace_keep_last %q, keeping %d workspaces", raw, defaultKeepLast)
+		}
+	}
+	return policy, keepLast
+}
+
+// configSetting reads a setting from the config file, if there is one
+func configSetting(key string) string {
+	if _, err := os.Stat(aux.GetConfigPath()); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(aux.GetFromConfig("constants." + key))
+}
+
+// JobWorkspace returns the workspace directory of a job
+func JobWorkspace(jobID int64) string {
+	return filepath.Join(WorkspaceRoot(), fmt.Sprintf("%s%d", workspacePrefix, jobID))
+}
+
+// PrepareWorkspace creates the workspace directory of a job
+func PrepareWorkspace(jobID int64) (string, error) {
+	dir := JobWorkspace(jobID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create workspace %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// CleanupWorkspace applies the retention policy after a job finished
+func CleanupWorkspace(job *models.Job, succeeded bool) {
+	policy, keepLast := WorkspaceRetention()
+
+	switch policy {
+	case RetentionAlways:
+		removeWorkspace(job.ID)
+	case RetentionOnSuccess:
+		if succeeded {
+			removeWorkspace(job.ID)
+		} else {
+			logToJob(job.ID, fmt.Sprintf("Keeping workspace %s of the failed job", JobWorkspace(job.ID)))
+		}
+	case RetentionKeepLast:
+		pruneWorkspaces(keepLast)
+	}
+}
+
+// removeWorkspace deletes the workspace directory of a job
+func removeWorkspace(jobID int64) {
+	dir := JobWorkspace(jobID)
+	if err := os.RemoveAll(dir); err != nil {
+		logToJob(jobID, fmt.Sprintf("WARNING: Failed to remove workspace %s: %v", dir, err))
+		return
+	}
+	logToJob(jobID, fmt.Sprintf("Removed workspace %s", dir))
+}
+
+// pruneWorkspaces removes all but the newest keep workspaces, skipping those of jobs still running or waiting for approval
+func pruneWorkspaces(keep int) {
+	entries, err := os.ReadDir(WorkspaceRoot())
+	if err != nil {
+		log.Printf("Error reading workspace root: %v", err)
+		return
+	}
+
+	var jobIDs []int64
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), workspacePrefix) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(entry.Name(), workspacePrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		jobIDs = append(jobIDs, id)
+	}
+
+	// Newest jobs first
+	sort.Slice(jobIDs, func(i, j int) bool { return jobIDs[i] > jobIDs[j] })
+
+	for i, id := range jobIDs {
+		if i < keep {
+			continue
+		}
+		if job, err := database.GetJob(id); err == nil && (job.Status == models.JobStatusRunning || job.Status == models.JobStatusPending || job.Status == models.JobStatusWaitingApproval) {
+			continue
+		}
+		dir := JobWorkspace(id)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Error removing workspace %s: %v", dir, err)
+			continue
+		}
+		log.Printf("Removed workspace %s (keeping the last %d)", dir, keep)
+	}
+}
@@ -110,8 +110,8 @@ func (q *JobQueue) RemoveActiveJob(id int64) {
 	delete(q.active, id)
 }
 
-// CancelJob cancels a running or pending job
-func (q *JobQueue) CancelJob(id int64) error {
+// CancelJob cancels a running, pending or waiting job
+func (q *JobQueue) CancelJob(id int64, cancelledBy string) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
@@ -144,11 +144,18 @@ func (q *JobQueue) CancelJob(id int64) error {
 		return err
 	}
 
-	// Only cancel if pending or running
-	if dbJob.Status == models.JobStatusPending || dbJob.Status == models.JobStatusRunning {
+	// Only cancel if pending, running or waiting for approval
+	if dbJob.Status == models.JobStatusPending || dbJob.Status == models.JobStatusRunning || dbJob.Status == models.JobStatusWaitingApproval {
 		if err := database.UpdateJobStatus(id, models.JobStatusCancelled, "Job cancelled by user"); err != nil {
 			return err
 		}
+		if dbJob.Status == models.JobStatusWaitingApproval {
+			if approval, err := database.GetPendingJobApproval(id); err == nil {
+				if err := pipeline.DecideApproval(dbJob, approval, models.ApprovalCancelled, cancelledBy, ""); err != nil {
+					log.Printf("Error closing approval of job %d: %v", id, err)
+				}
+			}
+		}
 		completedAt := time.Now()
 		dbJob.CompletedAt = &completedAt
 		dbJob.Status = models.JobStatusCancelled
@@ -165,6 +172,126 @@ func (q *JobQueue) CancelJob(id int64) error {
 	return &QueueError{Message: fmt.Sprintf("Job %d cannot be cancelled (status: %s)", id, dbJob.Status)}
 }
 
+// ApproveJob records the approval of the step a job waits at and queues the job to continue after it.
+// When the queue is full the job stays pending and CheckApprovalsPeriodically resumes it later
+func (q *JobQueue) ApproveJob(job *models.Job, approval *models.JobApproval, approvedBy, comment string) error {
+	if err := pipeline.DecideApproval(job, approval, models.ApprovalApproved, approvedBy, comment); err != nil {
+		return err
+	}
+	job.Logs, job.Steps = "", nil
+
+	if q.resume(job, approval) {
+		log.Printf("Job %d (%s) approved by %s, enqueued to resume", job.ID, job.Name, approvedBy)
+	} else {
+		log.Printf("Job %d (%s) approved by %s, the queue is full, it resumes once there is room", job.ID, job.Name, approvedBy)
+	}
+	if q.hub != nil {
+		q.hub.BroadcastJobUpdate(job)
+	}
+	return nil
+}
+
+// resume queues an approved job to continue after its approval step. It returns false if the queue is
+// full; a job that is queued already or no longer pending needs nothing
+func (q *JobQueue) resume(job *models.Job, approval *models.JobApproval) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, queued := q.active[job.ID]; queued {
+		return true
+	}
+	if current, err := database.GetJob(job.ID); err != nil || current.Status != models.JobStatusPending {
+		return true
+	}
+	job.Approval = approval
+	select {
+	case q.jobs <- job:
+		q.active[job.ID] = job
+		return true
+	default:
+		return false
+	}
+}
+
+// ResumeApprovedJobs queues the approved jobs ApproveJob found no room for, and those left pending by a restart
+func (q *JobQueue) ResumeApprovedJobs() {
+	approvals, err := database.GetApprovedPendingJobApprovals()
+	if err != nil {
+		log.Printf("Error loading approved jobs: %v", err)
+		return
+	}
+	for _, approval := range approvals {
+		job, err := database.GetJob(approval.JobID)
+		if err != nil {
+			continue
+		}
+		job.Logs, job.Steps = "", nil
+		if !q.resume(job, approval) {
+			return
+		}
+	}
+}
+
+// RejectJob records the rejection of the step a job waits at, which fails the job
+func (q *JobQueue) RejectJob(job *models.Job, approval *models.JobApproval, rejectedBy, comment string) error {
+	if err := pipeline.DecideApproval(job, approval, models.ApprovalRejected, rejectedBy, comment); err != nil {
+		return err
+	}
+	job.Logs, job.Steps = "", nil
+	if q.hub != nil {
+		q.hub.BroadcastJobUpdate(job)
+	}
+	log.Printf("Job %d (%s) rejected by %s", job.ID, job.Name, rejectedBy)
+	return nil
+}
+
+// ExpireApprovals fails the jobs whose approval was not decided before its timeout
+func (q *JobQueue) ExpireApprovals() {
+	approvals, err := database.GetExpiredJobApprovals()
+	if err != nil {
+		log.Printf("Error loading expired approvals: %v", err)
+		return
+	}
+	for _, approval := range approvals {
+		job, err := database.GetJob(approval.JobID)
+		if err != nil {
+			log.Printf("Error loading job %d: %v", approval.JobID, err)
+			continue
+		}
+		if err := pipeline.DecideApproval(job, approval, models.ApprovalExpired, "", ""); err != nil {
+			if err != database.ErrApprovalDecided {
+				log.Printf("Error expiring approval of job %d: %v", job.ID, err)
+			}
+			continue
+		}
+		job.Logs, job.Steps = "", nil
+		if q.hub != nil {
+			q.hub.BroadcastJobUpdate(job)
+		}
+		log.Printf("Job %d (%s) failed, approval of step '%s' timed out", job.ID, job.Name, approval.StepName)
+	}
+}
+
+// CheckApprovalsPeriodically runs ExpireApprovals and ResumeApprovedJobs at the given interval, it never returns
+func (q *JobQueue) CheckApprovalsPeriodically(interval time.Duration) {
+	for {
+		q.ExpireApprovals()
+		q.ResumeApprovedJobs()
+		time.Sleep(interval)
+	}
+}
+
+// waitForApproval frees the worker of a job that paused at an approval step, ApproveJob queues it again
+func (q *JobQueue) waitForApproval(job *models.Job) {
+	// Clients show the approval the job now waits for
+	job.Approval, _ = database.GetPendingJobApproval(job.ID)
+	if q.hub != nil {
+		q.hub.BroadcastJobUpdate(job)
+	}
+	q.RemoveActiveJob(job.ID)
+	log.Printf("Worker: Job %d (%s) is waiting for approval", job.ID, job.Name)
+}
+
 // worker processes jobs from the queue
 func (q *JobQueue) worker(id int) {
 	defer q.wg.Done()
@@ -203,8 +330,19 @@ func (q *JobQueue) processJob(job *models.Job) {
 		q.hub.BroadcastJobUpdate(job)
 	}
 
-	// Execute pipeline if pipeline_id is provided
-	if job.PipelineID != nil {
+	// Approved jobs continue after the approval step, with the definition they paused with
+	if job.Approval != nil {
+		if err := pipeline.ResumePipeline(job, job.Approval); err != nil {
+			if err == pipeline.ErrWaitingApproval {
+				q.waitForApproval(job)
+				return
+			}
+			log.Printf("Error executing pipeline: %v", err)
+			// Status already updated by executor
+			return
+		}
+	} else if job.PipelineID != nil {
+		// Execute pipeline if pipeline_id is provided
 		pipelineRecord, err := database.GetPipelineWithSecrets(*job.PipelineID)
 		if err != nil {
 			log.Printf("Error loading pipeline: %v", err)
@@ -212,6 +350,30 @@ func (q *JobQueue) processJob(job *models.Job) {
 			return
 		}
 
+		// Secret values are masked wherever the executor logs commands or output
+		if storedVars, err := database.GetPipelineVariables(*job.PipelineID); err == nil {
+			for _, v := range storedVars {
+				if v.IsSecret {
+					job.Secrets = append(job.Secrets, v.Value)
+				}
+			}
+		}
+
+		// Repository-backed pipelines run the definition found at the configured ref
+		if pipelineRecord.Repository != "" {
+			definition, commit, err := pipeline.LoadPipelineDefinition(pipelineRecord)
+			if err != nil {
+				log.Printf("Error loading pipeline definition from repository: %v", err)
+				database.UpdateJobStatus(job.ID, models.JobStatusFailed, "Failed to load pipeline definition from repository: "+err.Error())
+				return
+			}
+			log.Printf("Job %d: loaded pipeline definition from %s at %s", job.ID, pipelineRecord.Repository, commit)
+			pipelineRecord.Definition = definition
+			if err := database.UpdatePipelineDefinition(pipelineRecord.ID, definition); err != nil {
+				log.Printf("Error storing pipeline definition: %v", err)
+			}
+		}
+
 		// Parse pipeline definition
 		pipelineDef, err := pipeline.ParsePipelineDefinition(pipelineRecord.Definition)
 		if err != nil {
@@ -220,13 +382,20 @@ func (q *JobQueue) processJob(job *models.Job) {
 			return
 		}
 
-		// Substitute variables in pipeline definition
-		if pipelineRecord.Variables != nil && len(pipelineRecord.Variables) > 0 {
-			pipeline.SubstituteVariables(pipelineDef, pipelineRecord.Variables)
+		// Substitute variables in pipeline definition, stored variables override those in the YAML
+		variables := pipeline.ResolveVariables(pipelineDef, pipelineRecord.Variables)
+		if len(variables) > 0 {
+			pipeline.SubstituteVariables(pipelineDef, variables)
 		}
+		// Step conditions are evaluated against the resolved variables
+		pipelineDef.Variables = variables
 
 		// Execute the pipeline
 		if err := pipeline.ExecutePipeline(job, pipelineDef); err != nil {
+			if err == pipeline.ErrWaitingApproval {
+				q.waitForApproval(job)
+				return
+			}
 			log.Printf("Error executing pipeline: %v", err)
 			// Status already updated by executor
 			return
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"goli/database"
+	"goli/models"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitingJob stores a job paused at an approval step
+func waitingJob(t *testing.T) (*models.Job, *models.JobApproval) {
+	t.Helper()
+	job, err := database.CreateJob(&models.Job{Name: "release", Status: models.JobStatusWaitingApproval})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.UpdateJobStatus(job.ID, models.JobStatusWaitingApproval, "")
+	step := &models.JobStep{JobID: job.ID, StepName: "Approve", StepOrder: 1, Status: models.JobStatusWaitingApproval}
+	if err := database.CreateJobStep(step); err != nil {
+		t.Fatal(err)
+	}
+	approval := &models.JobApproval{JobID: job.ID, StepID: step.ID, StepName: step.StepName, StepOrder: 1, ExpiresAt: time.Now().Add(time.Hour), State: "{}"}
+	if err := database.CreateJobApproval(approval); err != nil {
+		t.Fatal(err)
+	}
+	return job, approval
+}
+
+func TestApproveJobWithFullQueue(t *testing.T) {
+	if err := database.OpenDatabase(filepath.Join(t.TempDir(), "goli.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.CloseDatabase() })
+
+	// No workers and a queue with one slot, taken
+	q := NewJobQueue(0)
+	q.jobs = make(chan *models.Job, 1)
+	q.jobs <- &models.Job{}
+
+	job, approval := waitingJob(t)
+	if err := q.ApproveJob(job, approval, "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := database.GetJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != models.JobStatusPending {
+		t.Errorf("job status = %s, want pending until the queue has room", stored.Status)
+	}
+	if _, active := q.GetActiveJob(job.ID); active {
+		t.Error("job is active without being queued")
+	}
+
+	// Still full
+	q.ResumeApprovedJobs()
+	if len(q.jobs) != 1 {
+		t.Fatalf("queue holds %d jobs", len(q.jobs))
+	}
+
+	<-q.jobs
+	q.ResumeApprovedJobs()
+	q.ResumeApprovedJobs()
+	if len(q.jobs) != 1 {
+		t.Fatalf("queue holds %d jobs, want the approved job once", len(q.jobs))
+	}
+	resumed := <-q.jobs
+	if resumed.ID != job.ID || resumed.Approval == nil || resumed.Approval.ID != approval.ID {
+		t.Errorf("resumed job %d with approval %+v", resumed.ID, resumed.Approval)
+	}
+}
+
+func TestApproveJobQueuesOnce(t *testing.T) {
+	if err := database.OpenDatabase(filepath.Join(t.TempDir(), "goli.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.CloseDatabase() })
+
+	q := NewJobQueue(0)
+	job, approval := waitingJob(t)
+	if err := q.ApproveJob(job, approval, "alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	q.ResumeApprovedJobs()
+	if len(q.jobs) != 1 {
+		t.Errorf("queue holds %d jobs, want the approved job once", len(q.jobs))
+	}
+	if err := q.ApproveJob(job, approval, "bob", ""); err != database.ErrApprovalDecided {
+		t.Errorf("second approval = %v, want %v", err, database.ErrApprovalDecided)
+	}
+}
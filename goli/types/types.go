@@ -11,3 +11,28 @@ type GoliRequestBody struct {
 	Volume_In string `json:"volume_in"`
 	Opts      string `json:"opts"`
 }
+
+// DockerContainer is a structured entry of `docker ps -a`
+type DockerContainer struct {
+	ID      string            `json:"id"`
+	Names   []string          `json:"names"`
+	Image   string            `json:"image"`
+	Command string            `json:"command,omitempty"`
+	State   string            `json:"state"`
+	Status  string            `json:"status"`
+	Ports   []string          `json:"ports"`
+	Labels  map[string]string `json:"labels"`
+	Created string            `json:"created"`
+	Size    string            `json:"size,omitempty"`
+}
+
+// DockerImage is a structured entry of `docker images`
+type DockerImage struct {
+	ID         string            `json:"id"`
+	Repository string            `json:"repository"`
+	Tag        string            `json:"tag"`
+	Digest     string            `json:"digest,omitempty"`
+	Labels     map[string]string `json:"labels"`
+	Created    string            `json:"created"`
+	Size       string            `json:"size"`
+}
@@ -3,13 +3,26 @@ package utils
 import (
 	"bytes"
 	"context"
+	"database/sql"
+	"fmt"
 	aux "goli/auxiliary"
+	"goli/database"
 	"log"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 )
 
+// DockerHubHost is the registry host used for images without an explicit registry
+const DockerHubHost = "docker.io"
+
+var (
+	// registryLogins remembers the credential version used for the last successful login per host
+	registryLogins   = make(map[string]time.Time)
+	registryLoginsMu sync.Mutex
+)
+
 // AuthenticateGitHubContainerRegistry authenticates Docker with GitHub Container Registry
 // using credentials from the config file. Returns error if authentication fails.
 func AuthenticateGitHubContainerRegistry() error {
@@ -44,21 +57,8 @@ func AuthenticateGitHubContainerRegistry() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Create docker login command with password from stdin for security
-	cmd := exec.CommandContext(ctx, "docker", "login", "ghcr.io", "-u", username, "--password-stdin")
-
-	// Write password to stdin
-	cmd.Stdin = bytes.NewBufferString(token)
-
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	err := cmd.Run()
-	stderrStr := stderr.String()
-
-	if err != nil {
-		log.Printf("Docker login to GitHub Container Registry failed: %v, stderr: %s", err, stderrStr)
+	if err := DockerLogin(ctx, "ghcr.io", username, token); err != nil {
+		log.Printf("Docker login to GitHub Container Registry failed: %v", err)
 		return err
 	}
 
@@ -68,6 +68,7 @@ func AuthenticateGitHubContainerRegistry() error {
 
 // EnsureGitHubAuthForImage checks if an image is from GitHub Container Registry (ghcr.io)
 // and ensures authentication if needed. Returns true if authentication was attempted.
+// Deprecated: use EnsureRegistryAuthForImage, which supports every registry.
 func EnsureGitHubAuthForImage(image string) bool {
 	// Check if image is from GitHub Container Registry
 	if strings.HasPrefix(image, "ghcr.io/") {
@@ -80,3 +81,97 @@ func EnsureGitHubAuthForImage(image string) bool {
 	}
 	return false
 }
+
+// DockerLogin runs `docker login` for a registry host, passing the token via stdin
+func DockerLogin(ctx context.Context, host, username, token string) error {
+	args := []string{"login", "-u", username, "--password-stdin"}
+	if host != DockerHubHost {
+		args = []string{"login", host, "-u", username, "--password-stdin"}
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stdin = bytes.NewBufferString(token)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker login to %s failed: %v: %s", host, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// NormalizeRegistryHost turns user input like "https://Index.Docker.io/v1/" into a registry host
+func NormalizeRegistryHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	switch host {
+	case "", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return DockerHubHost
+	}
+	return host
+}
+
+// RegistryHostForImage returns the registry host of an image reference
+// Follows docker's rules: the first path component is a registry if it contains '.' or ':' or is "localhost"
+func RegistryHostForImage(image string) string {
+	i := strings.Index(image, "/")
+	if i < 0 {
+		return DockerHubHost
+	}
+	first := image[:i]
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return NormalizeRegistryHost(first)
+	}
+	return DockerHubHost
+}
+
+// EnsureRegistryAuthForImage logs in to the registry hosting the image if credentials are stored for it.
+// Falls back to the legacy GitHub credentials from config.toml for ghcr.io images.
+// Returns true if the registry is authenticated.
+func EnsureRegistryAuthForImage(image string) bool {
+	host := RegistryHostForImage(image)
+
+	cred, err := database.GetRegistryCredentialByHost(host)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Warning: Failed to load registry credentials for %s: %v", host, err)
+		}
+		if host == "ghcr.io" {
+			return EnsureGitHubAuthForImage(image)
+		}
+		return false
+	}
+
+	registryLoginsMu.Lock()
+	defer registryLoginsMu.Unlock()
+
+	// docker keeps the login in its config, only log in again when the credentials changed
+	if last, ok := registryLogins[host]; ok && last.Equal(cred.UpdatedAt) {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := DockerLogin(ctx, host, cred.Username, cred.Token); err != nil {
+		log.Printf("Warning: Failed to authenticate with registry %s for image %s: %v", host, image, err)
+		return false
+	}
+
+	registryLogins[host] = cred.UpdatedAt
+	log.Printf("Authenticated to registry %s as %s", host, cred.Username)
+	return true
+}
+
+// ForgetRegistryLogin clears the cached login state so the next pull logs in again
+func ForgetRegistryLogin(host string) {
+	registryLoginsMu.Lock()
+	defer registryLoginsMu.Unlock()
+	delete(registryLogins, host)
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ImageInfo is the subset of `docker image inspect` Goli needs for provenance
+type ImageInfo struct {
+	ID          string   `json:"Id"`
+	RepoTags    []string `json:"RepoTags"`
+	RepoDigests []string `json:"RepoDigests"`
+}
+
+// ContainerInfo is the subset of `docker container inspect` Goli needs for provenance
+type ContainerInfo struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Image  string `json:"Image"` // Image ID the container was created from
+	Config struct {
+		Image  string            `json:"Image"` // Image reference as given to docker run
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Status string `json:"Status"`
+	} `json:"State"`
+}
+
+// InspectImages runs `docker image inspect` for the given references
+func InspectImages(ctx context.Context, refs ...string) ([]ImageInfo, error) {
+	var images []ImageInfo
+	if err := dockerInspect(ctx, "image", refs, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// InspectContainers runs `docker container inspect` for the given names or IDs
+func InspectContainers(ctx context.Context, ids ...string) ([]ContainerInfo, error) {
+	var containers []ContainerInfo
+	if err := dockerInspect(ctx, "container", ids, &containers); err != nil {
+		return nil, err
+	}
+	for i := range containers {
+		containers[i].Name = strings.TrimPrefix(containers[i].Name, "/")
+	}
+	return containers, nil
+}
+
+// DigestForImage picks the repo digest (sha256:...) matching the image's repository
+// Returns "" if the image has no digest in that repository: locally built images have none, and the
+// digest of an image pushed elsewhere under another name does not identify it in this repository
+func DigestForImage(image string, info ImageInfo) string {
+	repo := ImageRepository(image)
+	for _, rd := range info.RepoDigests {
+		parts := strings.SplitN(rd, "@", 2)
+		if len(parts) == 2 && ImageRepository(parts[0]) == repo {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// ImageRepository strips the tag and digest from an image reference
+func ImageRepository(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	// A ':' after the last '/' is a tag separator, before it a registry port
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	// docker reports Docker Hub official images without the library/ prefix
+	return strings.TrimPrefix(strings.TrimPrefix(image, "docker.io/"), "library/")
+}
+
+// dockerInspect runs `docker <kind> inspect` and decodes its JSON array output
+func dockerInspect(ctx context.Context, kind string, refs []string, v interface{}) error {
+	if len(refs) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	args := append([]string{kind, "inspect"}, refs...)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker %s inspect failed: %v: %s", kind, err, strings.TrimSpace(stderr.String()))
+	}
+	return json.Unmarshal(stdout.Bytes(), v)
+}
@@ -0,0 +1,34 @@
+package utils
+
+import "testing"
+
+func TestDigestForImage(t *testing.T) {
+	info := ImageInfo{RepoDigests: []string{
+		"registry.example.com:5000/team/app@sha256:aaa",
+		"nginx@sha256:bbb",
+		"ghcr.io/org/app@sha256:ccc",
+	}}
+
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"registry.example.com:5000/team/app:1.2", "sha256:aaa"},
+		{"nginx:latest", "sha256:bbb"},
+		{"docker.io/library/nginx", "sha256:bbb"},
+		{"ghcr.io/org/app@sha256:ccc", "sha256:ccc"},
+		// The same image under a name it was never pushed as has no digest
+		{"app:1.2", ""},
+		{"registry.example.com:5000/team/other:1.2", ""},
+		{"myapp:dev", ""},
+	}
+	for _, tt := range tests {
+		if got := DigestForImage(tt.image, info); got != tt.want {
+			t.Errorf("DigestForImage(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+
+	if got := DigestForImage("myapp:dev", ImageInfo{}); got != "" {
+		t.Errorf("DigestForImage of a locally built image = %q, want none", got)
+	}
+}
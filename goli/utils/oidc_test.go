@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"goli/utils/oidctest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func discoverTestProvider(t *testing.T, mock *oidctest.Provider) *OIDCProvider {
+	t.Helper()
+	provider, err := DiscoverOIDCProvider(context.Background(), mock.Issuer()+"/", mock.ClientID, mock.ClientSecret,
+		"http://goli.test/api/v1/auth/oidc/callback", []string{"openid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	mock := oidctest.NewProvider(t, "goli", "client-secret")
+	provider := discoverTestProvider(t, mock)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := func() map[string]interface{} {
+		return mock.Claims("user-1", "nonce-1")
+	}
+	with := func(key string, value interface{}) string {
+		claims := valid()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return mock.IDToken(claims)
+	}
+
+	if claims, err := provider.VerifyIDToken(context.Background(), mock.IDToken(valid()), "nonce-1"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	} else if claims["sub"] != "user-1" {
+		t.Errorf("sub = %v, want user-1", claims["sub"])
+	}
+	if _, err := provider.VerifyIDToken(context.Background(), with("aud", []interface{}{"other", "goli"}), "nonce-1"); err != nil {
+		t.Errorf("token with the client in a list audience rejected: %v", err)
+	}
+
+	tampered := strings.Split(mock.IDToken(valid()), ".")
+	tampered[1] = strings.Split(with("sub", "admin"), ".")[1]
+
+	tests := []struct {
+		name  string
+		token string
+		nonce string
+		want  string
+	}{
+		{"tampered claims", strings.Join(tampered, "."), "nonce-1", "invalid ID token signature"},
+		{"other key", oidctest.EncodeJWT(map[string]interface{}{"alg": "RS256", "kid": oidctest.KeyID}, valid(),
+			func(signed string) []byte { return oidctest.SignRS256(otherKey, signed) }), "nonce-1", "invalid ID token signature"},
+		{"unknown key", oidctest.EncodeJWT(map[string]interface{}{"alg": "RS256", "kid": "rotated"}, valid(),
+			func(signed string) []byte { return oidctest.SignRS256(mock.Key, signed) }), "nonce-1", "unknown ID token key"},
+		{"alg none", oidctest.EncodeJWT(map[string]interface{}{"alg": "none", "kid": oidctest.KeyID}, valid(),
+			func(string) []byte { return nil }), "nonce-1", "unsupported ID token algorithm"},
+		{"alg HS256 with the client secret", oidctest.EncodeJWT(map[string]interface{}{"alg": "HS256", "kid": oidctest.KeyID}, valid(),
+			func(signed string) []byte {
+				mac := hmac.New(sha256.New, []byte(mock.ClientSecret))
+				mac.Write([]byte(signed))
+				return mac.Sum(nil)
+			}), "nonce-1", "unsupported ID token algorithm"},
+		{"wrong issuer", with("iss", "https://evil.example"), "nonce-1", "ID token issued by"},
+		{"wrong audience", with("aud", "other-client"), "nonce-1", "not meant for this client"},
+		{"no audience", with("aud", nil), "nonce-1", "not meant for this client"},
+		{"other authorized party", with("azp", "other-client"), "nonce-1", "authorized for another client"},
+		{"wrong nonce", mock.IDToken(valid()), "nonce-2", "nonce does not match"},
+		{"no nonce", with("nonce", nil), "nonce-1", "nonce does not match"},
+		{"expired", with("exp", time.Now().Add(-time.Hour).Unix()), "nonce-1", "expired"},
+		{"no expiry", with("exp", nil), "nonce-1", "expired"},
+		{"issued in the future", with("iat", time.Now().Add(time.Hour).Unix()), "nonce-1", "issued in the future"},
+		{"no subject", with("sub", ""), "nonce-1", "no subject"},
+		{"malformed", "not-a-token", "nonce-1", "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.VerifyIDToken(context.Background(), tt.token, tt.nonce)
+			if err == nil {
+				t.Fatal("token accepted")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscoverOIDCProviderRejectsOtherIssuer(t *testing.T) {
+	mock := oidctest.NewProvider(t, "goli", "")
+	_, err := DiscoverOIDCProvider(context.Background(), mock.Issuer()+"/tenant", "goli", "", "http://goli.test/callback", nil)
+	if err == nil {
+		t.Fatal("discovery of another issuer accepted")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	for _, secret := range []string{"", "client secret"} {
+		mock := oidctest.NewProvider(t, "goli", secret)
+		provider := discoverTestProvider(t, mock)
+
+		verifier, challenge, err := NewPKCEVerifier()
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, err := mock.Authorize(provider.AuthCodeURL("state-1", "nonce-1", challenge), map[string]interface{}{"sub": "user-1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query.Get("state") != "state-1" {
+			t.Errorf("state = %q, want state-1", query.Get("state"))
+		}
+
+		if _, err := provider.Exchange(context.Background(), query.Get("code"), "wrong-verifier"); err == nil {
+			t.Error("code exchanged with the wrong PKCE verifier")
+		}
+
+		query, _ = mock.Authorize(provider.AuthCodeURL("state-1", "nonce-1", challenge), map[string]interface{}{"sub": "user-1"})
+		rawToken, err := provider.Exchange(context.Background(), query.Get("code"), verifier)
+		if err != nil {
+			t.Fatalf("exchange with client secret %q: %v", secret, err)
+		}
+		if _, err := provider.VerifyIDToken(context.Background(), rawToken, "nonce-1"); err != nil {
+			t.Errorf("exchanged token rejected: %v", err)
+		}
+		if _, err := provider.Exchange(context.Background(), query.Get("code"), verifier); err == nil {
+			t.Error("code exchanged twice")
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Package oidctest runs a mock OpenID Connect identity provider for tests: discovery, JWKS, and a token
+// endpoint that checks the authorization code, PKCE verifier and client credentials like a real provider
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// KeyID is the key ID of the provider's signing key
+const KeyID = "test-key"
+
+// Provider is a mock identity provider listening on a local httptest server
+type Provider struct {
+	Server       *httptest.Server
+	Key          *rsa.PrivateKey
+	ClientID     string
+	ClientSecret string // Empty for a public client
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// authorization is a code handed out by Authorize, waiting to be exchanged at the token endpoint
+type authorization struct {
+	claims        map[string]interface{}
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+}
+
+// NewProvider starts a provider for the client, it is closed when the test ends
+func NewProvider(t testing.TB, clientID, clientSecret string) *Provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Provider{Key: key, ClientID: clientID, ClientSecret: clientSecret, codes: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/keys", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Server.Close)
+	return p
+}
+
+// Issuer returns the issuer URL of the provider
+func (p *Provider) Issuer() string {
+	return p.Server.URL
+}
+
+// Claims returns the claims of a valid ID token for the subject and nonce, valid for an hour
+func (p *Provider) Claims(subject, nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":   p.Issuer(),
+		"aud":   p.ClientID,
+		"sub":   subject,
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+// IDToken signs the claims with the provider's key (RS256)
+func (p *Provider) IDToken(claims map[string]interface{}) string {
+	return EncodeJWT(map[string]interface{}{"alg": "RS256", "kid": KeyID}, claims, func(signed string) []byte {
+		return SignRS256(p.Key, signed)
+	})
+}
+
+// Authorize plays the user logging in at the authorization URL a client redirected to. The claims are
+// added to the ID token the returned code is exchanged for, along with the nonce of the URL. It returns
+// the query the provider redirects back to the client with
+func (p *Provider) Authorize(authURL string, claims map[string]interface{}) (url.Values, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		return nil, fmt.Errorf("unexpected authorization request %s", authURL)
+	}
+
+	token := map[string]interface{}{}
+	for k, v := range p.Claims("", query.Get("nonce")) {
+		token[k] = v
+	}
+	for k, v := range claims {
+		token[k] = v
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		claims:        token,
+		clientID:      query.Get("client_id"),
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+	}
+	p.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {query.Get("state")}}, nil
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.Issuer(),
+		"authorization_endpoint": p.Issuer() + "/authorize",
+		"token_endpoint":         p.Issuer() + "/token",
+		"jwks_uri":               p.Issuer() + "/keys",
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": KeyID,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(p.Key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.Key.E)).Bytes()),
+	}}})
+}
+
+// token exchanges a code for an ID token, each code once
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+	}
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		fail("invalid_request")
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if p.ClientSecret != "" {
+		user, secret, ok := r.BasicAuth()
+		user, _ = url.QueryUnescape(user)
+		secret, _ = url.QueryUnescape(secret)
+		if !ok || user != p.ClientID || secret != p.ClientSecret {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+		clientID = user
+	}
+
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || auth.clientID != clientID || auth.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge {
+		fail("invalid_grant")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     p.IDToken(auth.claims),
+	})
+}
+
+// EncodeJWT encodes a JWT with the header and claims, sign returns the signature of the signed part
+func EncodeJWT(header, claims map[string]interface{}, sign func(signed string) []byte) string {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			panic(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+// SignRS256 signs with RSASSA-PKCS1-v1_5 and SHA-256
+func SignRS256(key *rsa.PrivateKey, signed string) []byte {
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signature
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	aux "goli/auxiliary"
+	"strings"
+	"unicode"
+)
+
+// Password policy defaults, overridden by password_min_length and password_min_classes in config.toml
+const (
+	defaultPasswordMinLength  = 8
+	defaultPasswordMinClasses = 1
+	maxPasswordBytes          = 72 // bcrypt ignores everything after 72 bytes
+)
+
+// ValidatePassword checks a new password of username against the password policy: at least
+// password_min_length characters from at least password_min_classes of lowercase letters, uppercase
+// letters, digits and symbols, and not the username
+func ValidatePassword(password, username string) error {
+	minLength := aux.GetIntFromConfig("constants.password_min_length", defaultPasswordMinLength)
+	if n := len([]rune(password)); n < minLength || n == 0 {
+		return fmt.Errorf("Password must be at least %d characters", max(minLength, 1))
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("Password must be at most %d bytes", maxPasswordBytes)
+	}
+	if username != "" && strings.EqualFold(password, username) {
+		return errors.New("Password must not be the username")
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+	if minClasses := aux.GetIntFromConfig("constants.password_min_classes", defaultPasswordMinClasses); classes < minClasses {
+		return fmt.Errorf("Password must contain at least %d of lowercase letters, uppercase letters, digits and symbols", minClasses)
+	}
+	return nil
+}
@@ -106,6 +106,27 @@ func SendUnauthorizedResponseGin(c *gin.Context, message string) {
 	})
 }
 
+// SendForbiddenResponseGin sends a forbidden error response using Gin context
+func SendForbiddenResponseGin(c *gin.Context, message string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"status":      "error",
+		"description": message,
+	})
+}
+
+// SendTooManyRequestsResponseGin sends a rate limit error response with a Retry-After header using Gin context
+func SendTooManyRequestsResponseGin(c *gin.Context, message string, retryAfter time.Duration) {
+	seconds := int(retryAfter.Seconds())
+	if retryAfter > time.Duration(seconds)*time.Second {
+		seconds++
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"status":      "error",
+		"description": message,
+	})
+}
+
 // SendNotFoundResponseGin sends a not found error response using Gin context
 func SendNotFoundResponseGin(c *gin.Context, message string) {
 	c.JSON(http.StatusNotFound, gin.H{
@@ -122,11 +143,27 @@ func SendInternalServerErrorResponseGin(c *gin.Context, message string) {
 	})
 }
 
-// Notification helpers for 2FA
+// Notification helpers for 2FA and password resets
 
 // SendEmail2FACode sends a 2FA code via email using SMTP configuration in config.toml
-// This function now uses a timeout and supports TLS/SSL connections
 func SendEmail2FACode(toEmail, code string) error {
+	body := fmt.Sprintf("Your verification code is: %s\r\n\r\n", code) +
+		"This code will expire in 10 minutes.\r\n" +
+		"If you did not request this code, please ignore this email.\r\n"
+	return sendEmail(toEmail, "Your Goli verification code", body, "[2FA EMAIL] To: "+toEmail+" Code: "+code)
+}
+
+// SendPasswordResetEmail sends a one-time password reset link via email
+func SendPasswordResetEmail(toEmail, link string, validFor time.Duration) error {
+	body := "Open this link to choose a new Goli password:\r\n\r\n" + link + "\r\n\r\n" +
+		fmt.Sprintf("The link can be used once and will expire in %d minutes.\r\n", int(validFor.Minutes())) +
+		"If you did not request a password reset, please ignore this email.\r\n"
+	return sendEmail(toEmail, "Reset your Goli password", body, "[PASSWORD RESET] To: "+toEmail+" Link: "+link)
+}
+
+// sendEmail sends an email using the SMTP configuration in config.toml, or prints fallbackLog if SMTP
+// is not configured. It uses a timeout and supports TLS/SSL connections
+func sendEmail(toEmail, subject, body, fallbackLog string) error {
 	smtpHost := aux.GetFromConfig("constants.smtp_host")
 	smtpPortStr := aux.GetFromConfig("constants.smtp_port")
 	smtpUser := aux.GetFromConfig("constants.smtp_user")
@@ -139,7 +176,7 @@ func SendEmail2FACode(toEmail, code string) error {
 
 	if smtpHost == "" || smtpPortStr == "" || smtpUser == "" || smtpPass == "" || from == "" {
 		// Fallback: log only
-		fmt.Printf("[2FA EMAIL] To: %s Code: %s (SMTP not configured)\n", toEmail, code)
+		fmt.Printf("%s (SMTP not configured)\n", fallbackLog)
 		return nil
 	}
 
@@ -157,7 +194,7 @@ func SendEmail2FACode(toEmail, code string) error {
 	resultChan := make(chan error, 1)
 
 	go func() {
-		err := sendEmailWithTLS(ctx, smtpHost, smtpPort, smtpUser, smtpPass, from, fromName, toEmail, code)
+		err := sendEmailWithTLS(ctx, smtpHost, smtpPort, smtpUser, smtpPass, from, fromName, toEmail, subject, body)
 		resultChan <- err
 	}()
 
@@ -171,12 +208,12 @@ func SendEmail2FACode(toEmail, code string) error {
 }
 
 // sendEmailWithTLS sends an email with proper TLS/SSL support
-func sendEmailWithTLS(ctx context.Context, host string, port int, username, password, from, fromName, to, code string) error {
+func sendEmailWithTLS(ctx context.Context, host string, port int, username, password, from, fromName, to, subject, body string) error {
 	addr := fmt.Sprintf("%s:%d", host, port)
 	auth := smtp.PlainAuth("", username, password, host)
 
 	// Build proper email message with headers
-	message := buildEmailMessage(from, fromName, to, "Your Goli verification code", code)
+	message := buildEmailMessage(from, fromName, to, subject, body)
 
 	// Determine if we should use SSL (port 465) or STARTTLS (port 587, 25, etc.)
 	useSSL := port == 465
@@ -280,9 +317,7 @@ func buildEmailMessage(from, fromName, to, subject, body string) []byte {
 	message.WriteString("\r\n")
 
 	// Email body
-	message.WriteString(fmt.Sprintf("Your verification code is: %s\r\n\r\n", body))
-	message.WriteString("This code will expire in 10 minutes.\r\n")
-	message.WriteString("If you did not request this code, please ignore this email.\r\n")
+	message.WriteString(body)
 
 	return message.Bytes()
 }
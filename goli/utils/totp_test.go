@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors, "12345678901234567890" in base32
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8 digit codes, the 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := TOTPCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.want)
+		}
+	}
+
+	// Secrets are accepted in lower case and with padding, as some apps and users write them
+	if code, err := TOTPCode(strings.ToLower(rfc6238Secret)+"====", time.Unix(59, 0)); err != nil || code != "287082" {
+		t.Errorf("code of a lower case padded secret = %s, %v", code, err)
+	}
+	if _, err := TOTPCode("not base32!", time.Unix(59, 0)); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+	code := func(offset int64) string {
+		c, err := totpCodeAt(rfc6238Secret, step+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	for _, offset := range []int64{-1, 0, 1} {
+		got, ok := ValidateTOTP(rfc6238Secret, code(offset), now)
+		if !ok || got != step+offset {
+			t.Errorf("code of step %+d: step %d, %v, want %d", offset, got, ok, step+offset)
+		}
+	}
+	for _, offset := range []int64{-2, 2} {
+		if _, ok := ValidateTOTP(rfc6238Secret, code(offset), now); ok {
+			t.Errorf("code of step %+d accepted", offset)
+		}
+	}
+
+	if _, ok := ValidateTOTP(rfc6238Secret, " 050 471 ", now); !ok {
+		t.Error("code with spaces rejected")
+	}
+	for _, invalid := range []string{"", "05047", "0504710", "abcdef"} {
+		if _, ok := ValidateTOTP(rfc6238Secret, invalid, now); ok {
+			t.Errorf("code %q accepted", invalid)
+		}
+	}
+}
@@ -95,7 +95,7 @@ build_frontend() {
                 cd -
                 return 0
             else
-                echo "Warning: Frontend build failed, using existing web files if available"
+                echo "Warning: Frontend build failed, using existing web files if available (web files built before the setup bootstrap cannot complete the setup, use 'goli admin create')"
                 cd -
                 return 1
             fi
@@ -105,7 +105,7 @@ build_frontend() {
             return 1
         fi
     else
-        echo "Warning: npm not found, using existing web files if available"
+        echo "Warning: npm not found, using existing web files if available (web files built before the setup bootstrap cannot complete the setup, use 'goli admin create')"
         return 1
     fi
 }
@@ -149,7 +149,7 @@ compile_and_install_binaries() {
     fi
     
     # Build with CGO enabled (required for go-sqlite3)
-    cd "${curr_dir}/goli" && CGO_ENABLED=1 $_go mod tidy && CGO_ENABLED=1 $_go build -o "${goli_work_dir}/goli" main.go && cd -
+    cd "${curr_dir}/goli" && CGO_ENABLED=1 $_go mod tidy && CGO_ENABLED=1 $_go build -o "${goli_work_dir}/goli" . && cd -
     if [ $? -eq 0 ]; then
         echo "Goli binary installed successfully"
     else
@@ -187,6 +187,9 @@ install() {
     # Create Goli directories
     mkdir -p /goli/config
     mkdir -p /goli/data
+    mkdir -p /goli/templates
+    mkdir -p /goli/workspaces
+    mkdir -p /goli/artifacts
     
     # Create Goli Toml config file with setup_complete flag set to false
     echo "s/dummy_key/${auth_key}/1" > "${curr_dir}/utils/rule_1.sed"
@@ -216,6 +219,9 @@ install() {
     chmod 755 /goli
     chmod 755 /goli/config
     chmod 755 /goli/data
+    chmod 755 /goli/templates
+    chmod 755 /goli/workspaces
+    chmod 755 /goli/artifacts
     chmod 644 /goli/config/config.toml
     chmod 755 /usr/local/sbin/goli
     chmod 755 /usr/local/sbin/goli/goli
@@ -250,6 +256,8 @@ install() {
         echo "   - Configure your admin user (default: 'goli')"
         echo "   - Update application settings"
         echo "   - Configure tool parameters"
+        echo "   Or, without a browser, create the admin user on the command line:"
+        echo "     sudo -u goli ${goli_work_dir}/goli admin create -username goli"
         echo ""
         echo "========================================="
         echo "IMPORTANT: ONE-TIME SETUP PASSWORD"